@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,7 +18,6 @@ import (
 	"github.com/protolambda/eth2api"
 	"github.com/protolambda/eth2api/client/beaconapi"
 	"github.com/protolambda/eth2api/client/builderapi"
-	"github.com/protolambda/eth2api/client/debugapi"
 	"github.com/protolambda/eth2api/client/nodeapi"
 	"github.com/protolambda/eth2api/client/validatorapi"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
@@ -38,6 +38,23 @@ const (
 
 var EMPTY_TREE_ROOT = tree.Root{}
 
+// Returned when the beacon node responds that the requested object does not
+// exist
+var NotFound = errors.New("not found")
+
+// Validator statuses as defined in the current beacon API specification
+const (
+	ValidatorStatusPendingInitialized eth2api.ValidatorStatus = "pending_initialized"
+	ValidatorStatusPendingQueued      eth2api.ValidatorStatus = "pending_queued"
+	ValidatorStatusActiveOngoing      eth2api.ValidatorStatus = "active_ongoing"
+	ValidatorStatusActiveExiting      eth2api.ValidatorStatus = "active_exiting"
+	ValidatorStatusActiveSlashed      eth2api.ValidatorStatus = "active_slashed"
+	ValidatorStatusExitedUnslashed    eth2api.ValidatorStatus = "exited_unslashed"
+	ValidatorStatusExitedSlashed      eth2api.ValidatorStatus = "exited_slashed"
+	ValidatorStatusWithdrawalPossible eth2api.ValidatorStatus = "withdrawal_possible"
+	ValidatorStatusWithdrawalDone     eth2api.ValidatorStatus = "withdrawal_done"
+)
+
 type BeaconClientConfig struct {
 	ClientIndex             int
 	TerminalTotalDifficulty int64
@@ -46,6 +63,8 @@ type BeaconClientConfig struct {
 	GenesisValidatorsRoot   *tree.Root
 	GenesisTime             *common.Timestamp
 	Subnet                  string
+	// Source of time for the slot clock, the system time if nil
+	Clock utils.Clock
 }
 
 type BeaconClient struct {
@@ -172,24 +191,124 @@ func (bn *BeaconClient) P2PAddr(parentCtx context.Context) (string, error) {
 	if err := nodeapi.Identity(ctx, bn.api, &out); err != nil {
 		return "", err
 	}
+	// Use the TCP port advertised by the client, if any
+	port := PortBeaconTCP
+	if record, err := ParseENR(out.ENR); err == nil && record.TCP != 0 {
+		port = record.TCP
+	}
 	ip := bn.GetIP()
 	if ip != nil {
 		return fmt.Sprintf(
-			"/ip4/%s/tcp/%d/p2p/%s",
+			"/%s/%s/tcp/%d/p2p/%s",
+			ipMultiaddrProtocol(ip),
 			ip.String(),
-			PortBeaconTCP,
+			port,
 			out.PeerID,
 		), nil
 	} else {
 		return fmt.Sprintf(
 			"/dns/%s/tcp/%d/p2p/%s",
 			bn.GetHost(),
-			PortBeaconTCP,
+			port,
 			out.PeerID,
 		), nil
 	}
 }
 
+// Returns the TCP and QUIC multiaddrs advertised in the ENR of the client.
+// The IP of the client is used if the record does not contain any.
+func (bn *BeaconClient) Multiaddrs(parentCtx context.Context) ([]string, error) {
+	enr, err := bn.ENR(parentCtx)
+	if err != nil {
+		return nil, err
+	}
+	record, err := ParseENR(enr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := bn.GetIP(); ip != nil && record.IP == nil && record.IP6 == nil {
+		if ip.To4() != nil {
+			record.IP = ip
+		} else {
+			record.IP6 = ip
+		}
+	}
+	return record.Multiaddrs()
+}
+
+func (bn *BeaconClient) PeerCount(
+	parentCtx context.Context,
+) (*eth2api.PeerCountResponse, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	var out eth2api.PeerCountResponse
+	if err := nodeapi.PeerCount(ctx, bn.api, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (bn *BeaconClient) Identity(
+	parentCtx context.Context,
+) (*eth2api.NetworkIdentity, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	var out eth2api.NetworkIdentity
+	if err := nodeapi.Identity(ctx, bn.api, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Returns the peers known by the beacon client, filtered by the connection
+// states and directions, or all of them if no filter is given
+func (bn *BeaconClient) Peers(
+	parentCtx context.Context,
+	states []eth2api.ConnectionState,
+	directions []eth2api.ConnectionDirection,
+) ([]eth2api.Peer, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	out := make([]eth2api.Peer, 0)
+	if err := nodeapi.Peers(ctx, bn.api, states, directions, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Returns the peers the beacon client is currently connected to
+func (bn *BeaconClient) ConnectedPeers(
+	parentCtx context.Context,
+) ([]eth2api.Peer, error) {
+	return bn.Peers(
+		parentCtx,
+		[]eth2api.ConnectionState{eth2api.ConnectionStateConnected},
+		nil,
+	)
+}
+
+func (bn *BeaconClient) SyncingStatus(
+	parentCtx context.Context,
+) (*eth2api.SyncingStatus, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	var out eth2api.SyncingStatus
+	if err := nodeapi.SyncingStatus(ctx, bn.api, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (bn *BeaconClient) NodeVersion(parentCtx context.Context) (string, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	var out eth2api.NodeVersionResponse
+	if err := nodeapi.NodeVersion(ctx, bn.api, &out); err != nil {
+		return "", err
+	}
+	return out.Version, nil
+}
+
 func (bn *BeaconClient) BeaconAPIURL() (string, error) {
 	if bn.api == nil {
 		return "", fmt.Errorf("api not initialized")
@@ -301,20 +420,25 @@ func (bn *BeaconClient) BlockV2(
 	blockId eth2api.BlockId,
 ) (*VersionedSignedBeaconBlock, error) {
 	var (
-		versionedBlock = new(eth2api.VersionedSignedBeaconBlock)
-		exists         bool
-		err            error
+		versionedBlock = &VersionedSignedBeaconBlock{
+			spec: bn.Config.Spec,
+		}
+		exists bool
+		err    error
 	)
 	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
 	defer cancel()
-	exists, err = beaconapi.BlockV2(ctx, bn.api, blockId, versionedBlock)
+	// Decoded using the registered forks instead of beaconapi.BlockV2
+	exists, err = eth2api.SimpleRequest(
+		ctx,
+		bn.api,
+		eth2api.FmtGET("/eth/v2/beacon/blocks/%s", blockId.BlockId()),
+		versionedBlock,
+	)
 	if !exists {
-		return nil, fmt.Errorf("endpoint not found on beacon client")
+		return nil, fmt.Errorf("block %s %w on beacon client", blockId.BlockId(), NotFound)
 	}
-	return &VersionedSignedBeaconBlock{
-		VersionedSignedBeaconBlock: versionedBlock,
-		spec:                       bn.Config.Spec,
-	}, err
+	return versionedBlock, err
 }
 
 type BlockV2OptimisticResponse struct {
@@ -381,6 +505,40 @@ func (bn *BeaconClient) BlobSidecars(
 	return *blobSidecars, err
 }
 
+// Requests only the sidecars of the block with the given indexes
+func (bn *BeaconClient) BlobSidecarsByIndices(
+	parentCtx context.Context,
+	blockId eth2api.BlockId,
+	indices []uint64,
+) ([]deneb.BlobSidecar, error) {
+	var (
+		resp struct {
+			Data []deneb.BlobSidecar `json:"data"`
+		}
+		q = make([]string, len(indices))
+	)
+	for i, index := range indices {
+		q[i] = strconv.FormatUint(index, 10)
+	}
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	exists, err := eth2api.SimpleRequest(
+		ctx,
+		bn.api,
+		eth2api.QueryGET(
+			eth2api.Query{"indices": strings.Join(q, ",")},
+			"/eth/v1/beacon/blob_sidecars/"+blockId.BlockId(),
+		),
+		&resp,
+	)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("endpoint not found on beacon client")
+	}
+	return resp.Data, nil
+}
+
 func (bn *BeaconClient) StateValidator(
 	parentCtx context.Context,
 	stateId eth2api.StateId,
@@ -487,6 +645,24 @@ func (bn *BeaconClient) StateFork(
 	return fork, err
 }
 
+func (bn *BeaconClient) StateRoot(
+	parentCtx context.Context,
+	stateId eth2api.StateId,
+) (tree.Root, error) {
+	var (
+		root   tree.Root
+		exists bool
+		err    error
+	)
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	root, exists, err = beaconapi.StateRoot(ctx, bn.api, stateId)
+	if !exists {
+		return root, fmt.Errorf("endpoint not found on beacon client")
+	}
+	return root, err
+}
+
 func (bn *BeaconClient) StateRandaoMix(
 	parentCtx context.Context,
 	stateId eth2api.StateId,
@@ -582,11 +758,23 @@ func IsFullyWithdrawableValidator(
 		balance > 0
 }
 
+// Deprecated: partial withdrawals do not depend on the epoch, use
+// IsPartiallyWithdrawable instead.
 func IsPartiallyWithdrawableValidator(
 	spec *common.Spec,
 	validator *phase0.Validator,
 	balance common.Gwei,
 	epoch common.Epoch,
+) bool {
+	return IsPartiallyWithdrawable(spec, validator, balance)
+}
+
+// Partial withdrawals do not depend on the epoch, as opposed to full
+// withdrawals, which require the validator to be withdrawable.
+func IsPartiallyWithdrawable(
+	spec *common.Spec,
+	validator *phase0.Validator,
+	balance common.Gwei,
 ) bool {
 	effectiveBalance := validator.EffectiveBalance
 	hasMaxEffectiveBalance := effectiveBalance == spec.MAX_EFFECTIVE_BALANCE
@@ -607,25 +795,25 @@ func (bn *BeaconClient) BeaconStateV2(
 	stateId eth2api.StateId,
 ) (*VersionedBeaconStateResponse, error) {
 	var (
-		versionedBeaconStateResponse = new(eth2api.VersionedBeaconState)
-		exists                       bool
-		err                          error
+		versionedBeaconStateResponse = &VersionedBeaconStateResponse{
+			spec: bn.Config.Spec,
+		}
+		exists bool
+		err    error
 	)
 	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
 	defer cancel()
-	exists, err = debugapi.BeaconStateV2(
+	// Decoded using the registered forks instead of debugapi.BeaconStateV2
+	exists, err = eth2api.SimpleRequest(
 		ctx,
 		bn.api,
-		stateId,
+		eth2api.FmtGET("/eth/v2/debug/beacon/states/%s", stateId.StateId()),
 		versionedBeaconStateResponse,
 	)
 	if !exists {
 		return nil, fmt.Errorf("endpoint not found on beacon client")
 	}
-	return &VersionedBeaconStateResponse{
-		VersionedBeaconState: versionedBeaconStateResponse,
-		spec:                 bn.Config.Spec,
-	}, err
+	return versionedBeaconStateResponse, err
 }
 
 func (bn *BeaconClient) BeaconStateV2ByBlock(
@@ -704,6 +892,42 @@ func (bn *BeaconClient) StateValidatorBalances(
 	return stateValidatorBalanceResponse, err
 }
 
+// Returns the slot clock of the network the client is part of
+func (bn *BeaconClient) SlotClock() *SlotClock {
+	if bn.Config.GenesisTime == nil {
+		panic(fmt.Errorf("init not called yet"))
+	}
+	return NewSlotClock(
+		bn.Config.Spec,
+		*bn.Config.GenesisTime,
+		bn.Config.Clock,
+	)
+}
+
+// Returns a ticker that fires once per slot duration, which unlike the slot
+// clock does not require the genesis time
+func (bn *BeaconClient) NewSlotTicker() utils.Ticker {
+	clock := bn.Config.Clock
+	if clock == nil {
+		clock = utils.SystemClock{}
+	}
+	return clock.NewTicker(
+		time.Duration(bn.Config.Spec.SECONDS_PER_SLOT) * time.Second,
+	)
+}
+
+// Returns the fork schedule of the network the client is part of
+func (bn *BeaconClient) ForkSchedule() *ForkSchedule {
+	if bn.Config.GenesisTime == nil {
+		panic(fmt.Errorf("init not called yet"))
+	}
+	return NewForkSchedule(
+		bn.Config.Spec,
+		*bn.Config.GenesisTime,
+		*bn.Config.GenesisValidatorsRoot,
+	)
+}
+
 func (bn *BeaconClient) ComputeDomain(
 	ctx context.Context,
 	typ common.BLSDomainType,
@@ -719,16 +943,8 @@ func (bn *BeaconClient) ComputeDomain(
 			*bn.Config.GenesisValidatorsRoot,
 		), nil
 	}
-	// We need to request for head state to know current active version
-	state, err := bn.BeaconStateV2ByBlock(ctx, eth2api.BlockHead)
-	if err != nil {
-		return common.BLSDomain{}, err
-	}
-	return common.ComputeDomain(
-		typ,
-		state.CurrentVersion(),
-		*bn.Config.GenesisValidatorsRoot,
-	), nil
+	// Use the version of the fork active at the current wall clock slot
+	return bn.ForkSchedule().Domain(typ, bn.SlotClock().CurrentEpoch()), nil
 }
 
 func (bn *BeaconClient) SubmitPoolBLSToExecutionChange(
@@ -740,6 +956,26 @@ func (bn *BeaconClient) SubmitPoolBLSToExecutionChange(
 	return beaconapi.SubmitBLSToExecutionChanges(ctx, bn.api, l)
 }
 
+// Submits the list of signed changes and returns the per-item failures
+// reported by the beacon node. If the beacon node rejects the request without
+// indexing the failures, the error is returned instead.
+func (bn *BeaconClient) SubmitPoolBLSToExecutionChangeIndexed(
+	parentCtx context.Context,
+	l common.SignedBLSToExecutionChanges,
+) ([]eth2api.IndexedErrorMessageItem, error) {
+	err := bn.SubmitPoolBLSToExecutionChange(parentCtx, l)
+	if err == nil {
+		return nil, nil
+	}
+	var ierr eth2api.IndexedError
+	if errors.As(err, &ierr) {
+		if failures := ierr.IndexedErrors(); len(failures) > 0 {
+			return failures, nil
+		}
+	}
+	return nil, err
+}
+
 func (bn *BeaconClient) SubmitVoluntaryExit(
 	parentCtx context.Context,
 	exit *phase0.SignedVoluntaryExit,
@@ -749,6 +985,50 @@ func (bn *BeaconClient) SubmitVoluntaryExit(
 	return beaconapi.SubmitVoluntaryExit(ctx, bn.api, exit)
 }
 
+func (bn *BeaconClient) SubmitPoolProposerSlashing(
+	parentCtx context.Context,
+	slashing *phase0.ProposerSlashing,
+) error {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	return beaconapi.SubmitProposerSlashing(ctx, bn.api, slashing)
+}
+
+func (bn *BeaconClient) SubmitPoolAttesterSlashing(
+	parentCtx context.Context,
+	slashing *phase0.AttesterSlashing,
+) error {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	return beaconapi.SubmitAttesterSlashing(ctx, bn.api, slashing)
+}
+
+func (bn *BeaconClient) PoolProposerSlashings(
+	parentCtx context.Context,
+) ([]phase0.ProposerSlashing, error) {
+	var (
+		slashings = make([]phase0.ProposerSlashing, 0)
+		err       error
+	)
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	err = beaconapi.PoolProposerSlashings(ctx, bn.api, &slashings)
+	return slashings, err
+}
+
+func (bn *BeaconClient) PoolAttesterSlashings(
+	parentCtx context.Context,
+) ([]phase0.AttesterSlashing, error) {
+	var (
+		slashings = make([]phase0.AttesterSlashing, 0)
+		err       error
+	)
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	err = beaconapi.PoolAttesterSlashings(ctx, bn.api, &slashings)
+	return slashings, err
+}
+
 func (b *BeaconClient) WaitForExecutionPayload(
 	ctx context.Context,
 ) (ethcommon.Hash, error) {
@@ -760,18 +1040,16 @@ func (b *BeaconClient) WaitForExecutionPayload(
 		b.Config.ClientIndex,
 		b.ClientName(),
 	)
-	slotDuration := time.Duration(b.Config.Spec.SECONDS_PER_SLOT) * time.Second
-	timer := time.NewTicker(slotDuration)
+	slotClock := b.SlotClock()
+	timer := slotClock.NewSlotTicker()
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return ethcommon.Hash{}, ctx.Err()
-		case <-timer.C:
-			realTimeSlot := b.Config.Spec.TimeToSlot(
-				common.Timestamp(time.Now().Unix()),
-				*b.Config.GenesisTime,
-			)
+		case <-timer.C():
+			realTimeSlot := slotClock.CurrentSlot()
 			var (
 				headInfo  *eth2api.BeaconBlockHeaderAndInfo
 				err       error
@@ -819,14 +1097,14 @@ func (b *BeaconClient) WaitForOptimisticState(
 		b.ClientName(),
 	)
 
-	slotDuration := time.Duration(b.Config.Spec.SECONDS_PER_SLOT) * time.Second
-	timer := time.NewTicker(slotDuration)
+	timer := b.NewSlotTicker()
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
-		case <-timer.C:
+		case <-timer.C():
 			var headOptStatus BlockV2OptimisticResponse
 			if exists, err := eth2api.SimpleRequest(ctx, b.api, eth2api.FmtGET("/eth/v2/beacon/blocks/%s", blockID.BlockId()), &headOptStatus); err != nil {
 				// Block still not synced
@@ -883,10 +1161,7 @@ func (bn *BeaconClient) GetFirstExecutionBeaconBlock(
 	if bn.Config.GenesisTime == nil {
 		panic(fmt.Errorf("init not called yet"))
 	}
-	lastSlot := bn.Config.Spec.TimeToSlot(
-		common.Timestamp(time.Now().Unix()),
-		*bn.Config.GenesisTime,
-	)
+	lastSlot := bn.SlotClock().CurrentSlot()
 	for slot := common.Slot(0); slot <= lastSlot; slot++ {
 		versionedBlock, err := bn.BlockV2(parentCtx, eth2api.BlockIdSlot(slot))
 		if err != nil {
@@ -1023,6 +1298,24 @@ func (beacons BeaconClients) P2PAddrs(
 	return strings.Join(staticPeers, ","), nil
 }
 
+// Returns comma-separated multiaddrs of all the endpoints advertised by the
+// running beacon nodes
+func (beacons BeaconClients) Multiaddrs(
+	parentCtx context.Context,
+) (string, error) {
+	addrs := make([]string, 0)
+	for _, bn := range beacons {
+		if bn.IsRunning() {
+			bnAddrs, err := bn.Multiaddrs(parentCtx)
+			if err != nil {
+				return "", err
+			}
+			addrs = append(addrs, bnAddrs...)
+		}
+	}
+	return strings.Join(addrs, ","), nil
+}
+
 func (b BeaconClients) GetBeaconBlockByExecutionHash(
 	parentCtx context.Context,
 	hash ethcommon.Hash,
@@ -1097,3 +1390,27 @@ func (all BeaconClients) SubmitPoolBLSToExecutionChange(
 	}
 	return nil
 }
+
+func (all BeaconClients) SubmitPoolProposerSlashing(
+	parentCtx context.Context,
+	slashing *phase0.ProposerSlashing,
+) error {
+	for _, b := range all {
+		if err := b.SubmitPoolProposerSlashing(parentCtx, slashing); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (all BeaconClients) SubmitPoolAttesterSlashing(
+	parentCtx context.Context,
+	slashing *phase0.AttesterSlashing,
+) error {
+	for _, b := range all {
+		if err := b.SubmitPoolAttesterSlashing(parentCtx, slashing); err != nil {
+			return err
+		}
+	}
+	return nil
+}
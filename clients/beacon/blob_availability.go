@@ -0,0 +1,401 @@
+package beacon
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// Availability of the blobs of a single block on a single beacon node
+type BlobAvailability struct {
+	Slot      common.Slot
+	BlockRoot tree.Root
+	// Number of commitments included in the block
+	Expected int
+	// Number of sidecars served by the node
+	Served int
+	// Commitment indexes for which the node served no sidecar
+	Missing []uint64
+	// Indexes of the served sidecars whose commitment does not match the
+	// block or whose KZG proof is invalid
+	Invalid []uint64
+	// Whether the block was within the blob retention window at the time of
+	// the check
+	WithinRetention bool
+	// Error returned by the node when requesting the sidecars
+	Err error
+}
+
+// Returns true if the node served all the blobs of the block
+func (a *BlobAvailability) Available() bool {
+	return a.Err == nil && len(a.Missing) == 0 && len(a.Invalid) == 0
+}
+
+// Blob availability of all monitored blocks on a single beacon node
+type BlobAvailabilityReport struct {
+	ClientIndex int
+	ClientName  string
+	// Latest availability check of every block that contains blobs
+	Slots map[common.Slot]*BlobAvailability
+	// Slots that left the retention window and are no longer served, mapped
+	// to the head slot at which the pruning was first observed
+	Pruned map[common.Slot]common.Slot
+}
+
+// Returns the checks of the blocks within the retention window whose blobs
+// were not fully served, sorted by slot
+func (r *BlobAvailabilityReport) Unavailable() []*BlobAvailability {
+	res := make([]*BlobAvailability, 0)
+	for _, a := range r.Slots {
+		if a.WithinRetention && !a.Available() {
+			res = append(res, a)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Slot < res[j].Slot
+	})
+	return res
+}
+
+type monitoredBlock struct {
+	slot        common.Slot
+	root        tree.Root
+	commitments common.KZGCommitments
+}
+
+// Number of blocks, away from the retention boundary, whose blobs are
+// re-checked on every update
+const BLOB_AVAILABILITY_RECHECK_SAMPLE = 4
+
+// Monitors whether every beacon node serves all the blobs of each block
+// within the retention window, and when the nodes prune them afterwards.
+//
+// The sidecars of each block are fully verified when the block is first seen,
+// and again on every re-check while a node does not serve all of them.
+// Otherwise only a single sidecar is requested to check that the blobs are
+// still served: on every update for the blocks close to the retention
+// boundary, and for a rotating sample of RecheckSample blocks otherwise.
+type BlobAvailabilityMonitor struct {
+	BeaconClients BeaconClients
+	// Reports keyed by client index
+	Reports map[int]*BlobAvailabilityReport
+	// Number of blocks away from the retention boundary re-checked on each
+	// update
+	RecheckSample int
+
+	blocks   map[common.Slot]*monitoredBlock
+	nextSlot common.Slot
+	// Last slot re-checked as part of the sample
+	recheckCursor common.Slot
+	mu            sync.Mutex
+}
+
+// Creates a monitor for all the given beacon clients, starting at the given
+// slot
+func (all BeaconClients) NewBlobAvailabilityMonitor(
+	fromSlot common.Slot,
+) *BlobAvailabilityMonitor {
+	m := &BlobAvailabilityMonitor{
+		BeaconClients: all,
+		Reports:       make(map[int]*BlobAvailabilityReport),
+		RecheckSample: BLOB_AVAILABILITY_RECHECK_SAMPLE,
+		blocks:        make(map[common.Slot]*monitoredBlock),
+		nextSlot:      fromSlot,
+	}
+	for _, bn := range all {
+		m.Reports[bn.Config.ClientIndex] = &BlobAvailabilityReport{
+			ClientIndex: bn.Config.ClientIndex,
+			ClientName:  bn.ClientName(),
+			Slots:       make(map[common.Slot]*BlobAvailability),
+			Pruned:      make(map[common.Slot]common.Slot),
+		}
+	}
+	return m
+}
+
+// Returns true if the blobs of the slot must still be served at the given
+// head slot
+func IsWithinBlobRetention(
+	spec *common.Spec,
+	slot common.Slot,
+	headSlot common.Slot,
+) bool {
+	return spec.SlotToEpoch(slot)+common.Epoch(
+		spec.MIN_EPOCHS_FOR_BLOB_SIDECARS_REQUESTS,
+	) >= spec.SlotToEpoch(headSlot)
+}
+
+// Requests the sidecars of the block from the node and compares them against
+// the block commitments
+func checkBlobAvailability(
+	ctx context.Context,
+	bn *BeaconClient,
+	block *monitoredBlock,
+	headSlot common.Slot,
+) *BlobAvailability {
+	a := &BlobAvailability{
+		Slot:      block.slot,
+		BlockRoot: block.root,
+		Expected:  len(block.commitments),
+		WithinRetention: IsWithinBlobRetention(
+			bn.Config.Spec,
+			block.slot,
+			headSlot,
+		),
+		Missing: make([]uint64, 0),
+		Invalid: make([]uint64, 0),
+	}
+	sidecars, err := bn.BlobSidecars(ctx, eth2api.BlockIdRoot(block.root))
+	if err != nil {
+		a.Err = err
+		return a
+	}
+	a.Served = len(sidecars)
+	served := make(map[uint64]bool)
+	for i := range sidecars {
+		sidecar := &sidecars[i]
+		index := uint64(sidecar.Index)
+		served[index] = true
+		if index >= uint64(len(block.commitments)) ||
+			sidecar.KZGCommitment != block.commitments[index] ||
+			VerifyBlobSidecarKZGProof(sidecar) != nil {
+			a.Invalid = append(a.Invalid, index)
+		}
+	}
+	for i := range block.commitments {
+		if !served[uint64(i)] {
+			a.Missing = append(a.Missing, uint64(i))
+		}
+	}
+	return a
+}
+
+// Requests a single sidecar of the block to check that the node still serves
+// the blobs verified by a previous check, or all of them if the previous
+// check found them unavailable
+func recheckBlobAvailability(
+	ctx context.Context,
+	bn *BeaconClient,
+	block *monitoredBlock,
+	prev *BlobAvailability,
+	headSlot common.Slot,
+) *BlobAvailability {
+	if prev == nil || !prev.Available() {
+		// The blobs were never fully served by this node, so all of them are
+		// verified again
+		return checkBlobAvailability(ctx, bn, block, headSlot)
+	}
+	a := *prev
+	a.WithinRetention = IsWithinBlobRetention(bn.Config.Spec, block.slot, headSlot)
+	sidecars, err := bn.BlobSidecarsByIndices(
+		ctx,
+		eth2api.BlockIdRoot(block.root),
+		[]uint64{0},
+	)
+	if err != nil {
+		a.Err = err
+		return &a
+	}
+	if len(sidecars) == 0 || sidecars[0].KZGCommitment != block.commitments[0] {
+		// The node stopped serving the blobs of the block
+		a.Served = 0
+		a.Invalid = make([]uint64, 0)
+		a.Missing = make([]uint64, len(block.commitments))
+		for i := range a.Missing {
+			a.Missing[i] = uint64(i)
+		}
+	}
+	return &a
+}
+
+// Returns the slots of the blocks to re-check at the given head: all the
+// blocks that leave the retention window within the next epoch, and a
+// rotating sample of the rest
+func (m *BlobAvailabilityMonitor) recheckSlots(
+	spec *common.Spec,
+	headSlot common.Slot,
+) []common.Slot {
+	var (
+		boundary = make([]common.Slot, 0)
+		rest     = make([]common.Slot, 0)
+	)
+	for slot := range m.blocks {
+		if !IsWithinBlobRetention(
+			spec,
+			slot,
+			headSlot+common.Slot(spec.SLOTS_PER_EPOCH),
+		) {
+			boundary = append(boundary, slot)
+		} else {
+			rest = append(rest, slot)
+		}
+	}
+	if m.RecheckSample <= 0 || len(rest) == 0 {
+		return boundary
+	}
+	sort.Slice(rest, func(i, j int) bool {
+		return rest[i] < rest[j]
+	})
+	start := sort.Search(len(rest), func(i int) bool {
+		return rest[i] > m.recheckCursor
+	})
+	for i := 0; i < m.RecheckSample && i < len(rest); i++ {
+		slot := rest[(start+i)%len(rest)]
+		boundary = append(boundary, slot)
+		m.recheckCursor = slot
+	}
+	return boundary
+}
+
+// Fetches the block at the given slot from the first node that has it and
+// records the availability of its blobs on every running node
+func (m *BlobAvailabilityMonitor) checkSlot(
+	ctx context.Context,
+	slot common.Slot,
+	headSlot common.Slot,
+) {
+	var block *VersionedSignedBeaconBlock
+	for _, bn := range m.BeaconClients.Running() {
+		if b, err := bn.BlockV2(ctx, eth2api.BlockIdSlot(slot)); err == nil {
+			block = b
+			break
+		}
+	}
+	if block == nil || !block.ContainsKZGCommitments() {
+		// Missed slot or pre-deneb block
+		return
+	}
+	commitments := block.KZGCommitments()
+	if len(commitments) == 0 {
+		return
+	}
+	mb := &monitoredBlock{
+		slot:        slot,
+		root:        block.Root(),
+		commitments: commitments,
+	}
+	m.blocks[slot] = mb
+	for _, bn := range m.BeaconClients.Running() {
+		m.Reports[bn.Config.ClientIndex].Slots[slot] = checkBlobAvailability(
+			ctx,
+			bn,
+			mb,
+			headSlot,
+		)
+	}
+}
+
+// Checks all new slots up to the current head, and re-checks some of the
+// blocks already seen to detect blobs that stopped being served
+func (m *BlobAvailabilityMonitor) Update(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	running := m.BeaconClients.Running()
+	if len(running) == 0 {
+		return fmt.Errorf("no running beacon clients")
+	}
+	headInfo, err := running[0].BlockHeader(ctx, eth2api.BlockHead)
+	if err != nil {
+		return err
+	}
+	var (
+		headSlot = headInfo.Header.Message.Slot
+		spec     = running[0].Config.Spec
+	)
+
+	// Re-check the known blocks before adding the new ones
+	for _, slot := range m.recheckSlots(spec, headSlot) {
+		var (
+			block   = m.blocks[slot]
+			removed = 0
+		)
+		for _, bn := range running {
+			report := m.Reports[bn.Config.ClientIndex]
+			if _, ok := report.Pruned[slot]; ok {
+				removed++
+				continue
+			}
+			prev := report.Slots[slot]
+			a := recheckBlobAvailability(ctx, bn, block, prev, headSlot)
+			if !a.WithinRetention && !a.Available() {
+				removed++
+				if prev == nil || prev.Available() {
+					// Pruned after leaving the retention window, keep the
+					// last check that was within the window
+					report.Pruned[slot] = headSlot
+					continue
+				}
+			}
+			report.Slots[slot] = a
+		}
+		if removed == len(running) {
+			// No longer served by any node
+			delete(m.blocks, slot)
+		}
+	}
+
+	for ; m.nextSlot <= headSlot; m.nextSlot++ {
+		m.checkSlot(ctx, m.nextSlot, headSlot)
+	}
+	return nil
+}
+
+// Logs the blob availability of every node
+func (m *BlobAvailabilityMonitor) PrintReport() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, bn := range m.BeaconClients {
+		report := m.Reports[bn.Config.ClientIndex]
+		unavailable := report.Unavailable()
+		bn.Logf(
+			"beacon %d (%s): blob availability: blocks=%d, unavailable=%d, pruned=%d",
+			report.ClientIndex,
+			report.ClientName,
+			len(report.Slots),
+			len(unavailable),
+			len(report.Pruned),
+		)
+		for _, a := range unavailable {
+			bn.Logf(
+				"beacon %d (%s): slot %d: blobs unavailable: expected=%d, served=%d, missing=%v, invalid=%v, err=%v",
+				report.ClientIndex,
+				report.ClientName,
+				a.Slot,
+				a.Expected,
+				a.Served,
+				a.Missing,
+				a.Invalid,
+				a.Err,
+			)
+		}
+	}
+}
+
+// Updates the monitor every slot until the context is done
+func (m *BlobAvailabilityMonitor) Run(ctx context.Context) {
+	if len(m.BeaconClients) == 0 {
+		return
+	}
+	timer := m.BeaconClients[0].NewSlotTicker()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C():
+			if err := m.Update(ctx); err != nil {
+				m.BeaconClients[0].Logf(
+					"BlobAvailabilityMonitor: update failed: %v",
+					err,
+				)
+			}
+		}
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Tests for the blob availability monitor
+*/
+package beacon
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// Beacon node that serves a deneb block with a single blob at slot 1, and
+// counts the requests of its sidecars
+type testBlobNode struct {
+	head atomic.Uint64
+	// Whether the sidecar is served, an empty list is returned otherwise
+	serve   atomic.Bool
+	full    atomic.Int32
+	indexed atomic.Int32
+}
+
+// Starts the beacon node and returns a monitor of it, whose retention window
+// is two epochs
+func newTestBlobNode(t *testing.T) (*testBlobNode, *BlobAvailabilityMonitor) {
+	var (
+		blob  kzg4844.Blob
+		block = new(deneb.SignedBeaconBlock)
+		node  = new(testBlobNode)
+	)
+	node.serve.Store(true)
+	commitment, err := kzg4844.BlobToCommitment(blob)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	proof, err := kzg4844.ComputeBlobProof(blob, commitment)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	block.Message.Slot = 1
+	block.Message.Body.BlobKZGCommitments = common.KZGCommitments{
+		common.KZGCommitment(commitment),
+	}
+	sidecar := deneb.BlobSidecar{
+		Blob:          append(deneb.Blob{}, blob[:]...),
+		KZGCommitment: common.KZGCommitment(commitment),
+		KZGProof:      common.KZGProof(proof),
+	}
+
+	respond := func(w http.ResponseWriter, v interface{}) {
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			t.Errorf("unable to encode response: %v", err)
+		}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/eth/v1/beacon/headers/head":
+			respond(w, map[string]interface{}{
+				"data": eth2api.BeaconBlockHeaderAndInfo{
+					Root:      tree.Root{0x01},
+					Canonical: true,
+					Header: common.SignedBeaconBlockHeader{
+						Message: common.BeaconBlockHeader{
+							Slot: common.Slot(node.head.Load()),
+						},
+					},
+				},
+			})
+		case r.URL.Path == "/eth/v2/beacon/blocks/1":
+			respond(w, map[string]interface{}{
+				"version": "deneb",
+				"data":    block,
+			})
+		case strings.HasPrefix(r.URL.Path, "/eth/v1/beacon/blob_sidecars/"):
+			if r.URL.Query().Has("indices") {
+				node.indexed.Add(1)
+			} else {
+				node.full.Add(1)
+			}
+			sidecars := []deneb.BlobSidecar{sidecar}
+			if !node.serve.Load() {
+				sidecars = []deneb.BlobSidecar{}
+			}
+			respond(w, map[string]interface{}{"data": sidecars})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	bn := testBeaconClient(t, srv.URL, nil)
+	spec := *bn.Config.Spec
+	spec.MIN_EPOCHS_FOR_BLOB_SIDECARS_REQUESTS = 2
+	bn.Config.Spec = &spec
+	return node, BeaconClients{bn}.NewBlobAvailabilityMonitor(0)
+}
+
+func TestBlobAvailabilityMonitor(t *testing.T) {
+	node, m := newTestBlobNode(t)
+	m.RecheckSample = 0
+	var (
+		head    = &node.head
+		full    = &node.full
+		indexed = &node.indexed
+	)
+
+	update := func(slot uint64, wantFull int32, wantIndexed int32) {
+		t.Helper()
+		head.Store(slot)
+		if err := m.Update(context.Background()); err != nil {
+			t.Fatalf("head %d: unable to update: %v", slot, err)
+		}
+		if full.Load() != wantFull || indexed.Load() != wantIndexed {
+			t.Fatalf(
+				"head %d: incorrect requests: want %d full and %d indexed, got %d and %d",
+				slot,
+				wantFull,
+				wantIndexed,
+				full.Load(),
+				indexed.Load(),
+			)
+		}
+	}
+	report := m.Reports[m.BeaconClients[0].Config.ClientIndex]
+
+	// Blobs are fully verified only when the block is first seen
+	update(1, 1, 0)
+	if a := report.Slots[1]; a == nil || !a.Available() {
+		t.Fatalf("blobs not available: %+v", a)
+	}
+	update(2, 1, 0)
+
+	// Blocks away from the retention boundary are sampled
+	m.RecheckSample = 1
+	update(3, 1, 1)
+	m.RecheckSample = 0
+	update(4, 1, 1)
+
+	// Blocks close to the boundary are re-checked on every update
+	update(16, 1, 2)
+	update(17, 1, 3)
+	if len(report.Unavailable()) != 0 {
+		t.Fatalf("unexpected unavailable blobs: %+v", report.Unavailable())
+	}
+
+	// Pruned blocks are recorded and no longer requested
+	node.serve.Store(false)
+	update(24, 1, 4)
+	if prunedAt, ok := report.Pruned[1]; !ok || prunedAt != 24 {
+		t.Fatalf("pruning not recorded: %v", report.Pruned)
+	}
+	update(25, 1, 4)
+}
+
+func TestBlobAvailabilityMonitorRecovery(t *testing.T) {
+	node, m := newTestBlobNode(t)
+	m.RecheckSample = 1
+	report := m.Reports[m.BeaconClients[0].Config.ClientIndex]
+
+	// The node does not serve the blobs when the block is first seen
+	node.serve.Store(false)
+	node.head.Store(1)
+	if err := m.Update(context.Background()); err != nil {
+		t.Fatalf("unable to update: %v", err)
+	}
+	if a := report.Slots[1]; a == nil || a.Available() || len(a.Missing) != 1 {
+		t.Fatalf("missing blobs not reported: %+v", a)
+	}
+
+	// The blobs of the block are fully verified again once served
+	node.serve.Store(true)
+	node.head.Store(2)
+	if err := m.Update(context.Background()); err != nil {
+		t.Fatalf("unable to update: %v", err)
+	}
+	if node.full.Load() != 2 || node.indexed.Load() != 0 {
+		t.Fatalf(
+			"incorrect requests: %d full and %d indexed",
+			node.full.Load(),
+			node.indexed.Load(),
+		)
+	}
+	if a := report.Slots[1]; a == nil || !a.Available() || a.Served != 1 {
+		t.Fatalf("recovered blobs not available: %+v", a)
+	}
+	if len(report.Unavailable()) != 0 {
+		t.Fatalf("unexpected unavailable blobs: %+v", report.Unavailable())
+	}
+}
@@ -0,0 +1,250 @@
+package beacon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/ztyp/tree"
+)
+
+const (
+	// Depth of the deneb beacon block body merkle tree (12 fields padded to
+	// 16 leaves)
+	BEACON_BLOCK_BODY_TREE_DEPTH = 4
+	// Index of the blob_kzg_commitments field in the deneb beacon block body
+	BLOB_KZG_COMMITMENTS_FIELD_INDEX = 11
+)
+
+// Verifies the KZG proof of the sidecar against its blob and commitment,
+// using the same trusted setup as the execution clients built from
+// go-ethereum
+func VerifyBlobSidecarKZGProof(sidecar *deneb.BlobSidecar) error {
+	var blob kzg4844.Blob
+	if len(sidecar.Blob) != len(blob) {
+		return fmt.Errorf(
+			"blob sidecar %d: incorrect blob length: want %d, got %d",
+			sidecar.Index,
+			len(blob),
+			len(sidecar.Blob),
+		)
+	}
+	copy(blob[:], sidecar.Blob)
+	if err := kzg4844.VerifyBlobProof(
+		blob,
+		kzg4844.Commitment(sidecar.KZGCommitment),
+		kzg4844.Proof(sidecar.KZGProof),
+	); err != nil {
+		return fmt.Errorf(
+			"blob sidecar %d: invalid kzg proof: %v",
+			sidecar.Index,
+			err,
+		)
+	}
+	return nil
+}
+
+// Returns the index of the commitment leaf within the subtree of depth
+// KZG_COMMITMENT_INCLUSION_PROOF_DEPTH rooted at the block body
+func BlobKZGCommitmentSubtreeIndex(
+	spec *common.Spec,
+	blobIndex uint64,
+) uint64 {
+	// Commitments list depth, plus one for the length mix-in
+	listDepth := uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH) -
+		BEACON_BLOCK_BODY_TREE_DEPTH
+	return BLOB_KZG_COMMITMENTS_FIELD_INDEX<<listDepth + blobIndex
+}
+
+func isValidMerkleBranch(
+	leaf tree.Root,
+	branch []tree.Root,
+	depth uint64,
+	index uint64,
+	root tree.Root,
+) bool {
+	if uint64(len(branch)) != depth {
+		return false
+	}
+	hFn := tree.GetHashFn()
+	value := leaf
+	for i := uint64(0); i < depth; i++ {
+		if (index>>i)&1 == 1 {
+			value = hFn(branch[i], value)
+		} else {
+			value = hFn(value, branch[i])
+		}
+	}
+	return value == root
+}
+
+// Verifies the inclusion proof of the sidecar commitment against the body
+// root of the sidecar block header
+func VerifyBlobSidecarInclusionProof(
+	spec *common.Spec,
+	sidecar *deneb.BlobSidecar,
+) error {
+	if uint64(sidecar.Index) >= uint64(spec.MAX_BLOB_COMMITMENTS_PER_BLOCK) {
+		return fmt.Errorf("blob sidecar %d: invalid index", sidecar.Index)
+	}
+	if !isValidMerkleBranch(
+		sidecar.KZGCommitment.HashTreeRoot(tree.GetHashFn()),
+		sidecar.KZGCommitmentInclusionProof,
+		uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH),
+		BlobKZGCommitmentSubtreeIndex(spec, uint64(sidecar.Index)),
+		sidecar.SignedBlockHeader.Message.BodyRoot,
+	) {
+		return fmt.Errorf(
+			"blob sidecar %d: invalid commitment inclusion proof",
+			sidecar.Index,
+		)
+	}
+	return nil
+}
+
+// Returns the blob versioned hashes of all blob transactions in the list, in
+// order
+func BlobTransactionsVersionedHashes(txs [][]byte) ([]tree.Root, error) {
+	versionedHashes := make([]tree.Root, 0)
+	for i, txBytes := range txs {
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(txBytes); err != nil {
+			return nil, fmt.Errorf("unable to decode transaction %d: %v", i, err)
+		}
+		if tx.Type() != types.BlobTxType {
+			continue
+		}
+		for _, h := range tx.BlobHashes() {
+			versionedHashes = append(versionedHashes, tree.Root(h))
+		}
+	}
+	return versionedHashes, nil
+}
+
+// Validates the blob sidecars of the given block:
+//   - The sidecar header matches the block
+//   - The KZG proof is valid for the blob and commitment
+//   - The commitment inclusion proof is valid against the block body root
+//   - The commitments match the ones included in the block
+//   - The versioned hashes of the block commitments match the blob
+//     transactions in the execution payload
+//
+// All failures found are joined in the returned error.
+func ValidateBlobSidecars(
+	spec *common.Spec,
+	block *VersionedSignedBeaconBlock,
+	sidecars []deneb.BlobSidecar,
+) error {
+	if !block.ContainsKZGCommitments() {
+		if len(sidecars) > 0 {
+			return fmt.Errorf(
+				"%d blob sidecars for a %s block",
+				len(sidecars),
+				block.Version,
+			)
+		}
+		return nil
+	}
+	var (
+		errs        = make([]error, 0)
+		commitments = block.KZGCommitments()
+		blockRoot   = block.Root()
+		seen        = make(map[deneb.BlobIndex]bool)
+	)
+	for i := range sidecars {
+		sidecar := &sidecars[i]
+		if seen[sidecar.Index] {
+			errs = append(
+				errs,
+				fmt.Errorf("blob sidecar %d: duplicate index", sidecar.Index),
+			)
+			continue
+		}
+		seen[sidecar.Index] = true
+		if headerRoot := sidecar.SignedBlockHeader.Message.HashTreeRoot(
+			tree.GetHashFn(),
+		); headerRoot != blockRoot {
+			errs = append(errs, fmt.Errorf(
+				"blob sidecar %d: header root %s does not match block root %s",
+				sidecar.Index,
+				headerRoot,
+				blockRoot,
+			))
+		}
+		if int(sidecar.Index) >= len(commitments) {
+			errs = append(errs, fmt.Errorf(
+				"blob sidecar %d: index out of range, block contains %d commitments",
+				sidecar.Index,
+				len(commitments),
+			))
+		} else if sidecar.KZGCommitment != commitments[sidecar.Index] {
+			errs = append(errs, fmt.Errorf(
+				"blob sidecar %d: commitment %s does not match block commitment %s",
+				sidecar.Index,
+				sidecar.KZGCommitment,
+				commitments[sidecar.Index],
+			))
+		}
+		if err := VerifyBlobSidecarInclusionProof(spec, sidecar); err != nil {
+			errs = append(errs, err)
+		}
+		if err := VerifyBlobSidecarKZGProof(sidecar); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(sidecars) != len(commitments) {
+		errs = append(errs, fmt.Errorf(
+			"incorrect number of blob sidecars: want %d, got %d",
+			len(commitments),
+			len(sidecars),
+		))
+	}
+
+	payload, versionedHashes, _, err := block.ExecutionPayload()
+	if err != nil {
+		errs = append(errs, err)
+	} else if txHashes, err := BlobTransactionsVersionedHashes(
+		payload.Transactions,
+	); err != nil {
+		errs = append(errs, err)
+	} else if len(txHashes) != len(versionedHashes) {
+		errs = append(errs, fmt.Errorf(
+			"incorrect number of blob versioned hashes in payload: want %d, got %d",
+			len(versionedHashes),
+			len(txHashes),
+		))
+	} else {
+		for i := range txHashes {
+			if txHashes[i] != tree.Root(versionedHashes[i]) {
+				errs = append(errs, fmt.Errorf(
+					"blob versioned hash %d mismatch: want %s, got %s",
+					i,
+					versionedHashes[i],
+					txHashes[i],
+				))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Fetches the block and its blob sidecars and validates them
+func (bn *BeaconClient) VerifyBlobSidecars(
+	ctx context.Context,
+	blockId eth2api.BlockId,
+) error {
+	block, err := bn.BlockV2(ctx, blockId)
+	if err != nil {
+		return err
+	}
+	sidecars, err := bn.BlobSidecars(ctx, blockId)
+	if err != nil {
+		return err
+	}
+	return ValidateBlobSidecars(bn.Config.Spec, block, sidecars)
+}
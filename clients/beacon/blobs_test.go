@@ -0,0 +1,196 @@
+/*
+Tests for the blob sidecar validation
+*/
+package beacon
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+	"github.com/holiman/uint256"
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// Returns a deneb block with the given number of blobs, whose versioned hashes
+// are included in a blob transaction of the payload, and the valid sidecars of
+// the blobs
+func testBlobBlock(
+	t *testing.T,
+	spec *common.Spec,
+	count int,
+) (*VersionedSignedBeaconBlock, []deneb.BlobSidecar) {
+	t.Helper()
+	blobs := make([]kzg4844.Blob, count)
+	for i := range blobs {
+		// Small values keep every field element canonical
+		blobs[i][31] = byte(i + 1)
+	}
+	block := new(deneb.SignedBeaconBlock)
+	body := &block.Message.Body
+	sidecars := make([]deneb.BlobSidecar, len(blobs))
+	for i, blob := range blobs {
+		commitment, err := kzg4844.BlobToCommitment(blob)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		proof, err := kzg4844.ComputeBlobProof(blob, commitment)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		body.BlobKZGCommitments = append(
+			body.BlobKZGCommitments,
+			common.KZGCommitment(commitment),
+		)
+		sidecars[i] = deneb.BlobSidecar{
+			Index:         deneb.BlobIndex(i),
+			Blob:          append(deneb.Blob{}, blob[:]...),
+			KZGCommitment: common.KZGCommitment(commitment),
+			KZGProof:      common.KZGProof(proof),
+		}
+	}
+	txBytes, err := types.NewTx(&types.BlobTx{
+		ChainID:    uint256.NewInt(1),
+		Gas:        21000,
+		BlobHashes: KZGCommitmentsToVersionedHashes(body.BlobKZGCommitments),
+	}).MarshalBinary()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	body.ExecutionPayload.Transactions = common.PayloadTransactions{txBytes}
+	bodyRoot := body.HashTreeRoot(spec, tree.GetHashFn())
+	depth := uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH)
+	for i := range sidecars {
+		gindex := tree.Gindex64(
+			1<<depth + BlobKZGCommitmentSubtreeIndex(spec, uint64(i)),
+		)
+		sidecars[i].SignedBlockHeader.Message.BodyRoot = bodyRoot
+		sidecars[i].KZGCommitmentInclusionProof = body.HashTreeProof(
+			spec,
+			tree.GetHashFn(),
+			gindex,
+		)
+	}
+	return &VersionedSignedBeaconBlock{
+		VersionedSignedBeaconBlock: &eth2api.VersionedSignedBeaconBlock{
+			Version: "deneb",
+			Data:    block,
+		},
+		spec: spec,
+	}, sidecars
+}
+
+func TestVerifyBlobSidecar(t *testing.T) {
+	spec := configs.Minimal
+	_, sidecars := testBlobBlock(t, spec, 3)
+
+	for i := range sidecars {
+		if err := VerifyBlobSidecarKZGProof(&sidecars[i]); err != nil {
+			t.Fatalf("sidecar %d: %v", i, err)
+		}
+		if err := VerifyBlobSidecarInclusionProof(spec, &sidecars[i]); err != nil {
+			t.Fatalf("sidecar %d: %v", i, err)
+		}
+	}
+
+	// Swapped proofs and commitments must be rejected
+	invalid := sidecars[0]
+	invalid.KZGProof = sidecars[1].KZGProof
+	if err := VerifyBlobSidecarKZGProof(&invalid); err == nil {
+		t.Fatalf("expected invalid kzg proof error")
+	}
+	invalid = sidecars[0]
+	invalid.KZGCommitment = sidecars[1].KZGCommitment
+	if err := VerifyBlobSidecarInclusionProof(spec, &invalid); err == nil {
+		t.Fatalf("expected invalid inclusion proof error")
+	}
+	invalid = sidecars[0]
+	invalid.Index = 1
+	if err := VerifyBlobSidecarInclusionProof(spec, &invalid); err == nil {
+		t.Fatalf("expected invalid inclusion proof error")
+	}
+}
+
+func TestValidateBlobSidecars(t *testing.T) {
+	spec := configs.Minimal
+	block, sidecars := testBlobBlock(t, spec, 3)
+	if err := ValidateBlobSidecars(spec, block, sidecars); err != nil {
+		t.Fatalf("valid sidecars rejected: %v", err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		modify func(block *deneb.SignedBeaconBlock, sidecars []deneb.BlobSidecar) []deneb.BlobSidecar
+		errors []string
+	}{
+		{
+			name: "header root mismatch",
+			modify: func(_ *deneb.SignedBeaconBlock, sidecars []deneb.BlobSidecar) []deneb.BlobSidecar {
+				sidecars[1].SignedBlockHeader.Message.Slot = 1
+				return sidecars
+			},
+			errors: []string{"blob sidecar 1: header root"},
+		},
+		{
+			name: "commitment mismatch",
+			modify: func(_ *deneb.SignedBeaconBlock, sidecars []deneb.BlobSidecar) []deneb.BlobSidecar {
+				sidecars[0].KZGCommitment = sidecars[1].KZGCommitment
+				return sidecars
+			},
+			errors: []string{"blob sidecar 0: commitment"},
+		},
+		{
+			name: "index out of range",
+			modify: func(_ *deneb.SignedBeaconBlock, sidecars []deneb.BlobSidecar) []deneb.BlobSidecar {
+				sidecars[2].Index = 3
+				return sidecars
+			},
+			errors: []string{"blob sidecar 3: index out of range"},
+		},
+		{
+			name: "missing sidecar",
+			modify: func(_ *deneb.SignedBeaconBlock, sidecars []deneb.BlobSidecar) []deneb.BlobSidecar {
+				return sidecars[:2]
+			},
+			errors: []string{"incorrect number of blob sidecars: want 3, got 2"},
+		},
+		{
+			name: "versioned hash mismatch",
+			modify: func(block *deneb.SignedBeaconBlock, sidecars []deneb.BlobSidecar) []deneb.BlobSidecar {
+				// Blob transaction with the versioned hashes in reverse order
+				hashes := KZGCommitmentsToVersionedHashes(block.Message.Body.BlobKZGCommitments)
+				for i, j := 0, len(hashes)-1; i < j; i, j = i+1, j-1 {
+					hashes[i], hashes[j] = hashes[j], hashes[i]
+				}
+				txBytes, err := types.NewTx(&types.BlobTx{
+					ChainID:    uint256.NewInt(1),
+					Gas:        21000,
+					BlobHashes: hashes,
+				}).MarshalBinary()
+				if err != nil {
+					t.Fatalf("%v", err)
+				}
+				block.Message.Body.ExecutionPayload.Transactions = common.PayloadTransactions{txBytes}
+				return sidecars
+			},
+			errors: []string{"blob versioned hash 0 mismatch", "blob versioned hash 2 mismatch"},
+		},
+	} {
+		block, sidecars := testBlobBlock(t, spec, 3)
+		sidecars = test.modify(block.Data.(*deneb.SignedBeaconBlock), sidecars)
+		err := ValidateBlobSidecars(spec, block, sidecars)
+		if err == nil {
+			t.Fatalf("%s: expected validation error", test.name)
+		}
+		for _, expected := range test.errors {
+			if !strings.Contains(err.Error(), expected) {
+				t.Fatalf("%s: missing error %q: %v", test.name, expected, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package beacon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// File names of the anchor exported by WriteSSZ
+const (
+	CheckpointStateFileName = "state.ssz"
+	CheckpointBlockFileName = "block.ssz"
+)
+
+// Finalized state and block served by a beacon client, used to start other
+// clients from a checkpoint
+type CheckpointSyncAnchor struct {
+	State *VersionedBeaconStateResponse
+	Block *VersionedSignedBeaconBlock
+}
+
+// Fetches the finalized state and block of the beacon client, verifying
+// that the block is the latest block header of the state
+func (bn *BeaconClient) FinalizedAnchor(
+	ctx context.Context,
+) (*CheckpointSyncAnchor, error) {
+	state, err := bn.BeaconStateV2(ctx, eth2api.StateFinalized)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get finalized state: %v", err)
+	}
+	block, err := bn.BlockV2(ctx, eth2api.BlockFinalized)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get finalized block: %v", err)
+	}
+	c := &CheckpointSyncAnchor{State: state, Block: block}
+	if err := c.Verify(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Verifies that the block is the latest block applied to the state
+func (c *CheckpointSyncAnchor) Verify() error {
+	header := c.State.LatestBlockHeader()
+	// The state root of the latest header is only filled in by the next slot
+	// processing
+	if header.StateRoot == (tree.Root{}) {
+		header.StateRoot = c.State.Root()
+	}
+	if root := header.HashTreeRoot(tree.GetHashFn()); root != c.Block.Root() {
+		return fmt.Errorf(
+			"state latest block header %s does not match block %s",
+			root,
+			c.Block.Root(),
+		)
+	}
+	return nil
+}
+
+func (c *CheckpointSyncAnchor) StateRoot() tree.Root {
+	return c.State.Root()
+}
+
+func (c *CheckpointSyncAnchor) BlockRoot() tree.Root {
+	return c.Block.Root()
+}
+
+func encodeSSZ(spec *common.Spec, obj interface{}) ([]byte, error) {
+	specObj, ok := obj.(common.SpecObj)
+	if !ok {
+		return nil, fmt.Errorf("%T is not ssz encodable", obj)
+	}
+	var buf bytes.Buffer
+	if err := specObj.Serialize(spec, codec.NewEncodingWriter(&buf)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Writes the SSZ encoded state and block into the directory, returns the
+// paths of both files
+func (c *CheckpointSyncAnchor) WriteSSZ(dir string) (string, string, error) {
+	if c.State.Data == nil || c.Block.VersionedSignedBeaconBlock == nil {
+		return "", "", fmt.Errorf("incomplete anchor")
+	}
+	var (
+		statePath = filepath.Join(dir, CheckpointStateFileName)
+		blockPath = filepath.Join(dir, CheckpointBlockFileName)
+	)
+	for _, f := range []struct {
+		path string
+		spec *common.Spec
+		obj  interface{}
+	}{
+		{statePath, c.State.spec, c.State.Data},
+		{blockPath, c.Block.spec, c.Block.Data},
+	} {
+		data, err := encodeSSZ(f.spec, f.obj)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to encode %s: %v", f.path, err)
+		}
+		if err := os.WriteFile(f.path, data, 0o644); err != nil {
+			return "", "", err
+		}
+	}
+	return statePath, blockPath, nil
+}
+
+// Verifies that the finalized checkpoint of the beacon client matches the
+// finalized checkpoint of the trusted client.
+// If both clients finalized the same epoch, the checkpoints must be equal. If
+// the trusted client finalized a later epoch, the finalized block of the
+// beacon client must be canonical on the trusted client. In both cases the
+// states at the start slot of the finalized epoch of the beacon client must
+// be equal. The beacon client must not be ahead of the trusted client.
+func (bn *BeaconClient) VerifyFinalizedCheckpoint(
+	ctx context.Context,
+	trusted *BeaconClient,
+) error {
+	// Use a single finalized checkpoint of each client for all queries in
+	// case finality advances meanwhile
+	checkpoints, err := bn.StateFinalityCheckpoints(ctx, eth2api.StateHead)
+	if err != nil {
+		return fmt.Errorf("failed to get finality checkpoints: %v", err)
+	}
+	trustedCheckpoints, err := trusted.StateFinalityCheckpoints(
+		ctx,
+		eth2api.StateHead,
+	)
+	if err != nil {
+		return fmt.Errorf(
+			"failed to get finality checkpoints from trusted client: %v",
+			err,
+		)
+	}
+	var (
+		finalized        = checkpoints.Finalized
+		trustedFinalized = trustedCheckpoints.Finalized
+	)
+	if finalized.Epoch > trustedFinalized.Epoch {
+		return fmt.Errorf(
+			"finalized epoch %d ahead of trusted client finalized epoch %d",
+			finalized.Epoch,
+			trustedFinalized.Epoch,
+		)
+	}
+
+	if finalized.Epoch == trustedFinalized.Epoch {
+		if finalized.Root != trustedFinalized.Root {
+			return fmt.Errorf(
+				"finalized checkpoint mismatch at epoch %d: want %s, got %s",
+				finalized.Epoch,
+				trustedFinalized.Root,
+				finalized.Root,
+			)
+		}
+	} else {
+		// The trusted client finalized a later epoch
+		trustedHeader, err := trusted.BlockHeader(
+			ctx,
+			eth2api.BlockIdRoot(finalized.Root),
+		)
+		if err != nil {
+			return fmt.Errorf(
+				"finalized block %s not found on trusted client: %v",
+				finalized.Root,
+				err,
+			)
+		}
+		if !trustedHeader.Canonical {
+			return fmt.Errorf(
+				"finalized block %s not canonical on trusted client",
+				finalized.Root,
+			)
+		}
+	}
+
+	// The finalized state is requested by slot rather than through the
+	// finalized state id, which could refer to a later checkpoint by now and
+	// is not served consistently across clients
+	slot, err := bn.Config.Spec.EpochStartSlot(finalized.Epoch)
+	if err != nil {
+		return err
+	}
+	stateRoot, err := bn.StateRoot(ctx, eth2api.StateIdSlot(slot))
+	if err != nil {
+		return fmt.Errorf("failed to get finalized state root: %v", err)
+	}
+	trustedStateRoot, err := trusted.StateRoot(ctx, eth2api.StateIdSlot(slot))
+	if err != nil {
+		return fmt.Errorf(
+			"failed to get state root at slot %d from trusted client: %v",
+			slot,
+			err,
+		)
+	}
+	if stateRoot != trustedStateRoot {
+		return fmt.Errorf(
+			"finalized state root mismatch at slot %d: want %s, got %s",
+			slot,
+			trustedStateRoot,
+			stateRoot,
+		)
+	}
+	return nil
+}
+
+// Waits until the beacon client serves the chain of blocks from its
+// finalized block back to the given slot, following the parent roots
+func (bn *BeaconClient) WaitForBackfill(
+	ctx context.Context,
+	slot common.Slot,
+) error {
+	oldest, err := bn.BlockHeader(ctx, eth2api.BlockFinalized)
+	if err != nil {
+		return fmt.Errorf("failed to get finalized block: %v", err)
+	}
+	timer := bn.NewSlotTicker()
+	defer timer.Stop()
+
+	for {
+		for oldest.Header.Message.Slot > slot {
+			parent, err := bn.BlockHeader(
+				ctx,
+				eth2api.BlockIdRoot(oldest.Header.Message.ParentRoot),
+			)
+			if err != nil {
+				// Parent not backfilled yet
+				break
+			}
+			oldest = parent
+		}
+		if oldest.Header.Message.Slot <= slot {
+			return nil
+		}
+		bn.Logf(
+			"WaitForBackfill: beacon %d (%s): oldest block at slot %d, waiting for slot %d\n",
+			bn.Config.ClientIndex,
+			bn.ClientName(),
+			oldest.Header.Message.Slot,
+			slot,
+		)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf(
+				"backfill did not reach slot %d, oldest block at slot %d: %v",
+				slot,
+				oldest.Header.Message.Slot,
+				ctx.Err(),
+			)
+		case <-timer.C():
+		}
+	}
+}
@@ -0,0 +1,329 @@
+/*
+Tests for the checkpoint sync helpers
+*/
+package beacon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marioevz/eth-clients/clients/utils"
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/bellatrix"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+)
+
+func TestCheckpointSyncAnchor(t *testing.T) {
+	var (
+		spec  = configs.Minimal
+		hFn   = tree.GetHashFn()
+		block = new(bellatrix.SignedBeaconBlock)
+		state = new(bellatrix.BeaconState)
+	)
+	block.Message.Slot = 16
+	block.Message.Body.SyncAggregate.SyncCommitteeBits = make(
+		altair.SyncCommitteeBits,
+		(spec.SYNC_COMMITTEE_SIZE+7)/8,
+	)
+	state.Slot = 16
+	state.LatestBlockHeader = common.BeaconBlockHeader{
+		Slot:     16,
+		BodyRoot: block.Message.Body.HashTreeRoot(spec, hFn),
+	}
+	// Vectors must be complete to be serialized
+	state.BlockRoots = make(phase0.HistoricalBatchRoots, spec.SLOTS_PER_HISTORICAL_ROOT)
+	state.StateRoots = make(phase0.HistoricalBatchRoots, spec.SLOTS_PER_HISTORICAL_ROOT)
+	state.RandaoMixes = make(phase0.RandaoMixes, spec.EPOCHS_PER_HISTORICAL_VECTOR)
+	state.Slashings = make(phase0.SlashingsHistory, spec.EPOCHS_PER_SLASHINGS_VECTOR)
+	state.CurrentSyncCommittee.Pubkeys = make([]common.BLSPubkey, spec.SYNC_COMMITTEE_SIZE)
+	state.NextSyncCommittee.Pubkeys = make([]common.BLSPubkey, spec.SYNC_COMMITTEE_SIZE)
+	block.Message.StateRoot = state.HashTreeRoot(spec, hFn)
+
+	anchor := &CheckpointSyncAnchor{
+		State: &VersionedBeaconStateResponse{
+			VersionedBeaconState: &eth2api.VersionedBeaconState{
+				Version: "bellatrix",
+				Data:    state,
+			},
+			spec: spec,
+		},
+		Block: &VersionedSignedBeaconBlock{
+			VersionedSignedBeaconBlock: &eth2api.VersionedSignedBeaconBlock{
+				Version: "bellatrix",
+				Data:    block,
+			},
+			spec: spec,
+		},
+	}
+	if err := anchor.Verify(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	statePath, blockPath, err := anchor.WriteSSZ(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to write anchor: %v", err)
+	}
+	for _, f := range []struct {
+		path string
+		obj  common.SpecObj
+		root tree.Root
+	}{
+		{statePath, new(bellatrix.BeaconState), anchor.StateRoot()},
+		{blockPath, new(bellatrix.SignedBeaconBlock), block.HashTreeRoot(spec, hFn)},
+	} {
+		data, err := os.ReadFile(f.path)
+		if err != nil {
+			t.Fatalf("unable to read %s: %v", f.path, err)
+		}
+		if err := f.obj.Deserialize(
+			spec,
+			codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data))),
+		); err != nil {
+			t.Fatalf("unable to decode %s: %v", f.path, err)
+		}
+		if root := f.obj.HashTreeRoot(spec, hFn); root != f.root {
+			t.Fatalf("incorrect root of %s: want %s, got %s", f.path, f.root, root)
+		}
+	}
+
+	// Blocks that are not the latest block of the state are rejected
+	block.Message.Slot = 15
+	if err := anchor.Verify(); err == nil {
+		t.Fatalf("mismatching block accepted")
+	}
+}
+
+func TestWaitForBackfill(t *testing.T) {
+	var (
+		hFn     = tree.GetHashFn()
+		headers = make(map[tree.Root]common.BeaconBlockHeader)
+		parent  tree.Root
+		head    tree.Root
+		// Oldest slot served by the client
+		backfilled atomic.Uint64
+	)
+	for slot := common.Slot(0); slot <= 10; slot++ {
+		header := common.BeaconBlockHeader{Slot: slot, ParentRoot: parent}
+		parent = header.HashTreeRoot(hFn)
+		headers[parent] = header
+		head = parent
+	}
+	backfilled.Store(8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/eth/v1/beacon/headers/")
+		root := head
+		if id != "finalized" {
+			if err := root.UnmarshalText([]byte(id)); err != nil {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		header, ok := headers[root]
+		if !ok || uint64(header.Slot) < backfilled.Load() {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": eth2api.BeaconBlockHeaderAndInfo{
+				Root:      root,
+				Canonical: true,
+				Header:    common.SignedBeaconBlockHeader{Message: header},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	var (
+		fake  = utils.NewFakeClock(time.Unix(int64(testGenesisTime), 0))
+		bn    = testBeaconClient(t, srv.URL, fake)
+		errCh = make(chan error, 1)
+	)
+	go func() {
+		errCh <- bn.WaitForBackfill(context.Background(), 3)
+	}()
+	// Not done until the client serves the block at the requested slot
+	fake.BlockUntil(1)
+	select {
+	case err := <-errCh:
+		t.Fatalf("wait returned before backfill: %v", err)
+	default:
+	}
+	backfilled.Store(2)
+	fake.Advance(bn.SlotClock().SlotDuration())
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for backfill")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	backfilled.Store(8)
+	if err := bn.WaitForBackfill(ctx, 3); err == nil {
+		t.Fatalf("incomplete backfill returned no error")
+	}
+}
+
+// Beacon node that serves a finalized checkpoint, the headers of its
+// canonical blocks and its state roots
+type testFinalityNode struct {
+	finalized          common.Checkpoint
+	finalizedStateRoot tree.Root
+	canonical          map[tree.Root]bool
+	stateRoots         map[common.Slot]tree.Root
+}
+
+func (n *testFinalityNode) serve(t *testing.T) *BeaconClient {
+	respond := func(w http.ResponseWriter, v interface{}) {
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{"data": v}); err != nil {
+			t.Errorf("unable to encode response: %v", err)
+		}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		switch {
+		case path == "/eth/v1/beacon/states/head/finality_checkpoints":
+			respond(w, eth2api.FinalityCheckpoints{Finalized: n.finalized})
+		case path == "/eth/v1/beacon/states/finalized/root":
+			respond(w, eth2api.RootResponse{Root: n.finalizedStateRoot})
+		case strings.HasPrefix(path, "/eth/v1/beacon/states/"):
+			slot, err := strconv.ParseUint(
+				strings.TrimSuffix(strings.TrimPrefix(path, "/eth/v1/beacon/states/"), "/root"),
+				10,
+				64,
+			)
+			root, ok := n.stateRoots[common.Slot(slot)]
+			if err != nil || !ok {
+				http.NotFound(w, r)
+				return
+			}
+			respond(w, eth2api.RootResponse{Root: root})
+		case strings.HasPrefix(path, "/eth/v1/beacon/headers/"):
+			var root tree.Root
+			if err := root.UnmarshalText(
+				[]byte(strings.TrimPrefix(path, "/eth/v1/beacon/headers/")),
+			); err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			canonical, ok := n.canonical[root]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			respond(w, eth2api.BeaconBlockHeaderAndInfo{
+				Root:      root,
+				Canonical: canonical,
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return testBeaconClient(t, srv.URL, nil)
+}
+
+func TestVerifyFinalizedCheckpoint(t *testing.T) {
+	var (
+		spec       = configs.Minimal
+		checkpoint = common.Checkpoint{Epoch: 2, Root: tree.Root{0x02}}
+		slot       = common.Slot(2) * spec.SLOTS_PER_EPOCH
+	)
+	newNode := func() *testFinalityNode {
+		return &testFinalityNode{
+			finalized:          checkpoint,
+			finalizedStateRoot: tree.Root{0x12},
+			canonical:          map[tree.Root]bool{checkpoint.Root: true},
+			stateRoots:         map[common.Slot]tree.Root{slot: tree.Root{0x22}},
+		}
+	}
+	for _, test := range []struct {
+		name    string
+		modify  func(n, trusted *testFinalityNode)
+		wantErr bool
+	}{
+		{
+			name:   "same checkpoint",
+			modify: func(n, trusted *testFinalityNode) {},
+		},
+		{
+			name: "different checkpoint root",
+			modify: func(n, trusted *testFinalityNode) {
+				n.finalized.Root = tree.Root{0x03}
+			},
+			wantErr: true,
+		},
+		{
+			name: "different state at the finalized epoch",
+			modify: func(n, trusted *testFinalityNode) {
+				n.stateRoots[slot] = tree.Root{0x23}
+			},
+			wantErr: true,
+		},
+		{
+			name: "finalized state served differently",
+			modify: func(n, trusted *testFinalityNode) {
+				n.finalizedStateRoot = tree.Root{0x13}
+			},
+		},
+		{
+			name: "ahead of the trusted client",
+			modify: func(n, trusted *testFinalityNode) {
+				n.finalized = common.Checkpoint{Epoch: 3, Root: tree.Root{0x03}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "trusted client ahead",
+			modify: func(n, trusted *testFinalityNode) {
+				trusted.finalized = common.Checkpoint{Epoch: 3, Root: tree.Root{0x03}}
+				trusted.finalizedStateRoot = tree.Root{0x13}
+			},
+		},
+		{
+			name: "trusted client ahead on another chain",
+			modify: func(n, trusted *testFinalityNode) {
+				trusted.finalized = common.Checkpoint{Epoch: 3, Root: tree.Root{0x03}}
+				trusted.canonical[checkpoint.Root] = false
+			},
+			wantErr: true,
+		},
+		{
+			name: "trusted client ahead with a different state",
+			modify: func(n, trusted *testFinalityNode) {
+				trusted.finalized = common.Checkpoint{Epoch: 3, Root: tree.Root{0x03}}
+				trusted.stateRoots[slot] = tree.Root{0x23}
+			},
+			wantErr: true,
+		},
+	} {
+		n, trusted := newNode(), newNode()
+		test.modify(n, trusted)
+		err := n.serve(t).VerifyFinalizedCheckpoint(
+			context.Background(),
+			trusted.serve(t),
+		)
+		if test.wantErr && err == nil {
+			t.Fatalf("%s: expected error", test.name)
+		} else if !test.wantErr && err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+}
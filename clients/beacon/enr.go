@@ -0,0 +1,252 @@
+package beacon
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"net"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/codec"
+)
+
+// Decoded Ethereum Node Record of a beacon client.
+// Fields that are not present in the record are left empty.
+type ENR struct {
+	Node *enode.Node
+
+	IP    net.IP
+	TCP   int
+	UDP   int
+	QUIC  int
+	IP6   net.IP
+	TCP6  int
+	UDP6  int
+	QUIC6 int
+
+	Eth2     *common.Eth2Data
+	Attnets  *common.AttnetBits
+	Syncnets *common.SyncnetBits
+}
+
+// Loads an optional entry, returns whether the entry is present
+func loadENREntry(node *enode.Node, entry enr.Entry) (bool, error) {
+	if err := node.Load(entry); err != nil {
+		if enr.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+type sszFixedObj interface {
+	Deserialize(dr *codec.DecodingReader) error
+	FixedLength() uint64
+}
+
+// Loads an optional entry that contains an ssz encoded object
+func loadENRSSZEntry(
+	node *enode.Node,
+	key string,
+	obj sszFixedObj,
+) (bool, error) {
+	var raw []byte
+	if ok, err := loadENREntry(node, enr.WithEntry(key, &raw)); err != nil || !ok {
+		return ok, err
+	}
+	if uint64(len(raw)) != obj.FixedLength() {
+		return false, fmt.Errorf(
+			"invalid %s entry length: want %d, got %d",
+			key,
+			obj.FixedLength(),
+			len(raw),
+		)
+	}
+	if err := obj.Deserialize(
+		codec.NewDecodingReader(bytes.NewReader(raw), uint64(len(raw))),
+	); err != nil {
+		return false, fmt.Errorf("invalid %s entry: %v", key, err)
+	}
+	return true, nil
+}
+
+// Decodes the text representation of an ENR ("enr:..."), verifying its
+// signature
+func ParseENR(s string) (*ENR, error) {
+	node, err := enode.Parse(enode.ValidSchemes, s)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		e        = &ENR{Node: node}
+		ip4      enr.IPv4
+		ip6      enr.IPv6
+		eth2     common.Eth2Data
+		attnets  common.AttnetBits
+		syncnets common.SyncnetBits
+	)
+	if ok, err := loadENREntry(node, &ip4); err != nil {
+		return nil, err
+	} else if ok {
+		e.IP = net.IP(ip4)
+	}
+	if ok, err := loadENREntry(node, &ip6); err != nil {
+		return nil, err
+	} else if ok {
+		e.IP6 = net.IP(ip6)
+	}
+	for _, port := range []struct {
+		key  string
+		dest *int
+	}{
+		{"tcp", &e.TCP},
+		{"udp", &e.UDP},
+		{"quic", &e.QUIC},
+		{"tcp6", &e.TCP6},
+		{"udp6", &e.UDP6},
+		{"quic6", &e.QUIC6},
+	} {
+		var value uint16
+		if ok, err := loadENREntry(node, enr.WithEntry(port.key, &value)); err != nil {
+			return nil, err
+		} else if ok {
+			*port.dest = int(value)
+		}
+	}
+	if ok, err := loadENRSSZEntry(node, "eth2", &eth2); err != nil {
+		return nil, err
+	} else if ok {
+		e.Eth2 = &eth2
+	}
+	if ok, err := loadENRSSZEntry(node, "attnets", &attnets); err != nil {
+		return nil, err
+	} else if ok {
+		e.Attnets = &attnets
+	}
+	if ok, err := loadENRSSZEntry(node, "syncnets", &syncnets); err != nil {
+		return nil, err
+	} else if ok {
+		e.Syncnets = &syncnets
+	}
+	return e, nil
+}
+
+// Returns the indexes of the attestation subnets the node is subscribed to
+func (e *ENR) AttestationSubnets() []uint64 {
+	if e.Attnets == nil {
+		return nil
+	}
+	return bitfieldIndexes(e.Attnets[:], e.Attnets.BitLen())
+}
+
+// Returns the indexes of the sync committee subnets the node is subscribed
+// to
+func (e *ENR) SyncCommitteeSubnets() []uint64 {
+	if e.Syncnets == nil {
+		return nil
+	}
+	return bitfieldIndexes(e.Syncnets[:], e.Syncnets.BitLen())
+}
+
+func bitfieldIndexes(bits []byte, bitLen uint64) []uint64 {
+	indexes := make([]uint64, 0)
+	for i := uint64(0); i < bitLen; i++ {
+		if bits[i/8]&(1<<(i%8)) != 0 {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func base58Encode(data []byte) string {
+	var (
+		x    = new(big.Int).SetBytes(data)
+		base = big.NewInt(58)
+		mod  = new(big.Int)
+		out  = make([]byte, 0)
+	)
+	for x.Sign() > 0 {
+		x.DivMod(x, base, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+// Returns the libp2p peer ID derived from the secp256k1 public key of the
+// record
+func (e *ENR) PeerID() (string, error) {
+	pubkey := e.Node.Pubkey()
+	if pubkey == nil {
+		return "", fmt.Errorf("record does not contain a secp256k1 public key")
+	}
+	compressed := crypto.CompressPubkey(pubkey)
+	// Protobuf encoded public key: key type secp256k1 (2) and key data
+	key := append([]byte{0x08, 0x02, 0x12, byte(len(compressed))}, compressed...)
+	// Identity multihash, used for keys that are at most 42 bytes long
+	multihash := append([]byte{0x00, byte(len(key))}, key...)
+	return base58Encode(multihash), nil
+}
+
+func ipMultiaddrProtocol(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ip4"
+	}
+	return "ip6"
+}
+
+// Returns the multiaddrs of the TCP and QUIC endpoints advertised by the
+// record, TCP first
+func (e *ENR) Multiaddrs() ([]string, error) {
+	peerID, err := e.PeerID()
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0)
+	for _, endpoint := range []struct {
+		ip   net.IP
+		tcp  int
+		quic int
+	}{
+		{e.IP, e.TCP, e.QUIC},
+		{e.IP6, e.TCP6, e.QUIC6},
+	} {
+		if endpoint.ip == nil {
+			continue
+		}
+		proto := ipMultiaddrProtocol(endpoint.ip)
+		if endpoint.tcp != 0 {
+			addrs = append(addrs, fmt.Sprintf(
+				"/%s/%s/tcp/%d/p2p/%s",
+				proto,
+				endpoint.ip,
+				endpoint.tcp,
+				peerID,
+			))
+		}
+		if endpoint.quic != 0 {
+			addrs = append(addrs, fmt.Sprintf(
+				"/%s/%s/udp/%d/quic-v1/p2p/%s",
+				proto,
+				endpoint.ip,
+				endpoint.quic,
+				peerID,
+			))
+		}
+	}
+	return addrs, nil
+}
@@ -0,0 +1,136 @@
+/*
+Tests for the ENR decoding utilities
+*/
+package beacon
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+func TestBase58Encode(t *testing.T) {
+	for _, test := range []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte("hello world"), "StV1DL6CwTryKyV"},
+		{[]byte{0x00, 0x00, 0x01}, "112"},
+		{[]byte{}, ""},
+	} {
+		if got := base58Encode(test.data); got != test.expected {
+			t.Fatalf("incorrect encoding of %x: want %s, got %s", test.data, test.expected, got)
+		}
+	}
+}
+
+// Private key and record of the example of EIP-778, and the libp2p peer ID
+// of the key as computed by go-libp2p
+const (
+	testENRKey    = "b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291"
+	testENRRecord = "enr:-IS4QHCYrYZbAKWCBRlAy5zzaDZXJBGkcnh4MHcBFZntXNFrdvJjX04jRzjzCBOonrkTfj499SZuOh8R33Ls8RRcy5wBgmlkgnY0gmlwhH8AAAGJc2VjcDI1NmsxoQPKY0yuDUmstAHYpMa2_oxVtw0RW_QAdpzBQA8yWM0xOIN1ZHCCdl8"
+	testENRPeerID = "16Uiu2HAmSH2XVgZqYHWucap5kuPzLnt2TsNQkoppVxB5eJGvaXwm"
+)
+
+func TestParseENR(t *testing.T) {
+	key, err := crypto.HexToECDSA(testENRKey)
+	if err != nil {
+		t.Fatalf("unable to decode key: %v", err)
+	}
+	var r enr.Record
+	r.Set(enr.IPv4(net.IPv4(10, 0, 0, 2)))
+	r.Set(enr.TCP(9100))
+	r.Set(enr.UDP(9101))
+	r.Set(enr.WithEntry("quic", uint16(9102)))
+	r.Set(enr.WithEntry("eth2", []byte{
+		0x01, 0x02, 0x03, 0x04, // fork digest
+		0x05, 0x00, 0x00, 0x00, // next fork version
+		0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // next fork epoch
+	}))
+	r.Set(enr.WithEntry("attnets", []byte{0x81, 0, 0, 0, 0, 0, 0, 0x80}))
+	r.Set(enr.WithEntry("syncnets", []byte{0x02}))
+	if err := enode.SignV4(&r, key); err != nil {
+		t.Fatalf("unable to sign record: %v", err)
+	}
+	node, err := enode.New(enode.ValidSchemes, &r)
+	if err != nil {
+		t.Fatalf("unable to create node: %v", err)
+	}
+
+	record, err := ParseENR(node.String())
+	if err != nil {
+		t.Fatalf("unable to parse record: %v", err)
+	}
+	if !record.IP.Equal(net.IPv4(10, 0, 0, 2)) || record.TCP != 9100 ||
+		record.UDP != 9101 || record.QUIC != 9102 {
+		t.Fatalf("incorrect endpoint: %s, tcp %d, udp %d, quic %d", record.IP, record.TCP, record.UDP, record.QUIC)
+	}
+	if record.Eth2 == nil ||
+		record.Eth2.ForkDigest != (common.ForkDigest{0x01, 0x02, 0x03, 0x04}) ||
+		record.Eth2.NextForkVersion != (common.Version{0x05}) ||
+		record.Eth2.NextForkEpoch != 10 {
+		t.Fatalf("incorrect eth2 entry: %+v", record.Eth2)
+	}
+	if subnets := record.AttestationSubnets(); !reflect.DeepEqual(subnets, []uint64{0, 7, 63}) {
+		t.Fatalf("incorrect attestation subnets: %v", subnets)
+	}
+	if subnets := record.SyncCommitteeSubnets(); !reflect.DeepEqual(subnets, []uint64{1}) {
+		t.Fatalf("incorrect sync committee subnets: %v", subnets)
+	}
+
+	peerID, err := record.PeerID()
+	if err != nil {
+		t.Fatalf("unable to get peer id: %v", err)
+	}
+	if peerID != testENRPeerID {
+		t.Fatalf("incorrect peer id: want %s, got %s", testENRPeerID, peerID)
+	}
+	addrs, err := record.Multiaddrs()
+	if err != nil {
+		t.Fatalf("unable to get multiaddrs: %v", err)
+	}
+	expected := []string{
+		"/ip4/10.0.0.2/tcp/9100/p2p/" + peerID,
+		"/ip4/10.0.0.2/udp/9102/quic-v1/p2p/" + peerID,
+	}
+	if !reflect.DeepEqual(addrs, expected) {
+		t.Fatalf("incorrect multiaddrs: want %v, got %v", expected, addrs)
+	}
+
+	// Records without a valid v4 signature are rejected
+	r.Set(enr.TCP(9200))
+	unsigned := enode.SignNull(&r, node.ID())
+	if _, err := ParseENR(unsigned.String()); err == nil {
+		t.Fatalf("record without signature accepted")
+	}
+}
+
+func TestParseEIP778ENR(t *testing.T) {
+	record, err := ParseENR(testENRRecord)
+	if err != nil {
+		t.Fatalf("unable to parse record: %v", err)
+	}
+	key, err := crypto.HexToECDSA(testENRKey)
+	if err != nil {
+		t.Fatalf("unable to decode key: %v", err)
+	}
+	if !record.Node.Pubkey().Equal(&key.PublicKey) {
+		t.Fatalf("record not signed by the example key")
+	}
+	if !record.IP.Equal(net.IPv4(127, 0, 0, 1)) || record.UDP != 30303 ||
+		record.TCP != 0 || record.Eth2 != nil {
+		t.Fatalf("incorrect record: %+v", record)
+	}
+	peerID, err := record.PeerID()
+	if err != nil {
+		t.Fatalf("unable to get peer id: %v", err)
+	}
+	if peerID != testENRPeerID {
+		t.Fatalf("incorrect peer id: want %s, got %s", testENRPeerID, peerID)
+	}
+}
@@ -0,0 +1,414 @@
+package beacon
+
+import (
+	"fmt"
+
+	api "github.com/ethereum/go-ethereum/beacon/engine"
+	el_common "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/holiman/uint256"
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/bellatrix"
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// Fields of a signed beacon block of any fork.
+// Fields introduced by a fork are nil in the blocks of the previous forks.
+type SignedBeaconBlockFields struct {
+	Message       common.SpecObj
+	Slot          common.Slot
+	ProposerIndex common.ValidatorIndex
+	ParentRoot    tree.Root
+	StateRoot     tree.Root
+	Signature     common.BLSSignature
+
+	// Bellatrix
+	ExecutionPayloadBlockHash *tree.Root
+	// Capella
+	Withdrawals *common.Withdrawals
+	// Deneb
+	BlobKZGCommitments *common.KZGCommitments
+}
+
+// Fields of a beacon state of any fork.
+// Fields introduced by a fork are nil in the states of the previous forks,
+// and fields removed by a fork are nil in the states of the later forks.
+type BeaconStateFields struct {
+	GenesisTime                 common.Timestamp
+	GenesisValidatorsRoot       common.Root
+	Slot                        common.Slot
+	Fork                        common.Fork
+	LatestBlockHeader           common.BeaconBlockHeader
+	BlockRoots                  phase0.HistoricalBatchRoots
+	StateRoots                  phase0.HistoricalBatchRoots
+	HistoricalRoots             phase0.HistoricalRoots
+	Eth1Data                    common.Eth1Data
+	Eth1DataVotes               phase0.Eth1DataVotes
+	Eth1DepositIndex            common.DepositIndex
+	Validators                  phase0.ValidatorRegistry
+	Balances                    phase0.Balances
+	RandaoMixes                 phase0.RandaoMixes
+	Slashings                   phase0.SlashingsHistory
+	JustificationBits           common.JustificationBits
+	PreviousJustifiedCheckpoint common.Checkpoint
+	CurrentJustifiedCheckpoint  common.Checkpoint
+	FinalizedCheckpoint         common.Checkpoint
+
+	// Phase0
+	PreviousEpochAttestations *phase0.PendingAttestations
+	CurrentEpochAttestations  *phase0.PendingAttestations
+	// Altair
+	PreviousEpochParticipation *altair.ParticipationRegistry
+	CurrentEpochParticipation  *altair.ParticipationRegistry
+	InactivityScores           *altair.InactivityScores
+	CurrentSyncCommittee       *common.SyncCommittee
+	NextSyncCommittee          *common.SyncCommittee
+	// Bellatrix
+	LatestExecutionPayloadHeaderHash *tree.Root
+	// Capella
+	NextWithdrawalIndex          *common.WithdrawalIndex
+	NextWithdrawalValidatorIndex *common.ValidatorIndex
+}
+
+// Wraps the typed accessor of a fork block, returning an error for blocks of
+// other types
+func signedBeaconBlockAccessor[T eth2api.SignedBeaconBlock](
+	fn func(b T) *SignedBeaconBlockFields,
+) func(eth2api.SignedBeaconBlock) (*SignedBeaconBlockFields, error) {
+	return func(b eth2api.SignedBeaconBlock) (*SignedBeaconBlockFields, error) {
+		if block, ok := b.(T); ok {
+			return fn(block), nil
+		}
+		return nil, fmt.Errorf("badly formatted beacon block, type=%T", b)
+	}
+}
+
+// Wraps the typed accessor of a fork state, returning an error for states of
+// other types
+func beaconStateAccessor[T common.SpecObj](
+	fn func(s T) *BeaconStateFields,
+) func(common.SpecObj) (*BeaconStateFields, error) {
+	return func(s common.SpecObj) (*BeaconStateFields, error) {
+		if state, ok := s.(T); ok {
+			return fn(state), nil
+		}
+		return nil, fmt.Errorf("badly formatted beacon state, type=%T", s)
+	}
+}
+
+// Wraps the typed execution payload converter of a fork block, returning an
+// error for blocks of other types
+func executableDataAccessor[T eth2api.SignedBeaconBlock](
+	fn func(b T) *api.ExecutableData,
+) func(eth2api.SignedBeaconBlock) (*api.ExecutableData, error) {
+	return func(b eth2api.SignedBeaconBlock) (*api.ExecutableData, error) {
+		if block, ok := b.(T); ok {
+			return fn(block), nil
+		}
+		return nil, fmt.Errorf("badly formatted beacon block, type=%T", b)
+	}
+}
+
+func phase0SignedBeaconBlockFields(b *phase0.SignedBeaconBlock) *SignedBeaconBlockFields {
+	return &SignedBeaconBlockFields{
+		Message:       &b.Message,
+		Slot:          b.Message.Slot,
+		ProposerIndex: b.Message.ProposerIndex,
+		ParentRoot:    b.Message.ParentRoot,
+		StateRoot:     b.Message.StateRoot,
+		Signature:     b.Signature,
+	}
+}
+
+func altairSignedBeaconBlockFields(b *altair.SignedBeaconBlock) *SignedBeaconBlockFields {
+	return &SignedBeaconBlockFields{
+		Message:       &b.Message,
+		Slot:          b.Message.Slot,
+		ProposerIndex: b.Message.ProposerIndex,
+		ParentRoot:    b.Message.ParentRoot,
+		StateRoot:     b.Message.StateRoot,
+		Signature:     b.Signature,
+	}
+}
+
+func bellatrixSignedBeaconBlockFields(b *bellatrix.SignedBeaconBlock) *SignedBeaconBlockFields {
+	return &SignedBeaconBlockFields{
+		Message:                   &b.Message,
+		Slot:                      b.Message.Slot,
+		ProposerIndex:             b.Message.ProposerIndex,
+		ParentRoot:                b.Message.ParentRoot,
+		StateRoot:                 b.Message.StateRoot,
+		Signature:                 b.Signature,
+		ExecutionPayloadBlockHash: &b.Message.Body.ExecutionPayload.BlockHash,
+	}
+}
+
+func capellaSignedBeaconBlockFields(b *capella.SignedBeaconBlock) *SignedBeaconBlockFields {
+	return &SignedBeaconBlockFields{
+		Message:                   &b.Message,
+		Slot:                      b.Message.Slot,
+		ProposerIndex:             b.Message.ProposerIndex,
+		ParentRoot:                b.Message.ParentRoot,
+		StateRoot:                 b.Message.StateRoot,
+		Signature:                 b.Signature,
+		ExecutionPayloadBlockHash: &b.Message.Body.ExecutionPayload.BlockHash,
+		Withdrawals:               &b.Message.Body.ExecutionPayload.Withdrawals,
+	}
+}
+
+func denebSignedBeaconBlockFields(b *deneb.SignedBeaconBlock) *SignedBeaconBlockFields {
+	return &SignedBeaconBlockFields{
+		Message:                   &b.Message,
+		Slot:                      b.Message.Slot,
+		ProposerIndex:             b.Message.ProposerIndex,
+		ParentRoot:                b.Message.ParentRoot,
+		StateRoot:                 b.Message.StateRoot,
+		Signature:                 b.Signature,
+		ExecutionPayloadBlockHash: &b.Message.Body.ExecutionPayload.BlockHash,
+		Withdrawals:               &b.Message.Body.ExecutionPayload.Withdrawals,
+		BlobKZGCommitments:        &b.Message.Body.BlobKZGCommitments,
+	}
+}
+
+func executableDataTransactions(txs common.PayloadTransactions) [][]byte {
+	result := make([][]byte, 0)
+	for _, t := range txs {
+		result = append(result, t)
+	}
+	return result
+}
+
+func executableDataWithdrawals(ws common.Withdrawals) []*types.Withdrawal {
+	result := make([]*types.Withdrawal, 0)
+	for _, w := range ws {
+		withdrawal := new(types.Withdrawal)
+		withdrawal.Index = uint64(w.Index)
+		withdrawal.Validator = uint64(w.ValidatorIndex)
+		copy(withdrawal.Address[:], w.Address[:])
+		withdrawal.Amount = uint64(w.Amount)
+		result = append(result, withdrawal)
+	}
+	return result
+}
+
+func bellatrixExecutableData(b *bellatrix.SignedBeaconBlock) *api.ExecutableData {
+	p := &b.Message.Body.ExecutionPayload
+	return &api.ExecutableData{
+		ParentHash:    el_common.Hash(p.ParentHash),
+		FeeRecipient:  el_common.Address(p.FeeRecipient),
+		StateRoot:     el_common.Hash(p.StateRoot),
+		ReceiptsRoot:  el_common.Hash(p.ReceiptsRoot),
+		LogsBloom:     append([]byte{}, p.LogsBloom[:]...),
+		Random:        el_common.Hash(p.PrevRandao),
+		Number:        uint64(p.BlockNumber),
+		GasLimit:      uint64(p.GasLimit),
+		GasUsed:       uint64(p.GasUsed),
+		Timestamp:     uint64(p.Timestamp),
+		ExtraData:     append([]byte{}, p.ExtraData...),
+		BaseFeePerGas: (*uint256.Int)(&p.BaseFeePerGas).ToBig(),
+		BlockHash:     el_common.Hash(p.BlockHash),
+		Transactions:  executableDataTransactions(p.Transactions),
+	}
+}
+
+func capellaExecutableData(b *capella.SignedBeaconBlock) *api.ExecutableData {
+	p := &b.Message.Body.ExecutionPayload
+	return &api.ExecutableData{
+		ParentHash:    el_common.Hash(p.ParentHash),
+		FeeRecipient:  el_common.Address(p.FeeRecipient),
+		StateRoot:     el_common.Hash(p.StateRoot),
+		ReceiptsRoot:  el_common.Hash(p.ReceiptsRoot),
+		LogsBloom:     append([]byte{}, p.LogsBloom[:]...),
+		Random:        el_common.Hash(p.PrevRandao),
+		Number:        uint64(p.BlockNumber),
+		GasLimit:      uint64(p.GasLimit),
+		GasUsed:       uint64(p.GasUsed),
+		Timestamp:     uint64(p.Timestamp),
+		ExtraData:     append([]byte{}, p.ExtraData...),
+		BaseFeePerGas: (*uint256.Int)(&p.BaseFeePerGas).ToBig(),
+		BlockHash:     el_common.Hash(p.BlockHash),
+		Transactions:  executableDataTransactions(p.Transactions),
+		Withdrawals:   executableDataWithdrawals(p.Withdrawals),
+	}
+}
+
+func denebExecutableData(b *deneb.SignedBeaconBlock) *api.ExecutableData {
+	var (
+		p             = &b.Message.Body.ExecutionPayload
+		blobGasUsed   = uint64(p.BlobGasUsed)
+		excessBlobGas = uint64(p.ExcessBlobGas)
+	)
+	return &api.ExecutableData{
+		ParentHash:    el_common.Hash(p.ParentHash),
+		FeeRecipient:  el_common.Address(p.FeeRecipient),
+		StateRoot:     el_common.Hash(p.StateRoot),
+		ReceiptsRoot:  el_common.Hash(p.ReceiptsRoot),
+		LogsBloom:     append([]byte{}, p.LogsBloom[:]...),
+		Random:        el_common.Hash(p.PrevRandao),
+		Number:        uint64(p.BlockNumber),
+		GasLimit:      uint64(p.GasLimit),
+		GasUsed:       uint64(p.GasUsed),
+		Timestamp:     uint64(p.Timestamp),
+		ExtraData:     append([]byte{}, p.ExtraData...),
+		BaseFeePerGas: (*uint256.Int)(&p.BaseFeePerGas).ToBig(),
+		BlockHash:     el_common.Hash(p.BlockHash),
+		Transactions:  executableDataTransactions(p.Transactions),
+		Withdrawals:   executableDataWithdrawals(p.Withdrawals),
+		BlobGasUsed:   &blobGasUsed,
+		ExcessBlobGas: &excessBlobGas,
+	}
+}
+
+func phase0BeaconStateFields(s *phase0.BeaconState) *BeaconStateFields {
+	return &BeaconStateFields{
+		GenesisTime:                 s.GenesisTime,
+		GenesisValidatorsRoot:       s.GenesisValidatorsRoot,
+		Slot:                        s.Slot,
+		Fork:                        s.Fork,
+		LatestBlockHeader:           s.LatestBlockHeader,
+		BlockRoots:                  s.BlockRoots,
+		StateRoots:                  s.StateRoots,
+		HistoricalRoots:             s.HistoricalRoots,
+		Eth1Data:                    s.Eth1Data,
+		Eth1DataVotes:               s.Eth1DataVotes,
+		Eth1DepositIndex:            s.Eth1DepositIndex,
+		Validators:                  s.Validators,
+		Balances:                    s.Balances,
+		RandaoMixes:                 s.RandaoMixes,
+		Slashings:                   s.Slashings,
+		JustificationBits:           s.JustificationBits,
+		PreviousJustifiedCheckpoint: s.PreviousJustifiedCheckpoint,
+		CurrentJustifiedCheckpoint:  s.CurrentJustifiedCheckpoint,
+		FinalizedCheckpoint:         s.FinalizedCheckpoint,
+		PreviousEpochAttestations:   &s.PreviousEpochAttestations,
+		CurrentEpochAttestations:    &s.CurrentEpochAttestations,
+	}
+}
+
+func altairBeaconStateFields(s *altair.BeaconState) *BeaconStateFields {
+	return &BeaconStateFields{
+		GenesisTime:                 s.GenesisTime,
+		GenesisValidatorsRoot:       s.GenesisValidatorsRoot,
+		Slot:                        s.Slot,
+		Fork:                        s.Fork,
+		LatestBlockHeader:           s.LatestBlockHeader,
+		BlockRoots:                  s.BlockRoots,
+		StateRoots:                  s.StateRoots,
+		HistoricalRoots:             s.HistoricalRoots,
+		Eth1Data:                    s.Eth1Data,
+		Eth1DataVotes:               s.Eth1DataVotes,
+		Eth1DepositIndex:            s.Eth1DepositIndex,
+		Validators:                  s.Validators,
+		Balances:                    s.Balances,
+		RandaoMixes:                 s.RandaoMixes,
+		Slashings:                   s.Slashings,
+		JustificationBits:           s.JustificationBits,
+		PreviousJustifiedCheckpoint: s.PreviousJustifiedCheckpoint,
+		CurrentJustifiedCheckpoint:  s.CurrentJustifiedCheckpoint,
+		FinalizedCheckpoint:         s.FinalizedCheckpoint,
+		PreviousEpochParticipation:  &s.PreviousEpochParticipation,
+		CurrentEpochParticipation:   &s.CurrentEpochParticipation,
+		InactivityScores:            &s.InactivityScores,
+		CurrentSyncCommittee:        &s.CurrentSyncCommittee,
+		NextSyncCommittee:           &s.NextSyncCommittee,
+	}
+}
+
+func bellatrixBeaconStateFields(s *bellatrix.BeaconState) *BeaconStateFields {
+	return &BeaconStateFields{
+		GenesisTime:                      s.GenesisTime,
+		GenesisValidatorsRoot:            s.GenesisValidatorsRoot,
+		Slot:                             s.Slot,
+		Fork:                             s.Fork,
+		LatestBlockHeader:                s.LatestBlockHeader,
+		BlockRoots:                       s.BlockRoots,
+		StateRoots:                       s.StateRoots,
+		HistoricalRoots:                  s.HistoricalRoots,
+		Eth1Data:                         s.Eth1Data,
+		Eth1DataVotes:                    s.Eth1DataVotes,
+		Eth1DepositIndex:                 s.Eth1DepositIndex,
+		Validators:                       s.Validators,
+		Balances:                         s.Balances,
+		RandaoMixes:                      s.RandaoMixes,
+		Slashings:                        s.Slashings,
+		JustificationBits:                s.JustificationBits,
+		PreviousJustifiedCheckpoint:      s.PreviousJustifiedCheckpoint,
+		CurrentJustifiedCheckpoint:       s.CurrentJustifiedCheckpoint,
+		FinalizedCheckpoint:              s.FinalizedCheckpoint,
+		PreviousEpochParticipation:       &s.PreviousEpochParticipation,
+		CurrentEpochParticipation:        &s.CurrentEpochParticipation,
+		InactivityScores:                 &s.InactivityScores,
+		CurrentSyncCommittee:             &s.CurrentSyncCommittee,
+		NextSyncCommittee:                &s.NextSyncCommittee,
+		LatestExecutionPayloadHeaderHash: &s.LatestExecutionPayloadHeader.BlockHash,
+	}
+}
+
+func capellaBeaconStateFields(s *capella.BeaconState) *BeaconStateFields {
+	return &BeaconStateFields{
+		GenesisTime:                      s.GenesisTime,
+		GenesisValidatorsRoot:            s.GenesisValidatorsRoot,
+		Slot:                             s.Slot,
+		Fork:                             s.Fork,
+		LatestBlockHeader:                s.LatestBlockHeader,
+		BlockRoots:                       s.BlockRoots,
+		StateRoots:                       s.StateRoots,
+		HistoricalRoots:                  s.HistoricalRoots,
+		Eth1Data:                         s.Eth1Data,
+		Eth1DataVotes:                    s.Eth1DataVotes,
+		Eth1DepositIndex:                 s.Eth1DepositIndex,
+		Validators:                       s.Validators,
+		Balances:                         s.Balances,
+		RandaoMixes:                      s.RandaoMixes,
+		Slashings:                        s.Slashings,
+		JustificationBits:                s.JustificationBits,
+		PreviousJustifiedCheckpoint:      s.PreviousJustifiedCheckpoint,
+		CurrentJustifiedCheckpoint:       s.CurrentJustifiedCheckpoint,
+		FinalizedCheckpoint:              s.FinalizedCheckpoint,
+		PreviousEpochParticipation:       &s.PreviousEpochParticipation,
+		CurrentEpochParticipation:        &s.CurrentEpochParticipation,
+		InactivityScores:                 &s.InactivityScores,
+		CurrentSyncCommittee:             &s.CurrentSyncCommittee,
+		NextSyncCommittee:                &s.NextSyncCommittee,
+		LatestExecutionPayloadHeaderHash: &s.LatestExecutionPayloadHeader.BlockHash,
+		NextWithdrawalIndex:              &s.NextWithdrawalIndex,
+		NextWithdrawalValidatorIndex:     &s.NextWithdrawalValidatorIndex,
+	}
+}
+
+func denebBeaconStateFields(s *deneb.BeaconState) *BeaconStateFields {
+	return &BeaconStateFields{
+		GenesisTime:                      s.GenesisTime,
+		GenesisValidatorsRoot:            s.GenesisValidatorsRoot,
+		Slot:                             s.Slot,
+		Fork:                             s.Fork,
+		LatestBlockHeader:                s.LatestBlockHeader,
+		BlockRoots:                       s.BlockRoots,
+		StateRoots:                       s.StateRoots,
+		HistoricalRoots:                  s.HistoricalRoots,
+		Eth1Data:                         s.Eth1Data,
+		Eth1DataVotes:                    s.Eth1DataVotes,
+		Eth1DepositIndex:                 s.Eth1DepositIndex,
+		Validators:                       s.Validators,
+		Balances:                         s.Balances,
+		RandaoMixes:                      s.RandaoMixes,
+		Slashings:                        s.Slashings,
+		JustificationBits:                s.JustificationBits,
+		PreviousJustifiedCheckpoint:      s.PreviousJustifiedCheckpoint,
+		CurrentJustifiedCheckpoint:       s.CurrentJustifiedCheckpoint,
+		FinalizedCheckpoint:              s.FinalizedCheckpoint,
+		PreviousEpochParticipation:       &s.PreviousEpochParticipation,
+		CurrentEpochParticipation:        &s.CurrentEpochParticipation,
+		InactivityScores:                 &s.InactivityScores,
+		CurrentSyncCommittee:             &s.CurrentSyncCommittee,
+		NextSyncCommittee:                &s.NextSyncCommittee,
+		LatestExecutionPayloadHeaderHash: &s.LatestExecutionPayloadHeader.BlockHash,
+		NextWithdrawalIndex:              &s.NextWithdrawalIndex,
+		NextWithdrawalValidatorIndex:     &s.NextWithdrawalValidatorIndex,
+	}
+}
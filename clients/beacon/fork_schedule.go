@@ -0,0 +1,200 @@
+package beacon
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/marioevz/eth-clients/clients/execution"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// Fork of the schedule, with its version and activation epoch in the spec
+type ScheduledFork struct {
+	*ForkDefinition
+	Version common.Version
+	Epoch   common.Epoch
+}
+
+// Schedule of the registered forks in a given network
+type ForkSchedule struct {
+	Spec                  *common.Spec
+	GenesisTime           common.Timestamp
+	GenesisValidatorsRoot tree.Root
+	// Forks in chronological order, including the ones scheduled at the far
+	// future epoch
+	Forks []*ScheduledFork
+}
+
+// Builds the schedule of the registered forks that define their version and
+// activation epoch
+func NewForkSchedule(
+	spec *common.Spec,
+	genesisTime common.Timestamp,
+	genesisValidatorsRoot tree.Root,
+) *ForkSchedule {
+	s := &ForkSchedule{
+		Spec:                  spec,
+		GenesisTime:           genesisTime,
+		GenesisValidatorsRoot: genesisValidatorsRoot,
+		Forks:                 make([]*ScheduledFork, 0),
+	}
+	for _, f := range ForkDefinitions() {
+		if f.ForkVersion == nil || f.ForkEpoch == nil {
+			continue
+		}
+		s.Forks = append(s.Forks, &ScheduledFork{
+			ForkDefinition: f,
+			Version:        f.ForkVersion(spec),
+			Epoch:          f.ForkEpoch(spec),
+		})
+	}
+	return s
+}
+
+// Returns the fork active at the given epoch
+func (s *ForkSchedule) ForkAtEpoch(epoch common.Epoch) *ScheduledFork {
+	var active *ScheduledFork
+	for _, f := range s.Forks {
+		if f.Epoch <= epoch {
+			active = f
+		}
+	}
+	return active
+}
+
+func (s *ForkSchedule) ForkAtSlot(slot common.Slot) *ScheduledFork {
+	return s.ForkAtEpoch(s.Spec.SlotToEpoch(slot))
+}
+
+func (s *ForkSchedule) ForkAtTimestamp(t common.Timestamp) *ScheduledFork {
+	return s.ForkAtSlot(s.Spec.TimeToSlot(t, s.GenesisTime))
+}
+
+// Returns the scheduled fork with the given name
+func (s *ForkSchedule) ForkByName(name string) (*ScheduledFork, error) {
+	for _, f := range s.Forks {
+		if strings.EqualFold(f.Name, name) {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("fork %s not scheduled", name)
+}
+
+// Returns the first fork activated after the given epoch, nil if there is
+// none scheduled
+func (s *ForkSchedule) NextFork(epoch common.Epoch) *ScheduledFork {
+	for _, f := range s.Forks {
+		if f.Epoch > epoch && f.Epoch != common.FAR_FUTURE_EPOCH {
+			return f
+		}
+	}
+	return nil
+}
+
+// Returns the activation epoch of the next fork after the given epoch, or
+// the far future epoch if there is none scheduled
+func (s *ForkSchedule) NextForkEpoch(epoch common.Epoch) common.Epoch {
+	if f := s.NextFork(epoch); f != nil {
+		return f.Epoch
+	}
+	return common.FAR_FUTURE_EPOCH
+}
+
+func (s *ForkSchedule) ForkVersion(epoch common.Epoch) common.Version {
+	return s.ForkAtEpoch(epoch).Version
+}
+
+func (s *ForkSchedule) ForkDigest(epoch common.Epoch) common.ForkDigest {
+	return common.ComputeForkDigest(
+		s.ForkVersion(epoch),
+		s.GenesisValidatorsRoot,
+	)
+}
+
+// Returns the fork data of the state at the given epoch
+func (s *ForkSchedule) Fork(epoch common.Epoch) common.Fork {
+	var (
+		current  = s.ForkAtEpoch(epoch)
+		previous = current
+	)
+	for i, f := range s.Forks {
+		if f == current && i > 0 {
+			previous = s.Forks[i-1]
+		}
+	}
+	return common.Fork{
+		PreviousVersion: previous.Version,
+		CurrentVersion:  current.Version,
+		Epoch:           current.Epoch,
+	}
+}
+
+// Returns the eth2 field advertised in the ENR of the nodes at the given
+// epoch
+func (s *ForkSchedule) ENRForkID(epoch common.Epoch) common.Eth2Data {
+	data := common.Eth2Data{
+		ForkDigest:      s.ForkDigest(epoch),
+		NextForkVersion: s.ForkVersion(epoch),
+		NextForkEpoch:   common.FAR_FUTURE_EPOCH,
+	}
+	if next := s.NextFork(epoch); next != nil {
+		data.NextForkVersion = next.Version
+		data.NextForkEpoch = next.Epoch
+	}
+	return data
+}
+
+// Returns the signature domain of the given type at the given epoch.
+// Voluntary exits are signed with the capella fork version from deneb on
+// (EIP-7044).
+func (s *ForkSchedule) Domain(
+	typ common.BLSDomainType,
+	epoch common.Epoch,
+) common.BLSDomain {
+	version := s.ForkVersion(epoch)
+	if typ == common.DOMAIN_VOLUNTARY_EXIT && epoch >= s.Spec.DENEB_FORK_EPOCH {
+		version = s.Spec.CAPELLA_FORK_VERSION
+	}
+	return common.ComputeDomain(
+		typ,
+		version,
+		s.GenesisValidatorsRoot,
+	)
+}
+
+// Returns the timestamp of the first slot of the epoch
+func (s *ForkSchedule) EpochTimestamp(epoch common.Epoch) common.Timestamp {
+	slot := s.Spec.SLOTS_PER_EPOCH * common.Slot(epoch)
+	return s.GenesisTime + common.Timestamp(slot)*common.Timestamp(s.Spec.SECONDS_PER_SLOT)
+}
+
+// Returns the activation timestamps of the execution forks that activate
+// together with capella and deneb
+func (s *ForkSchedule) ExecutionForkTimes() execution.ExecutionForkTimes {
+	var forks execution.ExecutionForkTimes
+	if s.Spec.CAPELLA_FORK_EPOCH != common.FAR_FUTURE_EPOCH {
+		t := uint64(s.EpochTimestamp(s.Spec.CAPELLA_FORK_EPOCH))
+		forks.ShanghaiTime = &t
+	}
+	if s.Spec.DENEB_FORK_EPOCH != common.FAR_FUTURE_EPOCH {
+		t := uint64(s.EpochTimestamp(s.Spec.DENEB_FORK_EPOCH))
+		forks.CancunTime = &t
+	}
+	return forks
+}
+
+// Returns the version of the engine API methods used at the given epoch,
+// zero before the execution payload is introduced.
+// Forks registered with an explicit engine API version use it, otherwise the
+// version is selected from the execution fork times of the schedule.
+func (s *ForkSchedule) EngineAPIVersion(epoch common.Epoch) int {
+	f := s.ForkAtEpoch(epoch)
+	if !f.ExecutionPayload {
+		return 0
+	}
+	if f.EngineAPI != 0 {
+		return f.EngineAPI
+	}
+	return s.ExecutionForkTimes().EngineAPIVersion(uint64(s.EpochTimestamp(epoch)))
+}
@@ -0,0 +1,119 @@
+/*
+Tests for the fork schedule helper
+*/
+package beacon
+
+import (
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/ztyp/tree"
+)
+
+func TestForkSchedule(t *testing.T) {
+	spec := *configs.Minimal
+	spec.ALTAIR_FORK_EPOCH = 0
+	spec.BELLATRIX_FORK_EPOCH = 0
+	spec.CAPELLA_FORK_EPOCH = 1
+	spec.DENEB_FORK_EPOCH = 3
+	var (
+		genesisTime   = common.Timestamp(1000)
+		gvr           = tree.Root{0x01}
+		schedule      = NewForkSchedule(&spec, genesisTime, gvr)
+		slotsPerEpoch = uint64(spec.SLOTS_PER_EPOCH)
+	)
+
+	for _, test := range []struct {
+		epoch           common.Epoch
+		fork            string
+		engineAPI       int
+		nextForkEpoch   common.Epoch
+		previousVersion common.Version
+	}{
+		{0, "bellatrix", 1, 1, spec.ALTAIR_FORK_VERSION},
+		{1, "capella", 2, 3, spec.BELLATRIX_FORK_VERSION},
+		{2, "capella", 2, 3, spec.BELLATRIX_FORK_VERSION},
+		{3, "deneb", 3, common.FAR_FUTURE_EPOCH, spec.CAPELLA_FORK_VERSION},
+		{100, "deneb", 3, common.FAR_FUTURE_EPOCH, spec.CAPELLA_FORK_VERSION},
+	} {
+		fork := schedule.ForkAtEpoch(test.epoch)
+		if fork.Name != test.fork {
+			t.Fatalf("incorrect fork at epoch %d: want %s, got %s", test.epoch, test.fork, fork.Name)
+		}
+		if v := schedule.EngineAPIVersion(test.epoch); v != test.engineAPI {
+			t.Fatalf("incorrect engine api version at epoch %d: want %d, got %d", test.epoch, test.engineAPI, v)
+		}
+		if e := schedule.NextForkEpoch(test.epoch); e != test.nextForkEpoch {
+			t.Fatalf("incorrect next fork epoch at epoch %d: want %d, got %d", test.epoch, test.nextForkEpoch, e)
+		}
+		if f := schedule.Fork(test.epoch); f.PreviousVersion != test.previousVersion ||
+			f.CurrentVersion != fork.Version {
+			t.Fatalf("incorrect fork data at epoch %d: %+v", test.epoch, f)
+		}
+		expectedDigest := common.ComputeForkDigest(fork.Version, gvr)
+		if d := schedule.ForkDigest(test.epoch); d != expectedDigest {
+			t.Fatalf("incorrect fork digest at epoch %d: want %s, got %s", test.epoch, expectedDigest, d)
+		}
+		if d := schedule.Domain(common.DOMAIN_BEACON_PROPOSER, test.epoch); d != common.ComputeDomain(
+			common.DOMAIN_BEACON_PROPOSER,
+			fork.Version,
+			gvr,
+		) {
+			t.Fatalf("incorrect domain at epoch %d", test.epoch)
+		}
+		exitVersion := fork.Version
+		if test.fork == "deneb" {
+			exitVersion = spec.CAPELLA_FORK_VERSION
+		}
+		if d := schedule.Domain(common.DOMAIN_VOLUNTARY_EXIT, test.epoch); d != common.ComputeDomain(
+			common.DOMAIN_VOLUNTARY_EXIT,
+			exitVersion,
+			gvr,
+		) {
+			t.Fatalf("incorrect voluntary exit domain at epoch %d", test.epoch)
+		}
+	}
+
+	// Slots and timestamps resolve to the fork of their epoch
+	if fork := schedule.ForkAtSlot(common.Slot(slotsPerEpoch - 1)); fork.Name != "bellatrix" {
+		t.Fatalf("incorrect fork at last slot of epoch 0: %s", fork.Name)
+	}
+	if fork := schedule.ForkAtSlot(common.Slot(slotsPerEpoch)); fork.Name != "capella" {
+		t.Fatalf("incorrect fork at first slot of epoch 1: %s", fork.Name)
+	}
+	timestamp := genesisTime + common.Timestamp(3*slotsPerEpoch*uint64(spec.SECONDS_PER_SLOT))
+	if fork := schedule.ForkAtTimestamp(timestamp); fork.Name != "deneb" {
+		t.Fatalf("incorrect fork at timestamp %d: %s", timestamp, fork.Name)
+	}
+	if fork := schedule.ForkAtTimestamp(timestamp - 1); fork.Name != "capella" {
+		t.Fatalf("incorrect fork at timestamp %d: %s", timestamp-1, fork.Name)
+	}
+	if ts := schedule.EpochTimestamp(3); ts != timestamp {
+		t.Fatalf("incorrect timestamp of epoch 3: want %d, got %d", timestamp, ts)
+	}
+
+	// Execution forks activate at the timestamps of their consensus forks
+	forks := schedule.ExecutionForkTimes()
+	if forks.ShanghaiTime == nil || *forks.ShanghaiTime != uint64(schedule.EpochTimestamp(1)) ||
+		forks.CancunTime == nil || *forks.CancunTime != uint64(timestamp) {
+		t.Fatalf("incorrect execution fork times: %+v", forks)
+	}
+	spec.DENEB_FORK_EPOCH = common.FAR_FUTURE_EPOCH
+	if forks := schedule.ExecutionForkTimes(); forks.CancunTime != nil {
+		t.Fatalf("cancun time set for an unscheduled fork: %d", *forks.CancunTime)
+	}
+	spec.DENEB_FORK_EPOCH = 3
+
+	// ENR fork ID advertises the upcoming fork
+	forkID := schedule.ENRForkID(1)
+	if forkID.NextForkVersion != spec.DENEB_FORK_VERSION || forkID.NextForkEpoch != 3 {
+		t.Fatalf("incorrect enr fork id: %+v", forkID)
+	}
+	if _, err := schedule.ForkByName("Capella"); err != nil {
+		t.Fatalf("unable to find fork by name: %v", err)
+	}
+	if _, err := schedule.ForkByName("unknown"); err == nil {
+		t.Fatalf("unknown fork found")
+	}
+}
@@ -0,0 +1,410 @@
+package beacon
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	api "github.com/ethereum/go-ethereum/beacon/engine"
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/bellatrix"
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/ztyp/codec"
+)
+
+// Definition of a consensus fork, used to decode and access the versioned
+// blocks and states returned by the beacon API.
+//
+// The accessors of VersionedSignedBeaconBlock and VersionedBeaconStateResponse
+// read the fields through the typed accessors of the fork, so a new fork is
+// supported by registering its definition with RegisterFork. Fields
+// introduced by the new fork (e.g. execution requests or pending deposits in
+// Electra) are read by asserting the data of the versioned wrappers to the
+// types of the fork.
+type ForkDefinition struct {
+	// Name of the fork as returned in the version field of the beacon API
+	Name string
+	// Allocators of the fork objects
+	NewSignedBeaconBlock func() eth2api.SignedBeaconBlock
+	NewBeaconState       func() common.SpecObj
+	// Deserializes a binary-tree backed state of the fork
+	NewBeaconStateView func(
+		spec *common.Spec,
+		r *codec.DecodingReader,
+	) (common.BeaconState, error)
+
+	// Typed accessors of the fork objects, which return an error if the
+	// object is not of the type returned by the allocators
+	SignedBeaconBlockFields func(b eth2api.SignedBeaconBlock) (*SignedBeaconBlockFields, error)
+	BeaconStateFields       func(s common.SpecObj) (*BeaconStateFields, error)
+	// Converts the execution payload of the block, only for forks with
+	// execution payloads
+	ExecutableData func(b eth2api.SignedBeaconBlock) (*api.ExecutableData, error)
+
+	// Version and activation epoch of the fork in the given spec, forks
+	// without them are not included in the fork schedule.
+	// The epoch of a fork must not be earlier than the epoch of the previous
+	// fork, and its version must differ from the versions of the previous
+	// forks.
+	ForkVersion func(spec *common.Spec) common.Version
+	ForkEpoch   func(spec *common.Spec) common.Epoch
+
+	// Features included in the fork
+	ExecutionPayload   bool
+	Withdrawals        bool
+	BlobKZGCommitments bool
+	// The parent beacon block root is sent to the execution client along
+	// with the payload
+	ParentBeaconBlockRoot bool
+	// Version of the engine API methods used by the fork, derived from the
+	// execution fork times of the spec when zero
+	EngineAPI int
+
+	// Order of registration
+	Index int
+}
+
+var (
+	forkDefinitions       = make([]*ForkDefinition, 0)
+	forkDefinitionsByName = make(map[string]*ForkDefinition)
+	forkDefinitionsMu     sync.RWMutex
+)
+
+// Verifies that the fork contains all the accessors, and that the fields
+// returned by them match the features of the fork
+func (f *ForkDefinition) validate() error {
+	if f.Name == "" {
+		return fmt.Errorf("fork name is empty")
+	}
+	if f.NewSignedBeaconBlock == nil ||
+		f.NewBeaconState == nil ||
+		f.NewBeaconStateView == nil {
+		return fmt.Errorf("fork %s: missing allocators", f.Name)
+	}
+	if f.SignedBeaconBlockFields == nil || f.BeaconStateFields == nil {
+		return fmt.Errorf("fork %s: missing accessors", f.Name)
+	}
+	if f.ExecutionPayload != (f.ExecutableData != nil) {
+		return fmt.Errorf(
+			"fork %s: execution payload accessor does not match the fork features",
+			f.Name,
+		)
+	}
+
+	block, err := f.SignedBeaconBlockFields(f.NewSignedBeaconBlock())
+	if err != nil {
+		return fmt.Errorf("fork %s: block accessor: %w", f.Name, err)
+	}
+	for _, feature := range []struct {
+		name     string
+		enabled  bool
+		included bool
+	}{
+		{"execution payload", f.ExecutionPayload, block.ExecutionPayloadBlockHash != nil},
+		{"withdrawals", f.Withdrawals, block.Withdrawals != nil},
+		{"blob kzg commitments", f.BlobKZGCommitments, block.BlobKZGCommitments != nil},
+	} {
+		if feature.enabled != feature.included {
+			return fmt.Errorf(
+				"fork %s: block %s do not match the fork features",
+				f.Name,
+				feature.name,
+			)
+		}
+	}
+	if f.ExecutionPayload {
+		if _, err := f.ExecutableData(f.NewSignedBeaconBlock()); err != nil {
+			return fmt.Errorf("fork %s: execution payload accessor: %w", f.Name, err)
+		}
+	}
+
+	state, err := f.BeaconStateFields(f.NewBeaconState())
+	if err != nil {
+		return fmt.Errorf("fork %s: state accessor: %w", f.Name, err)
+	}
+	if f.ExecutionPayload != (state.LatestExecutionPayloadHeaderHash != nil) {
+		return fmt.Errorf(
+			"fork %s: state execution payload header does not match the fork features",
+			f.Name,
+		)
+	}
+	if f.Withdrawals != (state.NextWithdrawalIndex != nil &&
+		state.NextWithdrawalValidatorIndex != nil) {
+		return fmt.Errorf(
+			"fork %s: state withdrawal indexes do not match the fork features",
+			f.Name,
+		)
+	}
+	return nil
+}
+
+// Specs used to verify the chronological order of the registered forks
+var forkOrderSpecs = []*common.Spec{configs.Mainnet, configs.Minimal}
+
+// Verifies that the fork follows the previously registered forks in each of
+// the reference specs: its activation epoch must not be earlier than the
+// epoch of the last scheduled fork, and its version must differ from the
+// versions of all scheduled forks, so the previous version of each fork in
+// the schedule is the version of the fork before it
+func (f *ForkDefinition) validateOrder(previous []*ForkDefinition) error {
+	if (f.ForkVersion == nil) != (f.ForkEpoch == nil) {
+		return fmt.Errorf("fork %s: fork version and epoch must be both set", f.Name)
+	}
+	if f.ForkVersion == nil {
+		return nil
+	}
+	for _, spec := range forkOrderSpecs {
+		var (
+			version = f.ForkVersion(spec)
+			epoch   = f.ForkEpoch(spec)
+		)
+		for _, p := range previous {
+			if p.ForkVersion == nil {
+				continue
+			}
+			if p.ForkVersion(spec) == version {
+				return fmt.Errorf(
+					"fork %s: version %s of the %s spec already used by fork %s",
+					f.Name,
+					version,
+					spec.PRESET_BASE,
+					p.Name,
+				)
+			}
+		}
+		for i := len(previous) - 1; i >= 0; i-- {
+			p := previous[i]
+			if p.ForkEpoch == nil {
+				continue
+			}
+			if prevEpoch := p.ForkEpoch(spec); epoch < prevEpoch {
+				return fmt.Errorf(
+					"fork %s: epoch %d of the %s spec is earlier than the epoch %d of fork %s",
+					f.Name,
+					epoch,
+					spec.PRESET_BASE,
+					prevEpoch,
+					p.Name,
+				)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// Registers a new fork definition, which is then used to decode the beacon
+// API responses whose version matches the fork name.
+// Forks must be registered in chronological order, which is verified against
+// the mainnet and minimal specs.
+func RegisterFork(f *ForkDefinition) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+	forkDefinitionsMu.Lock()
+	defer forkDefinitionsMu.Unlock()
+	name := strings.ToLower(f.Name)
+	if _, ok := forkDefinitionsByName[name]; ok {
+		return fmt.Errorf("fork %s already registered", f.Name)
+	}
+	if err := f.validateOrder(forkDefinitions); err != nil {
+		return err
+	}
+	f.Index = len(forkDefinitions)
+	forkDefinitions = append(forkDefinitions, f)
+	forkDefinitionsByName[name] = f
+	return nil
+}
+
+// Returns the definition of the fork with the given name
+func ForkDefinitionByName(name string) (*ForkDefinition, error) {
+	forkDefinitionsMu.RLock()
+	defer forkDefinitionsMu.RUnlock()
+	if f, ok := forkDefinitionsByName[strings.ToLower(name)]; ok {
+		return f, nil
+	}
+	return nil, fmt.Errorf("unknown fork version: %q", name)
+}
+
+// Returns all registered fork definitions in chronological order
+func ForkDefinitions() []*ForkDefinition {
+	forkDefinitionsMu.RLock()
+	defer forkDefinitionsMu.RUnlock()
+	return append([]*ForkDefinition{}, forkDefinitions...)
+}
+
+func init() {
+	for _, f := range []*ForkDefinition{
+		{
+			Name: "phase0",
+			ForkVersion: func(spec *common.Spec) common.Version {
+				return spec.GENESIS_FORK_VERSION
+			},
+			ForkEpoch: func(spec *common.Spec) common.Epoch {
+				return common.GENESIS_EPOCH
+			},
+			NewSignedBeaconBlock: func() eth2api.SignedBeaconBlock {
+				return new(phase0.SignedBeaconBlock)
+			},
+			NewBeaconState: func() common.SpecObj {
+				return new(phase0.BeaconState)
+			},
+			NewBeaconStateView: func(
+				spec *common.Spec,
+				r *codec.DecodingReader,
+			) (common.BeaconState, error) {
+				return phase0.AsBeaconStateView(
+					phase0.BeaconStateType(spec).Deserialize(r),
+				)
+			},
+			SignedBeaconBlockFields: signedBeaconBlockAccessor(phase0SignedBeaconBlockFields),
+			BeaconStateFields:       beaconStateAccessor(phase0BeaconStateFields),
+		},
+		{
+			Name: "altair",
+			ForkVersion: func(spec *common.Spec) common.Version {
+				return spec.ALTAIR_FORK_VERSION
+			},
+			ForkEpoch: func(spec *common.Spec) common.Epoch {
+				return spec.ALTAIR_FORK_EPOCH
+			},
+			NewSignedBeaconBlock: func() eth2api.SignedBeaconBlock {
+				return new(altair.SignedBeaconBlock)
+			},
+			NewBeaconState: func() common.SpecObj {
+				return new(altair.BeaconState)
+			},
+			NewBeaconStateView: func(
+				spec *common.Spec,
+				r *codec.DecodingReader,
+			) (common.BeaconState, error) {
+				return altair.AsBeaconStateView(
+					altair.BeaconStateType(spec).Deserialize(r),
+				)
+			},
+			SignedBeaconBlockFields: signedBeaconBlockAccessor(altairSignedBeaconBlockFields),
+			BeaconStateFields:       beaconStateAccessor(altairBeaconStateFields),
+		},
+		{
+			Name: "bellatrix",
+			ForkVersion: func(spec *common.Spec) common.Version {
+				return spec.BELLATRIX_FORK_VERSION
+			},
+			ForkEpoch: func(spec *common.Spec) common.Epoch {
+				return spec.BELLATRIX_FORK_EPOCH
+			},
+			NewSignedBeaconBlock: func() eth2api.SignedBeaconBlock {
+				return new(bellatrix.SignedBeaconBlock)
+			},
+			NewBeaconState: func() common.SpecObj {
+				return new(bellatrix.BeaconState)
+			},
+			NewBeaconStateView: func(
+				spec *common.Spec,
+				r *codec.DecodingReader,
+			) (common.BeaconState, error) {
+				return bellatrix.AsBeaconStateView(
+					bellatrix.BeaconStateType(spec).Deserialize(r),
+				)
+			},
+			SignedBeaconBlockFields: signedBeaconBlockAccessor(bellatrixSignedBeaconBlockFields),
+			BeaconStateFields:       beaconStateAccessor(bellatrixBeaconStateFields),
+			ExecutableData:          executableDataAccessor(bellatrixExecutableData),
+			ExecutionPayload:        true,
+		},
+		{
+			Name: "capella",
+			ForkVersion: func(spec *common.Spec) common.Version {
+				return spec.CAPELLA_FORK_VERSION
+			},
+			ForkEpoch: func(spec *common.Spec) common.Epoch {
+				return spec.CAPELLA_FORK_EPOCH
+			},
+			NewSignedBeaconBlock: func() eth2api.SignedBeaconBlock {
+				return new(capella.SignedBeaconBlock)
+			},
+			NewBeaconState: func() common.SpecObj {
+				return new(capella.BeaconState)
+			},
+			NewBeaconStateView: func(
+				spec *common.Spec,
+				r *codec.DecodingReader,
+			) (common.BeaconState, error) {
+				return capella.AsBeaconStateView(
+					capella.BeaconStateType(spec).Deserialize(r),
+				)
+			},
+			SignedBeaconBlockFields: signedBeaconBlockAccessor(capellaSignedBeaconBlockFields),
+			BeaconStateFields:       beaconStateAccessor(capellaBeaconStateFields),
+			ExecutableData:          executableDataAccessor(capellaExecutableData),
+			ExecutionPayload:        true,
+			Withdrawals:             true,
+		},
+		{
+			Name: "deneb",
+			ForkVersion: func(spec *common.Spec) common.Version {
+				return spec.DENEB_FORK_VERSION
+			},
+			ForkEpoch: func(spec *common.Spec) common.Epoch {
+				return spec.DENEB_FORK_EPOCH
+			},
+			NewSignedBeaconBlock: func() eth2api.SignedBeaconBlock {
+				return new(deneb.SignedBeaconBlock)
+			},
+			NewBeaconState: func() common.SpecObj {
+				return new(deneb.BeaconState)
+			},
+			NewBeaconStateView: func(
+				spec *common.Spec,
+				r *codec.DecodingReader,
+			) (common.BeaconState, error) {
+				return deneb.AsBeaconStateView(
+					deneb.BeaconStateType(spec).Deserialize(r),
+				)
+			},
+			SignedBeaconBlockFields: signedBeaconBlockAccessor(denebSignedBeaconBlockFields),
+			BeaconStateFields:       beaconStateAccessor(denebBeaconStateFields),
+			ExecutableData:          executableDataAccessor(denebExecutableData),
+			ExecutionPayload:        true,
+			Withdrawals:             true,
+			BlobKZGCommitments:      true,
+			ParentBeaconBlockRoot:   true,
+		},
+	} {
+		if err := RegisterFork(f); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Versioned response of the beacon API, decoded in two steps to select the
+// fork type of the data
+type versionedResponse struct {
+	Version string          `json:"version"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func decodeVersionedResponse(
+	b []byte,
+	alloc func(f *ForkDefinition) interface{},
+) (string, interface{}, error) {
+	var resp versionedResponse
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return "", nil, err
+	}
+	f, err := ForkDefinitionByName(resp.Version)
+	if err != nil {
+		return "", nil, err
+	}
+	data := alloc(f)
+	if err := json.Unmarshal(resp.Data, data); err != nil {
+		return "", nil, err
+	}
+	return resp.Version, data, nil
+}
@@ -0,0 +1,334 @@
+/*
+Tests for the fork registry used by the versioned wrappers
+*/
+package beacon
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+)
+
+func TestVersionedSignedBeaconBlockDecoding(t *testing.T) {
+	block := new(deneb.SignedBeaconBlock)
+	block.Message.Slot = 10
+	block.Message.Body.ExecutionPayload.BlobGasUsed = 131072
+	data, err := json.Marshal(block)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, test := range []struct {
+		version   string
+		expectErr bool
+	}{
+		{version: "deneb"},
+		{version: "DENEB"},
+		{version: "unknown", expectErr: true},
+		{version: "", expectErr: true},
+	} {
+		resp, err := json.Marshal(versionedResponse{
+			Version: test.version,
+			Data:    data,
+		})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		versionedBlock := new(VersionedSignedBeaconBlock)
+		err = json.Unmarshal(resp, versionedBlock)
+		if test.expectErr {
+			if err == nil {
+				t.Fatalf("%q: expected error", test.version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", test.version, err)
+		}
+		if !versionedBlock.ContainsExecutionPayload() ||
+			!versionedBlock.ContainsWithdrawals() ||
+			!versionedBlock.ContainsKZGCommitments() {
+			t.Fatalf("%q: missing fork features", test.version)
+		}
+		if versionedBlock.Slot() != block.Message.Slot {
+			t.Fatalf(
+				"%q: incorrect slot: want %d, got %d",
+				test.version,
+				block.Message.Slot,
+				versionedBlock.Slot(),
+			)
+		}
+		payload, _, beaconRoot, err := versionedBlock.ExecutionPayload()
+		if err != nil {
+			t.Fatalf("%q: %v", test.version, err)
+		}
+		if payload.BlobGasUsed == nil ||
+			*payload.BlobGasUsed != uint64(block.Message.Body.ExecutionPayload.BlobGasUsed) {
+			t.Fatalf("%q: incorrect blob gas used", test.version)
+		}
+		if len(payload.LogsBloom) != len(common.LogsBloom{}) {
+			t.Fatalf("%q: incorrect logs bloom length", test.version)
+		}
+		if beaconRoot == nil {
+			t.Fatalf("%q: missing parent beacon block root", test.version)
+		}
+	}
+}
+
+func TestPreExecutionBlockAccessors(t *testing.T) {
+	versionedBlock := &VersionedSignedBeaconBlock{
+		VersionedSignedBeaconBlock: &eth2api.VersionedSignedBeaconBlock{
+			Version: "phase0",
+			Data:    new(phase0.SignedBeaconBlock),
+		},
+	}
+	if versionedBlock.ContainsExecutionPayload() {
+		t.Fatalf("phase0 block contains execution payload")
+	}
+	if _, _, _, err := versionedBlock.ExecutionPayload(); err == nil {
+		t.Fatalf("expected error on phase0 execution payload")
+	}
+	if versionedBlock.ExecutionPayloadBlockHash() != nil {
+		t.Fatalf("phase0 block contains execution block hash")
+	}
+	if withdrawals, err := versionedBlock.Withdrawals(); err != nil ||
+		withdrawals != nil {
+		t.Fatalf("unexpected phase0 withdrawals: %v, %v", withdrawals, err)
+	}
+
+	versionedBlock.Version = "unknown"
+	if _, err := versionedBlock.Withdrawals(); err == nil {
+		t.Fatalf("expected error on unknown version")
+	}
+}
+
+func TestUnregisteredForkAccessors(t *testing.T) {
+	expectPanic := func(name string, fn func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("%s: expected panic", name)
+			}
+		}()
+		fn()
+	}
+
+	for _, test := range []struct {
+		name    string
+		version string
+		data    eth2api.SignedBeaconBlock
+	}{
+		{"unknown version", "unknown", new(deneb.SignedBeaconBlock)},
+		{"data of another fork", "deneb", new(phase0.SignedBeaconBlock)},
+		{"missing data", "deneb", nil},
+	} {
+		versionedBlock := &VersionedSignedBeaconBlock{
+			VersionedSignedBeaconBlock: &eth2api.VersionedSignedBeaconBlock{
+				Version: test.version,
+				Data:    test.data,
+			},
+		}
+		if _, _, _, err := versionedBlock.ExecutionPayload(); err == nil {
+			t.Fatalf("%s: expected execution payload error", test.name)
+		}
+		expectPanic(test.name, func() { versionedBlock.Slot() })
+		expectPanic(test.name, func() { versionedBlock.Root() })
+	}
+
+	for _, test := range []struct {
+		name    string
+		version string
+		data    common.SpecObj
+	}{
+		{"unknown version", "unknown", new(deneb.BeaconState)},
+		{"data of another fork", "deneb", new(phase0.BeaconState)},
+		{"missing data", "deneb", nil},
+	} {
+		vbs := &VersionedBeaconStateResponse{
+			VersionedBeaconState: &eth2api.VersionedBeaconState{
+				Version: test.version,
+				Data:    test.data,
+			},
+		}
+		if _, err := vbs.NextWithdrawalIndex(); err == nil {
+			t.Fatalf("%s: expected next withdrawal index error", test.name)
+		}
+		expectPanic(test.name, func() { vbs.StateSlot() })
+		expectPanic(test.name, func() { vbs.Root() })
+	}
+}
+
+// Removes a fork registered by a test
+func unregisterTestFork(name string) {
+	forkDefinitionsMu.Lock()
+	defer forkDefinitionsMu.Unlock()
+	delete(forkDefinitionsByName, name)
+	for i, f := range forkDefinitions {
+		if f.Name == name {
+			forkDefinitions = append(forkDefinitions[:i], forkDefinitions[i+1:]...)
+			break
+		}
+	}
+}
+
+func TestRegisterForkValidation(t *testing.T) {
+	denebFork, err := ForkDefinitionByName("deneb")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	phase0Fork, err := ForkDefinitionByName("phase0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, test := range []struct {
+		name   string
+		mutate func(f *ForkDefinition)
+	}{
+		{
+			name:   "duplicate fork",
+			mutate: func(f *ForkDefinition) {},
+		},
+		{
+			name: "missing state accessor",
+			mutate: func(f *ForkDefinition) {
+				f.Name = "test-fork"
+				f.BeaconStateFields = nil
+			},
+		},
+		{
+			name: "accessor of another fork",
+			mutate: func(f *ForkDefinition) {
+				f.Name = "test-fork"
+				f.SignedBeaconBlockFields = phase0Fork.SignedBeaconBlockFields
+			},
+		},
+		{
+			name: "missing execution payload accessor",
+			mutate: func(f *ForkDefinition) {
+				f.Name = "test-fork"
+				f.ExecutableData = nil
+			},
+		},
+		{
+			name: "missing blob kzg commitments",
+			mutate: func(f *ForkDefinition) {
+				*f = *phase0Fork
+				f.Name = "test-fork"
+				f.BlobKZGCommitments = true
+			},
+		},
+		{
+			name: "missing allocators",
+			mutate: func(f *ForkDefinition) {
+				*f = ForkDefinition{Name: "test-fork"}
+			},
+		},
+		{
+			name: "version of a previous fork",
+			mutate: func(f *ForkDefinition) {
+				f.Name = "test-fork"
+			},
+		},
+		{
+			name: "epoch before the previous fork",
+			mutate: func(f *ForkDefinition) {
+				f.Name = "test-fork"
+				f.ForkVersion = func(spec *common.Spec) common.Version {
+					return common.Version{0x05}
+				}
+				f.ForkEpoch = func(spec *common.Spec) common.Epoch {
+					return spec.CAPELLA_FORK_EPOCH
+				}
+			},
+		},
+		{
+			name: "missing fork epoch",
+			mutate: func(f *ForkDefinition) {
+				f.Name = "test-fork"
+				f.ForkVersion = func(spec *common.Spec) common.Version {
+					return common.Version{0x05}
+				}
+				f.ForkEpoch = nil
+			},
+		},
+	} {
+		f := *denebFork
+		test.mutate(&f)
+		if err := RegisterFork(&f); err == nil {
+			t.Fatalf("%s: expected error", test.name)
+		}
+	}
+	if _, err := ForkDefinitionByName("test-fork"); err == nil {
+		t.Fatalf("invalid fork was registered")
+	}
+}
+
+// A new fork whose types keep the fields of the previous fork is supported by
+// registering its definition, without changes to the versioned wrappers
+func TestRegisterFork(t *testing.T) {
+	denebFork, err := ForkDefinitionByName("deneb")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	electra := *denebFork
+	electra.Name = "electra"
+	electra.ForkVersion = func(spec *common.Spec) common.Version {
+		return common.Version{0x05}
+	}
+	electra.ForkEpoch = func(spec *common.Spec) common.Epoch {
+		return common.FAR_FUTURE_EPOCH
+	}
+	if err := RegisterFork(&electra); err != nil {
+		t.Fatalf("unable to register fork: %v", err)
+	}
+	defer unregisterTestFork("electra")
+	if electra.Index != denebFork.Index+1 {
+		t.Fatalf("incorrect fork index: %d", electra.Index)
+	}
+
+	block := new(deneb.SignedBeaconBlock)
+	block.Message.Slot = 12
+	block.Message.Body.BlobKZGCommitments = common.KZGCommitments{{0x01}}
+	data, err := json.Marshal(block)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	resp, err := json.Marshal(versionedResponse{Version: "electra", Data: data})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	versionedBlock := new(VersionedSignedBeaconBlock)
+	if err := json.Unmarshal(resp, versionedBlock); err != nil {
+		t.Fatalf("unable to decode block: %v", err)
+	}
+	if versionedBlock.Slot() != 12 ||
+		len(versionedBlock.KZGCommitments()) != 1 ||
+		!versionedBlock.ContainsWithdrawals() {
+		t.Fatalf("incorrect block fields")
+	}
+
+	state := new(deneb.BeaconState)
+	state.Slot = 12
+	state.NextWithdrawalIndex = 7
+	vbs := &VersionedBeaconStateResponse{
+		VersionedBeaconState: &eth2api.VersionedBeaconState{
+			Version: "electra",
+			Data:    state,
+		},
+	}
+	if vbs.StateSlot() != 12 {
+		t.Fatalf("incorrect state slot: %d", vbs.StateSlot())
+	}
+	if index, err := vbs.NextWithdrawalIndex(); err != nil || index != 7 {
+		t.Fatalf("incorrect next withdrawal index: %d, %v", index, err)
+	}
+	// Data of another fork is not read as the registered fork
+	vbs.Data = new(phase0.BeaconState)
+	if _, err := vbs.NextWithdrawalIndex(); err == nil {
+		t.Fatalf("expected error on mismatching state type")
+	}
+}
@@ -0,0 +1,246 @@
+package beacon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/marioevz/eth-clients/clients/utils"
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// Header served by the light client API. The execution payload header and
+// its branch are only present since Capella and are kept undecoded.
+type LightClientHeader struct {
+	Beacon          common.BeaconBlockHeader `json:"beacon"`
+	Execution       json.RawMessage          `json:"execution,omitempty"`
+	ExecutionBranch []common.Root            `json:"execution_branch,omitempty"`
+}
+
+type LightClientBootstrap struct {
+	// Fork of the header as returned in the version field of the response
+	Version                    string               `json:"-"`
+	Header                     LightClientHeader    `json:"header"`
+	CurrentSyncCommittee       common.SyncCommittee `json:"current_sync_committee"`
+	CurrentSyncCommitteeBranch []common.Root        `json:"current_sync_committee_branch"`
+}
+
+type LightClientUpdate struct {
+	Version                 string               `json:"-"`
+	AttestedHeader          LightClientHeader    `json:"attested_header"`
+	NextSyncCommittee       common.SyncCommittee `json:"next_sync_committee"`
+	NextSyncCommitteeBranch []common.Root        `json:"next_sync_committee_branch"`
+	FinalizedHeader         LightClientHeader    `json:"finalized_header"`
+	FinalityBranch          []common.Root        `json:"finality_branch"`
+	SyncAggregate           altair.SyncAggregate `json:"sync_aggregate"`
+	SignatureSlot           common.Slot          `json:"signature_slot"`
+}
+
+type LightClientFinalityUpdate struct {
+	Version         string               `json:"-"`
+	AttestedHeader  LightClientHeader    `json:"attested_header"`
+	FinalizedHeader LightClientHeader    `json:"finalized_header"`
+	FinalityBranch  []common.Root        `json:"finality_branch"`
+	SyncAggregate   altair.SyncAggregate `json:"sync_aggregate"`
+	SignatureSlot   common.Slot          `json:"signature_slot"`
+}
+
+type LightClientOptimisticUpdate struct {
+	Version        string               `json:"-"`
+	AttestedHeader LightClientHeader    `json:"attested_header"`
+	SyncAggregate  altair.SyncAggregate `json:"sync_aggregate"`
+	SignatureSlot  common.Slot          `json:"signature_slot"`
+}
+
+// Returns the finality update as a full update without a next sync
+// committee
+func (u *LightClientFinalityUpdate) Update() *LightClientUpdate {
+	return &LightClientUpdate{
+		Version:         u.Version,
+		AttestedHeader:  u.AttestedHeader,
+		FinalizedHeader: u.FinalizedHeader,
+		FinalityBranch:  u.FinalityBranch,
+		SyncAggregate:   u.SyncAggregate,
+		SignatureSlot:   u.SignatureSlot,
+	}
+}
+
+// Returns the optimistic update as a full update without a next sync
+// committee nor a finalized header
+func (u *LightClientOptimisticUpdate) Update() *LightClientUpdate {
+	return &LightClientUpdate{
+		Version:        u.Version,
+		AttestedHeader: u.AttestedHeader,
+		SyncAggregate:  u.SyncAggregate,
+		SignatureSlot:  u.SignatureSlot,
+	}
+}
+
+type versionedLightClientResponse[T any] struct {
+	Version string `json:"version"`
+	Data    T      `json:"data"`
+}
+
+func (bn *BeaconClient) LightClientBootstrap(
+	parentCtx context.Context,
+	blockRoot tree.Root,
+) (*LightClientBootstrap, error) {
+	var resp versionedLightClientResponse[LightClientBootstrap]
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	exists, err := eth2api.SimpleRequest(
+		ctx,
+		bn.api,
+		eth2api.FmtGET(
+			"/eth/v1/beacon/light_client/bootstrap/%s",
+			blockRoot.String(),
+		),
+		&resp,
+	)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("bootstrap not found for block %s", blockRoot)
+	}
+	resp.Data.Version = resp.Version
+	return &resp.Data, nil
+}
+
+// Returns the best updates of count sync committee periods starting at the
+// given period
+func (bn *BeaconClient) LightClientUpdates(
+	parentCtx context.Context,
+	startPeriod uint64,
+	count uint64,
+) ([]*LightClientUpdate, error) {
+	var resp []versionedLightClientResponse[LightClientUpdate]
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	exists, err := eth2api.SimpleRequest(
+		ctx,
+		bn.api,
+		eth2api.QueryGET(
+			eth2api.Query{
+				"start_period": strconv.FormatUint(startPeriod, 10),
+				"count":        strconv.FormatUint(count, 10),
+			},
+			"/eth/v1/beacon/light_client/updates",
+		),
+		&resp,
+	)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("endpoint not found on beacon client")
+	}
+	updates := make([]*LightClientUpdate, len(resp))
+	for i := range resp {
+		resp[i].Data.Version = resp[i].Version
+		updates[i] = &resp[i].Data
+	}
+	return updates, nil
+}
+
+func (bn *BeaconClient) LightClientFinalityUpdate(
+	parentCtx context.Context,
+) (*LightClientFinalityUpdate, error) {
+	var resp versionedLightClientResponse[LightClientFinalityUpdate]
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	exists, err := eth2api.SimpleRequest(
+		ctx,
+		bn.api,
+		eth2api.FmtGET("/eth/v1/beacon/light_client/finality_update"),
+		&resp,
+	)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("finality update not available")
+	}
+	resp.Data.Version = resp.Version
+	return &resp.Data, nil
+}
+
+func (bn *BeaconClient) LightClientOptimisticUpdate(
+	parentCtx context.Context,
+) (*LightClientOptimisticUpdate, error) {
+	var resp versionedLightClientResponse[LightClientOptimisticUpdate]
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	exists, err := eth2api.SimpleRequest(
+		ctx,
+		bn.api,
+		eth2api.FmtGET("/eth/v1/beacon/light_client/optimistic_update"),
+		&resp,
+	)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("optimistic update not available")
+	}
+	resp.Data.Version = resp.Version
+	return &resp.Data, nil
+}
+
+// Bootstraps a light client verifier from the trusted block root using the
+// light client server of the beacon client
+func (bn *BeaconClient) LightClientVerifier(
+	ctx context.Context,
+	trustedBlockRoot tree.Root,
+) (*LightClientVerifier, error) {
+	bootstrap, err := bn.LightClientBootstrap(ctx, trustedBlockRoot)
+	if err != nil {
+		return nil, err
+	}
+	return NewLightClientVerifier(bn.ForkSchedule(), trustedBlockRoot, bootstrap)
+}
+
+// Feeds the verifier with the updates served by the beacon client: the best
+// update of every period since the finalized header of the verifier, then
+// the latest finality and optimistic updates
+func (bn *BeaconClient) SyncLightClient(
+	ctx context.Context,
+	v *LightClientVerifier,
+) error {
+	var (
+		startPeriod   = v.period(v.FinalizedHeader.Slot)
+		currentPeriod = v.period(bn.SlotClock().CurrentSlot())
+	)
+	updates, err := bn.LightClientUpdates(
+		ctx,
+		startPeriod,
+		currentPeriod-startPeriod+1,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to get updates: %v", err)
+	}
+	for _, u := range updates {
+		if _, err := v.ProcessUpdate(u); err != nil {
+			return fmt.Errorf(
+				"invalid update at slot %d: %v",
+				u.AttestedHeader.Beacon.Slot,
+				err,
+			)
+		}
+	}
+	finality, err := bn.LightClientFinalityUpdate(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get finality update: %v", err)
+	}
+	if _, err := v.ProcessFinalityUpdate(finality); err != nil {
+		return fmt.Errorf("invalid finality update: %v", err)
+	}
+	optimistic, err := bn.LightClientOptimisticUpdate(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get optimistic update: %v", err)
+	}
+	if _, err := v.ProcessOptimisticUpdate(optimistic); err != nil {
+		return fmt.Errorf("invalid optimistic update: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,369 @@
+package beacon
+
+import (
+	"errors"
+	"fmt"
+
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/util/merkle"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// Generalized indexes of the light client proofs in the beacon state, as
+// defined by the Altair light client sync protocol and valid until Electra
+const (
+	CURRENT_SYNC_COMMITTEE_GINDEX = 54
+	NEXT_SYNC_COMMITTEE_GINDEX    = 55
+	FINALIZED_ROOT_GINDEX         = 105
+)
+
+// Minimum number of sync committee participants for an update to be
+// considered
+const MIN_SYNC_COMMITTEE_PARTICIPANTS = 1
+
+// Light client store that follows the sync committee signatures starting
+// from a trusted block root, following the Altair sync protocol. The
+// optimistic header follows updates signed by more than half of the sync
+// committee, while the finalized header and the sync committees only change
+// with updates signed by a supermajority. The execution branch of the
+// headers is not verified.
+type LightClientVerifier struct {
+	Schedule *ForkSchedule
+
+	FinalizedHeader      common.BeaconBlockHeader
+	OptimisticHeader     common.BeaconBlockHeader
+	CurrentSyncCommittee common.SyncCommittee
+	// Nil until an update containing the next sync committee of the period
+	// of the finalized header is applied
+	NextSyncCommittee *common.SyncCommittee
+}
+
+// Returns the depth of the generalized index and the index of its node at
+// that depth
+func gindexDepthAndIndex(gindex uint64) (uint64, uint64) {
+	depth := uint64(0)
+	for g := gindex; g > 1; g >>= 1 {
+		depth++
+	}
+	return depth, gindex - (1 << depth)
+}
+
+func verifyStateBranch(
+	leaf tree.Root,
+	branch []common.Root,
+	gindex uint64,
+	stateRoot common.Root,
+) error {
+	depth, index := gindexDepthAndIndex(gindex)
+	if uint64(len(branch)) != depth {
+		return fmt.Errorf(
+			"invalid branch length: want %d, got %d",
+			depth,
+			len(branch),
+		)
+	}
+	if !merkle.VerifyMerkleBranch(leaf, branch, depth, index, stateRoot) {
+		return fmt.Errorf("invalid branch for gindex %d", gindex)
+	}
+	return nil
+}
+
+func isZeroBranch(branch []common.Root) bool {
+	for _, r := range branch {
+		if r != (common.Root{}) {
+			return false
+		}
+	}
+	return true
+}
+
+func isZeroSyncCommittee(c *common.SyncCommittee) bool {
+	for _, p := range c.Pubkeys {
+		if p != (common.BLSPubkey{}) {
+			return false
+		}
+	}
+	return c.AggregatePubkey == common.BLSPubkey{}
+}
+
+// Initializes the verifier from the bootstrap of the trusted block root
+func NewLightClientVerifier(
+	schedule *ForkSchedule,
+	trustedBlockRoot tree.Root,
+	bootstrap *LightClientBootstrap,
+) (*LightClientVerifier, error) {
+	var (
+		spec   = schedule.Spec
+		hFn    = tree.GetHashFn()
+		header = bootstrap.Header.Beacon
+	)
+	if root := header.HashTreeRoot(hFn); root != trustedBlockRoot {
+		return nil, fmt.Errorf(
+			"bootstrap header root mismatch: want %s, got %s",
+			trustedBlockRoot,
+			root,
+		)
+	}
+	if err := verifyStateBranch(
+		bootstrap.CurrentSyncCommittee.HashTreeRoot(spec, hFn),
+		bootstrap.CurrentSyncCommitteeBranch,
+		CURRENT_SYNC_COMMITTEE_GINDEX,
+		header.StateRoot,
+	); err != nil {
+		return nil, fmt.Errorf("invalid current sync committee: %v", err)
+	}
+	return &LightClientVerifier{
+		Schedule:             schedule,
+		FinalizedHeader:      header,
+		OptimisticHeader:     header,
+		CurrentSyncCommittee: bootstrap.CurrentSyncCommittee,
+	}, nil
+}
+
+func (v *LightClientVerifier) period(slot common.Slot) uint64 {
+	spec := v.Schedule.Spec
+	return uint64(spec.SlotToEpoch(slot) / spec.EPOCHS_PER_SYNC_COMMITTEE_PERIOD)
+}
+
+func participants(u *LightClientUpdate, size uint64) uint64 {
+	count := uint64(0)
+	for i := uint64(0); i < size; i++ {
+		if u.SyncAggregate.SyncCommitteeBits.GetBit(i) {
+			count++
+		}
+	}
+	return count
+}
+
+// Returns whether the update contains the next sync committee of the period
+// of the finalized header, which is still unknown to the verifier
+func (v *LightClientVerifier) updateHasNextSyncCommittee(
+	u *LightClientUpdate,
+) bool {
+	return v.NextSyncCommittee == nil &&
+		!isZeroBranch(u.NextSyncCommitteeBranch) &&
+		v.period(u.AttestedHeader.Beacon.Slot) == v.period(v.FinalizedHeader.Slot)
+}
+
+// Returns whether the update contains the next sync committee of the period
+// of the finalized header and finalizes a header of the same period as the
+// attested header, which allows applying it without finalizing a newer
+// header
+func (v *LightClientVerifier) updateHasFinalizedNextSyncCommittee(
+	u *LightClientUpdate,
+) bool {
+	return v.NextSyncCommittee == nil &&
+		!isZeroBranch(u.NextSyncCommitteeBranch) &&
+		!isZeroBranch(u.FinalityBranch) &&
+		v.period(u.FinalizedHeader.Beacon.Slot) ==
+			v.period(u.AttestedHeader.Beacon.Slot)
+}
+
+// Validates the update against the current state of the verifier
+func (v *LightClientVerifier) Validate(u *LightClientUpdate) error {
+	var (
+		spec              = v.Schedule.Spec
+		hFn               = tree.GetHashFn()
+		committeeSize     = uint64(spec.SYNC_COMMITTEE_SIZE)
+		attested          = u.AttestedHeader.Beacon
+		finalized         = u.FinalizedHeader.Beacon
+		storePeriod       = v.period(v.FinalizedHeader.Slot)
+		signaturePeriod   = v.period(u.SignatureSlot)
+		attestedPeriod    = v.period(attested.Slot)
+		isFinalityUpdate  = !isZeroBranch(u.FinalityBranch)
+		isCommitteeUpdate = !isZeroBranch(u.NextSyncCommitteeBranch)
+	)
+	if uint64(len(u.SyncAggregate.SyncCommitteeBits)) != (committeeSize+7)/8 {
+		return fmt.Errorf(
+			"invalid sync committee bits length: %d",
+			len(u.SyncAggregate.SyncCommitteeBits),
+		)
+	}
+	if participants(u, committeeSize) < MIN_SYNC_COMMITTEE_PARTICIPANTS {
+		return errors.New("not enough sync committee participants")
+	}
+	if !(u.SignatureSlot > attested.Slot && attested.Slot >= finalized.Slot) {
+		return fmt.Errorf(
+			"invalid slots: signature %d, attested %d, finalized %d",
+			u.SignatureSlot,
+			attested.Slot,
+			finalized.Slot,
+		)
+	}
+	if v.NextSyncCommittee != nil {
+		if signaturePeriod != storePeriod && signaturePeriod != storePeriod+1 {
+			return fmt.Errorf(
+				"signature period %d not in store period %d or the next",
+				signaturePeriod,
+				storePeriod,
+			)
+		}
+	} else if signaturePeriod != storePeriod {
+		return fmt.Errorf(
+			"signature period %d not in store period %d",
+			signaturePeriod,
+			storePeriod,
+		)
+	}
+	if attested.Slot <= v.FinalizedHeader.Slot && !v.updateHasNextSyncCommittee(u) {
+		return fmt.Errorf(
+			"update attested slot %d not newer than finalized slot %d",
+			attested.Slot,
+			v.FinalizedHeader.Slot,
+		)
+	}
+
+	// Verify that the finalized header is part of the attested state
+	if !isFinalityUpdate {
+		if finalized != (common.BeaconBlockHeader{}) {
+			return errors.New("finalized header without finality branch")
+		}
+	} else {
+		var finalizedRoot common.Root
+		if finalized.Slot != 0 {
+			finalizedRoot = finalized.HashTreeRoot(hFn)
+		} else if finalized != (common.BeaconBlockHeader{}) {
+			return errors.New("genesis finalized header must be empty")
+		}
+		if err := verifyStateBranch(
+			finalizedRoot,
+			u.FinalityBranch,
+			FINALIZED_ROOT_GINDEX,
+			attested.StateRoot,
+		); err != nil {
+			return fmt.Errorf("invalid finalized header: %v", err)
+		}
+	}
+
+	// Verify that the next sync committee is part of the attested state
+	if !isCommitteeUpdate {
+		if !isZeroSyncCommittee(&u.NextSyncCommittee) {
+			return errors.New("next sync committee without branch")
+		}
+	} else {
+		if attestedPeriod == storePeriod && v.NextSyncCommittee != nil &&
+			u.NextSyncCommittee.HashTreeRoot(spec, hFn) !=
+				v.NextSyncCommittee.HashTreeRoot(spec, hFn) {
+			return errors.New("next sync committee differs from known one")
+		}
+		if err := verifyStateBranch(
+			u.NextSyncCommittee.HashTreeRoot(spec, hFn),
+			u.NextSyncCommitteeBranch,
+			NEXT_SYNC_COMMITTEE_GINDEX,
+			attested.StateRoot,
+		); err != nil {
+			return fmt.Errorf("invalid next sync committee: %v", err)
+		}
+	}
+
+	// Verify the sync committee signature of the attested header
+	committee := &v.CurrentSyncCommittee
+	if signaturePeriod != storePeriod {
+		committee = v.NextSyncCommittee
+	}
+	if uint64(len(committee.Pubkeys)) != committeeSize {
+		return fmt.Errorf("invalid sync committee size: %d", len(committee.Pubkeys))
+	}
+	pubkeys := make([]*blsu.Pubkey, 0, committeeSize)
+	for i := uint64(0); i < committeeSize; i++ {
+		if !u.SyncAggregate.SyncCommitteeBits.GetBit(i) {
+			continue
+		}
+		pub, err := committee.Pubkeys[i].Pubkey()
+		if err != nil {
+			return fmt.Errorf("invalid sync committee pubkey %d: %v", i, err)
+		}
+		pubkeys = append(pubkeys, pub)
+	}
+	forkVersionSlot := u.SignatureSlot
+	if forkVersionSlot > 0 {
+		forkVersionSlot--
+	}
+	domain := v.Schedule.Domain(
+		common.DOMAIN_SYNC_COMMITTEE,
+		spec.SlotToEpoch(forkVersionSlot),
+	)
+	signingRoot := common.ComputeSigningRoot(attested.HashTreeRoot(hFn), domain)
+	sig, err := u.SyncAggregate.SyncCommitteeSignature.Signature()
+	if err != nil {
+		return fmt.Errorf("invalid sync committee signature: %v", err)
+	}
+	if !blsu.Eth2FastAggregateVerify(pubkeys, signingRoot[:], sig) {
+		return errors.New("invalid sync committee signature")
+	}
+	return nil
+}
+
+// Validates the update and applies it to the verifier. Returns whether the
+// finalized or optimistic header of the verifier changed.
+func (v *LightClientVerifier) ProcessUpdate(u *LightClientUpdate) (bool, error) {
+	if err := v.Validate(u); err != nil {
+		return false, err
+	}
+	var (
+		spec          = v.Schedule.Spec
+		committeeSize = uint64(spec.SYNC_COMMITTEE_SIZE)
+		count         = participants(u, committeeSize)
+		attested      = u.AttestedHeader.Beacon
+		finalized     = u.FinalizedHeader.Beacon
+		changed       = false
+	)
+	// Follow the attested header if more than half of the committee signed
+	if count*2 > committeeSize && attested.Slot > v.OptimisticHeader.Slot {
+		v.OptimisticHeader = attested
+		changed = true
+	}
+	if count*3 < committeeSize*2 {
+		return changed, nil
+	}
+	if finalized.Slot <= v.FinalizedHeader.Slot &&
+		!v.updateHasFinalizedNextSyncCommittee(u) {
+		return changed, nil
+	}
+	// Only finality updates reach this point
+	var (
+		storePeriod     = v.period(v.FinalizedHeader.Slot)
+		finalizedPeriod = v.period(finalized.Slot)
+	)
+	if v.NextSyncCommittee == nil {
+		if finalizedPeriod != storePeriod {
+			return changed, fmt.Errorf(
+				"finalized period %d skips store period %d",
+				finalizedPeriod,
+				storePeriod,
+			)
+		}
+		if !isZeroBranch(u.NextSyncCommitteeBranch) {
+			next := u.NextSyncCommittee
+			v.NextSyncCommittee = &next
+		}
+	} else if finalizedPeriod == storePeriod+1 {
+		v.CurrentSyncCommittee = *v.NextSyncCommittee
+		v.NextSyncCommittee = nil
+		if !isZeroBranch(u.NextSyncCommitteeBranch) {
+			next := u.NextSyncCommittee
+			v.NextSyncCommittee = &next
+		}
+	}
+	if finalized.Slot > v.FinalizedHeader.Slot {
+		v.FinalizedHeader = finalized
+		if finalized.Slot > v.OptimisticHeader.Slot {
+			v.OptimisticHeader = finalized
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+func (v *LightClientVerifier) ProcessFinalityUpdate(
+	u *LightClientFinalityUpdate,
+) (bool, error) {
+	return v.ProcessUpdate(u.Update())
+}
+
+func (v *LightClientVerifier) ProcessOptimisticUpdate(
+	u *LightClientOptimisticUpdate,
+) (bool, error) {
+	return v.ProcessUpdate(u.Update())
+}
@@ -0,0 +1,272 @@
+/*
+Tests for the light client verifier
+*/
+package beacon
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/ztyp/tree"
+)
+
+type testSyncCommittee struct {
+	keys      []*blsu.SecretKey
+	committee common.SyncCommittee
+}
+
+func newTestSyncCommittee(t *testing.T, spec *common.Spec, seed byte) *testSyncCommittee {
+	c := &testSyncCommittee{}
+	for i := 0; i < int(spec.SYNC_COMMITTEE_SIZE); i++ {
+		var raw [32]byte
+		raw[0], raw[1], raw[31] = seed, byte(i), 1
+		sk := new(blsu.SecretKey)
+		if err := sk.Deserialize(&raw); err != nil {
+			t.Fatalf("unable to create key: %v", err)
+		}
+		pk, err := blsu.SkToPk(sk)
+		if err != nil {
+			t.Fatalf("unable to derive pubkey: %v", err)
+		}
+		c.keys = append(c.keys, sk)
+		c.committee.Pubkeys = append(c.committee.Pubkeys, common.BLSPubkey(pk.Serialize()))
+	}
+	return c
+}
+
+// Signs the header with every member of the committee
+func (c *testSyncCommittee) sign(
+	t *testing.T,
+	spec *common.Spec,
+	schedule *ForkSchedule,
+	header common.BeaconBlockHeader,
+	signatureSlot common.Slot,
+) altair.SyncAggregate {
+	domain := schedule.Domain(
+		common.DOMAIN_SYNC_COMMITTEE,
+		spec.SlotToEpoch(signatureSlot-1),
+	)
+	signingRoot := common.ComputeSigningRoot(header.HashTreeRoot(tree.GetHashFn()), domain)
+	sigs := make([]*blsu.Signature, len(c.keys))
+	bits := make(altair.SyncCommitteeBits, (spec.SYNC_COMMITTEE_SIZE+7)/8)
+	for i, sk := range c.keys {
+		sigs[i] = blsu.Sign(sk, signingRoot[:])
+		bits.SetBit(uint64(i), true)
+	}
+	agg, err := blsu.Aggregate(sigs)
+	if err != nil {
+		t.Fatalf("unable to aggregate signatures: %v", err)
+	}
+	return altair.SyncAggregate{
+		SyncCommitteeBits:      bits,
+		SyncCommitteeSignature: common.BLSSignature(agg.Serialize()),
+	}
+}
+
+func testStateHeader(
+	spec *common.Spec,
+	state *altair.BeaconState,
+) common.BeaconBlockHeader {
+	return common.BeaconBlockHeader{
+		Slot:      state.Slot,
+		StateRoot: state.HashTreeRoot(spec, tree.GetHashFn()),
+	}
+}
+
+// Returns the branch of the node of the state, without the roots that the
+// proof may include after it
+func testStateBranch(
+	spec *common.Spec,
+	state *altair.BeaconState,
+	gindex uint64,
+) []common.Root {
+	proof := state.HashTreeProof(spec, tree.GetHashFn(), tree.Gindex64(gindex))
+	depth, _ := gindexDepthAndIndex(gindex)
+	return proof[:depth]
+}
+
+func TestLightClientVerifier(t *testing.T) {
+	spec := *configs.Minimal
+	spec.ALTAIR_FORK_EPOCH = 0
+	var (
+		hFn       = tree.GetHashFn()
+		schedule  = NewForkSchedule(&spec, 0, tree.Root{0x01})
+		current   = newTestSyncCommittee(t, &spec, 1)
+		next      = newTestSyncCommittee(t, &spec, 2)
+		periodLen = common.Slot(spec.SLOTS_PER_EPOCH) *
+			common.Slot(spec.EPOCHS_PER_SYNC_COMMITTEE_PERIOD)
+	)
+
+	// Bootstrap from a trusted block in the first period
+	bootstrapState := &altair.BeaconState{
+		Slot:                 8,
+		CurrentSyncCommittee: current.committee,
+		NextSyncCommittee:    next.committee,
+	}
+	bootstrapHeader := testStateHeader(&spec, bootstrapState)
+	bootstrap := &LightClientBootstrap{
+		Header:                     LightClientHeader{Beacon: bootstrapHeader},
+		CurrentSyncCommittee:       current.committee,
+		CurrentSyncCommitteeBranch: testStateBranch(&spec, bootstrapState, CURRENT_SYNC_COMMITTEE_GINDEX),
+	}
+	if _, err := NewLightClientVerifier(schedule, tree.Root{0x02}, bootstrap); err == nil {
+		t.Fatalf("bootstrap accepted for an untrusted root")
+	}
+	verifier, err := NewLightClientVerifier(
+		schedule,
+		bootstrapHeader.HashTreeRoot(hFn),
+		bootstrap,
+	)
+	if err != nil {
+		t.Fatalf("unable to bootstrap: %v", err)
+	}
+
+	// Finalize a block of the first period and learn the next committee
+	finalizedHeader := common.BeaconBlockHeader{Slot: 16, StateRoot: common.Root{0x03}}
+	attestedState := &altair.BeaconState{
+		Slot:                 24,
+		CurrentSyncCommittee: current.committee,
+		NextSyncCommittee:    next.committee,
+		FinalizedCheckpoint: common.Checkpoint{
+			Epoch: 2,
+			Root:  finalizedHeader.HashTreeRoot(hFn),
+		},
+	}
+	attestedHeader := testStateHeader(&spec, attestedState)
+	newUpdate := func() *LightClientUpdate {
+		return &LightClientUpdate{
+			AttestedHeader:          LightClientHeader{Beacon: attestedHeader},
+			NextSyncCommittee:       next.committee,
+			NextSyncCommitteeBranch: testStateBranch(&spec, attestedState, NEXT_SYNC_COMMITTEE_GINDEX),
+			FinalizedHeader:         LightClientHeader{Beacon: finalizedHeader},
+			FinalityBranch:          testStateBranch(&spec, attestedState, FINALIZED_ROOT_GINDEX),
+			SyncAggregate:           current.sign(t, &spec, schedule, attestedHeader, 25),
+			SignatureSlot:           25,
+		}
+	}
+
+	for _, test := range []struct {
+		name   string
+		mutate func(u *LightClientUpdate)
+	}{
+		{
+			name: "signed by the wrong committee",
+			mutate: func(u *LightClientUpdate) {
+				u.SyncAggregate = next.sign(t, &spec, schedule, attestedHeader, 25)
+			},
+		},
+		{
+			name: "missing participant",
+			mutate: func(u *LightClientUpdate) {
+				u.SyncAggregate.SyncCommitteeBits.SetBit(0, false)
+			},
+		},
+		{
+			name: "invalid finality branch",
+			mutate: func(u *LightClientUpdate) {
+				u.FinalizedHeader.Beacon.Slot++
+			},
+		},
+		{
+			name: "invalid next sync committee branch",
+			mutate: func(u *LightClientUpdate) {
+				u.NextSyncCommittee = current.committee
+			},
+		},
+		{
+			name: "signature slot in a future period",
+			mutate: func(u *LightClientUpdate) {
+				u.SignatureSlot = periodLen + 1
+			},
+		},
+	} {
+		u := newUpdate()
+		test.mutate(u)
+		if _, err := verifier.ProcessUpdate(u); err == nil {
+			t.Fatalf("%s: invalid update accepted", test.name)
+		}
+	}
+
+	// A committee update without finality only moves the optimistic header
+	committeeUpdate := newUpdate()
+	committeeUpdate.FinalizedHeader = LightClientHeader{}
+	committeeUpdate.FinalityBranch = make([]common.Root, len(committeeUpdate.FinalityBranch))
+	changed, err := verifier.ProcessUpdate(committeeUpdate)
+	if err != nil {
+		t.Fatalf("unable to process committee update: %v", err)
+	}
+	if !changed || verifier.OptimisticHeader != attestedHeader ||
+		verifier.FinalizedHeader != bootstrapHeader {
+		t.Fatalf("incorrect headers after committee update")
+	}
+	if verifier.NextSyncCommittee != nil {
+		t.Fatalf("next sync committee learned from an update without finality")
+	}
+
+	changed, err = verifier.ProcessUpdate(newUpdate())
+	if err != nil {
+		t.Fatalf("unable to process update: %v", err)
+	}
+	if !changed || verifier.FinalizedHeader != finalizedHeader ||
+		verifier.OptimisticHeader != attestedHeader {
+		t.Fatalf(
+			"incorrect headers after update: finalized %+v, optimistic %+v",
+			verifier.FinalizedHeader,
+			verifier.OptimisticHeader,
+		)
+	}
+	if verifier.NextSyncCommittee == nil {
+		t.Fatalf("next sync committee not learned")
+	}
+	// The same update is still valid but does not change the headers
+	if changed, err := verifier.ProcessUpdate(newUpdate()); err != nil || changed {
+		t.Fatalf("incorrect result of repeated update: changed %t, err %v", changed, err)
+	}
+
+	// Optimistic updates signed by the next committee in the next period
+	optimisticHeader := common.BeaconBlockHeader{Slot: periodLen + 2}
+	changed, err = verifier.ProcessOptimisticUpdate(&LightClientOptimisticUpdate{
+		AttestedHeader: LightClientHeader{Beacon: optimisticHeader},
+		SyncAggregate:  next.sign(t, &spec, schedule, optimisticHeader, periodLen+3),
+		SignatureSlot:  periodLen + 3,
+	})
+	if err != nil {
+		t.Fatalf("unable to process optimistic update: %v", err)
+	}
+	if !changed || verifier.OptimisticHeader != optimisticHeader ||
+		verifier.FinalizedHeader != finalizedHeader {
+		t.Fatalf("incorrect headers after optimistic update")
+	}
+}
+
+func TestLightClientUpdates(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/eth/v1/beacon/light_client/updates" ||
+			r.URL.Query().Get("start_period") != "1" ||
+			r.URL.Query().Get("count") != "2" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`[
+			{"version": "altair", "data": {"attested_header": {"beacon": {"slot": "70"}}, "signature_slot": "71"}},
+			{"version": "capella", "data": {"attested_header": {"beacon": {"slot": "140"}, "execution_branch": []}, "signature_slot": "141"}}
+		]`))
+	}))
+	defer srv.Close()
+	bn := testBeaconClient(t, srv.URL, nil)
+	updates, err := bn.LightClientUpdates(context.Background(), 1, 2)
+	if err != nil {
+		t.Fatalf("unable to get updates: %v", err)
+	}
+	if len(updates) != 2 ||
+		updates[0].Version != "altair" || updates[0].AttestedHeader.Beacon.Slot != 70 ||
+		updates[1].Version != "capella" || updates[1].SignatureSlot != 141 {
+		t.Fatalf("incorrect updates: %+v", updates)
+	}
+}
@@ -0,0 +1,136 @@
+package beacon
+
+import (
+	"context"
+	"time"
+
+	"github.com/marioevz/eth-clients/clients/utils"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// Number of intervals each slot is divided into: blocks are proposed at the
+// start of the slot, attestations are produced at the first interval and
+// aggregated at the second
+const INTERVALS_PER_SLOT = 3
+
+// Slot and epoch timing of a network, derived from its genesis time
+type SlotClock struct {
+	Spec        *common.Spec
+	GenesisTime common.Timestamp
+	Clock       utils.Clock
+}
+
+// Returns a slot clock that uses the system time if no clock is given
+func NewSlotClock(
+	spec *common.Spec,
+	genesisTime common.Timestamp,
+	clock utils.Clock,
+) *SlotClock {
+	if clock == nil {
+		clock = utils.SystemClock{}
+	}
+	return &SlotClock{
+		Spec:        spec,
+		GenesisTime: genesisTime,
+		Clock:       clock,
+	}
+}
+
+func (c *SlotClock) Now() common.Timestamp {
+	return common.Timestamp(c.Clock.Now().Unix())
+}
+
+func (c *SlotClock) SlotDuration() time.Duration {
+	return time.Duration(c.Spec.SECONDS_PER_SLOT) * time.Second
+}
+
+// Returns the slot at the current time, zero before genesis
+func (c *SlotClock) CurrentSlot() common.Slot {
+	return c.Spec.TimeToSlot(c.Now(), c.GenesisTime)
+}
+
+func (c *SlotClock) CurrentEpoch() common.Epoch {
+	return c.Spec.SlotToEpoch(c.CurrentSlot())
+}
+
+func (c *SlotClock) SlotStart(slot common.Slot) time.Time {
+	return time.Unix(int64(c.GenesisTime), 0).Add(
+		time.Duration(slot) * c.SlotDuration(),
+	)
+}
+
+func (c *SlotClock) EpochStart(epoch common.Epoch) time.Time {
+	return c.SlotStart(common.Slot(epoch) * c.Spec.SLOTS_PER_EPOCH)
+}
+
+// Time at which the attestations of the slot are due, a third into the slot
+func (c *SlotClock) AttestationDeadline(slot common.Slot) time.Time {
+	return c.SlotStart(slot).Add(c.SlotDuration() / INTERVALS_PER_SLOT)
+}
+
+// Time at which the aggregates of the slot are due, two thirds into the slot
+func (c *SlotClock) AggregationDeadline(slot common.Slot) time.Time {
+	return c.SlotStart(slot).Add(2 * c.SlotDuration() / INTERVALS_PER_SLOT)
+}
+
+func (c *SlotClock) isBeforeGenesis() bool {
+	return c.Clock.Now().Before(c.SlotStart(0))
+}
+
+// Returns the time until the start of the next slot, or until genesis if it
+// has not happened yet
+func (c *SlotClock) TimeUntilNextSlot() time.Duration {
+	if c.isBeforeGenesis() {
+		return c.SlotStart(0).Sub(c.Clock.Now())
+	}
+	return c.SlotStart(c.CurrentSlot() + 1).Sub(c.Clock.Now())
+}
+
+// Returns the time until the start of the next epoch, or until genesis if
+// it has not happened yet
+func (c *SlotClock) TimeUntilNextEpoch() time.Duration {
+	if c.isBeforeGenesis() {
+		return c.SlotStart(0).Sub(c.Clock.Now())
+	}
+	return c.EpochStart(c.CurrentEpoch() + 1).Sub(c.Clock.Now())
+}
+
+// Ticker that fires once per slot duration, starting one slot from now
+func (c *SlotClock) NewSlotTicker() utils.Ticker {
+	return c.Clock.NewTicker(c.SlotDuration())
+}
+
+// Waits until the given time has been reached or the context is done
+func (c *SlotClock) WaitUntil(ctx context.Context, t time.Time) error {
+	d := t.Sub(c.Clock.Now())
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.Clock.After(d):
+		return nil
+	}
+}
+
+// Waits until the start of the given slot
+func (c *SlotClock) WaitForSlot(ctx context.Context, slot common.Slot) error {
+	return c.WaitUntil(ctx, c.SlotStart(slot))
+}
+
+// Waits until the attestation deadline of the given slot
+func (c *SlotClock) WaitForAttestationDeadline(
+	ctx context.Context,
+	slot common.Slot,
+) error {
+	return c.WaitUntil(ctx, c.AttestationDeadline(slot))
+}
+
+// Waits until the aggregation deadline of the given slot
+func (c *SlotClock) WaitForAggregationDeadline(
+	ctx context.Context,
+	slot common.Slot,
+) error {
+	return c.WaitUntil(ctx, c.AggregationDeadline(slot))
+}
@@ -0,0 +1,239 @@
+/*
+Tests for the slot clock and the helpers that wait on it
+*/
+package beacon
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/marioevz/eth-clients/clients"
+	"github.com/marioevz/eth-clients/clients/utils"
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/bellatrix"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/ztyp/tree"
+)
+
+const testGenesisTime = common.Timestamp(1_000_000)
+
+func TestSlotClock(t *testing.T) {
+	var (
+		spec         = configs.Minimal
+		genesis      = time.Unix(int64(testGenesisTime), 0)
+		slotDuration = time.Duration(spec.SECONDS_PER_SLOT) * time.Second
+		fake         = utils.NewFakeClock(genesis.Add(-time.Second))
+		c            = NewSlotClock(spec, testGenesisTime, fake)
+	)
+
+	// Before genesis
+	if slot := c.CurrentSlot(); slot != 0 {
+		t.Fatalf("incorrect slot before genesis: %d", slot)
+	}
+	if d := c.TimeUntilNextSlot(); d != time.Second {
+		t.Fatalf("incorrect time until genesis: %s", d)
+	}
+
+	fake.Set(genesis.Add(slotDuration*time.Duration(spec.SLOTS_PER_EPOCH+1) + time.Second))
+	if slot := c.CurrentSlot(); slot != spec.SLOTS_PER_EPOCH+1 {
+		t.Fatalf("incorrect current slot: %d", slot)
+	}
+	if epoch := c.CurrentEpoch(); epoch != 1 {
+		t.Fatalf("incorrect current epoch: %d", epoch)
+	}
+	if d := c.TimeUntilNextSlot(); d != slotDuration-time.Second {
+		t.Fatalf("incorrect time until next slot: %s", d)
+	}
+	expected := slotDuration*time.Duration(spec.SLOTS_PER_EPOCH-1) - time.Second
+	if d := c.TimeUntilNextEpoch(); d != expected {
+		t.Fatalf("incorrect time until next epoch: want %s, got %s", expected, d)
+	}
+	if d := c.AttestationDeadline(2).Sub(c.SlotStart(2)); d != slotDuration/3 {
+		t.Fatalf("incorrect attestation deadline: %s", d)
+	}
+	if d := c.AggregationDeadline(2).Sub(c.SlotStart(2)); d != 2*slotDuration/3 {
+		t.Fatalf("incorrect aggregation deadline: %s", d)
+	}
+}
+
+func TestSlotClockWait(t *testing.T) {
+	var (
+		spec  = configs.Minimal
+		fake  = utils.NewFakeClock(time.Unix(int64(testGenesisTime), 0))
+		c     = NewSlotClock(spec, testGenesisTime, fake)
+		slot  = common.Slot(3)
+		ctx   = context.Background()
+		errCh = make(chan error, 1)
+	)
+	go func() {
+		errCh <- c.WaitForAttestationDeadline(ctx, slot)
+	}()
+	fake.BlockUntil(1)
+	fake.Set(c.AttestationDeadline(slot).Add(-time.Nanosecond))
+	select {
+	case err := <-errCh:
+		t.Fatalf("wait returned before the deadline: %v", err)
+	default:
+	}
+	fake.Advance(time.Nanosecond)
+	if err := <-errCh; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if now := fake.Now(); !now.Equal(c.AttestationDeadline(slot)) {
+		t.Fatalf("incorrect time after wait: %s", now)
+	}
+
+	// Deadlines already in the past return immediately
+	if err := c.WaitForSlot(ctx, slot); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Cancelled contexts stop the wait
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := c.WaitForAggregationDeadline(cancelCtx, slot+1); err == nil {
+		t.Fatalf("wait on cancelled context returned no error")
+	}
+}
+
+// Serves the blocks of a chain where the blocks from payloadFrom onwards
+// have an execution payload, the head being the last block
+func testBlocksServer(t *testing.T, slots int, payloadFrom int) *httptest.Server {
+	blocks := make([]*bellatrix.SignedBeaconBlock, slots)
+	for i := range blocks {
+		b := new(bellatrix.SignedBeaconBlock)
+		b.Message.Slot = common.Slot(i)
+		if i >= payloadFrom {
+			b.Message.Body.ExecutionPayload.BlockHash = common.Hash32{byte(i)}
+		}
+		blocks[i] = b
+	}
+	respond := func(w http.ResponseWriter, v interface{}) {
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			t.Errorf("unable to encode response: %v", err)
+		}
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		head := blocks[len(blocks)-1]
+		switch {
+		case r.URL.Path == "/eth/v1/beacon/headers/head":
+			respond(w, map[string]interface{}{
+				"data": eth2api.BeaconBlockHeaderAndInfo{
+					Root:      tree.Root{0x01},
+					Canonical: true,
+					Header: common.SignedBeaconBlockHeader{
+						Message: common.BeaconBlockHeader{Slot: head.Message.Slot},
+					},
+				},
+			})
+		case strings.HasPrefix(r.URL.Path, "/eth/v2/beacon/blocks/"):
+			id := strings.TrimPrefix(r.URL.Path, "/eth/v2/beacon/blocks/")
+			block := head
+			if slot, err := strconv.Atoi(id); err == nil {
+				if slot >= len(blocks) {
+					http.NotFound(w, r)
+					return
+				}
+				block = blocks[slot]
+			}
+			respond(w, map[string]interface{}{
+				"version": "bellatrix",
+				"data":    block,
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func testBeaconClient(
+	t *testing.T,
+	url string,
+	clock utils.Clock,
+) *BeaconClient {
+	external, err := clients.ExternalClientFromURL(url, "test-bn")
+	if err != nil {
+		t.Fatalf("unable to create client: %v", err)
+	}
+	var (
+		genesisTime = testGenesisTime
+		gvr         = tree.Root{}
+	)
+	bn := &BeaconClient{
+		Client: external,
+		Config: BeaconClientConfig{
+			Spec:                  configs.Minimal,
+			GenesisTime:           &genesisTime,
+			GenesisValidatorsRoot: &gvr,
+			Clock:                 clock,
+		},
+	}
+	if err := bn.Init(context.Background()); err != nil {
+		t.Fatalf("unable to init client: %v", err)
+	}
+	return bn
+}
+
+func TestWaitForExecutionPayload(t *testing.T) {
+	srv := testBlocksServer(t, 3, 2)
+	defer srv.Close()
+	var (
+		fake   = utils.NewFakeClock(time.Unix(int64(testGenesisTime), 0))
+		bn     = testBeaconClient(t, srv.URL, fake)
+		hashCh = make(chan ethcommon.Hash, 1)
+		errCh  = make(chan error, 1)
+	)
+	go func() {
+		hash, err := bn.WaitForExecutionPayload(context.Background())
+		if err != nil {
+			errCh <- err
+			return
+		}
+		hashCh <- hash
+	}()
+	// The head is only polled once a slot has passed
+	fake.BlockUntil(1)
+	fake.Advance(bn.SlotClock().SlotDuration())
+	select {
+	case err := <-errCh:
+		t.Fatalf("unexpected error: %v", err)
+	case hash := <-hashCh:
+		if hash != (ethcommon.Hash{0x02}) {
+			t.Fatalf("incorrect payload hash: %s", hash)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for execution payload")
+	}
+}
+
+func TestGetFirstExecutionBeaconBlock(t *testing.T) {
+	srv := testBlocksServer(t, 6, 3)
+	defer srv.Close()
+	var (
+		fake = utils.NewFakeClock(time.Unix(int64(testGenesisTime), 0))
+		bn   = testBeaconClient(t, srv.URL, fake)
+	)
+	// Blocks after the current slot are not requested
+	fake.Advance(2 * bn.SlotClock().SlotDuration())
+	if block, err := bn.GetFirstExecutionBeaconBlock(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if block != nil {
+		t.Fatalf("unexpected block found at slot %d", block.Slot())
+	}
+	fake.Advance(4 * bn.SlotClock().SlotDuration())
+	block, err := bn.GetFirstExecutionBeaconBlock(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if block == nil || block.Slot() != 3 {
+		t.Fatalf("incorrect first execution block: %v", block)
+	}
+}
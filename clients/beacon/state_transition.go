@@ -0,0 +1,209 @@
+package beacon
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/protolambda/eth2api"
+	zrnt "github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// Execution engine used during the offline state transition, which accepts
+// every payload since the execution validity is not checked by the verifier
+type acceptAllExecutionEngine struct{}
+
+func (acceptAllExecutionEngine) ExecutePayload(
+	ctx context.Context,
+	executionPayload interface{},
+) (bool, error) {
+	return true, nil
+}
+
+// Result of applying a block to its pre-state
+type StateTransitionResult struct {
+	Slot      common.Slot
+	BlockRoot tree.Root
+	// State root included in the block
+	BlockStateRoot tree.Root
+	// Root of the post-state computed locally
+	ComputedStateRoot tree.Root
+	// Root of the post-state served by the beacon node, nil if the state was
+	// not fetched
+	ServedStateRoot *tree.Root
+}
+
+// Returns an error if the computed post-state root does not match the block
+// state root or the state served by the beacon node
+func (r *StateTransitionResult) Verify() error {
+	if r.ComputedStateRoot != r.BlockStateRoot {
+		return fmt.Errorf(
+			"slot %d, block %s: computed state root %s does not match block state root %s",
+			r.Slot,
+			r.BlockRoot,
+			r.ComputedStateRoot,
+			r.BlockStateRoot,
+		)
+	}
+	if r.ServedStateRoot != nil && *r.ServedStateRoot != r.ComputedStateRoot {
+		return fmt.Errorf(
+			"slot %d, block %s: served state root %s does not match computed state root %s",
+			r.Slot,
+			r.BlockRoot,
+			*r.ServedStateRoot,
+			r.ComputedStateRoot,
+		)
+	}
+	return nil
+}
+
+// Runs the state transition of the block on top of the given pre-state and
+// returns the resulting state root.
+// All the signatures of the block are verified: the state transition of zrnt
+// provides no way to skip the randao reveal and operation signatures, so the
+// proposer signature is verified as well. The execution payload is not
+// validated.
+func ComputeStateTransition(
+	ctx context.Context,
+	preState *VersionedBeaconStateResponse,
+	block *VersionedSignedBeaconBlock,
+) (*StateTransitionResult, error) {
+	if preState.spec == nil {
+		return nil, fmt.Errorf("pre-state spec not set")
+	}
+	// Copy the spec to not modify the execution engine of the client config
+	spec := *preState.spec
+	spec.ExecutionEngine = acceptAllExecutionEngine{}
+
+	view, err := preState.StateView()
+	if err != nil {
+		return nil, err
+	}
+	state := &zrnt.StandardUpgradeableBeaconState{BeaconState: view}
+	epc, err := common.NewEpochsContext(&spec, state)
+	if err != nil {
+		return nil, err
+	}
+	if block.Data == nil {
+		return nil, fmt.Errorf("no block (version: %q)", block.Version)
+	}
+	slot := block.Slot()
+	benv := block.Data.Envelope(
+		&spec,
+		common.ComputeForkDigest(
+			spec.ForkVersion(slot),
+			preState.GenesisValidatorsRoot(),
+		),
+	)
+
+	if err := common.ProcessSlots(ctx, &spec, epc, state, slot); err != nil {
+		return nil, err
+	}
+	fork, err := state.Fork()
+	if err != nil {
+		return nil, err
+	}
+	genesisValidatorsRoot, err := state.GenesisValidatorsRoot()
+	if err != nil {
+		return nil, err
+	}
+	proposer, err := epc.GetBeaconProposer(slot)
+	if err != nil {
+		return nil, err
+	}
+	if proposer != benv.ProposerIndex {
+		return nil, fmt.Errorf(
+			"incorrect proposer: want %d, got %d",
+			proposer,
+			benv.ProposerIndex,
+		)
+	}
+	pub, ok := epc.ValidatorPubkeyCache.Pubkey(proposer)
+	if !ok {
+		return nil, fmt.Errorf("unknown pubkey for proposer %d", proposer)
+	}
+	if !benv.VerifySignatureVersioned(
+		&spec,
+		fork.CurrentVersion,
+		genesisValidatorsRoot,
+		proposer,
+		pub,
+	) {
+		return nil, fmt.Errorf("block has invalid signature")
+	}
+	if err := state.ProcessBlock(ctx, &spec, epc, benv); err != nil {
+		return nil, err
+	}
+
+	return &StateTransitionResult{
+		Slot:              slot,
+		BlockRoot:         benv.BlockRoot,
+		BlockStateRoot:    benv.StateRoot,
+		ComputedStateRoot: state.HashTreeRoot(tree.GetHashFn()),
+	}, nil
+}
+
+// Fetches the block and its parent state from the beacon node, runs the state
+// transition offline and compares the result against the block state root
+// and the post-state served by the node.
+func (bn *BeaconClient) VerifyBlockStateTransition(
+	ctx context.Context,
+	blockId eth2api.BlockId,
+) (*StateTransitionResult, error) {
+	block, err := bn.BlockV2(ctx, blockId)
+	if err != nil {
+		return nil, err
+	}
+	preState, err := bn.BeaconStateV2ByBlock(
+		ctx,
+		eth2api.BlockIdRoot(block.ParentRoot()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	result, err := ComputeStateTransition(
+		ctx,
+		preState,
+		block,
+	)
+	if err != nil {
+		return nil, err
+	}
+	postState, err := bn.BeaconStateV2(
+		ctx,
+		eth2api.StateIdRoot(block.StateRoot()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	servedStateRoot := postState.Root()
+	result.ServedStateRoot = &servedStateRoot
+	return result, result.Verify()
+}
+
+// Verifies the state transition of every block within the given slot range
+func (bn *BeaconClient) VerifyStateTransitions(
+	ctx context.Context,
+	fromSlot common.Slot,
+	toSlot common.Slot,
+) ([]*StateTransitionResult, error) {
+	results := make([]*StateTransitionResult, 0)
+	for slot := fromSlot; slot <= toSlot; slot++ {
+		if _, err := bn.BlockV2Root(ctx, eth2api.BlockIdSlot(slot)); err != nil {
+			// Missed slot
+			continue
+		}
+		result, err := bn.VerifyBlockStateTransition(
+			ctx,
+			eth2api.BlockIdSlot(slot),
+		)
+		if result != nil {
+			results = append(results, result)
+		}
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
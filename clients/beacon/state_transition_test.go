@@ -0,0 +1,228 @@
+/*
+Tests for the offline state transition
+*/
+package beacon
+
+import (
+	"context"
+	"testing"
+
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/eth2api"
+	zrnt "github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// Signs the root with the domain using the secret key
+func testSign(
+	t *testing.T,
+	sk *blsu.SecretKey,
+	root common.Root,
+	domain common.BLSDomain,
+) common.BLSSignature {
+	t.Helper()
+	sigRoot := common.ComputeSigningRoot(root, domain)
+	return common.BLSSignature(blsu.Sign(sk, sigRoot[:]).Serialize())
+}
+
+func TestComputeStateTransition(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		spec    = configs.Minimal
+		hFn     = tree.GetHashFn()
+		amounts = make([]common.Gwei, 16)
+	)
+	for i := range amounts {
+		amounts[i] = spec.MAX_EFFECTIVE_BALANCE
+	}
+	pre, keys := testGenesisState(t, spec, amounts)
+	preState := pre.Data.(*phase0.BeaconState)
+
+	// Build the block of slot 1 on top of the genesis state
+	slot := common.Slot(1)
+	state, err := pre.StateView()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	epc, err := common.NewEpochsContext(spec, state)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := common.ProcessSlots(
+		ctx,
+		spec,
+		epc,
+		&zrnt.StandardUpgradeableBeaconState{BeaconState: state},
+		slot,
+	); err != nil {
+		t.Fatalf("%v", err)
+	}
+	proposer, err := epc.GetBeaconProposer(slot)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	header, err := state.LatestBlockHeader()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	eth1Data, err := state.Eth1Data()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	randaoDomain, err := common.GetDomain(state, common.DOMAIN_RANDAO, 0)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	block := new(phase0.SignedBeaconBlock)
+	block.Message.Slot = slot
+	block.Message.ProposerIndex = proposer
+	block.Message.ParentRoot = header.HashTreeRoot(hFn)
+	block.Message.Body.Eth1Data = eth1Data
+	block.Message.Body.RandaoReveal = testSign(
+		t,
+		keys[proposer],
+		common.Epoch(0).HashTreeRoot(hFn),
+		randaoDomain,
+	)
+	versionedBlock := &VersionedSignedBeaconBlock{
+		VersionedSignedBeaconBlock: &eth2api.VersionedSignedBeaconBlock{
+			Version: "phase0",
+			Data:    block,
+		},
+		spec: spec,
+	}
+	// Signs the block with the key of the proposer
+	signProposer := func() {
+		block.Signature = testSign(
+			t,
+			keys[proposer],
+			block.Message.HashTreeRoot(spec, hFn),
+			common.ComputeDomain(
+				common.DOMAIN_BEACON_PROPOSER,
+				spec.GENESIS_FORK_VERSION,
+				preState.GenesisValidatorsRoot,
+			),
+		)
+	}
+
+	// Restores the fields modified by the test cases, processes the block on
+	// top of the genesis state without checking its signatures and signs it
+	// with the resulting state root
+	reveal := block.Message.Body.RandaoReveal
+	sign := func() {
+		block.Message.ProposerIndex = proposer
+		block.Message.Body.RandaoReveal = reveal
+		block.Message.StateRoot = common.Root{}
+		state, err := pre.StateView()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		epc, err := common.NewEpochsContext(spec, state)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		upgradeable := &zrnt.StandardUpgradeableBeaconState{BeaconState: state}
+		if err := common.ProcessSlots(ctx, spec, epc, upgradeable, slot); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := upgradeable.ProcessBlock(
+			ctx,
+			spec,
+			epc,
+			block.Envelope(spec, common.ForkDigest{}),
+		); err != nil {
+			t.Fatalf("unable to process block: %v", err)
+		}
+		block.Message.StateRoot = state.HashTreeRoot(hFn)
+		signProposer()
+	}
+	sign()
+
+	// The state root of the block is checked independently by zrnt
+	state, err = pre.StateView()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if epc, err = common.NewEpochsContext(spec, state); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := common.StateTransition(
+		ctx,
+		spec,
+		epc,
+		&zrnt.StandardUpgradeableBeaconState{BeaconState: state},
+		block.Envelope(
+			spec,
+			common.ComputeForkDigest(
+				spec.GENESIS_FORK_VERSION,
+				preState.GenesisValidatorsRoot,
+			),
+		),
+		true,
+	); err != nil {
+		t.Fatalf("invalid test block: %v", err)
+	}
+
+	for _, test := range []struct {
+		name            string
+		modify          func()
+		transitionError bool
+		verifyError     bool
+	}{
+		{
+			name: "correct state root",
+		},
+		{
+			name: "incorrect state root",
+			modify: func() {
+				block.Message.StateRoot = common.Root{0x01}
+				signProposer()
+			},
+			verifyError: true,
+		},
+		{
+			name: "invalid proposer signature",
+			modify: func() {
+				block.Signature = common.BLSSignature{}
+			},
+			transitionError: true,
+		},
+		{
+			name: "incorrect proposer",
+			modify: func() {
+				block.Message.ProposerIndex = (proposer + 1) % common.ValidatorIndex(len(keys))
+			},
+			transitionError: true,
+		},
+		{
+			name: "invalid randao reveal",
+			modify: func() {
+				block.Message.Body.RandaoReveal = common.BLSSignature{}
+			},
+			transitionError: true,
+		},
+	} {
+		sign()
+		if test.modify != nil {
+			test.modify()
+		}
+		result, err := ComputeStateTransition(ctx, pre, versionedBlock)
+		if test.transitionError {
+			if err == nil {
+				t.Fatalf("%s: expected state transition error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unable to compute state transition: %v", test.name, err)
+		}
+		if err := result.Verify(); test.verifyError && err == nil {
+			t.Fatalf("%s: expected verification error", test.name)
+		} else if !test.verifyError && err != nil {
+			t.Fatalf("%s: unexpected verification error: %v", test.name, err)
+		}
+	}
+}
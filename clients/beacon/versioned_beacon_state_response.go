@@ -1,245 +1,146 @@
 package beacon
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 
 	"github.com/protolambda/eth2api"
+	zrnt "github.com/protolambda/zrnt/eth2/beacon"
 	"github.com/protolambda/zrnt/eth2/beacon/altair"
-	"github.com/protolambda/zrnt/eth2/beacon/bellatrix"
-	"github.com/protolambda/zrnt/eth2/beacon/capella"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
-	"github.com/protolambda/zrnt/eth2/beacon/deneb"
 	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/ztyp/codec"
 	"github.com/protolambda/ztyp/tree"
 )
 
+// Beacon state of any of the registered forks.
+// States decoded from the beacon API always belong to a registered fork, and
+// the accessors panic otherwise.
 type VersionedBeaconStateResponse struct {
 	*eth2api.VersionedBeaconState
 	spec *common.Spec
 }
 
-func (vbs *VersionedBeaconStateResponse) Root() tree.Root {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.HashTreeRoot(vbs.spec, tree.GetHashFn())
-	case *altair.BeaconState:
-		return state.HashTreeRoot(vbs.spec, tree.GetHashFn())
-	case *bellatrix.BeaconState:
-		return state.HashTreeRoot(vbs.spec, tree.GetHashFn())
-	case *capella.BeaconState:
-		return state.HashTreeRoot(vbs.spec, tree.GetHashFn())
-	case *deneb.BeaconState:
-		return state.HashTreeRoot(vbs.spec, tree.GetHashFn())
+// Decodes the state using the type of the registered fork that matches the
+// version
+func (vbs *VersionedBeaconStateResponse) UnmarshalJSON(b []byte) error {
+	version, data, err := decodeVersionedResponse(
+		b,
+		func(f *ForkDefinition) interface{} {
+			return f.NewBeaconState()
+		},
+	)
+	if err != nil {
+		return err
+	}
+	vbs.VersionedBeaconState = &eth2api.VersionedBeaconState{
+		Version: version,
+		Data:    data.(common.SpecObj),
 	}
-	panic("badly formatted beacon state")
+	return nil
 }
 
-func (vbs *VersionedBeaconStateResponse) GenesisTime() common.Timestamp {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.GenesisTime
-	case *altair.BeaconState:
-		return state.GenesisTime
-	case *bellatrix.BeaconState:
-		return state.GenesisTime
-	case *capella.BeaconState:
-		return state.GenesisTime
-	case *deneb.BeaconState:
-		return state.GenesisTime
+func (vbs *VersionedBeaconStateResponse) ForkDefinition() (*ForkDefinition, error) {
+	return ForkDefinitionByName(vbs.Version)
+}
+
+// Returns the binary-tree backed view of the state for advanced processing
+func (vbs *VersionedBeaconStateResponse) StateView() (common.BeaconState, error) {
+	f, err := vbs.ForkDefinition()
+	if err != nil {
+		return nil, err
+	}
+	if vbs.Data == nil {
+		return nil, fmt.Errorf("no state (version: %q)", vbs.Version)
 	}
-	panic("badly formatted beacon state")
+	var buf bytes.Buffer
+	if err := vbs.Data.Serialize(vbs.spec, codec.NewEncodingWriter(&buf)); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	return f.NewBeaconStateView(
+		vbs.spec,
+		codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data))),
+	)
 }
 
-func (vbs *VersionedBeaconStateResponse) GenesisValidatorsRoot() common.Root {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.GenesisValidatorsRoot
-	case *altair.BeaconState:
-		return state.GenesisValidatorsRoot
-	case *bellatrix.BeaconState:
-		return state.GenesisValidatorsRoot
-	case *capella.BeaconState:
-		return state.GenesisValidatorsRoot
-	case *deneb.BeaconState:
-		return state.GenesisValidatorsRoot
+// Returns the typed fields of the state, or an error if the state does not
+// belong to a registered fork
+func (vbs *VersionedBeaconStateResponse) fields() (*BeaconStateFields, error) {
+	f, err := vbs.ForkDefinition()
+	if err != nil {
+		return nil, err
 	}
-	panic("badly formatted beacon state")
+	return f.BeaconStateFields(vbs.Data)
 }
 
-func (vbs *VersionedBeaconStateResponse) Fork() common.Fork {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.Fork
-	case *altair.BeaconState:
-		return state.Fork
-	case *bellatrix.BeaconState:
-		return state.Fork
-	case *capella.BeaconState:
-		return state.Fork
-	case *deneb.BeaconState:
-		return state.Fork
+// Returns the typed fields of the state, panics if the state does not belong
+// to a registered fork
+func (vbs *VersionedBeaconStateResponse) mustFields() *BeaconStateFields {
+	fields, err := vbs.fields()
+	if err != nil {
+		panic(err)
 	}
-	panic("badly formatted beacon state")
+	return fields
+}
+
+func (vbs *VersionedBeaconStateResponse) Root() tree.Root {
+	// Verifies that the state belongs to a registered fork
+	vbs.mustFields()
+	return vbs.Data.HashTreeRoot(vbs.spec, tree.GetHashFn())
+}
+
+func (vbs *VersionedBeaconStateResponse) GenesisTime() common.Timestamp {
+	return vbs.mustFields().GenesisTime
+}
+
+func (vbs *VersionedBeaconStateResponse) GenesisValidatorsRoot() common.Root {
+	return vbs.mustFields().GenesisValidatorsRoot
+}
+
+func (vbs *VersionedBeaconStateResponse) Fork() common.Fork {
+	return vbs.mustFields().Fork
 }
 
 func (vbs *VersionedBeaconStateResponse) CurrentVersion() common.Version {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.Fork.CurrentVersion
-	case *altair.BeaconState:
-		return state.Fork.CurrentVersion
-	case *bellatrix.BeaconState:
-		return state.Fork.CurrentVersion
-	case *capella.BeaconState:
-		return state.Fork.CurrentVersion
-	case *deneb.BeaconState:
-		return state.Fork.CurrentVersion
-	}
-	panic("badly formatted beacon state")
+	return vbs.Fork().CurrentVersion
 }
 
 func (vbs *VersionedBeaconStateResponse) PreviousVersion() common.Version {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.Fork.PreviousVersion
-	case *altair.BeaconState:
-		return state.Fork.PreviousVersion
-	case *bellatrix.BeaconState:
-		return state.Fork.PreviousVersion
-	case *capella.BeaconState:
-		return state.Fork.PreviousVersion
-	case *deneb.BeaconState:
-		return state.Fork.PreviousVersion
-	}
-	panic("badly formatted beacon state")
+	return vbs.Fork().PreviousVersion
 }
 
 func (vbs *VersionedBeaconStateResponse) LatestBlockHeader() common.BeaconBlockHeader {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.LatestBlockHeader
-	case *altair.BeaconState:
-		return state.LatestBlockHeader
-	case *bellatrix.BeaconState:
-		return state.LatestBlockHeader
-	case *capella.BeaconState:
-		return state.LatestBlockHeader
-	case *deneb.BeaconState:
-		return state.LatestBlockHeader
-	}
-	panic("badly formatted beacon state")
+	return vbs.mustFields().LatestBlockHeader
 }
 
 func (vbs *VersionedBeaconStateResponse) BlockRoots() phase0.HistoricalBatchRoots {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.BlockRoots
-	case *altair.BeaconState:
-		return state.BlockRoots
-	case *bellatrix.BeaconState:
-		return state.BlockRoots
-	case *capella.BeaconState:
-		return state.BlockRoots
-	case *deneb.BeaconState:
-		return state.BlockRoots
-	}
-	panic("badly formatted beacon state")
+	return vbs.mustFields().BlockRoots
 }
 
 func (vbs *VersionedBeaconStateResponse) StateRoots() phase0.HistoricalBatchRoots {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.StateRoots
-	case *altair.BeaconState:
-		return state.StateRoots
-	case *bellatrix.BeaconState:
-		return state.StateRoots
-	case *capella.BeaconState:
-		return state.StateRoots
-	case *deneb.BeaconState:
-		return state.StateRoots
-	}
-	panic("badly formatted beacon state")
+	return vbs.mustFields().StateRoots
 }
 
 func (vbs *VersionedBeaconStateResponse) HistoricalRoots() phase0.HistoricalRoots {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.HistoricalRoots
-	case *altair.BeaconState:
-		return state.HistoricalRoots
-	case *bellatrix.BeaconState:
-		return state.HistoricalRoots
-	case *capella.BeaconState:
-		return state.HistoricalRoots
-	case *deneb.BeaconState:
-		return state.HistoricalRoots
-	}
-	panic("badly formatted beacon state")
+	return vbs.mustFields().HistoricalRoots
 }
 
 func (vbs *VersionedBeaconStateResponse) Eth1Data() common.Eth1Data {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.Eth1Data
-	case *altair.BeaconState:
-		return state.Eth1Data
-	case *bellatrix.BeaconState:
-		return state.Eth1Data
-	case *capella.BeaconState:
-		return state.Eth1Data
-	case *deneb.BeaconState:
-		return state.Eth1Data
-	}
-	panic("badly formatted beacon state")
+	return vbs.mustFields().Eth1Data
 }
 
 func (vbs *VersionedBeaconStateResponse) Eth1DataVotes() phase0.Eth1DataVotes {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.Eth1DataVotes
-	case *altair.BeaconState:
-		return state.Eth1DataVotes
-	case *bellatrix.BeaconState:
-		return state.Eth1DataVotes
-	case *capella.BeaconState:
-		return state.Eth1DataVotes
-	case *deneb.BeaconState:
-		return state.Eth1DataVotes
-	}
-	panic("badly formatted beacon state")
+	return vbs.mustFields().Eth1DataVotes
 }
 
 func (vbs *VersionedBeaconStateResponse) Eth1DepositIndex() common.DepositIndex {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.Eth1DepositIndex
-	case *altair.BeaconState:
-		return state.Eth1DepositIndex
-	case *bellatrix.BeaconState:
-		return state.Eth1DepositIndex
-	case *capella.BeaconState:
-		return state.Eth1DepositIndex
-	case *deneb.BeaconState:
-		return state.Eth1DepositIndex
-	}
-	panic("badly formatted beacon state")
+	return vbs.mustFields().Eth1DepositIndex
 }
 
 func (vbs *VersionedBeaconStateResponse) Balances() phase0.Balances {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.Balances
-	case *altair.BeaconState:
-		return state.Balances
-	case *bellatrix.BeaconState:
-		return state.Balances
-	case *capella.BeaconState:
-		return state.Balances
-	case *deneb.BeaconState:
-		return state.Balances
-	}
-	panic("badly formatted beacon state")
+	return vbs.mustFields().Balances
 }
 
 func (vbs *VersionedBeaconStateResponse) Balance(
@@ -253,314 +154,194 @@ func (vbs *VersionedBeaconStateResponse) Balance(
 }
 
 func (vbs *VersionedBeaconStateResponse) Validators() phase0.ValidatorRegistry {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.Validators
-	case *altair.BeaconState:
-		return state.Validators
-	case *bellatrix.BeaconState:
-		return state.Validators
-	case *capella.BeaconState:
-		return state.Validators
-	case *deneb.BeaconState:
-		return state.Validators
-	}
-	panic("badly formatted beacon state")
+	return vbs.mustFields().Validators
 }
 
 func (vbs *VersionedBeaconStateResponse) RandaoMixes() phase0.RandaoMixes {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.RandaoMixes
-	case *altair.BeaconState:
-		return state.RandaoMixes
-	case *bellatrix.BeaconState:
-		return state.RandaoMixes
-	case *capella.BeaconState:
-		return state.RandaoMixes
-	case *deneb.BeaconState:
-		return state.RandaoMixes
-	}
-	panic("badly formatted beacon state")
+	return vbs.mustFields().RandaoMixes
 }
 
 func (vbs *VersionedBeaconStateResponse) Slashings() phase0.SlashingsHistory {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.Slashings
-	case *altair.BeaconState:
-		return state.Slashings
-	case *bellatrix.BeaconState:
-		return state.Slashings
-	case *capella.BeaconState:
-		return state.Slashings
-	case *deneb.BeaconState:
-		return state.Slashings
-	}
-	panic("badly formatted beacon state")
+	return vbs.mustFields().Slashings
 }
 
+// Phase0
 func (vbs *VersionedBeaconStateResponse) PreviousEpochAttestations() phase0.PendingAttestations {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.PreviousEpochAttestations
-	case *altair.BeaconState:
-		return nil
-	case *bellatrix.BeaconState:
-		return nil
-	case *capella.BeaconState:
-		return nil
-	case *deneb.BeaconState:
-		return nil
+	if fields := vbs.mustFields(); fields.PreviousEpochAttestations != nil {
+		return *fields.PreviousEpochAttestations
 	}
-	panic("badly formatted beacon state")
+	return nil
 }
 
 func (vbs *VersionedBeaconStateResponse) CurrentEpochAttestations() phase0.PendingAttestations {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.CurrentEpochAttestations
-	case *altair.BeaconState:
-		return nil
-	case *bellatrix.BeaconState:
-		return nil
-	case *capella.BeaconState:
-		return nil
-	case *deneb.BeaconState:
-		return nil
+	if fields := vbs.mustFields(); fields.CurrentEpochAttestations != nil {
+		return *fields.CurrentEpochAttestations
 	}
-	panic("badly formatted beacon state")
+	return nil
 }
 
+// Altair
 func (vbs *VersionedBeaconStateResponse) PreviousEpochParticipation() altair.ParticipationRegistry {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return nil
-	case *altair.BeaconState:
-		return state.PreviousEpochParticipation
-	case *bellatrix.BeaconState:
-		return state.PreviousEpochParticipation
-	case *capella.BeaconState:
-		return state.PreviousEpochParticipation
-	case *deneb.BeaconState:
-		return state.PreviousEpochParticipation
+	if fields := vbs.mustFields(); fields.PreviousEpochParticipation != nil {
+		return *fields.PreviousEpochParticipation
 	}
-	panic("badly formatted beacon state")
+	return nil
 }
 
 func (vbs *VersionedBeaconStateResponse) CurrentEpochParticipation() altair.ParticipationRegistry {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return nil
-	case *altair.BeaconState:
-		return state.CurrentEpochParticipation
-	case *bellatrix.BeaconState:
-		return state.CurrentEpochParticipation
-	case *capella.BeaconState:
-		return state.CurrentEpochParticipation
-	case *deneb.BeaconState:
-		return state.CurrentEpochParticipation
+	if fields := vbs.mustFields(); fields.CurrentEpochParticipation != nil {
+		return *fields.CurrentEpochParticipation
 	}
-	panic("badly formatted beacon state")
+	return nil
 }
 
 // Finality
 func (vbs *VersionedBeaconStateResponse) JustificationBits() common.JustificationBits {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.JustificationBits
-	case *altair.BeaconState:
-		return state.JustificationBits
-	case *bellatrix.BeaconState:
-		return state.JustificationBits
-	case *capella.BeaconState:
-		return state.JustificationBits
-	case *deneb.BeaconState:
-		return state.JustificationBits
-	}
-	panic("badly formatted beacon state")
+	return vbs.mustFields().JustificationBits
 }
 
 func (vbs *VersionedBeaconStateResponse) PreviousJustifiedCheckpoint() common.Checkpoint {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.PreviousJustifiedCheckpoint
-	case *altair.BeaconState:
-		return state.PreviousJustifiedCheckpoint
-	case *bellatrix.BeaconState:
-		return state.PreviousJustifiedCheckpoint
-	case *capella.BeaconState:
-		return state.PreviousJustifiedCheckpoint
-	case *deneb.BeaconState:
-		return state.PreviousJustifiedCheckpoint
-	}
-	panic("badly formatted beacon state")
+	return vbs.mustFields().PreviousJustifiedCheckpoint
 }
 
 func (vbs *VersionedBeaconStateResponse) CurrentJustifiedCheckpoint() common.Checkpoint {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.CurrentJustifiedCheckpoint
-	case *altair.BeaconState:
-		return state.CurrentJustifiedCheckpoint
-	case *bellatrix.BeaconState:
-		return state.CurrentJustifiedCheckpoint
-	case *capella.BeaconState:
-		return state.CurrentJustifiedCheckpoint
-	case *deneb.BeaconState:
-		return state.CurrentJustifiedCheckpoint
-	}
-	panic("badly formatted beacon state")
+	return vbs.mustFields().CurrentJustifiedCheckpoint
 }
 
 func (vbs *VersionedBeaconStateResponse) FinalizedCheckpoint() common.Checkpoint {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.FinalizedCheckpoint
-	case *altair.BeaconState:
-		return state.FinalizedCheckpoint
-	case *bellatrix.BeaconState:
-		return state.FinalizedCheckpoint
-	case *capella.BeaconState:
-		return state.FinalizedCheckpoint
-	case *deneb.BeaconState:
-		return state.FinalizedCheckpoint
-	}
-	panic("badly formatted beacon state")
+	return vbs.mustFields().FinalizedCheckpoint
 }
 
 // Altair
 // Inactivity
 func (vbs *VersionedBeaconStateResponse) InactivityScores() altair.InactivityScores {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return nil
-	case *altair.BeaconState:
-		return state.InactivityScores
-	case *bellatrix.BeaconState:
-		return state.InactivityScores
-	case *capella.BeaconState:
-		return state.InactivityScores
-	case *deneb.BeaconState:
-		return state.InactivityScores
+	if fields := vbs.mustFields(); fields.InactivityScores != nil {
+		return *fields.InactivityScores
 	}
-	panic("badly formatted beacon state")
+	return nil
 }
 
 // Sync
 func (vbs *VersionedBeaconStateResponse) CurrentSyncCommittee() *common.SyncCommittee {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return nil
-	case *altair.BeaconState:
-		return &state.CurrentSyncCommittee
-	case *bellatrix.BeaconState:
-		return &state.CurrentSyncCommittee
-	case *capella.BeaconState:
-		return &state.CurrentSyncCommittee
-	case *deneb.BeaconState:
-		return &state.CurrentSyncCommittee
-	}
-	panic("badly formatted beacon state")
+	return vbs.mustFields().CurrentSyncCommittee
 }
 
 func (vbs *VersionedBeaconStateResponse) NextSyncCommittee() *common.SyncCommittee {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return nil
-	case *altair.BeaconState:
-		return &state.NextSyncCommittee
-	case *bellatrix.BeaconState:
-		return &state.NextSyncCommittee
-	case *capella.BeaconState:
-		return &state.NextSyncCommittee
-	case *deneb.BeaconState:
-		return &state.NextSyncCommittee
-	}
-	panic("badly formatted beacon state")
+	return vbs.mustFields().NextSyncCommittee
 }
 
 func (vbs *VersionedBeaconStateResponse) StateSlot() common.Slot {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return state.Slot
-	case *altair.BeaconState:
-		return state.Slot
-	case *bellatrix.BeaconState:
-		return state.Slot
-	case *capella.BeaconState:
-		return state.Slot
-	case *deneb.BeaconState:
-		return state.Slot
-	}
-	panic("badly formatted beacon state")
+	return vbs.mustFields().Slot
 }
 
 func (vbs *VersionedBeaconStateResponse) LatestExecutionPayloadHeaderHash() tree.Root {
-	switch state := vbs.Data.(type) {
-	case *phase0.BeaconState:
-		return tree.Root{}
-	case *altair.BeaconState:
-		return tree.Root{}
-	case *bellatrix.BeaconState:
-		return state.LatestExecutionPayloadHeader.BlockHash
-	case *capella.BeaconState:
-		return state.LatestExecutionPayloadHeader.BlockHash
-	case *deneb.BeaconState:
-		return state.LatestExecutionPayloadHeader.BlockHash
+	if fields := vbs.mustFields(); fields.LatestExecutionPayloadHeaderHash != nil {
+		return *fields.LatestExecutionPayloadHeaderHash
 	}
-	panic("badly formatted beacon state")
+	return tree.Root{}
 }
 
 func (vbs *VersionedBeaconStateResponse) NextWithdrawalIndex() (common.WithdrawalIndex, error) {
-	var wIndex common.WithdrawalIndex
-	switch state := vbs.Data.(type) {
-	case *capella.BeaconState:
-		wIndex = state.NextWithdrawalIndex
-	case *deneb.BeaconState:
-		wIndex = state.NextWithdrawalIndex
+	f, err := vbs.ForkDefinition()
+	if err != nil || !f.Withdrawals {
+		return 0, err
+	}
+	fields, err := vbs.fields()
+	if err != nil {
+		return 0, err
 	}
-	return wIndex, nil
+	return *fields.NextWithdrawalIndex, nil
 }
 
 func (vbs *VersionedBeaconStateResponse) NextWithdrawalValidatorIndex() (common.ValidatorIndex, error) {
-	var wIndex common.ValidatorIndex
-	switch state := vbs.Data.(type) {
-	case *capella.BeaconState:
-		wIndex = state.NextWithdrawalValidatorIndex
-	case *deneb.BeaconState:
-		wIndex = state.NextWithdrawalValidatorIndex
+	f, err := vbs.ForkDefinition()
+	if err != nil || !f.Withdrawals {
+		return 0, err
 	}
-	return wIndex, nil
+	fields, err := vbs.fields()
+	if err != nil {
+		return 0, err
+	}
+	return *fields.NextWithdrawalValidatorIndex, nil
 }
 
+// Returns a copy of the state advanced to the given slot by running
+// process_slots, including the epoch processing and the fork upgrades of
+// the skipped slots. The state is returned unmodified if it is already at
+// the slot.
+func (vbs *VersionedBeaconStateResponse) ProcessSlots(
+	ctx context.Context,
+	slot common.Slot,
+) (*VersionedBeaconStateResponse, error) {
+	if vbs.StateSlot() == slot {
+		return vbs, nil
+	}
+	view, err := vbs.StateView()
+	if err != nil {
+		return nil, err
+	}
+	state := &zrnt.StandardUpgradeableBeaconState{BeaconState: view}
+	epc, err := common.NewEpochsContext(vbs.spec, state)
+	if err != nil {
+		return nil, err
+	}
+	if err := common.ProcessSlots(ctx, vbs.spec, epc, state, slot); err != nil {
+		return nil, err
+	}
+	f := NewForkSchedule(vbs.spec, 0, vbs.GenesisValidatorsRoot()).ForkAtSlot(slot)
+	if f == nil {
+		return nil, fmt.Errorf("no fork scheduled at slot %d", slot)
+	}
+	var buf bytes.Buffer
+	if err := state.Serialize(codec.NewEncodingWriter(&buf)); err != nil {
+		return nil, err
+	}
+	data := f.NewBeaconState()
+	if err := data.Deserialize(
+		vbs.spec,
+		codec.NewDecodingReader(bytes.NewReader(buf.Bytes()), uint64(buf.Len())),
+	); err != nil {
+		return nil, fmt.Errorf("unable to decode %s state: %v", f.Name, err)
+	}
+	return &VersionedBeaconStateResponse{
+		VersionedBeaconState: &eth2api.VersionedBeaconState{
+			Version: f.Name,
+			Data:    data,
+		},
+		spec: vbs.spec,
+	}, nil
+}
+
+// Returns the withdrawals expected in the payload of the block of the given
+// slot. The state must be at the slot of the block, see ProcessSlots.
 func (vbs *VersionedBeaconStateResponse) NextWithdrawals(
 	slot common.Slot,
 ) (common.Withdrawals, error) {
 	var (
 		withdrawalIndex common.WithdrawalIndex
 		validatorIndex  common.ValidatorIndex
-		validators      phase0.ValidatorRegistry
-		balances        phase0.Balances
+		validators      = vbs.Validators()
+		balances        = vbs.Balances()
 		epoch           = vbs.spec.SlotToEpoch(slot)
 	)
-	switch state := vbs.Data.(type) {
-	case *bellatrix.BeaconState:
-		// withdrawalIndex and validatorIndex start at zero
-		validators = state.Validators
-		balances = state.Balances
-	case *capella.BeaconState:
-		withdrawalIndex = state.NextWithdrawalIndex
-		validatorIndex = state.NextWithdrawalValidatorIndex
-		validators = state.Validators
-		balances = state.Balances
-	case *deneb.BeaconState:
-		withdrawalIndex = state.NextWithdrawalIndex
-		validatorIndex = state.NextWithdrawalValidatorIndex
-		validators = state.Validators
-		balances = state.Balances
-	default:
-		return nil, fmt.Errorf("badly formatted beacon state")
+	f, err := vbs.ForkDefinition()
+	if err != nil {
+		return nil, err
+	}
+	if !f.ExecutionPayload {
+		return nil, fmt.Errorf(
+			"beacon state version can't produce withdrawals",
+		)
+	}
+	// Before withdrawals are enabled, withdrawalIndex and validatorIndex
+	// start at zero
+	if f.Withdrawals {
+		if withdrawalIndex, err = vbs.NextWithdrawalIndex(); err != nil {
+			return nil, err
+		}
+		if validatorIndex, err = vbs.NextWithdrawalValidatorIndex(); err != nil {
+			return nil, err
+		}
 	}
 	validatorCount := uint64(len(validators))
 	withdrawals := make(common.Withdrawals, 0)
@@ -588,7 +369,7 @@ func (vbs *VersionedBeaconStateResponse) NextWithdrawals(
 				Amount:         balance,
 			})
 			withdrawalIndex += 1
-		} else if IsPartiallyWithdrawableValidator(vbs.spec, validator, balance, epoch) {
+		} else if IsPartiallyWithdrawable(vbs.spec, validator, balance) {
 			withdrawals = append(withdrawals, common.Withdrawal{
 				Index:          withdrawalIndex,
 				ValidatorIndex: validatorIndex,
@@ -607,3 +388,72 @@ func (vbs *VersionedBeaconStateResponse) NextWithdrawals(
 	}
 	return withdrawals, nil
 }
+
+func (vbs *VersionedBeaconStateResponse) ActiveValidatorCount(
+	epoch common.Epoch,
+) uint64 {
+	count := uint64(0)
+	for _, v := range vbs.Validators() {
+		if v.ActivationEpoch <= epoch && epoch < v.ExitEpoch {
+			count += 1
+		}
+	}
+	return count
+}
+
+// Returns the validator churn limit at the current epoch of the state
+func (vbs *VersionedBeaconStateResponse) ValidatorChurnLimit() uint64 {
+	epoch := vbs.spec.SlotToEpoch(vbs.StateSlot())
+	return vbs.spec.GetChurnLimit(vbs.ActiveValidatorCount(epoch))
+}
+
+// Simulates the initiation of the exit of the given validators, in the given
+// order, at the current epoch of the state, and returns the resulting exit
+// epochs.
+// Validators that have already initiated their exit keep their exit epoch.
+func (vbs *VersionedBeaconStateResponse) ExpectedExitEpochs(
+	validatorIndexes []common.ValidatorIndex,
+) (map[common.ValidatorIndex]common.Epoch, error) {
+	var (
+		validators     = vbs.Validators()
+		currentEpoch   = vbs.spec.SlotToEpoch(vbs.StateSlot())
+		churnLimit     = vbs.ValidatorChurnLimit()
+		exitQueueEpoch = vbs.spec.ComputeActivationExitEpoch(currentEpoch)
+		exitEpochs     = make(map[common.ValidatorIndex]common.Epoch)
+	)
+	for _, v := range validators {
+		if v.ExitEpoch != common.FAR_FUTURE_EPOCH &&
+			v.ExitEpoch > exitQueueEpoch {
+			exitQueueEpoch = v.ExitEpoch
+		}
+	}
+	exitQueueChurn := uint64(0)
+	for _, v := range validators {
+		if v.ExitEpoch == exitQueueEpoch {
+			exitQueueChurn += 1
+		}
+	}
+	for _, validatorIndex := range validatorIndexes {
+		if int(validatorIndex) >= len(validators) {
+			return nil, fmt.Errorf(
+				"invalid validator index %d",
+				validatorIndex,
+			)
+		}
+		if _, ok := exitEpochs[validatorIndex]; ok {
+			continue
+		}
+		validator := validators[validatorIndex]
+		if validator.ExitEpoch != common.FAR_FUTURE_EPOCH {
+			exitEpochs[validatorIndex] = validator.ExitEpoch
+			continue
+		}
+		if exitQueueChurn >= churnLimit {
+			exitQueueEpoch += 1
+			exitQueueChurn = 0
+		}
+		exitEpochs[validatorIndex] = exitQueueEpoch
+		exitQueueChurn += 1
+	}
+	return exitEpochs, nil
+}
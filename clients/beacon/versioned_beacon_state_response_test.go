@@ -0,0 +1,258 @@
+/*
+Tests for the versioned beacon state helpers
+*/
+package beacon
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	blsu "github.com/protolambda/bls12-381-util"
+
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/ztyp/codec"
+)
+
+func activeValidators(count int) phase0.ValidatorRegistry {
+	validators := make(phase0.ValidatorRegistry, count)
+	for i := range validators {
+		validators[i] = &phase0.Validator{
+			ActivationEpoch:   0,
+			ExitEpoch:         common.FAR_FUTURE_EPOCH,
+			WithdrawableEpoch: common.FAR_FUTURE_EPOCH,
+		}
+	}
+	return validators
+}
+
+func TestExpectedExitEpochs(t *testing.T) {
+	spec := configs.Minimal
+	currentEpoch := common.Epoch(10)
+	activationExitEpoch := spec.ComputeActivationExitEpoch(currentEpoch)
+	churnLimit := int(spec.GetChurnLimit(64))
+
+	for _, test := range []struct {
+		name       string
+		validators func() phase0.ValidatorRegistry
+		exiting    []common.ValidatorIndex
+		expected   map[common.ValidatorIndex]common.Epoch
+	}{
+		{
+			name: "single exit",
+			validators: func() phase0.ValidatorRegistry {
+				return activeValidators(64)
+			},
+			exiting: []common.ValidatorIndex{5},
+			expected: map[common.ValidatorIndex]common.Epoch{
+				5: activationExitEpoch,
+			},
+		},
+		{
+			name: "exits over churn limit",
+			validators: func() phase0.ValidatorRegistry {
+				return activeValidators(64)
+			},
+			exiting: func() []common.ValidatorIndex {
+				indexes := make([]common.ValidatorIndex, churnLimit+1)
+				for i := range indexes {
+					indexes[i] = common.ValidatorIndex(i)
+				}
+				return indexes
+			}(),
+			expected: func() map[common.ValidatorIndex]common.Epoch {
+				expected := make(map[common.ValidatorIndex]common.Epoch)
+				for i := 0; i < churnLimit; i++ {
+					expected[common.ValidatorIndex(i)] = activationExitEpoch
+				}
+				expected[common.ValidatorIndex(churnLimit)] = activationExitEpoch + 1
+				return expected
+			}(),
+		},
+		{
+			name: "existing exit queue",
+			validators: func() phase0.ValidatorRegistry {
+				validators := activeValidators(64)
+				for i := 0; i < churnLimit; i++ {
+					validators[i].ExitEpoch = activationExitEpoch + 3
+				}
+				return validators
+			},
+			exiting: []common.ValidatorIndex{0, 40},
+			expected: map[common.ValidatorIndex]common.Epoch{
+				0:  activationExitEpoch + 3,
+				40: activationExitEpoch + 4,
+			},
+		},
+	} {
+		state := &VersionedBeaconStateResponse{
+			VersionedBeaconState: &eth2api.VersionedBeaconState{
+				Version: "deneb",
+				Data: &deneb.BeaconState{
+					Slot:       spec.SLOTS_PER_EPOCH * common.Slot(currentEpoch),
+					Validators: test.validators(),
+				},
+			},
+			spec: spec,
+		}
+		exitEpochs, err := state.ExpectedExitEpochs(test.exiting)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(exitEpochs) != len(test.expected) {
+			t.Fatalf(
+				"%s: incorrect number of exit epochs: want %d, got %d",
+				test.name,
+				len(test.expected),
+				len(exitEpochs),
+			)
+		}
+		for validatorIndex, expectedEpoch := range test.expected {
+			if exitEpochs[validatorIndex] != expectedEpoch {
+				t.Fatalf(
+					"%s: incorrect exit epoch for validator %d: want %d, got %d",
+					test.name,
+					validatorIndex,
+					expectedEpoch,
+					exitEpochs[validatorIndex],
+				)
+			}
+		}
+	}
+}
+
+// Returns a phase0 genesis state with a validator with eth1 withdrawal
+// credentials for each of the deposit amounts, and the secret keys of the
+// validators
+func testGenesisState(
+	t *testing.T,
+	spec *common.Spec,
+	amounts []common.Gwei,
+) (*VersionedBeaconStateResponse, []*blsu.SecretKey) {
+	t.Helper()
+	var (
+		keys = make([]*blsu.SecretKey, len(amounts))
+		deps = make([]common.Deposit, len(amounts))
+	)
+	for i := range keys {
+		var b [32]byte
+		b[31] = byte(i + 1)
+		keys[i] = new(blsu.SecretKey)
+		if err := keys[i].Deserialize(&b); err != nil {
+			t.Fatalf("%v", err)
+		}
+		pub, err := blsu.SkToPk(keys[i])
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		deps[i].Data = common.DepositData{
+			Pubkey: common.BLSPubkey(pub.Serialize()),
+			Amount: amounts[i],
+		}
+		deps[i].Data.WithdrawalCredentials[0] = common.ETH1_ADDRESS_WITHDRAWAL_PREFIX
+		deps[i].Data.WithdrawalCredentials[31] = byte(i + 1)
+		deps[i].Data.Signature = testSign(
+			t,
+			keys[i],
+			deps[i].Data.MessageRoot(),
+			common.ComputeDomain(
+				common.DOMAIN_DEPOSIT,
+				spec.GENESIS_FORK_VERSION,
+				common.Root{},
+			),
+		)
+	}
+	genesis, _, err := phase0.GenesisFromEth1(spec, common.Root{0x01}, 0, deps, true)
+	if err != nil {
+		t.Fatalf("unable to create genesis: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := genesis.Serialize(codec.NewEncodingWriter(&buf)); err != nil {
+		t.Fatalf("%v", err)
+	}
+	state := new(phase0.BeaconState)
+	if err := state.Deserialize(
+		spec,
+		codec.NewDecodingReader(bytes.NewReader(buf.Bytes()), uint64(buf.Len())),
+	); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return &VersionedBeaconStateResponse{
+		VersionedBeaconState: &eth2api.VersionedBeaconState{
+			Version: "phase0",
+			Data:    state,
+		},
+		spec: spec,
+	}, keys
+}
+
+func TestProcessSlotsNextWithdrawals(t *testing.T) {
+	spec := *configs.Minimal
+	spec.ALTAIR_FORK_EPOCH = 1
+	spec.BELLATRIX_FORK_EPOCH = 1
+	spec.CAPELLA_FORK_EPOCH = 1
+	var (
+		ctx     = context.Background()
+		excess  = common.Gwei(1_000_000_000)
+		amounts = make([]common.Gwei, 16)
+	)
+	for i := range amounts {
+		amounts[i] = spec.MAX_EFFECTIVE_BALANCE
+	}
+	// Only the second validator has a balance over the maximum effective
+	// balance
+	amounts[1] += excess
+	genesis, _ := testGenesisState(t, &spec, amounts)
+
+	if _, err := genesis.NextWithdrawals(1); err == nil {
+		t.Fatalf("withdrawals computed from a phase0 state")
+	}
+	if same, err := genesis.ProcessSlots(ctx, 0); err != nil || same != genesis {
+		t.Fatalf("state not returned as is at its own slot: %v", err)
+	}
+
+	// The state is upgraded to capella at the first slot of epoch 1
+	capellaSlot := spec.SLOTS_PER_EPOCH
+	state, err := genesis.ProcessSlots(ctx, capellaSlot)
+	if err != nil {
+		t.Fatalf("unable to process slots: %v", err)
+	}
+	if state.Version != "capella" || state.StateSlot() != capellaSlot {
+		t.Fatalf(
+			"incorrect processed state: version %q, slot %d",
+			state.Version,
+			state.StateSlot(),
+		)
+	}
+	if genesis.StateSlot() != 0 {
+		t.Fatalf("pre-state modified")
+	}
+	withdrawals, err := state.NextWithdrawals(capellaSlot)
+	if err != nil {
+		t.Fatalf("unable to compute withdrawals: %v", err)
+	}
+	if len(withdrawals) != 1 || withdrawals[0].ValidatorIndex != 1 ||
+		withdrawals[0].Amount != excess {
+		t.Fatalf("incorrect withdrawals at the fork: %+v", withdrawals)
+	}
+
+	// The epoch processing penalizes the validators for not attesting, which
+	// reduces the amount of the partial withdrawal
+	nextSlot := capellaSlot + spec.SLOTS_PER_EPOCH
+	state, err = state.ProcessSlots(ctx, nextSlot)
+	if err != nil {
+		t.Fatalf("unable to process slots: %v", err)
+	}
+	withdrawals, err = state.NextWithdrawals(nextSlot)
+	if err != nil {
+		t.Fatalf("unable to compute withdrawals: %v", err)
+	}
+	if len(withdrawals) != 1 || withdrawals[0].ValidatorIndex != 1 ||
+		withdrawals[0].Amount >= excess {
+		t.Fatalf("incorrect withdrawals after epoch processing: %+v", withdrawals)
+	}
+}
@@ -6,15 +6,8 @@ import (
 
 	api "github.com/ethereum/go-ethereum/beacon/engine"
 	el_common "github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/holiman/uint256"
 	"github.com/protolambda/eth2api"
-	"github.com/protolambda/zrnt/eth2/beacon/altair"
-	"github.com/protolambda/zrnt/eth2/beacon/bellatrix"
-	"github.com/protolambda/zrnt/eth2/beacon/capella"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
-	"github.com/protolambda/zrnt/eth2/beacon/deneb"
-	"github.com/protolambda/zrnt/eth2/beacon/phase0"
 	"github.com/protolambda/ztyp/tree"
 )
 
@@ -22,19 +15,50 @@ var (
 	BLOB_COMMITMENT_VERSION_KZG = byte(0x01)
 )
 
+// Signed beacon block of any of the registered forks.
+// Blocks decoded from the beacon API always belong to a registered fork, and
+// the accessors panic otherwise.
 type VersionedSignedBeaconBlock struct {
 	*eth2api.VersionedSignedBeaconBlock
 	spec *common.Spec
 }
 
+// Decodes the block using the type of the registered fork that matches the
+// version
+func (versionedBlock *VersionedSignedBeaconBlock) UnmarshalJSON(b []byte) error {
+	version, data, err := decodeVersionedResponse(
+		b,
+		func(f *ForkDefinition) interface{} {
+			return f.NewSignedBeaconBlock()
+		},
+	)
+	if err != nil {
+		return err
+	}
+	versionedBlock.VersionedSignedBeaconBlock = &eth2api.VersionedSignedBeaconBlock{
+		Version: version,
+		Data:    data.(eth2api.SignedBeaconBlock),
+	}
+	return nil
+}
+
+func (versionedBlock *VersionedSignedBeaconBlock) ForkDefinition() (*ForkDefinition, error) {
+	return ForkDefinitionByName(versionedBlock.Version)
+}
+
 func (versionedBlock *VersionedSignedBeaconBlock) ContainsExecutionPayload() bool {
-	return versionedBlock.Version == "bellatrix" ||
-		versionedBlock.Version == "capella" ||
-		versionedBlock.Version == "deneb"
+	f, err := versionedBlock.ForkDefinition()
+	return err == nil && f.ExecutionPayload
+}
+
+func (versionedBlock *VersionedSignedBeaconBlock) ContainsWithdrawals() bool {
+	f, err := versionedBlock.ForkDefinition()
+	return err == nil && f.Withdrawals
 }
 
 func (versionedBlock *VersionedSignedBeaconBlock) ContainsKZGCommitments() bool {
-	return versionedBlock.Version == "deneb"
+	f, err := versionedBlock.ForkDefinition()
+	return err == nil && f.BlobKZGCommitments
 }
 
 func KZGCommitmentsToVersionedHashes(kzgCommitments []common.KZGCommitment) []el_common.Hash {
@@ -46,235 +70,105 @@ func KZGCommitmentsToVersionedHashes(kzgCommitments []common.KZGCommitment) []el
 	return versionedHashes
 }
 
+// Returns the typed fields of the block, or an error if the block does not
+// belong to a registered fork
+func (versionedBlock *VersionedSignedBeaconBlock) fields() (*SignedBeaconBlockFields, error) {
+	f, err := versionedBlock.ForkDefinition()
+	if err != nil {
+		return nil, err
+	}
+	return f.SignedBeaconBlockFields(versionedBlock.Data)
+}
+
+// Returns the typed fields of the block, panics if the block does not belong
+// to a registered fork
+func (versionedBlock *VersionedSignedBeaconBlock) mustFields() *SignedBeaconBlockFields {
+	fields, err := versionedBlock.fields()
+	if err != nil {
+		panic(err)
+	}
+	return fields
+}
+
 func (versionedBlock *VersionedSignedBeaconBlock) ExecutionPayload() (api.ExecutableData, []el_common.Hash, *el_common.Hash, error) {
 	var (
 		result          api.ExecutableData
 		versionedHashes []el_common.Hash
 		beaconRoot      *el_common.Hash
-		err             error
 	)
 
-	switch v := versionedBlock.Data.(type) {
-	case *bellatrix.SignedBeaconBlock:
-		execPayload := v.Message.Body.ExecutionPayload
-		copy(result.ParentHash[:], execPayload.ParentHash[:])
-		copy(result.FeeRecipient[:], execPayload.FeeRecipient[:])
-		copy(result.StateRoot[:], execPayload.StateRoot[:])
-		copy(result.ReceiptsRoot[:], execPayload.ReceiptsRoot[:])
-		copy(result.LogsBloom[:], execPayload.LogsBloom[:])
-		copy(result.Random[:], execPayload.PrevRandao[:])
-		result.Number = uint64(execPayload.BlockNumber)
-		result.GasLimit = uint64(execPayload.GasLimit)
-		result.GasUsed = uint64(execPayload.GasUsed)
-		result.Timestamp = uint64(execPayload.Timestamp)
-		copy(result.ExtraData[:], execPayload.ExtraData[:])
-		result.BaseFeePerGas = (*uint256.Int)(&execPayload.BaseFeePerGas).ToBig()
-		copy(result.BlockHash[:], execPayload.BlockHash[:])
-		result.Transactions = make([][]byte, 0)
-		for _, t := range execPayload.Transactions {
-			result.Transactions = append(result.Transactions, t)
-		}
-	case *capella.SignedBeaconBlock:
-		execPayload := v.Message.Body.ExecutionPayload
-		copy(result.ParentHash[:], execPayload.ParentHash[:])
-		copy(result.FeeRecipient[:], execPayload.FeeRecipient[:])
-		copy(result.StateRoot[:], execPayload.StateRoot[:])
-		copy(result.ReceiptsRoot[:], execPayload.ReceiptsRoot[:])
-		copy(result.LogsBloom[:], execPayload.LogsBloom[:])
-		copy(result.Random[:], execPayload.PrevRandao[:])
-		result.Number = uint64(execPayload.BlockNumber)
-		result.GasLimit = uint64(execPayload.GasLimit)
-		result.GasUsed = uint64(execPayload.GasUsed)
-		result.Timestamp = uint64(execPayload.Timestamp)
-		copy(result.ExtraData[:], execPayload.ExtraData[:])
-		result.BaseFeePerGas = (*uint256.Int)(&execPayload.BaseFeePerGas).ToBig()
-		copy(result.BlockHash[:], execPayload.BlockHash[:])
-		result.Transactions = make([][]byte, 0)
-		for _, t := range execPayload.Transactions {
-			result.Transactions = append(result.Transactions, t)
-		}
-		result.Withdrawals = make([]*types.Withdrawal, 0)
-		for _, w := range execPayload.Withdrawals {
-			withdrawal := new(types.Withdrawal)
-			withdrawal.Index = uint64(w.Index)
-			withdrawal.Validator = uint64(w.ValidatorIndex)
-			copy(withdrawal.Address[:], w.Address[:])
-			withdrawal.Amount = uint64(w.Amount)
-			result.Withdrawals = append(result.Withdrawals, withdrawal)
-		}
-	case *deneb.SignedBeaconBlock:
-		execPayload := v.Message.Body.ExecutionPayload
-		copy(result.ParentHash[:], execPayload.ParentHash[:])
-		copy(result.FeeRecipient[:], execPayload.FeeRecipient[:])
-		copy(result.StateRoot[:], execPayload.StateRoot[:])
-		copy(result.ReceiptsRoot[:], execPayload.ReceiptsRoot[:])
-		copy(result.LogsBloom[:], execPayload.LogsBloom[:])
-		copy(result.Random[:], execPayload.PrevRandao[:])
-		result.Number = uint64(execPayload.BlockNumber)
-		result.GasLimit = uint64(execPayload.GasLimit)
-		result.GasUsed = uint64(execPayload.GasUsed)
-		result.Timestamp = uint64(execPayload.Timestamp)
-		copy(result.ExtraData[:], execPayload.ExtraData[:])
-		result.BaseFeePerGas = (*uint256.Int)(&execPayload.BaseFeePerGas).ToBig()
-		copy(result.BlockHash[:], execPayload.BlockHash[:])
-		result.Transactions = make([][]byte, 0)
-		for _, t := range execPayload.Transactions {
-			result.Transactions = append(result.Transactions, t)
-		}
-		result.Withdrawals = make([]*types.Withdrawal, 0)
-		for _, w := range execPayload.Withdrawals {
-			withdrawal := new(types.Withdrawal)
-			withdrawal.Index = uint64(w.Index)
-			withdrawal.Validator = uint64(w.ValidatorIndex)
-			copy(withdrawal.Address[:], w.Address[:])
-			withdrawal.Amount = uint64(w.Amount)
-			result.Withdrawals = append(result.Withdrawals, withdrawal)
-		}
-		versionedHashes = KZGCommitmentsToVersionedHashes(v.Message.Body.BlobKZGCommitments)
-		beaconRoot = &el_common.Hash{}
-		copy(beaconRoot[:], v.Message.ParentRoot[:])
-	default:
-		err = fmt.Errorf(
+	f, err := versionedBlock.ForkDefinition()
+	if err != nil {
+		return result, nil, nil, err
+	}
+	if !f.ExecutionPayload {
+		return result, nil, nil, fmt.Errorf(
 			"beacon block version can't contain execution payload",
 		)
 	}
-	return result, versionedHashes, beaconRoot, err
+	data, err := f.ExecutableData(versionedBlock.Data)
+	if err != nil {
+		return result, nil, nil, err
+	}
+	result = *data
+	if f.BlobKZGCommitments {
+		versionedHashes = KZGCommitmentsToVersionedHashes(
+			versionedBlock.KZGCommitments(),
+		)
+	}
+	if f.ParentBeaconBlockRoot {
+		parentRoot := el_common.Hash(versionedBlock.ParentRoot())
+		beaconRoot = &parentRoot
+	}
+	return result, versionedHashes, beaconRoot, nil
 }
 
 func (versionedBlock *VersionedSignedBeaconBlock) Withdrawals() (common.Withdrawals, error) {
-	switch v := versionedBlock.Data.(type) {
-	case *capella.SignedBeaconBlock:
-		return v.Message.Body.ExecutionPayload.Withdrawals, nil
-	case *deneb.SignedBeaconBlock:
-		return v.Message.Body.ExecutionPayload.Withdrawals, nil
+	f, err := versionedBlock.ForkDefinition()
+	if err != nil {
+		return nil, err
+	}
+	if !f.Withdrawals {
+		return nil, nil
+	}
+	fields, err := versionedBlock.fields()
+	if err != nil {
+		return nil, err
 	}
-	return nil, nil
+	return *fields.Withdrawals, nil
 }
 
 func (b *VersionedSignedBeaconBlock) Root() tree.Root {
-	switch v := b.Data.(type) {
-	case *phase0.SignedBeaconBlock:
-		return v.Message.HashTreeRoot(b.spec, tree.GetHashFn())
-	case *altair.SignedBeaconBlock:
-		return v.Message.HashTreeRoot(b.spec, tree.GetHashFn())
-	case *bellatrix.SignedBeaconBlock:
-		return v.Message.HashTreeRoot(b.spec, tree.GetHashFn())
-	case *capella.SignedBeaconBlock:
-		return v.Message.HashTreeRoot(b.spec, tree.GetHashFn())
-	case *deneb.SignedBeaconBlock:
-		return v.Message.HashTreeRoot(b.spec, tree.GetHashFn())
-	}
-	panic(fmt.Errorf("badly formatted beacon block, type=%T", b.Data))
+	return b.mustFields().Message.HashTreeRoot(b.spec, tree.GetHashFn())
 }
 
 func (b *VersionedSignedBeaconBlock) StateRoot() tree.Root {
-	switch v := b.Data.(type) {
-	case *phase0.SignedBeaconBlock:
-		return v.Message.StateRoot
-	case *altair.SignedBeaconBlock:
-		return v.Message.StateRoot
-	case *bellatrix.SignedBeaconBlock:
-		return v.Message.StateRoot
-	case *capella.SignedBeaconBlock:
-		return v.Message.StateRoot
-	case *deneb.SignedBeaconBlock:
-		return v.Message.StateRoot
-	}
-	panic(fmt.Errorf("badly formatted beacon block, type=%T", b.Data))
+	return b.mustFields().StateRoot
 }
 
 func (b *VersionedSignedBeaconBlock) ParentRoot() tree.Root {
-	switch v := b.Data.(type) {
-	case *phase0.SignedBeaconBlock:
-		return v.Message.ParentRoot
-	case *altair.SignedBeaconBlock:
-		return v.Message.ParentRoot
-	case *bellatrix.SignedBeaconBlock:
-		return v.Message.ParentRoot
-	case *capella.SignedBeaconBlock:
-		return v.Message.ParentRoot
-	case *deneb.SignedBeaconBlock:
-		return v.Message.ParentRoot
-	}
-	panic(fmt.Errorf("badly formatted beacon block, type=%T", b.Data))
+	return b.mustFields().ParentRoot
 }
 
 func (b *VersionedSignedBeaconBlock) Slot() common.Slot {
-	switch v := b.Data.(type) {
-	case *phase0.SignedBeaconBlock:
-		return v.Message.Slot
-	case *altair.SignedBeaconBlock:
-		return v.Message.Slot
-	case *bellatrix.SignedBeaconBlock:
-		return v.Message.Slot
-	case *capella.SignedBeaconBlock:
-		return v.Message.Slot
-	case *deneb.SignedBeaconBlock:
-		return v.Message.Slot
-	}
-	panic(fmt.Errorf("badly formatted beacon block, type=%T", b.Data))
+	return b.mustFields().Slot
 }
 
 func (b *VersionedSignedBeaconBlock) ProposerIndex() common.ValidatorIndex {
-	switch v := b.Data.(type) {
-	case *phase0.SignedBeaconBlock:
-		return v.Message.ProposerIndex
-	case *altair.SignedBeaconBlock:
-		return v.Message.ProposerIndex
-	case *bellatrix.SignedBeaconBlock:
-		return v.Message.ProposerIndex
-	case *capella.SignedBeaconBlock:
-		return v.Message.ProposerIndex
-	case *deneb.SignedBeaconBlock:
-		return v.Message.ProposerIndex
-	}
-	panic(fmt.Errorf("badly formatted beacon block, type=%T", b.Data))
+	return b.mustFields().ProposerIndex
 }
 
 func (b *VersionedSignedBeaconBlock) Signature() common.BLSSignature {
-	switch v := b.Data.(type) {
-	case *phase0.SignedBeaconBlock:
-		return v.Signature
-	case *altair.SignedBeaconBlock:
-		return v.Signature
-	case *bellatrix.SignedBeaconBlock:
-		return v.Signature
-	case *capella.SignedBeaconBlock:
-		return v.Signature
-	case *deneb.SignedBeaconBlock:
-		return v.Signature
-	}
-	panic(fmt.Errorf("badly formatted beacon block, type=%T", b.Data))
+	return b.mustFields().Signature
 }
 
 func (b *VersionedSignedBeaconBlock) ExecutionPayloadBlockHash() *tree.Root {
-	switch v := b.Data.(type) {
-	case *phase0.SignedBeaconBlock:
-		return nil
-	case *altair.SignedBeaconBlock:
-		return nil
-	case *bellatrix.SignedBeaconBlock:
-		return &v.Message.Body.ExecutionPayload.BlockHash
-	case *capella.SignedBeaconBlock:
-		return &v.Message.Body.ExecutionPayload.BlockHash
-	case *deneb.SignedBeaconBlock:
-		return &v.Message.Body.ExecutionPayload.BlockHash
-	}
-	panic(fmt.Errorf("badly formatted beacon block, type=%T", b.Data))
+	return b.mustFields().ExecutionPayloadBlockHash
 }
 
 func (b *VersionedSignedBeaconBlock) KZGCommitments() common.KZGCommitments {
-	switch v := b.Data.(type) {
-	case *phase0.SignedBeaconBlock:
-		return nil
-	case *altair.SignedBeaconBlock:
-		return nil
-	case *bellatrix.SignedBeaconBlock:
-		return nil
-	case *capella.SignedBeaconBlock:
-		return nil
-	case *deneb.SignedBeaconBlock:
-		return v.Message.Body.BlobKZGCommitments
+	if fields := b.mustFields(); fields.BlobKZGCommitments != nil {
+		return *fields.BlobKZGCommitments
 	}
-	panic(fmt.Errorf("badly formatted beacon block, type=%T", b.Data))
+	return nil
 }
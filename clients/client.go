@@ -26,6 +26,14 @@ type ManagedClient interface {
 	Shutdown() error
 }
 
+// Client whose network connectivity to other clients can be controlled,
+// e.g. by the orchestrator that runs it
+type NetworkControlledClient interface {
+	Client
+	DisconnectFrom(peer Client) error
+	ConnectTo(peer Client) error
+}
+
 var _ Client = &ExternalClient{}
 
 type ExternalClient struct {
@@ -0,0 +1,62 @@
+package execution
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Funded account used to send transactions, which keeps track of its next
+// nonce
+type Account struct {
+	Key     *ecdsa.PrivateKey
+	Address common.Address
+
+	nonce uint64
+	mu    sync.Mutex
+}
+
+func NewAccount(key *ecdsa.PrivateKey) *Account {
+	return &Account{
+		Key:     key,
+		Address: crypto.PubkeyToAddress(key.PublicKey),
+	}
+}
+
+// Returns the nonce to use in the next transaction and increments it
+func (a *Account) NextNonce() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	nonce := a.nonce
+	a.nonce++
+	return nonce
+}
+
+// Returns the nonce to use in the next transaction
+func (a *Account) Nonce() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.nonce
+}
+
+func (a *Account) SetNonce(nonce uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nonce = nonce
+}
+
+// Sets the nonce of the account to the pending nonce of the execution client
+func (a *Account) SyncNonce(
+	ctx context.Context,
+	ec *ExecutionClient,
+) error {
+	nonce, err := ec.PendingNonceAt(ctx, a.Address)
+	if err != nil {
+		return err
+	}
+	a.SetNonce(nonce)
+	return nil
+}
@@ -0,0 +1,318 @@
+package execution
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/misc/eip4844"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+	"github.com/marioevz/eth-clients/clients/utils"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+)
+
+// Gas limit used for blob transactions when none is specified
+const DefaultBlobTransactionGas = 21000
+
+// Returns a blob filled with random canonical field elements
+func RandomBlob() (kzg4844.Blob, error) {
+	var blob kzg4844.Blob
+	if _, err := rand.Read(blob[:]); err != nil {
+		return blob, err
+	}
+	// Clearing the most significant byte keeps every field element below the
+	// BLS modulus
+	for i := 0; i < len(blob); i += params.BlobTxBytesPerFieldElement {
+		blob[i] = 0
+	}
+	return blob, nil
+}
+
+// Computes the commitments and proofs of the given blobs
+func NewBlobTxSidecar(blobs []kzg4844.Blob) (*types.BlobTxSidecar, error) {
+	sidecar := &types.BlobTxSidecar{
+		Blobs:       blobs,
+		Commitments: make([]kzg4844.Commitment, len(blobs)),
+		Proofs:      make([]kzg4844.Proof, len(blobs)),
+	}
+	for i := range blobs {
+		commitment, err := kzg4844.BlobToCommitment(blobs[i])
+		if err != nil {
+			return nil, fmt.Errorf("blob %d: %v", i, err)
+		}
+		proof, err := kzg4844.ComputeBlobProof(blobs[i], commitment)
+		if err != nil {
+			return nil, fmt.Errorf("blob %d: %v", i, err)
+		}
+		sidecar.Commitments[i] = commitment
+		sidecar.Proofs[i] = proof
+	}
+	return sidecar, nil
+}
+
+// Parameters of a blob transaction.
+// Nil fields are filled in by SendBlobTransaction using the execution client.
+type BlobTransactionConfig struct {
+	ChainID    *big.Int
+	Nonce      *uint64
+	To         common.Address
+	Value      *big.Int
+	Data       []byte
+	Gas        uint64
+	GasTipCap  *big.Int
+	GasFeeCap  *big.Int
+	BlobFeeCap *big.Int
+	// Blobs to include in the transaction, if empty BlobCount random blobs
+	// are generated
+	Blobs     []kzg4844.Blob
+	BlobCount int
+}
+
+// Signed blob transaction that includes its sidecar
+type BlobTransaction struct {
+	*types.Transaction
+}
+
+// Returns the sidecar of the transaction
+func (btx *BlobTransaction) Sidecar() *types.BlobTxSidecar {
+	return btx.BlobTxSidecar()
+}
+
+// Returns the index of the transaction blob contained in the beacon sidecar,
+// verifying that commitment, proof and blob all match
+func (btx *BlobTransaction) MatchBlobSidecar(
+	sidecar *deneb.BlobSidecar,
+) (int, bool) {
+	txSidecar := btx.BlobTxSidecar()
+	if txSidecar == nil {
+		return 0, false
+	}
+	for i := range txSidecar.Commitments {
+		if txSidecar.Commitments[i] != kzg4844.Commitment(sidecar.KZGCommitment) {
+			continue
+		}
+		if txSidecar.Proofs[i] != kzg4844.Proof(sidecar.KZGProof) ||
+			string(txSidecar.Blobs[i][:]) != string(sidecar.Blob) {
+			return i, false
+		}
+		return i, true
+	}
+	return 0, false
+}
+
+// Returns the indexes of the beacon sidecars that contain each of the blobs
+// of the transaction, in order.
+// Returns an error if any of the blobs is not found or its contents differ.
+func (btx *BlobTransaction) FindBlobSidecars(
+	sidecars []deneb.BlobSidecar,
+) ([]deneb.BlobIndex, error) {
+	txSidecar := btx.BlobTxSidecar()
+	if txSidecar == nil {
+		return nil, fmt.Errorf("transaction %s has no sidecar", btx.Hash())
+	}
+	indexes := make([]deneb.BlobIndex, len(txSidecar.Blobs))
+	found := make([]bool, len(txSidecar.Blobs))
+	for i := range sidecars {
+		if txIndex, ok := btx.MatchBlobSidecar(&sidecars[i]); ok {
+			indexes[txIndex] = sidecars[i].Index
+			found[txIndex] = true
+		} else if txSidecar.Commitments[txIndex] == kzg4844.Commitment(
+			sidecars[i].KZGCommitment,
+		) {
+			return nil, fmt.Errorf(
+				"transaction %s: blob %d: contents mismatch in sidecar %d",
+				btx.Hash(),
+				txIndex,
+				sidecars[i].Index,
+			)
+		}
+	}
+	for i := range found {
+		if !found[i] {
+			return nil, fmt.Errorf(
+				"transaction %s: blob %d not found in sidecars",
+				btx.Hash(),
+				i,
+			)
+		}
+	}
+	return indexes, nil
+}
+
+// Converts a value of the blob transaction config, returning an error if it is
+// negative or does not fit in 256 bits
+func blobTxUint256(name string, v *big.Int) (*uint256.Int, error) {
+	if v.Sign() < 0 {
+		return nil, fmt.Errorf("negative %s: %d", name, v)
+	}
+	u, overflow := uint256.FromBig(v)
+	if overflow {
+		return nil, fmt.Errorf("%s overflows uint256: %d", name, v)
+	}
+	return u, nil
+}
+
+// Builds and signs a blob transaction using the given config.
+// All config fields, except for the blobs, must be set.
+func BuildBlobTransaction(
+	cfg *BlobTransactionConfig,
+	key *ecdsa.PrivateKey,
+) (*BlobTransaction, error) {
+	if cfg.ChainID == nil || cfg.Nonce == nil || cfg.GasTipCap == nil ||
+		cfg.GasFeeCap == nil || cfg.BlobFeeCap == nil {
+		return nil, fmt.Errorf("incomplete blob transaction config")
+	}
+	chainID, err := blobTxUint256("chain id", cfg.ChainID)
+	if err != nil {
+		return nil, err
+	}
+	gasTipCap, err := blobTxUint256("gas tip cap", cfg.GasTipCap)
+	if err != nil {
+		return nil, err
+	}
+	gasFeeCap, err := blobTxUint256("gas fee cap", cfg.GasFeeCap)
+	if err != nil {
+		return nil, err
+	}
+	blobFeeCap, err := blobTxUint256("blob fee cap", cfg.BlobFeeCap)
+	if err != nil {
+		return nil, err
+	}
+	value := new(uint256.Int)
+	if cfg.Value != nil {
+		if value, err = blobTxUint256("value", cfg.Value); err != nil {
+			return nil, err
+		}
+	}
+	blobs := cfg.Blobs
+	if len(blobs) == 0 {
+		if cfg.BlobCount <= 0 {
+			return nil, fmt.Errorf("blob transaction must contain blobs")
+		}
+		blobs = make([]kzg4844.Blob, cfg.BlobCount)
+		for i := range blobs {
+			blob, err := RandomBlob()
+			if err != nil {
+				return nil, err
+			}
+			blobs[i] = blob
+		}
+	}
+	sidecar, err := NewBlobTxSidecar(blobs)
+	if err != nil {
+		return nil, err
+	}
+	gas := cfg.Gas
+	if gas == 0 {
+		gas = DefaultBlobTransactionGas
+	}
+	tx, err := types.SignNewTx(
+		key,
+		types.NewCancunSigner(cfg.ChainID),
+		&types.BlobTx{
+			ChainID:    chainID,
+			Nonce:      *cfg.Nonce,
+			GasTipCap:  gasTipCap,
+			GasFeeCap:  gasFeeCap,
+			Gas:        gas,
+			To:         cfg.To,
+			Value:      value,
+			Data:       cfg.Data,
+			BlobFeeCap: blobFeeCap,
+			BlobHashes: sidecar.BlobHashes(),
+			Sidecar:    sidecar,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &BlobTransaction{Transaction: tx}, nil
+}
+
+// Fills in the missing fields of the config using the current state of the
+// execution client.
+// Fee caps are set to twice the current base fees plus the tip.
+func (ec *ExecutionClient) completeBlobTransactionConfig(
+	parentCtx context.Context,
+	cfg *BlobTransactionConfig,
+	sender common.Address,
+) error {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	if cfg.ChainID == nil {
+		chainID, err := ec.eth.ChainID(ctx)
+		if err != nil {
+			return err
+		}
+		cfg.ChainID = chainID
+	}
+	if cfg.Nonce == nil {
+		nonce, err := ec.eth.PendingNonceAt(ctx, sender)
+		if err != nil {
+			return err
+		}
+		cfg.Nonce = &nonce
+	}
+	if cfg.GasTipCap == nil {
+		tip, err := ec.eth.SuggestGasTipCap(ctx)
+		if err != nil {
+			return err
+		}
+		cfg.GasTipCap = tip
+	}
+	if cfg.GasFeeCap != nil && cfg.BlobFeeCap != nil {
+		return nil
+	}
+	header, err := ec.eth.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if cfg.GasFeeCap == nil {
+		if header.BaseFee == nil {
+			return fmt.Errorf("latest header has no base fee")
+		}
+		cfg.GasFeeCap = new(big.Int).Add(
+			new(big.Int).Mul(header.BaseFee, big.NewInt(2)),
+			cfg.GasTipCap,
+		)
+	}
+	if cfg.BlobFeeCap == nil {
+		if header.ExcessBlobGas == nil {
+			return fmt.Errorf("latest header has no excess blob gas")
+		}
+		cfg.BlobFeeCap = new(big.Int).Mul(
+			eip4844.CalcBlobFee(*header.ExcessBlobGas),
+			big.NewInt(2),
+		)
+	}
+	return nil
+}
+
+// Builds, signs and sends a blob transaction in its network form, including
+// the blobs, commitments and proofs.
+// The config is modified to include the fields filled in from the client.
+func (ec *ExecutionClient) SendBlobTransaction(
+	ctx context.Context,
+	cfg *BlobTransactionConfig,
+	key *ecdsa.PrivateKey,
+) (*BlobTransaction, error) {
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	if err := ec.completeBlobTransactionConfig(ctx, cfg, sender); err != nil {
+		return nil, err
+	}
+	btx, err := BuildBlobTransaction(cfg, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := ec.SendTransaction(ctx, btx.Transaction); err != nil {
+		return nil, err
+	}
+	return btx, nil
+}
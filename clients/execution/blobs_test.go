@@ -0,0 +1,129 @@
+/*
+Tests for the blob transaction builder
+*/
+package execution
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+)
+
+func TestBuildBlobTransaction(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	nonce := uint64(3)
+	btx, err := BuildBlobTransaction(&BlobTransactionConfig{
+		ChainID:    big.NewInt(1337),
+		Nonce:      &nonce,
+		GasTipCap:  big.NewInt(1),
+		GasFeeCap:  big.NewInt(2),
+		BlobFeeCap: big.NewInt(3),
+		BlobCount:  2,
+	}, key)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// Network encoding must include the sidecar
+	data, err := btx.MarshalBinary()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	decoded := new(types.Transaction)
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("%v", err)
+	}
+	sidecar := decoded.BlobTxSidecar()
+	if sidecar == nil || len(sidecar.Blobs) != 2 {
+		t.Fatalf("decoded transaction is missing the sidecar")
+	}
+	for i, h := range sidecar.BlobHashes() {
+		if decoded.BlobHashes()[i] != h {
+			t.Fatalf("blob %d: incorrect versioned hash", i)
+		}
+	}
+	sender, err := types.Sender(types.NewCancunSigner(big.NewInt(1337)), decoded)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if sender != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("incorrect sender: %s", sender)
+	}
+
+	// Sidecars are served by the beacon node in reverse order with an
+	// unrelated blob in between
+	beaconSidecars := []deneb.BlobSidecar{
+		{
+			Index:         0,
+			Blob:          append(deneb.Blob{}, sidecar.Blobs[1][:]...),
+			KZGCommitment: common.KZGCommitment(sidecar.Commitments[1]),
+			KZGProof:      common.KZGProof(sidecar.Proofs[1]),
+		},
+		{
+			Index: 1,
+		},
+		{
+			Index:         2,
+			Blob:          append(deneb.Blob{}, sidecar.Blobs[0][:]...),
+			KZGCommitment: common.KZGCommitment(sidecar.Commitments[0]),
+			KZGProof:      common.KZGProof(sidecar.Proofs[0]),
+		},
+	}
+	indexes, err := btx.FindBlobSidecars(beaconSidecars)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(indexes) != 2 || indexes[0] != 2 || indexes[1] != 0 {
+		t.Fatalf("incorrect sidecar indexes: %v", indexes)
+	}
+
+	beaconSidecars[0].KZGProof = beaconSidecars[2].KZGProof
+	if _, err := btx.FindBlobSidecars(beaconSidecars); err == nil {
+		t.Fatalf("expected contents mismatch error")
+	}
+	if _, err := btx.FindBlobSidecars(beaconSidecars[2:]); err == nil {
+		t.Fatalf("expected missing blob error")
+	}
+}
+
+func TestBuildBlobTransactionOutOfRange(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var (
+		nonce    = uint64(0)
+		overflow = new(big.Int).Lsh(big.NewInt(1), 256)
+	)
+	for _, test := range []struct {
+		name   string
+		modify func(cfg *BlobTransactionConfig)
+	}{
+		{"chain id", func(cfg *BlobTransactionConfig) { cfg.ChainID = overflow }},
+		{"gas tip cap", func(cfg *BlobTransactionConfig) { cfg.GasTipCap = overflow }},
+		{"gas fee cap", func(cfg *BlobTransactionConfig) { cfg.GasFeeCap = overflow }},
+		{"blob fee cap", func(cfg *BlobTransactionConfig) { cfg.BlobFeeCap = overflow }},
+		{"value", func(cfg *BlobTransactionConfig) { cfg.Value = overflow }},
+		{"negative value", func(cfg *BlobTransactionConfig) { cfg.Value = big.NewInt(-1) }},
+	} {
+		cfg := &BlobTransactionConfig{
+			ChainID:    big.NewInt(1337),
+			Nonce:      &nonce,
+			GasTipCap:  big.NewInt(1),
+			GasFeeCap:  big.NewInt(2),
+			BlobFeeCap: big.NewInt(3),
+			BlobCount:  1,
+		}
+		test.modify(cfg)
+		if _, err := BuildBlobTransaction(cfg, key); err == nil {
+			t.Fatalf("%s: expected out of range error", test.name)
+		}
+	}
+}
@@ -0,0 +1,480 @@
+package execution
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	api "github.com/ethereum/go-ethereum/beacon/engine"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Activation timestamps of the execution forks, nil if not scheduled
+type ExecutionForkTimes struct {
+	ShanghaiTime *uint64
+	CancunTime   *uint64
+}
+
+// Returns the engine API version to use for a payload with the given
+// timestamp
+func (s ExecutionForkTimes) EngineAPIVersion(timestamp uint64) int {
+	if s.CancunTime != nil && timestamp >= *s.CancunTime {
+		return 3
+	}
+	if s.ShanghaiTime != nil && timestamp >= *s.ShanghaiTime {
+		return 2
+	}
+	return 1
+}
+
+type CLSimulatorConfig struct {
+	// Time between blocks, also used as timestamp increment, defaults to 12
+	// seconds
+	SlotTime time.Duration
+	// Time given to the execution client to build the payload, defaults to
+	// 500 milliseconds
+	PayloadBuildTime time.Duration
+	FeeRecipient     common.Address
+	Forks            ExecutionForkTimes
+	// Number of blocks between the head and the safe and finalized blocks
+	SafeDistance      uint64
+	FinalizedDistance uint64
+	// Functions that return the values of the payload attributes for the
+	// block number, the defaults derive them from the number
+	PrevRandao       func(number uint64) common.Hash
+	Withdrawals      func(number uint64) types.Withdrawals
+	ParentBeaconRoot func(number uint64) common.Hash
+	// Index of the client, within the simulator clients, that builds the
+	// payloads
+	BuilderIndex int
+}
+
+// Drives a set of execution clients through the engine API, without a
+// consensus client, by requesting a payload from the builder client on every
+// slot and importing it into all clients.
+type CLSimulator struct {
+	ExecutionClients ExecutionClients
+	Config           CLSimulatorConfig
+
+	head *types.Header
+	// Number of sibling payloads produced, used to make each one unique
+	siblings uint64
+	mu       sync.Mutex
+}
+
+func numberHash(prefix string, number uint64) common.Hash {
+	return crypto.Keccak256Hash(
+		[]byte(prefix),
+		binary.BigEndian.AppendUint64(nil, number),
+	)
+}
+
+func (all ExecutionClients) NewCLSimulator(cfg CLSimulatorConfig) *CLSimulator {
+	if cfg.SlotTime == 0 {
+		cfg.SlotTime = 12 * time.Second
+	}
+	if cfg.PayloadBuildTime == 0 {
+		cfg.PayloadBuildTime = 500 * time.Millisecond
+	}
+	if cfg.PrevRandao == nil {
+		cfg.PrevRandao = func(number uint64) common.Hash {
+			return numberHash("prevRandao", number)
+		}
+	}
+	if cfg.Withdrawals == nil {
+		cfg.Withdrawals = func(number uint64) types.Withdrawals {
+			return make(types.Withdrawals, 0)
+		}
+	}
+	if cfg.ParentBeaconRoot == nil {
+		cfg.ParentBeaconRoot = func(number uint64) common.Hash {
+			return numberHash("parentBeaconRoot", number)
+		}
+	}
+	return &CLSimulator{
+		ExecutionClients: all,
+		Config:           cfg,
+	}
+}
+
+func (s *CLSimulator) builder() (*ExecutionClient, error) {
+	if s.Config.BuilderIndex >= len(s.ExecutionClients) {
+		return nil, fmt.Errorf(
+			"invalid builder index %d",
+			s.Config.BuilderIndex,
+		)
+	}
+	builder := s.ExecutionClients[s.Config.BuilderIndex]
+	if !builder.IsRunning() {
+		return nil, fmt.Errorf("builder client is not running")
+	}
+	return builder, nil
+}
+
+// Sets the current head of the builder as the head of the simulated chain
+func (s *CLSimulator) Init(ctx context.Context) error {
+	builder, err := s.builder()
+	if err != nil {
+		return err
+	}
+	head, err := builder.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.head = head
+	return nil
+}
+
+// Returns the head of the simulated chain
+func (s *CLSimulator) Head() *types.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.head
+}
+
+// Returns the forkchoice state that points to the given head, with the safe
+// and finalized blocks at the configured distances from it.
+// The ancestors are looked up by number in the canonical chain of the
+// builder, so side chains must not be longer than the configured distances.
+func (s *CLSimulator) forkchoiceState(
+	ctx context.Context,
+	head *types.Header,
+) (*api.ForkchoiceStateV1, error) {
+	builder, err := s.builder()
+	if err != nil {
+		return nil, err
+	}
+	ancestor := func(distance uint64) (common.Hash, error) {
+		if distance == 0 {
+			return head.Hash(), nil
+		}
+		number := head.Number.Uint64()
+		if distance > number {
+			distance = number
+		}
+		h, err := builder.HeaderByNumber(
+			ctx,
+			new(big.Int).SetUint64(number-distance),
+		)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		return h.Hash(), nil
+	}
+	safe, err := ancestor(s.Config.SafeDistance)
+	if err != nil {
+		return nil, err
+	}
+	finalized, err := ancestor(s.Config.FinalizedDistance)
+	if err != nil {
+		return nil, err
+	}
+	return &api.ForkchoiceStateV1{
+		HeadBlockHash:      head.Hash(),
+		SafeBlockHash:      safe,
+		FinalizedBlockHash: finalized,
+	}, nil
+}
+
+// Sends the forkchoice update that points to the head to all running clients
+func (s *CLSimulator) updateForkchoice(
+	ctx context.Context,
+	head *types.Header,
+) error {
+	fcState, err := s.forkchoiceState(ctx, head)
+	if err != nil {
+		return err
+	}
+	version := s.Config.Forks.EngineAPIVersion(head.Time)
+	for _, ec := range s.ExecutionClients.Running() {
+		resp, err := ec.EngineForkchoiceUpdated(ctx, fcState, nil, version)
+		if err != nil {
+			return fmt.Errorf(
+				"execution client %d: forkchoice updated: %v",
+				ec.Config.ClientIndex,
+				err,
+			)
+		}
+		if resp.PayloadStatus.Status != api.VALID {
+			return fmt.Errorf(
+				"execution client %d: forkchoice updated: unexpected status %s",
+				ec.Config.ClientIndex,
+				resp.PayloadStatus.Status,
+			)
+		}
+	}
+	return nil
+}
+
+// Requests a payload on top of the parent from the builder, and returns it
+// with its parent beacon block root
+func (s *CLSimulator) buildPayload(
+	ctx context.Context,
+	builder *ExecutionClient,
+	parentHeader *types.Header,
+) (*api.ExecutableData, *common.Hash, error) {
+	slotSeconds := uint64(s.Config.SlotTime / time.Second)
+	if slotSeconds == 0 {
+		slotSeconds = 1
+	}
+	var (
+		number    = parentHeader.Number.Uint64() + 1
+		timestamp = parentHeader.Time + slotSeconds
+		version   = s.Config.Forks.EngineAPIVersion(timestamp)
+		attrs     = &api.PayloadAttributes{
+			Timestamp:             timestamp,
+			Random:                s.Config.PrevRandao(number),
+			SuggestedFeeRecipient: s.Config.FeeRecipient,
+		}
+		beaconRoot *common.Hash
+	)
+	if version >= 2 {
+		attrs.Withdrawals = s.Config.Withdrawals(number)
+	}
+	if version >= 3 {
+		root := s.Config.ParentBeaconRoot(number)
+		attrs.BeaconRoot = &root
+		beaconRoot = &root
+	}
+
+	fcState, err := s.forkchoiceState(ctx, parentHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := builder.EngineForkchoiceUpdated(ctx, fcState, attrs, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.PayloadStatus.Status != api.VALID || resp.PayloadID == nil {
+		return nil, nil, fmt.Errorf(
+			"unable to start payload build: status %s",
+			resp.PayloadStatus.Status,
+		)
+	}
+	select {
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	case <-time.After(s.Config.PayloadBuildTime):
+	}
+	payload, _, _, _, err := builder.EngineGetPayload(ctx, resp.PayloadID, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	return payload, beaconRoot, nil
+}
+
+// Returns a sibling of the block, with the same parent and contents and a
+// different extra data, which leaves the execution of the block unchanged,
+// and its parent beacon block root
+func (s *CLSimulator) siblingPayload(
+	block *types.Block,
+) (*api.ExecutableData, *common.Hash) {
+	s.mu.Lock()
+	s.siblings++
+	n := s.siblings
+	s.mu.Unlock()
+	header := types.CopyHeader(block.Header())
+	header.Extra = numberHash("sibling", n).Bytes()
+	sibling := block.WithSeal(header)
+	return api.BlockToExecutableData(sibling, nil, nil).ExecutionPayload,
+		header.ParentBeaconRoot
+}
+
+// Builds a payload on top of the given parent and imports it into all
+// running clients.
+// The clients do not build payloads on top of blocks that are ancestors of
+// their canonical head, so if the parent is one of them, the payload is a
+// sibling of its canonical child instead.
+// If canonical is true, the forkchoice of all clients is updated to the new
+// payload, otherwise the payload is left as a side chain and the clients
+// are moved back to the current head.
+func (s *CLSimulator) ProduceBlockOn(
+	ctx context.Context,
+	parent common.Hash,
+	canonical bool,
+) (*api.ExecutableData, error) {
+	builder, err := s.builder()
+	if err != nil {
+		return nil, err
+	}
+	parentHeader, err := builder.HeaderByHash(ctx, parent)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		payload    *api.ExecutableData
+		beaconRoot *common.Hash
+	)
+	child, err := builder.BlockByNumber(
+		ctx,
+		new(big.Int).Add(parentHeader.Number, common.Big1),
+	)
+	if err == nil && child.ParentHash() == parent {
+		payload, beaconRoot = s.siblingPayload(child)
+	} else if err != nil && !errors.Is(err, ethereum.NotFound) {
+		return nil, err
+	} else {
+		payload, beaconRoot, err = s.buildPayload(ctx, builder, parentHeader)
+		if err != nil {
+			return nil, err
+		}
+	}
+	versionedHashes, err := PayloadVersionedHashes(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Import the payload into all clients
+	version := s.Config.Forks.EngineAPIVersion(payload.Timestamp)
+	for _, ec := range s.ExecutionClients.Running() {
+		var status *api.PayloadStatusV1
+		if version >= 3 {
+			status, err = ec.EngineNewPayloadV3(
+				ctx,
+				payload,
+				versionedHashes,
+				beaconRoot,
+			)
+		} else {
+			status, err = ec.EngineNewPayload(ctx, payload, version)
+		}
+		if err != nil {
+			return nil, fmt.Errorf(
+				"execution client %d: new payload: %v",
+				ec.Config.ClientIndex,
+				err,
+			)
+		}
+		if status.Status != api.VALID {
+			return nil, fmt.Errorf(
+				"execution client %d: new payload: unexpected status %s",
+				ec.Config.ClientIndex,
+				status.Status,
+			)
+		}
+	}
+
+	block, err := api.ExecutableDataToBlock(*payload, versionedHashes, beaconRoot)
+	if err != nil {
+		return nil, err
+	}
+	if canonical {
+		if err := s.updateForkchoice(ctx, block.Header()); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		s.head = block.Header()
+		s.mu.Unlock()
+	} else if head := s.Head(); head != nil {
+		// Building the payload might have moved the builder to the parent
+		if err := s.updateForkchoice(ctx, head); err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+// Builds a payload on top of the current head and makes it canonical
+func (s *CLSimulator) ProduceBlock(
+	ctx context.Context,
+) (*api.ExecutableData, error) {
+	head := s.Head()
+	if head == nil {
+		return nil, fmt.Errorf("simulator not initialized")
+	}
+	return s.ProduceBlockOn(ctx, head.Hash(), true)
+}
+
+// Builds a chain of the given length on top of the ancestor without making
+// it canonical, and returns its payloads
+func (s *CLSimulator) ProduceFork(
+	ctx context.Context,
+	ancestor common.Hash,
+	length int,
+) ([]*api.ExecutableData, error) {
+	payloads := make([]*api.ExecutableData, 0, length)
+	parent := ancestor
+	for i := 0; i < length; i++ {
+		payload, err := s.ProduceBlockOn(ctx, parent, false)
+		if err != nil {
+			return payloads, err
+		}
+		payloads = append(payloads, payload)
+		parent = payload.BlockHash
+	}
+	return payloads, nil
+}
+
+// Updates the forkchoice of all clients to the given block, which must have
+// been imported already, and continues the simulated chain from it
+func (s *CLSimulator) Reorg(ctx context.Context, newHead common.Hash) error {
+	builder, err := s.builder()
+	if err != nil {
+		return err
+	}
+	head, err := builder.HeaderByHash(ctx, newHead)
+	if err != nil {
+		return err
+	}
+	if err := s.updateForkchoice(ctx, head); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.head = head
+	return nil
+}
+
+// Produces a block every slot until the context is done
+func (s *CLSimulator) Run(ctx context.Context) error {
+	if s.Head() == nil {
+		if err := s.Init(ctx); err != nil {
+			return err
+		}
+	}
+	timer := time.NewTicker(s.Config.SlotTime)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+			payload, err := s.ProduceBlock(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			s.ExecutionClients[s.Config.BuilderIndex].Logf(
+				"CLSimulator: produced block %d (%s), %d transactions",
+				payload.Number,
+				payload.BlockHash,
+				len(payload.Transactions),
+			)
+		}
+	}
+}
+
+// Returns the blob versioned hashes of the transactions in the payload, in
+// order
+func PayloadVersionedHashes(payload *api.ExecutableData) ([]common.Hash, error) {
+	versionedHashes := make([]common.Hash, 0)
+	for i, txBytes := range payload.Transactions {
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(txBytes); err != nil {
+			return nil, fmt.Errorf("unable to decode transaction %d: %v", i, err)
+		}
+		versionedHashes = append(versionedHashes, tx.BlobHashes()...)
+	}
+	return versionedHashes, nil
+}
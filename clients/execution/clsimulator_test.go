@@ -0,0 +1,299 @@
+/*
+Tests for the CL simulator block and fork production
+*/
+package execution
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/ethereum/go-ethereum/beacon/engine"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Chain of empty blocks of a fake execution client, which follows the
+// forkchoice rules of the engine API
+type testEngineChain struct {
+	blocks    map[common.Hash]*types.Block
+	canonical map[uint64]common.Hash
+	head      *types.Block
+	payloads  map[api.PayloadID]*types.Block
+	nextID    uint64
+	mu        sync.Mutex
+}
+
+func (c *testEngineChain) Head() common.Hash {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.head.Hash()
+}
+
+func (c *testEngineChain) Known(hash common.Hash) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.blocks[hash]
+	return ok
+}
+
+func (c *testEngineChain) setHead(head *types.Block) {
+	for n := range c.canonical {
+		if n > head.NumberU64() {
+			delete(c.canonical, n)
+		}
+	}
+	for b := head; b != nil && c.canonical[b.NumberU64()] != b.Hash(); b = c.blocks[b.ParentHash()] {
+		c.canonical[b.NumberU64()] = b.Hash()
+	}
+	c.head = head
+}
+
+type testEngineAPI struct {
+	c *testEngineChain
+}
+
+func (e *testEngineAPI) ForkchoiceUpdatedV1(
+	state api.ForkchoiceStateV1,
+	attrs *api.PayloadAttributes,
+) (*api.ForkChoiceResponse, error) {
+	c := e.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	head, ok := c.blocks[state.HeadBlockHash]
+	if !ok {
+		return &api.ForkChoiceResponse{
+			PayloadStatus: api.PayloadStatusV1{Status: api.SYNCING},
+		}, nil
+	}
+	valid := api.PayloadStatusV1{Status: api.VALID, LatestValidHash: &state.HeadBlockHash}
+	if head != c.head && c.canonical[head.NumberU64()] == head.Hash() {
+		// Canonical ancestors of the head are ignored, and no payload is
+		// built on top of them
+		return &api.ForkChoiceResponse{PayloadStatus: valid}, nil
+	}
+	c.setHead(head)
+	if attrs == nil {
+		return &api.ForkChoiceResponse{PayloadStatus: valid}, nil
+	}
+	block := types.NewBlockWithHeader(&types.Header{
+		ParentHash:  head.Hash(),
+		UncleHash:   types.EmptyUncleHash,
+		Coinbase:    attrs.SuggestedFeeRecipient,
+		Root:        head.Root(),
+		TxHash:      types.EmptyTxsHash,
+		ReceiptHash: types.EmptyReceiptsHash,
+		Difficulty:  common.Big0,
+		Number:      new(big.Int).Add(head.Number(), common.Big1),
+		GasLimit:    head.GasLimit(),
+		Time:        attrs.Timestamp,
+		BaseFee:     head.BaseFee(),
+		MixDigest:   attrs.Random,
+	})
+	var id api.PayloadID
+	c.nextID++
+	binary.BigEndian.PutUint64(id[:], c.nextID)
+	c.payloads[id] = block
+	return &api.ForkChoiceResponse{PayloadStatus: valid, PayloadID: &id}, nil
+}
+
+func (e *testEngineAPI) GetPayloadV1(id api.PayloadID) (*api.ExecutableData, error) {
+	e.c.mu.Lock()
+	defer e.c.mu.Unlock()
+	block, ok := e.c.payloads[id]
+	if !ok {
+		return nil, api.UnknownPayload
+	}
+	return api.BlockToExecutableData(block, nil, nil).ExecutionPayload, nil
+}
+
+func (e *testEngineAPI) NewPayloadV1(data api.ExecutableData) (*api.PayloadStatusV1, error) {
+	block, err := api.ExecutableDataToBlock(data, nil, nil)
+	if err != nil {
+		msg := err.Error()
+		return &api.PayloadStatusV1{Status: api.INVALID, ValidationError: &msg}, nil
+	}
+	e.c.mu.Lock()
+	defer e.c.mu.Unlock()
+	if _, ok := e.c.blocks[block.ParentHash()]; !ok {
+		return &api.PayloadStatusV1{Status: api.SYNCING}, nil
+	}
+	e.c.blocks[block.Hash()] = block
+	hash := block.Hash()
+	return &api.PayloadStatusV1{Status: api.VALID, LatestValidHash: &hash}, nil
+}
+
+type testEthAPI struct {
+	c *testEngineChain
+}
+
+func testBlockFields(block *types.Block) (map[string]interface{}, error) {
+	if block == nil {
+		return nil, nil
+	}
+	enc, err := json.Marshal(block.Header())
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(enc, &fields); err != nil {
+		return nil, err
+	}
+	fields["transactions"] = []interface{}{}
+	fields["uncles"] = []interface{}{}
+	return fields, nil
+}
+
+func (e *testEthAPI) GetBlockByNumber(
+	number rpc.BlockNumber,
+	full bool,
+) (map[string]interface{}, error) {
+	e.c.mu.Lock()
+	defer e.c.mu.Unlock()
+	if number < 0 {
+		return testBlockFields(e.c.head)
+	}
+	hash, ok := e.c.canonical[uint64(number)]
+	if !ok {
+		return nil, nil
+	}
+	return testBlockFields(e.c.blocks[hash])
+}
+
+func (e *testEthAPI) GetBlockByHash(
+	hash common.Hash,
+	full bool,
+) (map[string]interface{}, error) {
+	e.c.mu.Lock()
+	defer e.c.mu.Unlock()
+	return testBlockFields(e.c.blocks[hash])
+}
+
+// Returns an execution client served by a fake engine and eth API on top of
+// the genesis block
+func testEngineClient(
+	t *testing.T,
+	index int,
+	genesis *types.Block,
+) (*ExecutionClient, *testEngineChain) {
+	chain := &testEngineChain{
+		blocks:    map[common.Hash]*types.Block{genesis.Hash(): genesis},
+		canonical: map[uint64]common.Hash{0: genesis.Hash()},
+		head:      genesis,
+		payloads:  make(map[api.PayloadID]*types.Block),
+	}
+	ec := testRPCClient(t, index, map[string]interface{}{
+		"engine": &testEngineAPI{chain},
+		"eth":    &testEthAPI{chain},
+	})
+	return ec, chain
+}
+
+func TestCLSimulatorProduceFork(t *testing.T) {
+	ctx := context.Background()
+	genesis := types.NewBlockWithHeader(&types.Header{
+		UncleHash:   types.EmptyUncleHash,
+		Root:        common.Hash{0x01},
+		TxHash:      types.EmptyTxsHash,
+		ReceiptHash: types.EmptyReceiptsHash,
+		Difficulty:  common.Big0,
+		Number:      common.Big0,
+		GasLimit:    30_000_000,
+		BaseFee:     big.NewInt(1e9),
+	})
+	var (
+		ecs    = make(ExecutionClients, 2)
+		chains = make([]*testEngineChain, 2)
+	)
+	for i := range ecs {
+		ecs[i], chains[i] = testEngineClient(t, i, genesis)
+	}
+	sim := ecs.NewCLSimulator(CLSimulatorConfig{
+		PayloadBuildTime: time.Millisecond,
+	})
+	if err := sim.Init(ctx); err != nil {
+		t.Fatalf("unable to init simulator: %v", err)
+	}
+	// Checks that all clients follow the head and know all the blocks
+	checkClients := func(name string, head common.Hash, payloads ...*api.ExecutableData) {
+		if sim.Head().Hash() != head {
+			t.Fatalf("%s: incorrect simulator head: %s", name, sim.Head().Hash())
+		}
+		for i, c := range chains {
+			if c.Head() != head {
+				t.Fatalf("%s: client %d: incorrect head: want %s, got %s", name, i, head, c.Head())
+			}
+			for _, p := range payloads {
+				if !c.Known(p.BlockHash) {
+					t.Fatalf("%s: client %d: block %s not imported", name, i, p.BlockHash)
+				}
+			}
+		}
+	}
+
+	canonical := make([]*api.ExecutableData, 3)
+	for i := range canonical {
+		payload, err := sim.ProduceBlock(ctx)
+		if err != nil {
+			t.Fatalf("unable to produce block %d: %v", i+1, err)
+		}
+		canonical[i] = payload
+	}
+	checkClients("canonical chain", canonical[2].BlockHash, canonical...)
+
+	// Fork from a canonical ancestor of the head
+	fork, err := sim.ProduceFork(ctx, canonical[0].BlockHash, 3)
+	if err != nil {
+		t.Fatalf("unable to produce fork: %v", err)
+	}
+	if len(fork) != 3 {
+		t.Fatalf("incorrect fork length: %d", len(fork))
+	}
+	if fork[0].ParentHash != canonical[0].BlockHash || fork[0].BlockHash == canonical[1].BlockHash {
+		t.Fatalf("fork does not branch from the ancestor: %+v", fork[0])
+	}
+	for i := 1; i < len(fork); i++ {
+		if fork[i].ParentHash != fork[i-1].BlockHash {
+			t.Fatalf("fork block %d not built on the previous fork block", i)
+		}
+	}
+	checkClients("fork", canonical[2].BlockHash, fork...)
+
+	// Every fork from the same ancestor is different
+	other, err := sim.ProduceFork(ctx, canonical[0].BlockHash, 1)
+	if err != nil {
+		t.Fatalf("unable to produce second fork: %v", err)
+	}
+	if other[0].BlockHash == fork[0].BlockHash || other[0].ParentHash != canonical[0].BlockHash {
+		t.Fatalf("second fork not a new sibling: %+v", other[0])
+	}
+
+	// Fork from the head
+	headFork, err := sim.ProduceFork(ctx, canonical[2].BlockHash, 1)
+	if err != nil {
+		t.Fatalf("unable to produce fork from the head: %v", err)
+	}
+	if headFork[0].ParentHash != canonical[2].BlockHash {
+		t.Fatalf("fork from the head not built on the head: %+v", headFork[0])
+	}
+	checkClients("head fork", canonical[2].BlockHash, headFork...)
+
+	// Reorg to the fork and continue the chain from it
+	if err := sim.Reorg(ctx, fork[2].BlockHash); err != nil {
+		t.Fatalf("unable to reorg: %v", err)
+	}
+	checkClients("reorg", fork[2].BlockHash)
+	next, err := sim.ProduceBlock(ctx)
+	if err != nil {
+		t.Fatalf("unable to produce block after reorg: %v", err)
+	}
+	if next.ParentHash != fork[2].BlockHash {
+		t.Fatalf("block not built on the reorged head: %+v", next)
+	}
+	checkClients("after reorg", next.BlockHash, next)
+}
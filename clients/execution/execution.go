@@ -11,11 +11,14 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum"
 	api "github.com/ethereum/go-ethereum/beacon/engine"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethclient/gethclient"
+	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/marioevz/eth-clients/clients"
@@ -433,6 +436,51 @@ func (en *ExecutionClient) EngineNewPayload(
 	return &result, err
 }
 
+func (en *ExecutionClient) EngineNewPayloadV3(
+	parentCtx context.Context,
+	payload *api.ExecutableData,
+	versionedHashes []common.Hash,
+	beaconRoot *common.Hash,
+) (*api.PayloadStatusV1, error) {
+	var result api.PayloadStatusV1
+	if err := en.PrepareDefaultAuthCallToken(); err != nil {
+		return nil, err
+	}
+	if versionedHashes == nil {
+		// Must be serialized as an empty list
+		versionedHashes = make([]common.Hash, 0)
+	}
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*10)
+	defer cancel()
+	err := en.engineRpcClient.CallContext(
+		ctx,
+		&result,
+		"engine_newPayloadV3",
+		payload,
+		versionedHashes,
+		beaconRoot,
+	)
+	return &result, err
+}
+
+// Returns the engine API version that corresponds to the fork of the payload.
+// The payload is sent with the version of the latest fork whose fields it
+// contains: the parent beacon block root for cancun, and the withdrawals for
+// shanghai.
+func EngineAPIVersion(
+	payload *api.ExecutableData,
+	beaconRoot *common.Hash,
+) int {
+	var forks ExecutionForkTimes
+	if payload.Withdrawals != nil {
+		forks.ShanghaiTime = &payload.Timestamp
+	}
+	if beaconRoot != nil {
+		forks.CancunTime = &payload.Timestamp
+	}
+	return forks.EngineAPIVersion(payload.Timestamp)
+}
+
 // Eth RPC
 // Helper structs to fetch the TotalDifficulty
 type TD struct {
@@ -580,6 +628,200 @@ func (ec *ExecutionClient) BalanceAt(
 	return ec.eth.BalanceAt(ctx, account, n)
 }
 
+func (ec *ExecutionClient) PendingNonceAt(
+	parentCtx context.Context,
+	account common.Address,
+) (uint64, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	return ec.eth.PendingNonceAt(ctx, account)
+}
+
+func (ec *ExecutionClient) ChainID(
+	parentCtx context.Context,
+) (*big.Int, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	return ec.eth.ChainID(ctx)
+}
+
+func (ec *ExecutionClient) NonceAt(
+	parentCtx context.Context,
+	account common.Address,
+	n *big.Int,
+) (uint64, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	return ec.eth.NonceAt(ctx, account, n)
+}
+
+func (ec *ExecutionClient) CodeAt(
+	parentCtx context.Context,
+	account common.Address,
+	n *big.Int,
+) ([]byte, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	return ec.eth.CodeAt(ctx, account, n)
+}
+
+func (ec *ExecutionClient) StorageAt(
+	parentCtx context.Context,
+	account common.Address,
+	key common.Hash,
+	n *big.Int,
+) (common.Hash, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	value, err := ec.eth.StorageAt(ctx, account, key, n)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(value), nil
+}
+
+func (ec *ExecutionClient) GetProof(
+	parentCtx context.Context,
+	account common.Address,
+	keys []common.Hash,
+	n *big.Int,
+) (*gethclient.AccountResult, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	storageKeys := make([]string, len(keys))
+	for i, k := range keys {
+		storageKeys[i] = k.Hex()
+	}
+	return gethclient.New(ec.ethRpcClient).GetProof(ctx, account, storageKeys, n)
+}
+
+func (ec *ExecutionClient) TransactionReceipt(
+	parentCtx context.Context,
+	txHash common.Hash,
+) (*types.Receipt, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	return ec.eth.TransactionReceipt(ctx, txHash)
+}
+
+func (ec *ExecutionClient) FilterLogs(
+	parentCtx context.Context,
+	q ethereum.FilterQuery,
+) ([]types.Log, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	return ec.eth.FilterLogs(ctx, q)
+}
+
+func (ec *ExecutionClient) CallContract(
+	parentCtx context.Context,
+	msg ethereum.CallMsg,
+	n *big.Int,
+) ([]byte, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	return ec.eth.CallContract(ctx, msg, n)
+}
+
+func (ec *ExecutionClient) EstimateGas(
+	parentCtx context.Context,
+	msg ethereum.CallMsg,
+) (uint64, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	return ec.eth.EstimateGas(ctx, msg)
+}
+
+func (ec *ExecutionClient) FeeHistory(
+	parentCtx context.Context,
+	blockCount uint64,
+	lastBlock *big.Int,
+	rewardPercentiles []float64,
+) (*ethereum.FeeHistory, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	return ec.eth.FeeHistory(ctx, blockCount, lastBlock, rewardPercentiles)
+}
+
+func (ec *ExecutionClient) BlobBaseFee(
+	parentCtx context.Context,
+) (*big.Int, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	var fee hexutil.Big
+	if err := ec.ethRpcClient.CallContext(
+		ctx,
+		&fee,
+		"eth_blobBaseFee",
+	); err != nil {
+		return nil, err
+	}
+	return (*big.Int)(&fee), nil
+}
+
+func (ec *ExecutionClient) PeerCount(parentCtx context.Context) (uint64, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	return ec.eth.PeerCount(ctx)
+}
+
+func (ec *ExecutionClient) AdminPeers(
+	parentCtx context.Context,
+) ([]*p2p.PeerInfo, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	peers := make([]*p2p.PeerInfo, 0)
+	err := ec.ethRpcClient.CallContext(ctx, &peers, "admin_peers")
+	return peers, err
+}
+
+func (ec *ExecutionClient) AdminNodeInfo(
+	parentCtx context.Context,
+) (*p2p.NodeInfo, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	var info p2p.NodeInfo
+	if err := ec.ethRpcClient.CallContext(ctx, &info, "admin_nodeInfo"); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Returns the sync progress of the client, nil if the client is not syncing
+func (ec *ExecutionClient) SyncProgress(
+	parentCtx context.Context,
+) (*ethereum.SyncProgress, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	return ec.eth.SyncProgress(ctx)
+}
+
+// Requests the client to connect to the given enode, returns whether the
+// request was accepted
+func (ec *ExecutionClient) AddPeer(
+	parentCtx context.Context,
+	enode string,
+) (bool, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	var result bool
+	err := ec.ethRpcClient.CallContext(ctx, &result, "admin_addPeer", enode)
+	return result, err
+}
+
+// Requests the client to disconnect from the given enode, returns whether
+// the request was accepted
+func (ec *ExecutionClient) RemovePeer(
+	parentCtx context.Context,
+	enode string,
+) (bool, error) {
+	ctx, cancel := utils.ContextTimeoutRPC(parentCtx)
+	defer cancel()
+	var result bool
+	err := ec.ethRpcClient.CallContext(ctx, &result, "admin_removePeer", enode)
+	return result, err
+}
+
 type BinaryMarshable interface {
 	MarshalBinary() ([]byte, error)
 }
@@ -0,0 +1,54 @@
+/*
+Tests for the engine API helpers
+*/
+package execution
+
+import (
+	"testing"
+
+	api "github.com/ethereum/go-ethereum/beacon/engine"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestEngineAPIVersion(t *testing.T) {
+	var (
+		shanghai   = uint64(10)
+		cancun     = uint64(20)
+		forks      = ExecutionForkTimes{ShanghaiTime: &shanghai, CancunTime: &cancun}
+		beaconRoot = common.Hash{0x01}
+	)
+	for _, test := range []struct {
+		timestamp  uint64
+		payload    *api.ExecutableData
+		beaconRoot *common.Hash
+		version    int
+	}{
+		{
+			timestamp: 9,
+			payload:   &api.ExecutableData{Timestamp: 9},
+			version:   1,
+		},
+		{
+			timestamp: 10,
+			payload:   &api.ExecutableData{Timestamp: 10, Withdrawals: types.Withdrawals{}},
+			version:   2,
+		},
+		{
+			timestamp:  20,
+			payload:    &api.ExecutableData{Timestamp: 20, Withdrawals: types.Withdrawals{}},
+			beaconRoot: &beaconRoot,
+			version:    3,
+		},
+	} {
+		if v := forks.EngineAPIVersion(test.timestamp); v != test.version {
+			t.Fatalf("timestamp %d: incorrect version: want %d, got %d", test.timestamp, test.version, v)
+		}
+		if v := EngineAPIVersion(test.payload, test.beaconRoot); v != test.version {
+			t.Fatalf("payload %d: incorrect version: want %d, got %d", test.timestamp, test.version, v)
+		}
+	}
+	if v := (ExecutionForkTimes{}).EngineAPIVersion(100); v != 1 {
+		t.Fatalf("incorrect version without forks: %d", v)
+	}
+}
@@ -0,0 +1,514 @@
+package execution
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/misc/eip4844"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+type LoadTransactionType int
+
+const (
+	LoadLegacyTransaction LoadTransactionType = iota
+	LoadDynamicFeeTransaction
+	LoadAccessListTransaction
+	LoadBlobTransaction
+	LoadContractDeployment
+	LoadContractCall
+)
+
+func (t LoadTransactionType) String() string {
+	switch t {
+	case LoadLegacyTransaction:
+		return "legacy"
+	case LoadDynamicFeeTransaction:
+		return "dynamic-fee"
+	case LoadAccessListTransaction:
+		return "access-list"
+	case LoadBlobTransaction:
+		return "blob"
+	case LoadContractDeployment:
+		return "contract-deployment"
+	case LoadContractCall:
+		return "contract-call"
+	}
+	return fmt.Sprintf("unknown(%d)", int(t))
+}
+
+var (
+	// Runtime code of the contract deployed by the load generator, which
+	// stores the first word of the calldata in slot zero
+	loadContractCode = common.FromHex("0x60003560005500")
+	// Init code that returns the runtime code
+	loadContractInitCode = append(
+		common.FromHex("0x6007600c60003960076000f3"),
+		loadContractCode...,
+	)
+)
+
+const (
+	loadTransferGas   = 21000
+	loadAccessListGas = 21000 + 2400 + 1900
+	loadContractGas   = 100000
+)
+
+type LoadGeneratorConfig struct {
+	// Transactions per second sent across all clients
+	TPS float64
+	// Relative weight of each transaction type, defaults to only dynamic fee
+	// transactions
+	Mix map[LoadTransactionType]int
+	// Priority fee per gas, defaults to 1 gwei
+	GasTipCap *big.Int
+	// Number of blobs on each blob transaction, defaults to one
+	BlobsPerTransaction int
+}
+
+// Transaction sent by the load generator
+type LoadTransaction struct {
+	Hash   common.Hash
+	Type   LoadTransactionType
+	From   common.Address
+	Nonce  uint64
+	Client int
+	SentAt time.Time
+	// Block number and time at which the inclusion was observed, zero if the
+	// transaction is still pending
+	BlockNumber uint64
+	IncludedAt  time.Time
+}
+
+func (tx *LoadTransaction) Included() bool {
+	return !tx.IncludedAt.IsZero()
+}
+
+func (tx *LoadTransaction) InclusionLatency() time.Duration {
+	if !tx.Included() {
+		return 0
+	}
+	return tx.IncludedAt.Sub(tx.SentAt)
+}
+
+type LoadGeneratorStats struct {
+	Sent           int
+	Included       int
+	Pending        int
+	Failed         int
+	AverageLatency time.Duration
+	MaxLatency     time.Duration
+}
+
+// Sends a steady mix of transactions from a pool of funded accounts,
+// distributed across all running execution clients, and tracks how long each
+// transaction takes to be included.
+type LoadGenerator struct {
+	ExecutionClients ExecutionClients
+	Accounts         []*Account
+	Config           LoadGeneratorConfig
+
+	chainID      *big.Int
+	baseFee      *big.Int
+	blobFee      *big.Int
+	nextBlock    uint64
+	contracts    []common.Address
+	transactions []*LoadTransaction
+	pending      map[common.Hash]*LoadTransaction
+	deployments  map[common.Hash]common.Address
+	failed       int
+	accountLocks []sync.Mutex
+	mu           sync.Mutex
+}
+
+// Returns an error if the mix contains unknown transaction types or negative
+// weights, or if no transaction type can be selected
+func validateLoadMix(mix map[LoadTransactionType]int) error {
+	total := 0
+	for t, w := range mix {
+		if t < LoadLegacyTransaction || t > LoadContractCall {
+			return fmt.Errorf("unknown transaction type in mix: %s", t)
+		}
+		if w < 0 {
+			return fmt.Errorf("negative weight for %s transactions: %d", t, w)
+		}
+		total += w
+	}
+	if total <= 0 {
+		return fmt.Errorf("transaction mix weights must add up to more than zero")
+	}
+	return nil
+}
+
+func (all ExecutionClients) NewLoadGenerator(
+	accounts []*Account,
+	cfg LoadGeneratorConfig,
+) (*LoadGenerator, error) {
+	if len(cfg.Mix) == 0 {
+		cfg.Mix = map[LoadTransactionType]int{
+			LoadDynamicFeeTransaction: 1,
+		}
+	}
+	if err := validateLoadMix(cfg.Mix); err != nil {
+		return nil, err
+	}
+	if cfg.GasTipCap == nil {
+		cfg.GasTipCap = big.NewInt(1e9)
+	}
+	if cfg.BlobsPerTransaction == 0 {
+		cfg.BlobsPerTransaction = 1
+	}
+	return &LoadGenerator{
+		ExecutionClients: all,
+		Accounts:         accounts,
+		Config:           cfg,
+		transactions:     make([]*LoadTransaction, 0),
+		pending:          make(map[common.Hash]*LoadTransaction),
+		deployments:      make(map[common.Hash]common.Address),
+		accountLocks:     make([]sync.Mutex, len(accounts)),
+	}, nil
+}
+
+func (lg *LoadGenerator) randomType() LoadTransactionType {
+	total := 0
+	for _, w := range lg.Config.Mix {
+		total += w
+	}
+	r := rand.Intn(total)
+	// Iterate in type order so the selection only depends on the weights
+	for t := LoadLegacyTransaction; t <= LoadContractCall; t++ {
+		if r < lg.Config.Mix[t] {
+			return t
+		}
+		r -= lg.Config.Mix[t]
+	}
+	return LoadDynamicFeeTransaction
+}
+
+// Updates the fees and marks the transactions included in the header
+func (lg *LoadGenerator) processBlock(block *types.Block) {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	now := time.Now()
+	if baseFee := block.BaseFee(); baseFee != nil {
+		lg.baseFee = baseFee
+	}
+	if excessBlobGas := block.ExcessBlobGas(); excessBlobGas != nil {
+		lg.blobFee = eip4844.CalcBlobFee(*excessBlobGas)
+	}
+	for _, tx := range block.Transactions() {
+		sent, ok := lg.pending[tx.Hash()]
+		if !ok {
+			continue
+		}
+		sent.BlockNumber = block.NumberU64()
+		sent.IncludedAt = now
+		delete(lg.pending, tx.Hash())
+		if addr, ok := lg.deployments[tx.Hash()]; ok {
+			lg.contracts = append(lg.contracts, addr)
+			delete(lg.deployments, tx.Hash())
+		}
+	}
+	lg.nextBlock = block.NumberU64() + 1
+}
+
+// Processes all new blocks of the first running client
+func (lg *LoadGenerator) watchBlocks(ctx context.Context) error {
+	running := lg.ExecutionClients.Running()
+	if len(running) == 0 {
+		return fmt.Errorf("no running execution clients")
+	}
+	for {
+		lg.mu.Lock()
+		next := lg.nextBlock
+		lg.mu.Unlock()
+		block, err := running[0].BlockByNumber(
+			ctx,
+			new(big.Int).SetUint64(next),
+		)
+		if errors.Is(err, ethereum.NotFound) {
+			// Block not yet produced
+			return nil
+		} else if err != nil {
+			return err
+		}
+		lg.processBlock(block)
+	}
+}
+
+func (lg *LoadGenerator) buildTransaction(
+	t LoadTransactionType,
+	account *Account,
+	to common.Address,
+	nonce uint64,
+) (*types.Transaction, *common.Address, error) {
+	lg.mu.Lock()
+	var (
+		tip        = lg.Config.GasTipCap
+		feeCap     = new(big.Int).Add(new(big.Int).Mul(lg.baseFee, big.NewInt(2)), tip)
+		blobFeeCap = new(big.Int).Mul(lg.blobFee, big.NewInt(2))
+		contract   *common.Address
+	)
+	if len(lg.contracts) > 0 {
+		c := lg.contracts[rand.Intn(len(lg.contracts))]
+		contract = &c
+	}
+	lg.mu.Unlock()
+
+	var (
+		signer = types.NewCancunSigner(lg.chainID)
+		data   types.TxData
+	)
+	switch t {
+	case LoadLegacyTransaction:
+		data = &types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: feeCap,
+			Gas:      loadTransferGas,
+			To:       &to,
+			Value:    common.Big1,
+		}
+	case LoadDynamicFeeTransaction:
+		data = &types.DynamicFeeTx{
+			ChainID:   lg.chainID,
+			Nonce:     nonce,
+			GasTipCap: tip,
+			GasFeeCap: feeCap,
+			Gas:       loadTransferGas,
+			To:        &to,
+			Value:     common.Big1,
+		}
+	case LoadAccessListTransaction:
+		data = &types.AccessListTx{
+			ChainID:  lg.chainID,
+			Nonce:    nonce,
+			GasPrice: feeCap,
+			Gas:      loadAccessListGas,
+			To:       &to,
+			Value:    common.Big1,
+			AccessList: types.AccessList{
+				{
+					Address:     to,
+					StorageKeys: []common.Hash{{}},
+				},
+			},
+		}
+	case LoadBlobTransaction:
+		btx, err := BuildBlobTransaction(&BlobTransactionConfig{
+			ChainID:    lg.chainID,
+			Nonce:      &nonce,
+			To:         to,
+			GasTipCap:  tip,
+			GasFeeCap:  feeCap,
+			BlobFeeCap: blobFeeCap,
+			BlobCount:  lg.Config.BlobsPerTransaction,
+		}, account.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return btx.Transaction, nil, nil
+	case LoadContractCall:
+		if contract != nil {
+			calldata := common.BigToHash(big.NewInt(rand.Int63())).Bytes()
+			data = &types.DynamicFeeTx{
+				ChainID:   lg.chainID,
+				Nonce:     nonce,
+				GasTipCap: tip,
+				GasFeeCap: feeCap,
+				Gas:       loadContractGas,
+				To:        contract,
+				Data:      calldata,
+			}
+			break
+		}
+		// No contract deployed yet
+		fallthrough
+	case LoadContractDeployment:
+		data = &types.DynamicFeeTx{
+			ChainID:   lg.chainID,
+			Nonce:     nonce,
+			GasTipCap: tip,
+			GasFeeCap: feeCap,
+			Gas:       loadContractGas,
+			Data:      loadContractInitCode,
+		}
+		addr := crypto.CreateAddress(account.Address, nonce)
+		tx, err := types.SignNewTx(account.Key, signer, data)
+		return tx, &addr, err
+	default:
+		return nil, nil, fmt.Errorf("unknown transaction type: %s", t)
+	}
+	tx, err := types.SignNewTx(account.Key, signer, data)
+	return tx, nil, err
+}
+
+func (lg *LoadGenerator) send(
+	ctx context.Context,
+	accountIndex int,
+	ec *ExecutionClient,
+	t LoadTransactionType,
+) {
+	lg.accountLocks[accountIndex].Lock()
+	defer lg.accountLocks[accountIndex].Unlock()
+
+	var (
+		account = lg.Accounts[accountIndex]
+		to      = lg.Accounts[(accountIndex+1)%len(lg.Accounts)].Address
+		nonce   = account.Nonce()
+	)
+	tx, deployment, err := lg.buildTransaction(t, account, to, nonce)
+	if err == nil {
+		err = ec.SendTransaction(ctx, tx)
+	}
+	if err != nil {
+		ec.Logf(
+			"LoadGenerator: execution client %d: unable to send %s transaction: %v",
+			ec.Config.ClientIndex,
+			t,
+			err,
+		)
+		// The nonce might have been consumed by a previous transaction that
+		// was not tracked
+		if err := account.SyncNonce(ctx, ec); err != nil {
+			ec.Logf("LoadGenerator: unable to sync nonce: %v", err)
+		}
+		lg.mu.Lock()
+		lg.failed++
+		lg.mu.Unlock()
+		return
+	}
+	account.SetNonce(nonce + 1)
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	sent := &LoadTransaction{
+		Hash:   tx.Hash(),
+		Type:   t,
+		From:   account.Address,
+		Nonce:  nonce,
+		Client: ec.Config.ClientIndex,
+		SentAt: time.Now(),
+	}
+	lg.transactions = append(lg.transactions, sent)
+	lg.pending[sent.Hash] = sent
+	if deployment != nil {
+		lg.deployments[sent.Hash] = *deployment
+	}
+}
+
+// Fetches the chain id, fees, and nonces of all accounts
+func (lg *LoadGenerator) init(ctx context.Context) error {
+	running := lg.ExecutionClients.Running()
+	if len(running) == 0 {
+		return fmt.Errorf("no running execution clients")
+	}
+	if len(lg.Accounts) == 0 {
+		return fmt.Errorf("no accounts")
+	}
+	chainID, err := running[0].ChainID(ctx)
+	if err != nil {
+		return err
+	}
+	head, err := running[0].BlockByNumber(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if head.BaseFee() == nil {
+		return fmt.Errorf("head block has no base fee")
+	}
+	lg.chainID = chainID
+	lg.blobFee = big.NewInt(1)
+	lg.processBlock(head)
+	for _, account := range lg.Accounts {
+		if err := account.SyncNonce(ctx, running[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sends transactions at the configured rate until the context is done.
+// Transactions are sent round robin from each account through each running
+// client.
+func (lg *LoadGenerator) Run(ctx context.Context) error {
+	if lg.Config.TPS <= 0 {
+		return fmt.Errorf("invalid tps: %f", lg.Config.TPS)
+	}
+	if err := lg.init(ctx); err != nil {
+		return err
+	}
+	var (
+		wg          sync.WaitGroup
+		sendTicker  = time.NewTicker(time.Duration(float64(time.Second) / lg.Config.TPS))
+		blockTicker = time.NewTicker(time.Second)
+		count       = 0
+	)
+	defer sendTicker.Stop()
+	defer blockTicker.Stop()
+	defer wg.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-blockTicker.C:
+			if err := lg.watchBlocks(ctx); err != nil {
+				lg.ExecutionClients[0].Logf(
+					"LoadGenerator: unable to fetch blocks: %v",
+					err,
+				)
+			}
+		case <-sendTicker.C:
+			running := lg.ExecutionClients.Running()
+			if len(running) == 0 {
+				return fmt.Errorf("no running execution clients")
+			}
+			wg.Add(1)
+			go func(accountIndex int, ec *ExecutionClient) {
+				defer wg.Done()
+				lg.send(ctx, accountIndex, ec, lg.randomType())
+			}(count%len(lg.Accounts), running[count%len(running)])
+			count++
+		}
+	}
+}
+
+// Returns all the transactions sent so far
+func (lg *LoadGenerator) Transactions() []*LoadTransaction {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	return append([]*LoadTransaction{}, lg.transactions...)
+}
+
+func (lg *LoadGenerator) Stats() LoadGeneratorStats {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	stats := LoadGeneratorStats{
+		Sent:    len(lg.transactions),
+		Pending: len(lg.pending),
+		Failed:  lg.failed,
+	}
+	var total time.Duration
+	for _, tx := range lg.transactions {
+		if !tx.Included() {
+			continue
+		}
+		latency := tx.InclusionLatency()
+		stats.Included++
+		total += latency
+		if latency > stats.MaxLatency {
+			stats.MaxLatency = latency
+		}
+	}
+	if stats.Included > 0 {
+		stats.AverageLatency = total / time.Duration(stats.Included)
+	}
+	return stats
+}
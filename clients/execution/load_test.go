@@ -0,0 +1,69 @@
+/*
+Tests for the load generator configuration
+*/
+package execution
+
+import (
+	"testing"
+)
+
+func TestNewLoadGeneratorMix(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		mix     map[LoadTransactionType]int
+		wantErr bool
+	}{
+		{
+			name: "default mix",
+		},
+		{
+			name: "weighted mix",
+			mix: map[LoadTransactionType]int{
+				LoadLegacyTransaction: 0,
+				LoadBlobTransaction:   2,
+			},
+		},
+		{
+			name: "all weights zero",
+			mix: map[LoadTransactionType]int{
+				LoadLegacyTransaction:     0,
+				LoadDynamicFeeTransaction: 0,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative weight",
+			mix: map[LoadTransactionType]int{
+				LoadLegacyTransaction:     -1,
+				LoadDynamicFeeTransaction: 2,
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown type",
+			mix: map[LoadTransactionType]int{
+				LoadContractCall + 1: 1,
+			},
+			wantErr: true,
+		},
+	} {
+		lg, err := ExecutionClients{}.NewLoadGenerator(
+			nil,
+			LoadGeneratorConfig{Mix: test.mix},
+		)
+		if test.wantErr {
+			if err == nil {
+				t.Fatalf("%s: expected error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		for i := 0; i < 100; i++ {
+			if typ := lg.randomType(); lg.Config.Mix[typ] <= 0 {
+				t.Fatalf("%s: unweighted type selected: %s", test.name, typ)
+			}
+		}
+	}
+}
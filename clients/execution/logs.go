@@ -0,0 +1,71 @@
+package execution
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Builder of the query used in eth_getLogs
+type LogFilter struct {
+	query ethereum.FilterQuery
+}
+
+func NewLogFilter() *LogFilter {
+	return &LogFilter{}
+}
+
+// Restricts the query to the given block range, inclusive
+func (f *LogFilter) Range(from, to uint64) *LogFilter {
+	f.query.BlockHash = nil
+	f.query.FromBlock = new(big.Int).SetUint64(from)
+	f.query.ToBlock = new(big.Int).SetUint64(to)
+	return f
+}
+
+func (f *LogFilter) FromBlock(from uint64) *LogFilter {
+	f.query.BlockHash = nil
+	f.query.FromBlock = new(big.Int).SetUint64(from)
+	return f
+}
+
+func (f *LogFilter) ToBlock(to uint64) *LogFilter {
+	f.query.BlockHash = nil
+	f.query.ToBlock = new(big.Int).SetUint64(to)
+	return f
+}
+
+// Restricts the query to a single block, which replaces any block range
+func (f *LogFilter) BlockHash(h common.Hash) *LogFilter {
+	f.query.BlockHash = &h
+	f.query.FromBlock = nil
+	f.query.ToBlock = nil
+	return f
+}
+
+// Adds the addresses to the list of contracts that emit the logs
+func (f *LogFilter) Addresses(addresses ...common.Address) *LogFilter {
+	f.query.Addresses = append(f.query.Addresses, addresses...)
+	return f
+}
+
+// Matches any of the given topics at the position of the log topic list.
+// Positions not set match any topic.
+func (f *LogFilter) Topic(position int, topics ...common.Hash) *LogFilter {
+	for len(f.query.Topics) <= position {
+		f.query.Topics = append(f.query.Topics, []common.Hash{})
+	}
+	f.query.Topics[position] = append(f.query.Topics[position], topics...)
+	return f
+}
+
+// Matches the event signature, which is the first topic of the log
+func (f *LogFilter) Event(signature string) *LogFilter {
+	return f.Topic(0, crypto.Keccak256Hash([]byte(signature)))
+}
+
+func (f *LogFilter) Query() ethereum.FilterQuery {
+	return f.query
+}
@@ -0,0 +1,44 @@
+/*
+Tests for the log filter builder
+*/
+package execution
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestLogFilter(t *testing.T) {
+	var (
+		addr     = common.HexToAddress("0x01")
+		transfer = "Transfer(address,address,uint256)"
+		to       = common.HexToHash("0x02")
+	)
+	q := NewLogFilter().
+		Range(1, 10).
+		Addresses(addr).
+		Event(transfer).
+		Topic(2, to).
+		Query()
+	if q.FromBlock.Uint64() != 1 || q.ToBlock.Uint64() != 10 || q.BlockHash != nil {
+		t.Fatalf("incorrect block range: %v-%v", q.FromBlock, q.ToBlock)
+	}
+	if len(q.Addresses) != 1 || q.Addresses[0] != addr {
+		t.Fatalf("incorrect addresses: %v", q.Addresses)
+	}
+	if len(q.Topics) != 3 ||
+		len(q.Topics[0]) != 1 ||
+		q.Topics[0][0] != crypto.Keccak256Hash([]byte(transfer)) ||
+		len(q.Topics[1]) != 0 ||
+		len(q.Topics[2]) != 1 ||
+		q.Topics[2][0] != to {
+		t.Fatalf("incorrect topics: %v", q.Topics)
+	}
+
+	q = NewLogFilter().Range(1, 10).BlockHash(to).Query()
+	if q.FromBlock != nil || q.ToBlock != nil || q.BlockHash == nil {
+		t.Fatalf("block hash must replace the block range")
+	}
+}
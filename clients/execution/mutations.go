@@ -0,0 +1,547 @@
+package execution
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+
+	api "github.com/ethereum/go-ethereum/beacon/engine"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/rauljordan/engine-proxy/proxy"
+)
+
+// Status returned by V1 of engine_newPayload on a block hash mismatch. Later
+// versions return INVALID instead.
+const InvalidBlockHash = "INVALID_BLOCK_HASH"
+
+// Parameters of an engine_newPayload call
+type NewPayloadParams struct {
+	Payload *api.ExecutableData
+	// Only sent on V3 and later
+	VersionedHashes []common.Hash
+	BeaconRoot      *common.Hash
+}
+
+// Returns a deep copy of the parameters, so they can be mutated without
+// affecting the original payload
+func (p *NewPayloadParams) Copy() (*NewPayloadParams, error) {
+	// ExecutableData contains pointers and slices in most of its fields, so it
+	// is copied through its JSON representation
+	payloadJson, err := json.Marshal(p.Payload)
+	if err != nil {
+		return nil, err
+	}
+	cpy := &NewPayloadParams{
+		Payload: new(api.ExecutableData),
+	}
+	if err := json.Unmarshal(payloadJson, cpy.Payload); err != nil {
+		return nil, err
+	}
+	if p.VersionedHashes != nil {
+		cpy.VersionedHashes = make([]common.Hash, len(p.VersionedHashes))
+		copy(cpy.VersionedHashes, p.VersionedHashes)
+	}
+	if p.BeaconRoot != nil {
+		beaconRoot := *p.BeaconRoot
+		cpy.BeaconRoot = &beaconRoot
+	}
+	return cpy, nil
+}
+
+// Computes the hash of the block built from the payload, in the same way the
+// execution client does it, without checking the versioned hashes
+func (p *NewPayloadParams) ComputeBlockHash() (common.Hash, error) {
+	payload := p.Payload
+	txs := make(types.Transactions, len(payload.Transactions))
+	for i, txBytes := range payload.Transactions {
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(txBytes); err != nil {
+			return common.Hash{}, fmt.Errorf("unable to decode transaction %d: %v", i, err)
+		}
+		txs[i] = tx
+	}
+	var withdrawalsRoot *common.Hash
+	if payload.Withdrawals != nil {
+		h := types.DeriveSha(types.Withdrawals(payload.Withdrawals), trie.NewStackTrie(nil))
+		withdrawalsRoot = &h
+	}
+	header := &types.Header{
+		ParentHash:       payload.ParentHash,
+		UncleHash:        types.EmptyUncleHash,
+		Coinbase:         payload.FeeRecipient,
+		Root:             payload.StateRoot,
+		TxHash:           types.DeriveSha(txs, trie.NewStackTrie(nil)),
+		ReceiptHash:      payload.ReceiptsRoot,
+		Bloom:            types.BytesToBloom(payload.LogsBloom),
+		Difficulty:       common.Big0,
+		Number:           new(big.Int).SetUint64(payload.Number),
+		GasLimit:         payload.GasLimit,
+		GasUsed:          payload.GasUsed,
+		Time:             payload.Timestamp,
+		BaseFee:          payload.BaseFeePerGas,
+		Extra:            payload.ExtraData,
+		MixDigest:        payload.Random,
+		WithdrawalsHash:  withdrawalsRoot,
+		ExcessBlobGas:    payload.ExcessBlobGas,
+		BlobGasUsed:      payload.BlobGasUsed,
+		ParentBeaconRoot: p.BeaconRoot,
+	}
+	return header.Hash(), nil
+}
+
+// Replaces the block hash of the payload with the hash of its contents
+func (p *NewPayloadParams) Rehash() error {
+	h, err := p.ComputeBlockHash()
+	if err != nil {
+		return err
+	}
+	p.Payload.BlockHash = h
+	return nil
+}
+
+// Sends the payload to the execution client using the engine API version
+// that corresponds to the parameters
+func (p *NewPayloadParams) Send(
+	ctx context.Context,
+	ec *ExecutionClient,
+) (*api.PayloadStatusV1, error) {
+	version := EngineAPIVersion(p.Payload, p.BeaconRoot)
+	if version >= 3 {
+		return ec.EngineNewPayloadV3(ctx, p.Payload, p.VersionedHashes, p.BeaconRoot)
+	}
+	return ec.EngineNewPayload(ctx, p.Payload, version)
+}
+
+// Modification that turns a valid payload into an invalid one, together with
+// the response expected from the execution client when it has the parent of
+// the payload
+type PayloadMutation struct {
+	Name   string
+	Mutate func(p *NewPayloadParams) error
+	// Recompute the block hash after mutating the payload, so the execution
+	// client has to execute the block to find out it is invalid
+	Rehash bool
+	// The mutation modifies the versioned hashes or the parent beacon block
+	// root, which the proxy cannot spoof in the requests of the consensus
+	// client
+	ModifiesParams bool
+
+	// Expected status of the engine_newPayload response
+	Status string
+	// Whether the latest valid hash is expected to be the parent of the
+	// payload, otherwise it is expected to be nil
+	LatestValidHashIsParent bool
+}
+
+// Returns a mutated copy of the parameters, the original parameters are left
+// untouched
+func (m *PayloadMutation) Apply(p *NewPayloadParams) (*NewPayloadParams, error) {
+	mutated, err := p.Copy()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Mutate(mutated); err != nil {
+		return nil, fmt.Errorf("%s: %v", m.Name, err)
+	}
+	if m.Rehash {
+		if err := mutated.Rehash(); err != nil {
+			return nil, fmt.Errorf("%s: %v", m.Name, err)
+		}
+	}
+	return mutated, nil
+}
+
+// Returns the response expected for the mutated payload
+func (m *PayloadMutation) ExpectedStatus(
+	mutated *NewPayloadParams,
+) api.PayloadStatusV1 {
+	status := api.PayloadStatusV1{Status: m.Status}
+	if m.LatestValidHashIsParent {
+		parentHash := mutated.Payload.ParentHash
+		status.LatestValidHash = &parentHash
+	}
+	return status
+}
+
+// Checks the response of the execution client to the mutated payload
+func (m *PayloadMutation) CheckStatus(
+	mutated *NewPayloadParams,
+	status *api.PayloadStatusV1,
+	err error,
+) error {
+	if err != nil {
+		return fmt.Errorf("%s: unexpected error: %v", m.Name, err)
+	}
+	if status == nil {
+		return fmt.Errorf("%s: no payload status", m.Name)
+	}
+	expected := m.ExpectedStatus(mutated)
+	if status.Status != expected.Status &&
+		!(status.Status == InvalidBlockHash && expected.Status == api.INVALID &&
+			!m.Rehash && EngineAPIVersion(mutated.Payload, mutated.BeaconRoot) == 1) {
+		return fmt.Errorf(
+			"%s: incorrect status: want %s, got %s",
+			m.Name,
+			expected.Status,
+			status.Status,
+		)
+	}
+	if (expected.LatestValidHash == nil) != (status.LatestValidHash == nil) ||
+		(expected.LatestValidHash != nil &&
+			*expected.LatestValidHash != *status.LatestValidHash) {
+		return fmt.Errorf(
+			"%s: incorrect latest valid hash: want %v, got %v",
+			m.Name,
+			expected.LatestValidHash,
+			status.LatestValidHash,
+		)
+	}
+	return nil
+}
+
+// Applies the mutation, sends the payload to the execution client and checks
+// the response
+func (m *PayloadMutation) Test(
+	ctx context.Context,
+	ec *ExecutionClient,
+	p *NewPayloadParams,
+) error {
+	mutated, err := m.Apply(p)
+	if err != nil {
+		return err
+	}
+	status, err := mutated.Send(ctx, ec)
+	return m.CheckStatus(mutated, status, err)
+}
+
+// Returns a spoof that replaces the response of the execution client to the
+// given engine_newPayload method with the expected status of the mutated
+// payload, to test the consensus client behavior on invalid payloads
+func (m *PayloadMutation) ResponseSpoof(
+	method string,
+	mutated *NewPayloadParams,
+) *proxy.Spoof {
+	expected := m.ExpectedStatus(mutated)
+	return &proxy.Spoof{
+		Method: method,
+		Fields: map[string]interface{}{
+			"status":          expected.Status,
+			"latestValidHash": expected.LatestValidHash,
+		},
+	}
+}
+
+func payloadJsonFields(payload *api.ExecutableData) (map[string]interface{}, error) {
+	payloadJson, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(payloadJson, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// Returns the spoof that modifies a engine_newPayload request carrying the
+// original payload into one carrying the mutated payload.
+// The proxy can only modify the payload, so the mutation must not change the
+// versioned hashes or the parent beacon block root.
+func RequestSpoof(
+	method string,
+	original *NewPayloadParams,
+	mutated *NewPayloadParams,
+) (*proxy.Spoof, error) {
+	if !reflect.DeepEqual(original.VersionedHashes, mutated.VersionedHashes) {
+		return nil, fmt.Errorf("versioned hashes cannot be spoofed")
+	}
+	if (original.BeaconRoot == nil) != (mutated.BeaconRoot == nil) ||
+		(original.BeaconRoot != nil && *original.BeaconRoot != *mutated.BeaconRoot) {
+		return nil, fmt.Errorf("parent beacon block root cannot be spoofed")
+	}
+	originalFields, err := payloadJsonFields(original.Payload)
+	if err != nil {
+		return nil, err
+	}
+	mutatedFields, err := payloadJsonFields(mutated.Payload)
+	if err != nil {
+		return nil, err
+	}
+	spoof := &proxy.Spoof{
+		Method: method,
+		Fields: make(map[string]interface{}),
+	}
+	for k, v := range mutatedFields {
+		if !reflect.DeepEqual(originalFields[k], v) {
+			spoof.Fields[k] = v
+		}
+	}
+	return spoof, nil
+}
+
+// Returns a request callback for the proxy that applies the mutation to every
+// payload sent by the consensus client that matches the filter, or to every
+// payload if the filter is nil.
+// Returns an error if the mutation cannot be spoofed by the proxy. Requests
+// that cannot be mutated are forwarded unmodified and the failure is logged.
+func (m *PayloadMutation) RequestCallback(
+	filter func(*api.ExecutableData) bool,
+) (func([]byte) *proxy.Spoof, error) {
+	if m.ModifiesParams {
+		return nil, fmt.Errorf(
+			"%s: versioned hashes and parent beacon block root cannot be spoofed",
+			m.Name,
+		)
+	}
+	return func(req []byte) *proxy.Spoof {
+		var rpcMessage jsonrpcMessage
+		if err := json.Unmarshal(req, &rpcMessage); err != nil {
+			log.Warn("Unable to unmarshal request", "mutation", m.Name, "err", err)
+			return nil
+		}
+		original := &NewPayloadParams{Payload: new(api.ExecutableData)}
+		if err := UnmarshalFromJsonRPCRequest(
+			req,
+			original.Payload,
+			&original.VersionedHashes,
+			&original.BeaconRoot,
+		); err != nil {
+			log.Warn(
+				"Unable to unmarshal payload",
+				"mutation", m.Name,
+				"method", rpcMessage.Method,
+				"err", err,
+			)
+			return nil
+		}
+		if filter != nil && !filter(original.Payload) {
+			return nil
+		}
+		mutated, err := m.Apply(original)
+		if err != nil {
+			log.Warn(
+				"Unable to mutate payload",
+				"method", rpcMessage.Method,
+				"hash", original.Payload.BlockHash,
+				"err", err,
+			)
+			return nil
+		}
+		spoof, err := RequestSpoof(rpcMessage.Method, original, mutated)
+		if err != nil {
+			log.Warn(
+				"Unable to spoof payload",
+				"mutation", m.Name,
+				"method", rpcMessage.Method,
+				"hash", original.Payload.BlockHash,
+				"err", err,
+			)
+			return nil
+		}
+		log.Info(
+			"Spoofing payload",
+			"mutation", m.Name,
+			"method", rpcMessage.Method,
+			"hash", original.Payload.BlockHash,
+			"mutated", mutated.Payload.BlockHash,
+		)
+		return spoof
+	}, nil
+}
+
+func randomHash() common.Hash {
+	var h common.Hash
+	if _, err := rand.Read(h[:]); err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// Catalog of payload mutations
+
+var PayloadMutationStateRoot = &PayloadMutation{
+	Name: "wrong state root",
+	Mutate: func(p *NewPayloadParams) error {
+		p.Payload.StateRoot = randomHash()
+		return nil
+	},
+	Rehash:                  true,
+	Status:                  api.INVALID,
+	LatestValidHashIsParent: true,
+}
+
+var PayloadMutationBlockHash = &PayloadMutation{
+	Name: "bad block hash",
+	Mutate: func(p *NewPayloadParams) error {
+		p.Payload.BlockHash = randomHash()
+		return nil
+	},
+	Status: api.INVALID,
+}
+
+// Appends a transaction sent from an account without funds
+var PayloadMutationInvalidTransaction = &PayloadMutation{
+	Name: "invalid transaction",
+	Mutate: func(p *NewPayloadParams) error {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			return err
+		}
+		to := common.Address{}
+		tx, err := types.SignNewTx(key, types.HomesteadSigner{}, &types.LegacyTx{
+			Nonce:    0,
+			To:       &to,
+			Value:    big.NewInt(1),
+			Gas:      21000,
+			GasPrice: new(big.Int).Add(p.Payload.BaseFeePerGas, big.NewInt(1)),
+		})
+		if err != nil {
+			return err
+		}
+		txBytes, err := tx.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		p.Payload.Transactions = append(p.Payload.Transactions, txBytes)
+		return nil
+	},
+	Rehash:                  true,
+	Status:                  api.INVALID,
+	LatestValidHashIsParent: true,
+}
+
+var PayloadMutationExtraWithdrawal = &PayloadMutation{
+	Name: "extra withdrawal",
+	Mutate: func(p *NewPayloadParams) error {
+		if p.Payload.Withdrawals == nil {
+			return fmt.Errorf("payload does not support withdrawals")
+		}
+		var (
+			index     uint64
+			validator uint64
+		)
+		if l := len(p.Payload.Withdrawals); l > 0 {
+			index = p.Payload.Withdrawals[l-1].Index + 1
+			validator = p.Payload.Withdrawals[l-1].Validator + 1
+		}
+		p.Payload.Withdrawals = append(p.Payload.Withdrawals, &types.Withdrawal{
+			Index:     index,
+			Validator: validator,
+			Address:   common.Address{0x01},
+			Amount:    1,
+		})
+		return nil
+	},
+	Rehash:                  true,
+	Status:                  api.INVALID,
+	LatestValidHashIsParent: true,
+}
+
+var PayloadMutationMissingWithdrawal = &PayloadMutation{
+	Name: "missing withdrawal",
+	Mutate: func(p *NewPayloadParams) error {
+		if len(p.Payload.Withdrawals) == 0 {
+			return fmt.Errorf("payload contains no withdrawals")
+		}
+		p.Payload.Withdrawals = p.Payload.Withdrawals[:len(p.Payload.Withdrawals)-1]
+		return nil
+	},
+	Rehash:                  true,
+	Status:                  api.INVALID,
+	LatestValidHashIsParent: true,
+}
+
+// Sets the timestamp of the payload to the timestamp of its parent
+func PayloadMutationTimestamp(parentTimestamp uint64) *PayloadMutation {
+	return &PayloadMutation{
+		Name: "wrong timestamp",
+		Mutate: func(p *NewPayloadParams) error {
+			p.Payload.Timestamp = parentTimestamp
+			return nil
+		},
+		Rehash:                  true,
+		Status:                  api.INVALID,
+		LatestValidHashIsParent: true,
+	}
+}
+
+var PayloadMutationGasOverflow = &PayloadMutation{
+	Name: "gas used over gas limit",
+	Mutate: func(p *NewPayloadParams) error {
+		p.Payload.GasUsed = p.Payload.GasLimit + 1
+		return nil
+	},
+	Rehash:                  true,
+	Status:                  api.INVALID,
+	LatestValidHashIsParent: true,
+}
+
+// Modifies the versioned hashes sent alongside the payload, which are not part
+// of the block, hence the block hash is kept
+var PayloadMutationVersionedHashes = &PayloadMutation{
+	Name: "bad blob versioned hashes",
+	Mutate: func(p *NewPayloadParams) error {
+		if p.BeaconRoot == nil {
+			return fmt.Errorf("payload does not support blobs")
+		}
+		if len(p.VersionedHashes) == 0 {
+			p.VersionedHashes = append(p.VersionedHashes, randomHash())
+		} else {
+			p.VersionedHashes[len(p.VersionedHashes)-1] = randomHash()
+		}
+		return nil
+	},
+	ModifiesParams: true,
+	Status:         api.INVALID,
+}
+
+var PayloadMutationBeaconRoot = &PayloadMutation{
+	Name: "wrong parent beacon root",
+	Mutate: func(p *NewPayloadParams) error {
+		if p.BeaconRoot == nil {
+			return fmt.Errorf("payload does not support parent beacon root")
+		}
+		beaconRoot := randomHash()
+		p.BeaconRoot = &beaconRoot
+		return nil
+	},
+	Rehash:                  true,
+	ModifiesParams:          true,
+	Status:                  api.INVALID,
+	LatestValidHashIsParent: true,
+}
+
+// Returns the mutations from the catalog applicable to the payload
+func PayloadMutations(
+	p *NewPayloadParams,
+	parentTimestamp uint64,
+) []*PayloadMutation {
+	mutations := []*PayloadMutation{
+		PayloadMutationStateRoot,
+		PayloadMutationBlockHash,
+		PayloadMutationInvalidTransaction,
+		PayloadMutationTimestamp(parentTimestamp),
+		PayloadMutationGasOverflow,
+	}
+	if p.Payload.Withdrawals != nil {
+		mutations = append(mutations, PayloadMutationExtraWithdrawal)
+		if len(p.Payload.Withdrawals) > 0 {
+			mutations = append(mutations, PayloadMutationMissingWithdrawal)
+		}
+	}
+	if p.BeaconRoot != nil {
+		mutations = append(
+			mutations,
+			PayloadMutationVersionedHashes,
+			PayloadMutationBeaconRoot,
+		)
+	}
+	return mutations
+}
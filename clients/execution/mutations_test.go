@@ -0,0 +1,215 @@
+/*
+Tests for the payload mutations
+*/
+package execution
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	api "github.com/ethereum/go-ethereum/beacon/engine"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+func testNewPayloadParams(t *testing.T) *NewPayloadParams {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	to := common.Address{0x02}
+	tx, err := types.SignNewTx(
+		key,
+		types.NewCancunSigner(big.NewInt(1)),
+		&types.DynamicFeeTx{
+			ChainID:   big.NewInt(1),
+			To:        &to,
+			Gas:       21000,
+			GasTipCap: big.NewInt(1),
+			GasFeeCap: big.NewInt(10),
+		},
+	)
+	if err != nil {
+		t.Fatalf("unable to sign transaction: %v", err)
+	}
+	var (
+		blobGasUsed   uint64
+		excessBlobGas uint64
+		beaconRoot    = common.Hash{0x03}
+	)
+	withdrawals := types.Withdrawals{
+		{Index: 1, Validator: 2, Address: common.Address{0x04}, Amount: 5},
+	}
+	withdrawalsHash := types.DeriveSha(withdrawals, trie.NewStackTrie(nil))
+	header := &types.Header{
+		ParentHash:       common.Hash{0x01},
+		UncleHash:        types.EmptyUncleHash,
+		TxHash:           types.DeriveSha(types.Transactions{tx}, trie.NewStackTrie(nil)),
+		Number:           big.NewInt(10),
+		GasLimit:         30_000_000,
+		GasUsed:          21000,
+		Time:             120,
+		BaseFee:          big.NewInt(7),
+		Difficulty:       common.Big0,
+		BlobGasUsed:      &blobGasUsed,
+		ExcessBlobGas:    &excessBlobGas,
+		ParentBeaconRoot: &beaconRoot,
+		WithdrawalsHash:  &withdrawalsHash,
+	}
+	block := types.NewBlockWithHeader(header).
+		WithBody(types.Transactions{tx}, nil).
+		WithWithdrawals(withdrawals)
+	envelope := api.BlockToExecutableData(block, common.Big0, nil)
+	return &NewPayloadParams{
+		Payload:         envelope.ExecutionPayload,
+		VersionedHashes: []common.Hash{},
+		BeaconRoot:      &beaconRoot,
+	}
+}
+
+func TestComputeBlockHash(t *testing.T) {
+	p := testNewPayloadParams(t)
+	h, err := p.ComputeBlockHash()
+	if err != nil {
+		t.Fatalf("unable to compute block hash: %v", err)
+	}
+	if h != p.Payload.BlockHash {
+		t.Fatalf("incorrect block hash: want %s, got %s", p.Payload.BlockHash, h)
+	}
+	if _, err := api.ExecutableDataToBlock(
+		*p.Payload,
+		p.VersionedHashes,
+		p.BeaconRoot,
+	); err != nil {
+		t.Fatalf("unable to convert payload to block: %v", err)
+	}
+}
+
+func TestPayloadMutations(t *testing.T) {
+	p := testNewPayloadParams(t)
+	originalHash := p.Payload.BlockHash
+	for _, m := range PayloadMutations(p, 108) {
+		mutated, err := m.Apply(p)
+		if err != nil {
+			t.Fatalf("unable to apply mutation: %v", err)
+		}
+		if p.Payload.BlockHash != originalHash {
+			t.Fatalf("%s: original payload modified", m.Name)
+		}
+		_, err = api.ExecutableDataToBlock(
+			*mutated.Payload,
+			mutated.VersionedHashes,
+			mutated.BeaconRoot,
+		)
+		if m.Rehash {
+			if err != nil {
+				t.Fatalf("%s: rehashed payload rejected: %v", m.Name, err)
+			}
+			if mutated.Payload.BlockHash == originalHash {
+				t.Fatalf("%s: block hash did not change", m.Name)
+			}
+		} else if err == nil {
+			t.Fatalf("%s: payload accepted without rehash", m.Name)
+		}
+
+		expected := m.ExpectedStatus(mutated)
+		if err := m.CheckStatus(mutated, &expected, nil); err != nil {
+			t.Fatalf("%s: expected status rejected: %v", m.Name, err)
+		}
+		valid := api.PayloadStatusV1{Status: api.VALID, LatestValidHash: &originalHash}
+		if err := m.CheckStatus(mutated, &valid, nil); err == nil {
+			t.Fatalf("%s: valid status accepted", m.Name)
+		}
+	}
+}
+
+func TestRequestSpoof(t *testing.T) {
+	p := testNewPayloadParams(t)
+	mutated, err := PayloadMutationGasOverflow.Apply(p)
+	if err != nil {
+		t.Fatalf("unable to apply mutation: %v", err)
+	}
+	spoof, err := RequestSpoof("engine_newPayloadV3", p, mutated)
+	if err != nil {
+		t.Fatalf("unable to create spoof: %v", err)
+	}
+	if len(spoof.Fields) != 2 {
+		t.Fatalf("incorrect spoofed fields: %v", spoof.Fields)
+	}
+	for _, field := range []string{"gasUsed", "blockHash"} {
+		if _, ok := spoof.Fields[field]; !ok {
+			t.Fatalf("missing spoofed field %s: %v", field, spoof.Fields)
+		}
+	}
+
+	mutated, err = PayloadMutationBeaconRoot.Apply(p)
+	if err != nil {
+		t.Fatalf("unable to apply mutation: %v", err)
+	}
+	if _, err := RequestSpoof("engine_newPayloadV3", p, mutated); err == nil {
+		t.Fatalf("beacon root mutation spoofed")
+	}
+}
+
+func TestRequestCallback(t *testing.T) {
+	p := testNewPayloadParams(t)
+	newPayloadRequest := func() []byte {
+		req, err := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      1,
+			"method":  "engine_newPayloadV3",
+			"params":  []interface{}{p.Payload, p.VersionedHashes, p.BeaconRoot},
+		})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		return req
+	}
+	req := newPayloadRequest()
+
+	callback, err := PayloadMutationGasOverflow.RequestCallback(nil)
+	if err != nil {
+		t.Fatalf("unable to create callback: %v", err)
+	}
+	spoof := callback(req)
+	if spoof == nil || spoof.Method != "engine_newPayloadV3" {
+		t.Fatalf("incorrect spoof: %v", spoof)
+	}
+	if _, ok := spoof.Fields["gasUsed"]; !ok {
+		t.Fatalf("missing spoofed gas used: %v", spoof.Fields)
+	}
+	if spoof := callback([]byte("{")); spoof != nil {
+		t.Fatalf("malformed request spoofed: %v", spoof)
+	}
+
+	// Payloads filtered out or that cannot be mutated are not modified
+	callback, err = PayloadMutationGasOverflow.RequestCallback(
+		func(*api.ExecutableData) bool { return false },
+	)
+	if err != nil {
+		t.Fatalf("unable to create callback: %v", err)
+	}
+	if spoof := callback(req); spoof != nil {
+		t.Fatalf("filtered payload spoofed: %v", spoof)
+	}
+	callback, err = PayloadMutationMissingWithdrawal.RequestCallback(nil)
+	if err != nil {
+		t.Fatalf("unable to create callback: %v", err)
+	}
+	p.Payload.Withdrawals = types.Withdrawals{}
+	if spoof := callback(newPayloadRequest()); spoof != nil {
+		t.Fatalf("payload without withdrawals spoofed: %v", spoof)
+	}
+
+	for _, m := range []*PayloadMutation{
+		PayloadMutationVersionedHashes,
+		PayloadMutationBeaconRoot,
+	} {
+		if _, err := m.RequestCallback(nil); err == nil {
+			t.Fatalf("%s: callback created for a mutation that cannot be spoofed", m.Name)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package execution
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/gethclient"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+func bigOrZero(v *big.Int) *big.Int {
+	if v == nil {
+		return new(big.Int)
+	}
+	return v
+}
+
+// Verifies the merkle proof of the key against the root, and returns the
+// value proven, nil if the proof shows the key is absent
+func verifyMerkleProof(
+	root common.Hash,
+	key []byte,
+	proof []string,
+) ([]byte, error) {
+	proofDb := memorydb.New()
+	for i, encodedNode := range proof {
+		node, err := hexutil.Decode(encodedNode)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proof node %d: %v", i, err)
+		}
+		if err := proofDb.Put(crypto.Keccak256(node), node); err != nil {
+			return nil, err
+		}
+	}
+	return trie.VerifyProof(root, crypto.Keccak256(key), proofDb)
+}
+
+// Verifies the account proof against the state root and the storage proofs
+// against the storage root of the account
+func VerifyAccountProof(
+	stateRoot common.Hash,
+	result *gethclient.AccountResult,
+) error {
+	value, err := verifyMerkleProof(
+		stateRoot,
+		result.Address.Bytes(),
+		result.AccountProof,
+	)
+	if err != nil {
+		return fmt.Errorf("account %s: invalid proof: %v", result.Address, err)
+	}
+	var (
+		balance     = bigOrZero(result.Balance)
+		storageRoot = result.StorageHash
+	)
+	if value == nil {
+		// Absent account, the storage proofs are checked against the empty
+		// trie
+		if result.Nonce != 0 || balance.Sign() != 0 {
+			return fmt.Errorf(
+				"account %s: proof of absence for an account with nonce %d and balance %d",
+				result.Address,
+				result.Nonce,
+				balance,
+			)
+		}
+		storageRoot = types.EmptyRootHash
+	} else {
+		account := new(types.StateAccount)
+		if err := rlp.DecodeBytes(value, account); err != nil {
+			return fmt.Errorf(
+				"account %s: unable to decode account: %v",
+				result.Address,
+				err,
+			)
+		}
+		if account.Nonce != result.Nonce ||
+			account.Balance.Cmp(balance) != 0 ||
+			account.Root != result.StorageHash ||
+			!bytes.Equal(account.CodeHash, result.CodeHash[:]) {
+			return fmt.Errorf(
+				"account %s: proven account does not match the result",
+				result.Address,
+			)
+		}
+	}
+
+	errs := make([]error, 0)
+	for _, storage := range result.StorageProof {
+		key := common.HexToHash(storage.Key)
+		if storageRoot == types.EmptyRootHash {
+			// Nothing to prove against an empty storage trie, clients return
+			// an empty proof and a zero value
+			if len(storage.Proof) != 0 || bigOrZero(storage.Value).Sign() != 0 {
+				errs = append(errs, fmt.Errorf(
+					"account %s, storage key %s: non-empty result for empty storage",
+					result.Address,
+					key,
+				))
+			}
+			continue
+		}
+		value, err := verifyMerkleProof(
+			storageRoot,
+			key.Bytes(),
+			storage.Proof,
+		)
+		if err != nil {
+			errs = append(errs, fmt.Errorf(
+				"account %s, storage key %s: invalid proof: %v",
+				result.Address,
+				key,
+				err,
+			))
+			continue
+		}
+		proven := new(big.Int)
+		if value != nil {
+			content := make([]byte, 0)
+			if err := rlp.DecodeBytes(value, &content); err != nil {
+				errs = append(errs, fmt.Errorf(
+					"account %s, storage key %s: unable to decode value: %v",
+					result.Address,
+					key,
+					err,
+				))
+				continue
+			}
+			proven.SetBytes(content)
+		}
+		if expected := bigOrZero(storage.Value); proven.Cmp(expected) != 0 {
+			errs = append(errs, fmt.Errorf(
+				"account %s, storage key %s: proven value %d does not match result %d",
+				result.Address,
+				key,
+				proven,
+				expected,
+			))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Fetches the account and storage proofs at the given block and verifies them
+// against the state root of the block header
+func (ec *ExecutionClient) GetVerifiedProof(
+	ctx context.Context,
+	account common.Address,
+	keys []common.Hash,
+	n *big.Int,
+) (*gethclient.AccountResult, *types.Header, error) {
+	header, err := ec.HeaderByNumber(ctx, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Use the header number to request the proof for the same block, even if
+	// the head changed in between
+	result, err := ec.GetProof(ctx, account, keys, header.Number)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := VerifyAccountProof(header.Root, result); err != nil {
+		return nil, nil, fmt.Errorf(
+			"execution client %d, block %d: %v",
+			ec.Config.ClientIndex,
+			header.Number,
+			err,
+		)
+	}
+	return result, header, nil
+}
+
+// Fetches and verifies the proofs from every running client at the given
+// block number, and checks that all clients prove the same state root and
+// values
+func (all ExecutionClients) VerifyProofs(
+	ctx context.Context,
+	account common.Address,
+	keys []common.Hash,
+	n *big.Int,
+) error {
+	running := all.Running()
+	if len(running) == 0 {
+		return fmt.Errorf("no running execution clients")
+	}
+	if n == nil {
+		// Pin the block so all clients are compared at the same height
+		header, err := running[0].HeaderByNumber(ctx, nil)
+		if err != nil {
+			return err
+		}
+		n = header.Number
+	}
+	var (
+		errs       = make([]error, 0)
+		baseResult *gethclient.AccountResult
+		baseHeader *types.Header
+	)
+	for _, ec := range running {
+		result, header, err := ec.GetVerifiedProof(ctx, account, keys, n)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if baseHeader == nil {
+			baseResult, baseHeader = result, header
+			continue
+		}
+		if header.Root != baseHeader.Root {
+			errs = append(errs, fmt.Errorf(
+				"execution client %d, block %d: state root mismatch: %s != %s",
+				ec.Config.ClientIndex,
+				n,
+				header.Root,
+				baseHeader.Root,
+			))
+			continue
+		}
+		if result.StorageHash != baseResult.StorageHash ||
+			result.Nonce != baseResult.Nonce ||
+			bigOrZero(result.Balance).Cmp(bigOrZero(baseResult.Balance)) != 0 {
+			errs = append(errs, fmt.Errorf(
+				"execution client %d, block %d: account %s mismatch",
+				ec.Config.ClientIndex,
+				n,
+				account,
+			))
+		}
+		if len(result.StorageProof) != len(baseResult.StorageProof) {
+			errs = append(errs, fmt.Errorf(
+				"execution client %d, block %d: incorrect number of storage proofs: want %d, got %d",
+				ec.Config.ClientIndex,
+				n,
+				len(baseResult.StorageProof),
+				len(result.StorageProof),
+			))
+			continue
+		}
+		for i, storage := range result.StorageProof {
+			baseValue := bigOrZero(baseResult.StorageProof[i].Value)
+			if bigOrZero(storage.Value).Cmp(baseValue) != 0 {
+				errs = append(errs, fmt.Errorf(
+					"execution client %d, block %d: storage key %s mismatch: %d != %d",
+					ec.Config.ClientIndex,
+					n,
+					storage.Key,
+					bigOrZero(storage.Value),
+					baseValue,
+				))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,152 @@
+/*
+Tests for the eth_getProof verification
+*/
+package execution
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/gethclient"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// Collects the proof nodes in the format returned by eth_getProof
+type proofList []string
+
+func (l *proofList) Put(key []byte, value []byte) error {
+	*l = append(*l, hexutil.Encode(value))
+	return nil
+}
+
+func (l *proofList) Delete(key []byte) error {
+	panic("not supported")
+}
+
+func TestVerifyAccountProof(t *testing.T) {
+	var (
+		db      = trie.NewDatabase(rawdb.NewMemoryDatabase(), nil)
+		address = common.HexToAddress("0x1234")
+		eoa     = common.HexToAddress("0x9abc")
+		absent  = common.HexToAddress("0x5678")
+		slot    = common.HexToHash("0x01")
+		value   = big.NewInt(0xabcd)
+	)
+	storageTrie := trie.NewEmpty(db)
+	encodedValue, err := rlp.EncodeToBytes(value.Bytes())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	storageTrie.MustUpdate(crypto.Keccak256(slot[:]), encodedValue)
+	account := &types.StateAccount{
+		Nonce:    5,
+		Balance:  big.NewInt(1e18),
+		Root:     storageTrie.Hash(),
+		CodeHash: types.EmptyCodeHash[:],
+	}
+	encodedAccount, err := rlp.EncodeToBytes(account)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	stateTrie := trie.NewEmpty(db)
+	stateTrie.MustUpdate(crypto.Keccak256(address[:]), encodedAccount)
+	eoaAccount := &types.StateAccount{
+		Nonce:    1,
+		Balance:  big.NewInt(1e9),
+		Root:     types.EmptyRootHash,
+		CodeHash: types.EmptyCodeHash[:],
+	}
+	encodedEOA, err := rlp.EncodeToBytes(eoaAccount)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	stateTrie.MustUpdate(crypto.Keccak256(eoa[:]), encodedEOA)
+	stateRoot := stateTrie.Hash()
+
+	prove := func(tr *trie.Trie, key []byte) []string {
+		proof := make(proofList, 0)
+		if err := tr.Prove(crypto.Keccak256(key), &proof); err != nil {
+			t.Fatalf("%v", err)
+		}
+		return proof
+	}
+	result := &gethclient.AccountResult{
+		Address:      address,
+		AccountProof: prove(stateTrie, address[:]),
+		Balance:      account.Balance,
+		CodeHash:     types.EmptyCodeHash,
+		Nonce:        account.Nonce,
+		StorageHash:  account.Root,
+		StorageProof: []gethclient.StorageResult{
+			{
+				Key:   "0x1",
+				Value: value,
+				Proof: prove(storageTrie, slot[:]),
+			},
+		},
+	}
+	if err := VerifyAccountProof(stateRoot, result); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	result.StorageProof[0].Value = big.NewInt(1)
+	if err := VerifyAccountProof(stateRoot, result); err == nil {
+		t.Fatalf("expected storage value mismatch error")
+	}
+	result.StorageProof[0].Value = value
+	result.Balance = big.NewInt(1)
+	if err := VerifyAccountProof(stateRoot, result); err == nil {
+		t.Fatalf("expected account mismatch error")
+	}
+	result.Balance = account.Balance
+	if err := VerifyAccountProof(common.Hash{1}, result); err == nil {
+		t.Fatalf("expected invalid proof error")
+	}
+
+	absentResult := &gethclient.AccountResult{
+		Address:      absent,
+		AccountProof: prove(stateTrie, absent[:]),
+		Balance:      new(big.Int),
+	}
+	if err := VerifyAccountProof(stateRoot, absentResult); err != nil {
+		t.Fatalf("%v", err)
+	}
+	absentResult.Balance = big.NewInt(1)
+	if err := VerifyAccountProof(stateRoot, absentResult); err == nil {
+		t.Fatalf("expected proof of absence error")
+	}
+	absentResult.Balance = new(big.Int)
+
+	// Storage of accounts without storage is returned without proofs
+	eoaResult := &gethclient.AccountResult{
+		Address:      eoa,
+		AccountProof: prove(stateTrie, eoa[:]),
+		Balance:      eoaAccount.Balance,
+		CodeHash:     types.EmptyCodeHash,
+		Nonce:        eoaAccount.Nonce,
+		StorageHash:  types.EmptyRootHash,
+	}
+	for _, r := range []*gethclient.AccountResult{eoaResult, absentResult} {
+		r.StorageProof = []gethclient.StorageResult{
+			{Key: "0x1", Value: new(big.Int), Proof: []string{}},
+		}
+		if err := VerifyAccountProof(stateRoot, r); err != nil {
+			t.Fatalf("account %s: %v", r.Address, err)
+		}
+		r.StorageProof[0].Value = big.NewInt(1)
+		if err := VerifyAccountProof(stateRoot, r); err == nil {
+			t.Fatalf("account %s: expected empty storage value error", r.Address)
+		}
+		r.StorageProof[0].Value = new(big.Int)
+		r.StorageProof[0].Proof = prove(storageTrie, slot[:])
+		if err := VerifyAccountProof(stateRoot, r); err == nil {
+			t.Fatalf("account %s: expected empty storage proof error", r.Address)
+		}
+	}
+}
@@ -24,6 +24,16 @@ type Proxy struct {
 
 	rpc *rpc.Client
 	mu  sync.Mutex
+
+	// Response callback layers and the callbacks set before the first layer,
+	// keyed by method
+	layers        map[string][]*responseCallbackLayer
+	baseCallbacks map[string]func([]byte, []byte) *proxy.Spoof
+	layersMu      sync.Mutex
+}
+
+type responseCallbackLayer struct {
+	callback func(method string, res []byte, req []byte) *proxy.Spoof
 }
 
 func NewProxy(
@@ -133,6 +143,111 @@ func (p *Proxy) AddResponseCallbacks(
 	p.proxy.UpdateSpoofingCallbacks(p.callbacks)
 }
 
+// ResponseCallback returns the response callback set for the method, nil if
+// there is none.
+func (p *Proxy) ResponseCallback(
+	method string,
+) func([]byte, []byte) *proxy.Spoof {
+	return p.callbacks.ResponseCallbacks[method]
+}
+
+// RemoveResponseCallbacks removes the response callbacks of multiple methods
+// from the proxy.
+func (p *Proxy) RemoveResponseCallbacks(methods ...string) {
+	for _, method := range methods {
+		log.Info("Removing response spoof callback", "method", method)
+		delete(p.callbacks.ResponseCallbacks, method)
+	}
+	p.proxy.UpdateSpoofingCallbacks(p.callbacks)
+}
+
+// PushResponseCallback adds a callback for the response on multiple methods on
+// top of the callbacks already set. The spoof of the topmost callback that
+// returns one is used.
+// Returns the function that removes only this callback, leaving the callbacks
+// pushed before and after it in place.
+func (p *Proxy) PushResponseCallback(
+	callback func(method string, res []byte, req []byte) *proxy.Spoof,
+	methods ...string,
+) func() {
+	layer := &responseCallbackLayer{callback: callback}
+	install := make([]string, 0)
+	p.layersMu.Lock()
+	if p.layers == nil {
+		p.layers = make(map[string][]*responseCallbackLayer)
+		p.baseCallbacks = make(map[string]func([]byte, []byte) *proxy.Spoof)
+	}
+	for _, method := range methods {
+		if len(p.layers[method]) == 0 {
+			p.baseCallbacks[method] = p.ResponseCallback(method)
+			install = append(install, method)
+		}
+		p.layers[method] = append(p.layers[method], layer)
+	}
+	p.layersMu.Unlock()
+	for _, method := range install {
+		p.AddResponseCallbacks(p.layeredResponseCallback(method), method)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			restore := make(map[string]func([]byte, []byte) *proxy.Spoof)
+			p.layersMu.Lock()
+			for _, method := range methods {
+				layers := p.layers[method]
+				for i, l := range layers {
+					if l == layer {
+						// Copy so running callbacks keep their own slice
+						layers = append(layers[:i:i], layers[i+1:]...)
+						break
+					}
+				}
+				if len(layers) == 0 {
+					restore[method] = p.baseCallbacks[method]
+					delete(p.layers, method)
+					delete(p.baseCallbacks, method)
+				} else {
+					p.layers[method] = layers
+				}
+			}
+			p.layersMu.Unlock()
+			for method, base := range restore {
+				if base != nil {
+					p.AddResponseCallbacks(base, method)
+				} else {
+					p.RemoveResponseCallbacks(method)
+				}
+			}
+		})
+	}
+}
+
+// Returns the callback that runs the callbacks pushed for the method, from the
+// topmost one down to the callback set before the first push
+func (p *Proxy) layeredResponseCallback(
+	method string,
+) func([]byte, []byte) *proxy.Spoof {
+	return func(res []byte, req []byte) *proxy.Spoof {
+		p.layersMu.Lock()
+		var (
+			base   = p.baseCallbacks[method]
+			layers = p.layers[method]
+		)
+		p.layersMu.Unlock()
+		var baseSpoof *proxy.Spoof
+		if base != nil {
+			baseSpoof = base(res, req)
+		}
+		for i := len(layers) - 1; i >= 0; i-- {
+			if spoof := layers[i].callback(method, res, req); spoof != nil {
+				return spoof
+			}
+		}
+		return baseSpoof
+	}
+}
+
 // AddResponses adds spoofs for a set of responses to the proxy.
 func (p *Proxy) AddResponses(spoofs ...*proxy.Spoof) {
 	for _, spoof := range spoofs {
@@ -0,0 +1,100 @@
+/*
+Tests for the layered response callbacks of the proxy
+*/
+package execution
+
+import (
+	"testing"
+
+	"github.com/rauljordan/engine-proxy/proxy"
+)
+
+func TestPushResponseCallback(t *testing.T) {
+	engineProxy, err := proxy.New(proxy.WithDestinationAddress("http://127.0.0.1:1"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	p := &Proxy{
+		proxy: engineProxy,
+		callbacks: &proxy.SpoofingCallbacks{
+			ResponseCallbacks: make(map[string]func([]byte, []byte) *proxy.Spoof),
+		},
+	}
+	const method = "engine_newPayloadV3"
+	spoofing := func(name string, only string) func(string, []byte, []byte) *proxy.Spoof {
+		return func(method string, res []byte, req []byte) *proxy.Spoof {
+			if only != "" && string(req) != only {
+				return nil
+			}
+			return &proxy.Spoof{
+				Method: method,
+				Fields: map[string]interface{}{"layer": name},
+			}
+		}
+	}
+	// Returns the layer that spoofed the response of the request
+	layer := func(req string) interface{} {
+		callback := p.ResponseCallback(method)
+		if callback == nil {
+			return nil
+		}
+		if spoof := callback(nil, []byte(req)); spoof != nil {
+			return spoof.Fields["layer"]
+		}
+		return nil
+	}
+
+	p.AddResponseCallbacks(func(res []byte, req []byte) *proxy.Spoof {
+		return spoofing("base", "")(method, res, req)
+	}, method)
+	removeSyncing := p.PushResponseCallback(spoofing("syncing", ""), method)
+	removeInvalid := p.PushResponseCallback(spoofing("invalid", "bad"), method)
+
+	for _, test := range []struct {
+		name    string
+		remove  func()
+		good    interface{}
+		invalid interface{}
+	}{
+		{
+			name:    "all layers",
+			good:    "syncing",
+			invalid: "invalid",
+		},
+		{
+			name:    "lower layer removed",
+			remove:  removeSyncing,
+			good:    "base",
+			invalid: "invalid",
+		},
+		{
+			name:    "lower layer removed twice",
+			remove:  removeSyncing,
+			good:    "base",
+			invalid: "invalid",
+		},
+		{
+			name:    "all layers removed",
+			remove:  removeInvalid,
+			good:    "base",
+			invalid: "base",
+		},
+	} {
+		if test.remove != nil {
+			test.remove()
+		}
+		if got := layer("good"); got != test.good {
+			t.Fatalf("%s: incorrect layer: want %v, got %v", test.name, test.good, got)
+		}
+		if got := layer("bad"); got != test.invalid {
+			t.Fatalf("%s: incorrect layer: want %v, got %v", test.name, test.invalid, got)
+		}
+	}
+
+	// Without a callback set before the layers, the callback is removed
+	p.RemoveResponseCallbacks(method)
+	p.PushResponseCallback(spoofing("syncing", ""), method)()
+	if p.ResponseCallback(method) != nil {
+		t.Fatalf("callback not removed")
+	}
+}
@@ -0,0 +1,463 @@
+package execution
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/misc/eip4844"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// Minimum fee increase, in percent, accepted by the transaction pools to
+// replace a pending transaction
+const TransactionReplacementBump = 10
+
+// Derives the secp256k1 private key at the given BIP-32 path from the seed of
+// the BIP-39 mnemonic
+func DeriveKey(
+	mnemonic string,
+	path accounts.DerivationPath,
+) (*ecdsa.PrivateKey, error) {
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, "")
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	i := mac.Sum(nil)
+	var (
+		n         = crypto.S256().Params().N
+		key       = new(big.Int).SetBytes(i[:32])
+		chainCode = i[32:]
+	)
+	if key.Sign() == 0 || key.Cmp(n) >= 0 {
+		return nil, fmt.Errorf("invalid master key")
+	}
+	for _, index := range path {
+		data := make([]byte, 0, 37)
+		if index >= 0x80000000 {
+			data = append(data, 0)
+			data = append(data, common.LeftPadBytes(key.Bytes(), 32)...)
+		} else {
+			priv, err := crypto.ToECDSA(common.LeftPadBytes(key.Bytes(), 32))
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, crypto.CompressPubkey(&priv.PublicKey)...)
+		}
+		data = binary.BigEndian.AppendUint32(data, index)
+		mac := hmac.New(sha512.New, chainCode)
+		mac.Write(data)
+		i := mac.Sum(nil)
+		il := new(big.Int).SetBytes(i[:32])
+		if il.Cmp(n) >= 0 {
+			return nil, fmt.Errorf("invalid child key at index %d", index)
+		}
+		key = il.Add(il, key).Mod(il, n)
+		if key.Sign() == 0 {
+			return nil, fmt.Errorf("invalid child key at index %d", index)
+		}
+		chainCode = i[32:]
+	}
+	return crypto.ToECDSA(common.LeftPadBytes(key.Bytes(), 32))
+}
+
+// Fees used to price a transaction
+type Fees struct {
+	GasTipCap *big.Int
+	GasFeeCap *big.Int
+	// Nil if the header predates blob transactions
+	BlobFeeCap *big.Int
+}
+
+// Returns the fees for a transaction to be included in the next blocks
+// given the latest header.
+// Fee caps are set to twice the current base fees plus the tip, which covers
+// a few blocks of maximum base fee increase.
+func FeesFromHeader(header *types.Header, tip *big.Int) (*Fees, error) {
+	if header.BaseFee == nil {
+		return nil, fmt.Errorf("header %d has no base fee", header.Number)
+	}
+	fees := &Fees{
+		GasTipCap: new(big.Int).Set(tip),
+		GasFeeCap: new(big.Int).Add(
+			new(big.Int).Mul(header.BaseFee, big.NewInt(2)),
+			tip,
+		),
+	}
+	if header.ExcessBlobGas != nil {
+		fees.BlobFeeCap = new(big.Int).Mul(
+			eip4844.CalcBlobFee(*header.ExcessBlobGas),
+			big.NewInt(2),
+		)
+	}
+	return fees, nil
+}
+
+// Returns the fees estimated from the latest header of the client
+func (ec *ExecutionClient) EstimateFees(
+	ctx context.Context,
+	tip *big.Int,
+) (*Fees, error) {
+	header, err := ec.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return FeesFromHeader(header, tip)
+}
+
+// Returns the fee increased by the minimum replacement bump
+func bumpFee(fee *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(fee, big.NewInt(100+TransactionReplacementBump))
+	bumped.Div(bumped, big.NewInt(100))
+	return bumped.Add(bumped, common.Big1)
+}
+
+func maxBig(a, b *big.Int) *big.Int {
+	if a.Cmp(b) >= 0 {
+		return a
+	}
+	return b
+}
+
+// Transaction to be sent through the wallet, the nonce and fees are filled
+// in when sending
+type TransactionRequest struct {
+	To         *common.Address
+	Value      *big.Int
+	Data       []byte
+	AccessList types.AccessList
+	// Estimated by the client if zero
+	Gas uint64
+}
+
+// Transaction sent through the wallet
+type WalletTransaction struct {
+	Account *Account
+	Request TransactionRequest
+	// Latest version of the transaction sent
+	Tx *types.Transaction
+	// Hashes of all the versions sent with the same nonce, in order
+	Hashes []common.Hash
+	SentAt time.Time
+}
+
+// Holds the keys of the test accounts and sends their transactions, keeping
+// track of the pending nonces of each account across concurrent senders
+type Wallet struct {
+	Accounts []*Account
+	// Tip used when estimating the fees, defaults to 1 gwei
+	GasTipCap *big.Int
+
+	chainID *big.Int
+	// Held by each account while a nonce is assigned and its transaction
+	// sent, keyed by address
+	sendLocks map[common.Address]*sync.Mutex
+	mu        sync.Mutex
+}
+
+func NewWallet(keys ...*ecdsa.PrivateKey) *Wallet {
+	w := &Wallet{
+		Accounts:  make([]*Account, len(keys)),
+		GasTipCap: big.NewInt(1e9),
+	}
+	for i, key := range keys {
+		w.Accounts[i] = NewAccount(key)
+	}
+	return w
+}
+
+// Creates a wallet with the first count accounts derived from the mnemonic
+// using the default derivation path, m/44'/60'/0'/0/i, which is the one used
+// to prefund accounts in the genesis
+func NewWalletFromMnemonic(mnemonic string, count int) (*Wallet, error) {
+	keys := make([]*ecdsa.PrivateKey, count)
+	next := accounts.DefaultIterator(accounts.DefaultBaseDerivationPath)
+	for i := range keys {
+		path := next()
+		key, err := DeriveKey(mnemonic, path)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %v", path, err)
+		}
+		keys[i] = key
+	}
+	return NewWallet(keys...), nil
+}
+
+// Returns the account with the given address, nil if not in the wallet
+func (w *Wallet) Account(address common.Address) *Account {
+	for _, a := range w.Accounts {
+		if a.Address == address {
+			return a
+		}
+	}
+	return nil
+}
+
+// Fetches the chain id and the pending nonces of all accounts from the client
+func (w *Wallet) Sync(ctx context.Context, ec *ExecutionClient) error {
+	chainID, err := ec.ChainID(ctx)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.chainID = chainID
+	w.mu.Unlock()
+	for _, a := range w.Accounts {
+		lock := w.sendLock(a)
+		lock.Lock()
+		err := a.SyncNonce(ctx, ec)
+		lock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the lock that serializes the sends of the account
+func (w *Wallet) sendLock(account *Account) *sync.Mutex {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.sendLocks == nil {
+		w.sendLocks = make(map[common.Address]*sync.Mutex)
+	}
+	l, ok := w.sendLocks[account.Address]
+	if !ok {
+		l = new(sync.Mutex)
+		w.sendLocks[account.Address] = l
+	}
+	return l
+}
+
+func (w *Wallet) signer() (types.Signer, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.chainID == nil {
+		return nil, fmt.Errorf("wallet not synced")
+	}
+	return types.LatestSignerForChainID(w.chainID), nil
+}
+
+// Returns the balance of every account of the wallet
+func (w *Wallet) Balances(
+	ctx context.Context,
+	ec *ExecutionClient,
+) (map[common.Address]*big.Int, error) {
+	balances := make(map[common.Address]*big.Int)
+	for _, a := range w.Accounts {
+		balance, err := ec.BalanceAt(ctx, a.Address, nil)
+		if err != nil {
+			return nil, err
+		}
+		balances[a.Address] = balance
+	}
+	return balances, nil
+}
+
+func (w *Wallet) signAndSend(
+	ctx context.Context,
+	ec *ExecutionClient,
+	wtx *WalletTransaction,
+	nonce uint64,
+	fees *Fees,
+) error {
+	signer, err := w.signer()
+	if err != nil {
+		return err
+	}
+	tx, err := types.SignNewTx(wtx.Account.Key, signer, &types.DynamicFeeTx{
+		ChainID:    signer.ChainID(),
+		Nonce:      nonce,
+		GasTipCap:  fees.GasTipCap,
+		GasFeeCap:  fees.GasFeeCap,
+		Gas:        wtx.Request.Gas,
+		To:         wtx.Request.To,
+		Value:      wtx.Request.Value,
+		Data:       wtx.Request.Data,
+		AccessList: wtx.Request.AccessList,
+	})
+	if err != nil {
+		return err
+	}
+	if err := ec.SendTransaction(ctx, tx); err != nil {
+		return err
+	}
+	wtx.Tx = tx
+	wtx.Hashes = append(wtx.Hashes, tx.Hash())
+	wtx.SentAt = time.Now()
+	return nil
+}
+
+// Sends a dynamic fee transaction from the account using the next pending
+// nonce and the fees estimated from the latest header.
+// Concurrent sends from the same account are serialized, so the nonce is
+// only consumed if the client accepts the transaction, and if the client
+// rejects it, the nonce of the account can be synced again with the client
+// without affecting other transactions in flight.
+func (w *Wallet) Send(
+	ctx context.Context,
+	ec *ExecutionClient,
+	account *Account,
+	req TransactionRequest,
+) (*WalletTransaction, error) {
+	if req.Value == nil {
+		req.Value = new(big.Int)
+	}
+	if req.Gas == 0 {
+		gas, err := ec.EstimateGas(ctx, ethereum.CallMsg{
+			From:       account.Address,
+			To:         req.To,
+			Value:      req.Value,
+			Data:       req.Data,
+			AccessList: req.AccessList,
+		})
+		if err != nil {
+			return nil, err
+		}
+		req.Gas = gas
+	}
+	fees, err := ec.EstimateFees(ctx, w.GasTipCap)
+	if err != nil {
+		return nil, err
+	}
+	wtx := &WalletTransaction{
+		Account: account,
+		Request: req,
+		Hashes:  make([]common.Hash, 0),
+	}
+	lock := w.sendLock(account)
+	lock.Lock()
+	defer lock.Unlock()
+	nonce := account.Nonce()
+	if err := w.signAndSend(ctx, ec, wtx, nonce, fees); err != nil {
+		if err := account.SyncNonce(ctx, ec); err != nil {
+			ec.Logf("Wallet: unable to sync nonce: %v", err)
+		}
+		return nil, err
+	}
+	account.SetNonce(nonce + 1)
+	return wtx, nil
+}
+
+// Sends a value transfer between accounts
+func (w *Wallet) Fund(
+	ctx context.Context,
+	ec *ExecutionClient,
+	from *Account,
+	to common.Address,
+	amount *big.Int,
+) (*WalletTransaction, error) {
+	return w.Send(ctx, ec, from, TransactionRequest{
+		To:    &to,
+		Value: amount,
+	})
+}
+
+// Replaces a pending transaction with a new version with the same nonce and
+// fees bumped enough to be accepted by the transaction pool, or the current
+// estimate if higher
+func (w *Wallet) Replace(
+	ctx context.Context,
+	ec *ExecutionClient,
+	wtx *WalletTransaction,
+) error {
+	fees, err := ec.EstimateFees(ctx, w.GasTipCap)
+	if err != nil {
+		return err
+	}
+	fees.GasTipCap = maxBig(fees.GasTipCap, bumpFee(wtx.Tx.GasTipCap()))
+	fees.GasFeeCap = maxBig(fees.GasFeeCap, bumpFee(wtx.Tx.GasFeeCap()))
+	if fees.GasFeeCap.Cmp(fees.GasTipCap) < 0 {
+		fees.GasFeeCap = fees.GasTipCap
+	}
+	return w.signAndSend(ctx, ec, wtx, wtx.Tx.Nonce(), fees)
+}
+
+// Returns the receipt of any of the versions of the transaction, nil if none
+// is included in the canonical chain
+func (wtx *WalletTransaction) canonicalReceipt(
+	ctx context.Context,
+	ec *ExecutionClient,
+) (*types.Receipt, error) {
+	for _, h := range wtx.Hashes {
+		receipt, err := ec.TransactionReceipt(ctx, h)
+		if errors.Is(err, ethereum.NotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		// The receipt could belong to a block that was reorged out
+		header, err := ec.HeaderByNumber(ctx, receipt.BlockNumber)
+		if errors.Is(err, ethereum.NotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if header.Hash() == receipt.BlockHash {
+			return receipt, nil
+		}
+	}
+	return nil, nil
+}
+
+// Waits until one of the versions of the transaction is included in the
+// canonical chain with the given number of confirmations, and returns its
+// receipt.
+// Inclusion is checked again on every poll, so a transaction that is
+// reorged out is waited for again.
+// If replaceAfter is not zero, the transaction is replaced with bumped fees
+// every time it stays pending for that long.
+func (w *Wallet) WaitForReceipt(
+	ctx context.Context,
+	ec *ExecutionClient,
+	wtx *WalletTransaction,
+	confirmations uint64,
+	replaceAfter time.Duration,
+) (*types.Receipt, error) {
+	timer := time.NewTicker(time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		receipt, err := wtx.canonicalReceipt(ctx, ec)
+		if err != nil {
+			return nil, err
+		}
+		if receipt == nil {
+			if replaceAfter > 0 && time.Since(wtx.SentAt) >= replaceAfter {
+				if err := w.Replace(ctx, ec, wtx); err != nil {
+					ec.Logf(
+						"Wallet: unable to replace transaction %s: %v",
+						wtx.Tx.Hash(),
+						err,
+					)
+				}
+			}
+			continue
+		}
+		head, err := ec.HeaderByNumber(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		if head.Number.Uint64() >= receipt.BlockNumber.Uint64()+confirmations {
+			return receipt, nil
+		}
+	}
+}
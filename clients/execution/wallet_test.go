@@ -0,0 +1,254 @@
+/*
+Tests for the wallet key derivation, fee estimation and nonce tracking
+*/
+package execution
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/marioevz/eth-clients/clients"
+)
+
+// Returns an execution client whose engine and user RPCs are served by the
+// given services, keyed by namespace
+func testRPCClient(
+	t *testing.T,
+	index int,
+	services map[string]interface{},
+) *ExecutionClient {
+	server := rpc.NewServer()
+	for namespace, service := range services {
+		if err := server.RegisterName(namespace, service); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	srv := httptest.NewServer(server)
+	t.Cleanup(srv.Close)
+	t.Cleanup(server.Stop)
+	external, err := clients.ExternalClientFromURL(srv.URL, "test-el")
+	if err != nil {
+		t.Fatalf("unable to create client: %v", err)
+	}
+	port := int(*external.GetPort())
+	ec := &ExecutionClient{
+		Client: external,
+		Config: ExecutionClientConfig{
+			ClientIndex:   index,
+			EngineAPIPort: port,
+			RPCPort:       port,
+			JWTSecret:     make([]byte, 32),
+		},
+	}
+	if err := ec.Init(context.Background()); err != nil {
+		t.Fatalf("unable to init client: %v", err)
+	}
+	return ec
+}
+
+func TestNewWalletFromMnemonic(t *testing.T) {
+	wallet, err := NewWalletFromMnemonic(
+		"test test test test test test test test test test test junk",
+		3,
+	)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for i, expected := range []common.Address{
+		common.HexToAddress("0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"),
+		common.HexToAddress("0x70997970C51812dc3A010C7d01b50e0d17dc79C8"),
+		common.HexToAddress("0x3C44CdDdB6a900fa2b585dd299e03d12FA4293BC"),
+	} {
+		if wallet.Accounts[i].Address != expected {
+			t.Fatalf(
+				"account %d: incorrect address: want %s, got %s",
+				i,
+				expected,
+				wallet.Accounts[i].Address,
+			)
+		}
+	}
+
+	if _, err := NewWalletFromMnemonic("test test junk", 1); err == nil {
+		t.Fatalf("expected invalid mnemonic error")
+	}
+}
+
+func TestFeesFromHeader(t *testing.T) {
+	excessBlobGas := uint64(0)
+	fees, err := FeesFromHeader(&types.Header{
+		Number:        big.NewInt(1),
+		BaseFee:       big.NewInt(7),
+		ExcessBlobGas: &excessBlobGas,
+	}, big.NewInt(1))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if fees.GasFeeCap.Cmp(big.NewInt(15)) != 0 {
+		t.Fatalf("incorrect fee cap: %d", fees.GasFeeCap)
+	}
+	if fees.BlobFeeCap == nil || fees.BlobFeeCap.Cmp(big.NewInt(2)) != 0 {
+		t.Fatalf("incorrect blob fee cap: %d", fees.BlobFeeCap)
+	}
+	if bumped := bumpFee(big.NewInt(100)); bumped.Cmp(big.NewInt(111)) != 0 {
+		t.Fatalf("incorrect bumped fee: %d", bumped)
+	}
+
+	if _, err := FeesFromHeader(&types.Header{
+		Number: big.NewInt(1),
+	}, big.NewInt(1)); err == nil {
+		t.Fatalf("expected missing base fee error")
+	}
+}
+
+// Transaction pool of a fake execution client, which rejects every
+// failEvery-th transaction without consuming its nonce, and transactions that
+// reuse a nonce already in the pool
+type testTxPool struct {
+	header    *types.Header
+	signer    types.Signer
+	failEvery int
+	received  int
+	nonces    map[common.Address]map[uint64]bool
+	reused    int
+	mu        sync.Mutex
+}
+
+func (p *testTxPool) pendingNonce(addr common.Address) uint64 {
+	n := uint64(0)
+	for p.nonces[addr][n] {
+		n++
+	}
+	return n
+}
+
+func (p *testTxPool) ChainId() *hexutil.Big {
+	return (*hexutil.Big)(p.signer.ChainID())
+}
+
+func (p *testTxPool) GetBlockByNumber(
+	number rpc.BlockNumber,
+	full bool,
+) (map[string]interface{}, error) {
+	return testBlockFields(types.NewBlockWithHeader(p.header))
+}
+
+func (p *testTxPool) GetTransactionCount(
+	addr common.Address,
+	block rpc.BlockNumberOrHash,
+) (hexutil.Uint64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return hexutil.Uint64(p.pendingNonce(addr)), nil
+}
+
+func (p *testTxPool) SendRawTransaction(input hexutil.Bytes) (common.Hash, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(input); err != nil {
+		return common.Hash{}, err
+	}
+	from, err := types.Sender(p.signer, tx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.received++
+	if p.received%p.failEvery == 0 {
+		return common.Hash{}, fmt.Errorf("transaction pool busy")
+	}
+	if p.nonces[from] == nil {
+		p.nonces[from] = make(map[uint64]bool)
+	}
+	if p.nonces[from][tx.Nonce()] {
+		p.reused++
+		return common.Hash{}, fmt.Errorf("nonce too low")
+	}
+	p.nonces[from][tx.Nonce()] = true
+	return tx.Hash(), nil
+}
+
+func TestWalletConcurrentSend(t *testing.T) {
+	ctx := context.Background()
+	pool := &testTxPool{
+		header: &types.Header{
+			Number:     big.NewInt(1),
+			Difficulty: common.Big0,
+			BaseFee:    big.NewInt(1e9),
+		},
+		signer:    types.LatestSignerForChainID(big.NewInt(1)),
+		failEvery: 3,
+		nonces:    make(map[common.Address]map[uint64]bool),
+	}
+	ec := testRPCClient(t, 0, map[string]interface{}{"eth": pool})
+	keys := make([]*ecdsa.PrivateKey, 2)
+	for i := range keys {
+		key, err := crypto.ToECDSA(common.LeftPadBytes([]byte{byte(i + 1)}, 32))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		keys[i] = key
+	}
+	wallet := NewWallet(keys...)
+	if err := wallet.Sync(ctx, ec); err != nil {
+		t.Fatalf("unable to sync wallet: %v", err)
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		sent = make(map[common.Address][]uint64)
+	)
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(account *Account) {
+			defer wg.Done()
+			to := common.Address{0x01}
+			wtx, err := wallet.Send(ctx, ec, account, TransactionRequest{
+				To:  &to,
+				Gas: 21000,
+			})
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			sent[account.Address] = append(sent[account.Address], wtx.Tx.Nonce())
+			mu.Unlock()
+		}(wallet.Accounts[i%len(wallet.Accounts)])
+	}
+	wg.Wait()
+
+	if pool.reused != 0 {
+		t.Fatalf("%d transactions reused a nonce", pool.reused)
+	}
+	for _, account := range wallet.Accounts {
+		// Every accepted transaction uses the next nonce, without gaps
+		accepted := uint64(len(sent[account.Address]))
+		if accepted == 0 {
+			t.Fatalf("no transactions accepted from %s", account.Address)
+		}
+		if pending := pool.pendingNonce(account.Address); pending != accepted ||
+			uint64(len(pool.nonces[account.Address])) != accepted {
+			t.Fatalf(
+				"account %s: %d transactions accepted, pending nonce %d, %d nonces in the pool",
+				account.Address,
+				accepted,
+				pending,
+				len(pool.nonces[account.Address]),
+			)
+		}
+		if account.Nonce() != accepted {
+			t.Fatalf("account %s: incorrect nonce: %d", account.Address, account.Nonce())
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/marioevz/eth-clients/clients/validator"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+)
+
+// Default maximum number of items sent to a beacon node in a single request
+const DefaultBatchChunkSize = 500
+
+type BatchConfig struct {
+	// Number of goroutines used to sign the messages, defaults to the
+	// number of CPUs
+	SigningWorkers int
+	// Number of concurrent requests sent to a single beacon node when the
+	// endpoint only accepts one item per request, defaults to the number of
+	// CPUs
+	SubmissionWorkers int
+	// Maximum number of items sent in a single request, defaults to
+	// DefaultBatchChunkSize
+	ChunkSize int
+}
+
+func (c *BatchConfig) signingWorkers() int {
+	if c == nil || c.SigningWorkers <= 0 {
+		return runtime.NumCPU()
+	}
+	return c.SigningWorkers
+}
+
+func (c *BatchConfig) submissionWorkers() int {
+	if c == nil || c.SubmissionWorkers <= 0 {
+		return runtime.NumCPU()
+	}
+	return c.SubmissionWorkers
+}
+
+func (c *BatchConfig) chunkSize() int {
+	if c == nil || c.ChunkSize <= 0 {
+		return DefaultBatchChunkSize
+	}
+	return c.ChunkSize
+}
+
+// Result of a single item of a batch submission
+type BatchItemResult struct {
+	// Index of the item in the original request list
+	Index          int
+	ValidatorIndex common.ValidatorIndex
+	// Error produced while signing the item, in which case the item was not
+	// submitted to any node
+	SignError error
+	// Errors returned by each node, keyed by node index. Nodes that accepted
+	// the item are not present in the map.
+	Failures map[int]error
+}
+
+// Returns true if the item was signed and accepted by all nodes
+func (r *BatchItemResult) Accepted() bool {
+	return r.SignError == nil && len(r.Failures) == 0
+}
+
+type BatchResults []*BatchItemResult
+
+// Returns the subset of results that were not accepted by all nodes
+func (results BatchResults) Rejected() BatchResults {
+	res := make(BatchResults, 0)
+	for _, r := range results {
+		if !r.Accepted() {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// Thread-safe recording of node failures
+type batchFailureRecorder struct {
+	results BatchResults
+	mu      sync.Mutex
+}
+
+func (r *batchFailureRecorder) fail(index int, nodeIndex int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := r.results[index]
+	if result.Failures == nil {
+		result.Failures = make(map[int]error)
+	}
+	result.Failures[nodeIndex] = err
+}
+
+// Runs the function for every index in [0, count) using the specified
+// number of workers
+func runWorkers(count int, workers int, f func(i int)) {
+	if workers > count {
+		workers = count
+	}
+	var wg sync.WaitGroup
+	indexes := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// Signs all the BLS-to-execution changes in parallel using a single domain
+// computation and submits them to all running nodes concurrently, in chunks
+// of the configured size.
+// The returned results contain the per-item acceptance parsed from the
+// indexed error responses of each beacon node.
+func (all Nodes) BatchSignSubmitBLSToExecutionChanges(
+	ctx context.Context,
+	blsToExecutionChanges []validator.BLSToExecutionChangeInfo,
+	cfg *BatchConfig,
+) (BatchResults, error) {
+	n, err := all.verificationNode()
+	if err != nil {
+		return nil, err
+	}
+	bn := n.BeaconClient
+	domain, err := bn.ComputeDomain(
+		ctx,
+		common.DOMAIN_BLS_TO_EXECUTION_CHANGE,
+		&bn.Config.Spec.GENESIS_FORK_VERSION,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sign all changes in parallel
+	var (
+		vcs      = all.ValidatorClients()
+		results  = make(BatchResults, len(blsToExecutionChanges))
+		signed   = make([]*common.SignedBLSToExecutionChange, len(blsToExecutionChanges))
+		recorder = &batchFailureRecorder{results: results}
+	)
+	runWorkers(len(blsToExecutionChanges), cfg.signingWorkers(), func(i int) {
+		c := blsToExecutionChanges[i]
+		results[i] = &BatchItemResult{
+			Index:          i,
+			ValidatorIndex: c.ValidatorIndex,
+		}
+		signed[i], results[i].SignError = vcs.SignBLSToExecutionChange(
+			domain,
+			c,
+		)
+	})
+
+	// Split the successfully signed changes in chunks
+	type chunk struct {
+		changes common.SignedBLSToExecutionChanges
+		indexes []int
+	}
+	var (
+		chunkSize = cfg.chunkSize()
+		chunks    = make([]*chunk, 0)
+		current   *chunk
+	)
+	for i, s := range signed {
+		if s == nil {
+			continue
+		}
+		if current == nil || len(current.changes) >= chunkSize {
+			current = &chunk{
+				changes: make(common.SignedBLSToExecutionChanges, 0, chunkSize),
+				indexes: make([]int, 0, chunkSize),
+			}
+			chunks = append(chunks, current)
+		}
+		current.changes = append(current.changes, *s)
+		current.indexes = append(current.indexes, i)
+	}
+
+	// Submit all chunks to every node concurrently
+	var wg sync.WaitGroup
+	for _, n := range all.Running() {
+		if n.BeaconClient == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(n *Node) {
+			defer wg.Done()
+			for _, c := range chunks {
+				failures, err := n.BeaconClient.SubmitPoolBLSToExecutionChangeIndexed(
+					ctx,
+					c.changes,
+				)
+				if err != nil {
+					n.Logf(
+						"Node %d rejected chunk of %d BLS-to-execution changes: %v",
+						n.Index,
+						len(c.changes),
+						err,
+					)
+					for _, i := range c.indexes {
+						recorder.fail(i, n.Index, err)
+					}
+					continue
+				}
+				for _, f := range failures {
+					if int(f.Index) >= len(c.indexes) {
+						n.Logf(
+							"Node %d returned failure for out-of-range index %d: %s",
+							n.Index,
+							f.Index,
+							f.Message,
+						)
+						continue
+					}
+					recorder.fail(
+						c.indexes[f.Index],
+						n.Index,
+						fmt.Errorf("%s", f.Message),
+					)
+				}
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	return results, ctx.Err()
+}
+
+// Signs voluntary exits for all the given validators in parallel using a
+// single domain computation and submits them to all running nodes
+// concurrently.
+func (all Nodes) BatchSignSubmitVoluntaryExits(
+	ctx context.Context,
+	epoch common.Epoch,
+	validatorIndexes []common.ValidatorIndex,
+	cfg *BatchConfig,
+) (BatchResults, error) {
+	n, err := all.verificationNode()
+	if err != nil {
+		return nil, err
+	}
+	// Exits are signed with the capella fork version from deneb on
+	// (EIP-7044), otherwise with the version of the current fork
+	var (
+		bn      = n.BeaconClient
+		version *common.Version
+	)
+	if bn.SlotClock().CurrentEpoch() >= bn.Config.Spec.DENEB_FORK_EPOCH {
+		version = &bn.Config.Spec.CAPELLA_FORK_VERSION
+	}
+	domain, err := bn.ComputeDomain(
+		ctx,
+		common.DOMAIN_VOLUNTARY_EXIT,
+		version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sign all exits in parallel
+	var (
+		vcs      = all.ValidatorClients()
+		results  = make(BatchResults, len(validatorIndexes))
+		signed   = make([]*phase0.SignedVoluntaryExit, len(validatorIndexes))
+		recorder = &batchFailureRecorder{results: results}
+	)
+	runWorkers(len(validatorIndexes), cfg.signingWorkers(), func(i int) {
+		validatorIndex := validatorIndexes[i]
+		results[i] = &BatchItemResult{
+			Index:          i,
+			ValidatorIndex: validatorIndex,
+		}
+		vc := vcs.ByValidatorIndex(validatorIndex)
+		if vc == nil {
+			results[i].SignError = fmt.Errorf(
+				"validator index %d not found",
+				validatorIndex,
+			)
+			return
+		}
+		signed[i], results[i].SignError = vc.SignVoluntaryExit(
+			domain,
+			epoch,
+			validatorIndex,
+		)
+	})
+
+	// The voluntary exit endpoint accepts a single item per request, so each
+	// node is fed by its own pool of submission workers
+	var wg sync.WaitGroup
+	for _, n := range all.Running() {
+		if n.BeaconClient == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(n *Node) {
+			defer wg.Done()
+			runWorkers(len(signed), cfg.submissionWorkers(), func(i int) {
+				if signed[i] == nil {
+					return
+				}
+				if err := n.BeaconClient.SubmitVoluntaryExit(
+					ctx,
+					signed[i],
+				); err != nil {
+					recorder.fail(i, n.Index, err)
+				}
+			})
+		}(n)
+	}
+	wg.Wait()
+
+	return results, ctx.Err()
+}
@@ -0,0 +1,283 @@
+/*
+Tests for the batch signing and submission helpers
+*/
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/marioevz/eth-clients/clients"
+	"github.com/marioevz/eth-clients/clients/beacon"
+	"github.com/marioevz/eth-clients/clients/execution"
+	"github.com/marioevz/eth-clients/clients/validator"
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// Checks the changes of a request and returns the failures of the items of
+// the request, or false to fail the whole request
+type testBLSToExecutionChangesCheck func(
+	changes []common.SignedBLSToExecutionChange,
+) ([]eth2api.IndexedErrorMessageItem, bool)
+
+// Returns a node whose beacon client, configured with the spec, is served by
+// the handler
+func testBatchNode(
+	t *testing.T,
+	index int,
+	spec *common.Spec,
+	handler http.HandlerFunc,
+) *Node {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	external, err := clients.ExternalClientFromURL(srv.URL, "test-bn")
+	if err != nil {
+		t.Fatalf("unable to create client: %v", err)
+	}
+	var (
+		genesisTime = common.Timestamp(0)
+		gvr         = tree.Root{}
+	)
+	bn := &beacon.BeaconClient{
+		Client: external,
+		Config: beacon.BeaconClientConfig{
+			ClientIndex:           index,
+			Spec:                  spec,
+			GenesisTime:           &genesisTime,
+			GenesisValidatorsRoot: &gvr,
+		},
+	}
+	if err := bn.Init(context.Background()); err != nil {
+		t.Fatalf("unable to init client: %v", err)
+	}
+	return &Node{
+		Index:           index,
+		ExecutionClient: &execution.ExecutionClient{Client: external},
+		BeaconClient:    bn,
+	}
+}
+
+func TestBatchSignSubmitBLSToExecutionChanges(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		chunks []int
+	)
+	handler := func(check testBLSToExecutionChangesCheck) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/eth/v1/beacon/pool/bls_to_execution_changes" {
+				http.NotFound(w, r)
+				return
+			}
+			var changes []common.SignedBLSToExecutionChange
+			if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+				t.Errorf("unable to decode request: %v", err)
+				return
+			}
+			failures, ok := check(changes)
+			switch {
+			case !ok:
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(eth2api.ErrorMessage{
+					CodeValue: http.StatusInternalServerError,
+					Message:   "internal error",
+				})
+			case len(failures) > 0:
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(eth2api.ErrorMessage{
+					CodeValue: http.StatusBadRequest,
+					Message:   "some changes failed",
+					Failures:  failures,
+				})
+			}
+		}
+	}
+
+	nodes := Nodes{
+		// Rejects the changes of odd validator indexes
+		testBatchNode(t, 0, configs.Minimal, handler(func(
+			changes []common.SignedBLSToExecutionChange,
+		) ([]eth2api.IndexedErrorMessageItem, bool) {
+			mu.Lock()
+			chunks = append(chunks, len(changes))
+			mu.Unlock()
+			failures := make([]eth2api.IndexedErrorMessageItem, 0)
+			for i, c := range changes {
+				if c.BLSToExecutionChange.ValidatorIndex%2 == 1 {
+					failures = append(failures, eth2api.IndexedErrorMessageItem{
+						Index:   uint(i),
+						Message: "odd validator",
+					})
+				}
+			}
+			return failures, true
+		})),
+		// Fails the whole request that contains validator 4
+		testBatchNode(t, 1, configs.Minimal, handler(func(
+			changes []common.SignedBLSToExecutionChange,
+		) ([]eth2api.IndexedErrorMessageItem, bool) {
+			for _, c := range changes {
+				if c.BLSToExecutionChange.ValidatorIndex == 4 {
+					return nil, false
+				}
+			}
+			return nil, true
+		})),
+	}
+	keys := make(map[common.ValidatorIndex]*validator.ValidatorKeys)
+	for i := common.ValidatorIndex(0); i < 7; i++ {
+		k := &validator.ValidatorKeys{}
+		k.WithdrawalSecretKey[31] = byte(i + 1)
+		keys[i] = k
+	}
+	nodes[0].ValidatorClient = &validator.ValidatorClient{Keys: keys}
+
+	// Validator 99 is unknown and can't be signed
+	changes := make([]validator.BLSToExecutionChangeInfo, 0)
+	for _, i := range []common.ValidatorIndex{0, 1, 99, 2, 3, 4, 5, 6} {
+		changes = append(changes, validator.BLSToExecutionChangeInfo{
+			ValidatorIndex: i,
+			Eth1Address:    common.Eth1Address{byte(i)},
+		})
+	}
+	results, err := nodes.BatchSignSubmitBLSToExecutionChanges(
+		context.Background(),
+		changes,
+		&BatchConfig{ChunkSize: 3},
+	)
+	if err != nil {
+		t.Fatalf("unable to submit changes: %v", err)
+	}
+
+	if len(chunks) != 3 || chunks[0] != 3 || chunks[1] != 3 || chunks[2] != 1 {
+		t.Fatalf("incorrect chunks: %v", chunks)
+	}
+	for i, want := range []map[int]bool{
+		{},
+		{0: true},
+		nil,
+		{},
+		{0: true, 1: true},
+		{1: true},
+		{0: true, 1: true},
+		{},
+	} {
+		r := results[i]
+		if r.Index != i || r.ValidatorIndex != changes[i].ValidatorIndex {
+			t.Fatalf("result %d: incorrect item: %+v", i, r)
+		}
+		if want == nil {
+			if r.SignError == nil || r.Accepted() {
+				t.Fatalf("result %d: unsigned change accepted", i)
+			}
+			continue
+		}
+		if len(r.Failures) != len(want) {
+			t.Fatalf("result %d: incorrect failures: %v", i, r.Failures)
+		}
+		for nodeIndex := range want {
+			if r.Failures[nodeIndex] == nil {
+				t.Fatalf("result %d: failure of node %d missing: %v", i, nodeIndex, r.Failures)
+			}
+		}
+		if r.Accepted() != (len(want) == 0) {
+			t.Fatalf("result %d: incorrect acceptance", i)
+		}
+	}
+	if rejected := results.Rejected(); len(rejected) != 5 {
+		t.Fatalf("incorrect number of rejected changes: %d", len(rejected))
+	}
+}
+
+func TestBatchSignSubmitVoluntaryExitsDomain(t *testing.T) {
+	bellatrixSpec := *configs.Minimal
+	bellatrixSpec.ALTAIR_FORK_EPOCH = 0
+	bellatrixSpec.BELLATRIX_FORK_EPOCH = 0
+	bellatrixSpec.CAPELLA_FORK_EPOCH = common.FAR_FUTURE_EPOCH
+	bellatrixSpec.DENEB_FORK_EPOCH = common.FAR_FUTURE_EPOCH
+	denebSpec := bellatrixSpec
+	denebSpec.CAPELLA_FORK_EPOCH = 0
+	denebSpec.DENEB_FORK_EPOCH = 1
+
+	for _, test := range []struct {
+		name    string
+		spec    *common.Spec
+		version common.Version
+	}{
+		{"bellatrix", &bellatrixSpec, bellatrixSpec.BELLATRIX_FORK_VERSION},
+		{"deneb", &denebSpec, denebSpec.CAPELLA_FORK_VERSION},
+	} {
+		var (
+			mu        sync.Mutex
+			submitted []phase0.SignedVoluntaryExit
+		)
+		n := testBatchNode(t, 0, test.spec, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/eth/v1/beacon/pool/voluntary_exits" {
+				http.NotFound(w, r)
+				return
+			}
+			var exit phase0.SignedVoluntaryExit
+			if err := json.NewDecoder(r.Body).Decode(&exit); err != nil {
+				t.Errorf("unable to decode request: %v", err)
+				return
+			}
+			mu.Lock()
+			submitted = append(submitted, exit)
+			mu.Unlock()
+		})
+		keys := make(map[common.ValidatorIndex]*validator.ValidatorKeys)
+		for i := common.ValidatorIndex(0); i < 2; i++ {
+			k := &validator.ValidatorKeys{}
+			k.ValidatorSecretKey[31] = byte(i + 1)
+			keys[i] = k
+		}
+		vc := &validator.ValidatorClient{Keys: keys}
+		n.ValidatorClient = vc
+
+		results, err := Nodes{n}.BatchSignSubmitVoluntaryExits(
+			context.Background(),
+			0,
+			[]common.ValidatorIndex{0, 1},
+			nil,
+		)
+		if err != nil {
+			t.Fatalf("%s: unable to submit exits: %v", test.name, err)
+		}
+		if rejected := results.Rejected(); len(rejected) != 0 {
+			t.Fatalf("%s: exits rejected: %v", test.name, rejected)
+		}
+		if len(submitted) != 2 {
+			t.Fatalf("%s: incorrect number of submitted exits: %d", test.name, len(submitted))
+		}
+		domain := common.ComputeDomain(
+			common.DOMAIN_VOLUNTARY_EXIT,
+			test.version,
+			tree.Root{},
+		)
+		for _, exit := range submitted {
+			expected, err := vc.SignVoluntaryExit(
+				domain,
+				exit.Message.Epoch,
+				exit.Message.ValidatorIndex,
+			)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			if exit.Signature != expected.Signature {
+				t.Fatalf(
+					"%s: exit of validator %d not signed with version %s",
+					test.name,
+					exit.Message.ValidatorIndex,
+					test.version,
+				)
+			}
+		}
+	}
+}
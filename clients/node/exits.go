@@ -0,0 +1,363 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/marioevz/eth-clients/clients/beacon"
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// Order in which the statuses of an unslashed exiting validator are expected
+// to be observed
+var exitStatusOrder = map[eth2api.ValidatorStatus]int{
+	beacon.ValidatorStatusActiveOngoing:      0,
+	beacon.ValidatorStatusActiveExiting:      1,
+	beacon.ValidatorStatusExitedUnslashed:    2,
+	beacon.ValidatorStatusWithdrawalPossible: 3,
+	beacon.ValidatorStatusWithdrawalDone:     4,
+}
+
+// Lifecycle information of a single exiting validator
+type ValidatorExit struct {
+	ValidatorIndex common.ValidatorIndex
+	// Epochs calculated at the moment of submission using the churn limit
+	ExpectedExitEpoch         common.Epoch
+	ExpectedWithdrawableEpoch common.Epoch
+	// Epochs as recorded by the beacon node once the exit was processed
+	ExitEpoch         *common.Epoch
+	WithdrawableEpoch *common.Epoch
+	// Latest observed status and the slot at which each status was first
+	// observed
+	Status      eth2api.ValidatorStatus
+	StatusSlots map[eth2api.ValidatorStatus]common.Slot
+	LastBalance common.Gwei
+	// Full withdrawal found in an execution payload
+	WithdrawalSlot   *common.Slot
+	WithdrawalAmount common.Gwei
+	// List of deviations from the expected lifecycle
+	Deviations []string
+
+	missingWithdrawalReported bool
+}
+
+func (e *ValidatorExit) deviation(format string, values ...interface{}) {
+	e.Deviations = append(e.Deviations, fmt.Sprintf(format, values...))
+}
+
+// Returns true if the full withdrawal of the validator has been verified
+func (e *ValidatorExit) Done() bool {
+	return e.Status == beacon.ValidatorStatusWithdrawalDone &&
+		e.WithdrawalSlot != nil
+}
+
+// Updates the exit with the validator as seen in the state of the head slot.
+// Returns the first slot at which the full withdrawal of the validator can be
+// included, or nil if it is not known yet or already found.
+func (e *ValidatorExit) update(
+	spec *common.Spec,
+	headSlot common.Slot,
+	v *eth2api.ValidatorResponse,
+) *common.Slot {
+	if v.Status != e.Status {
+		if _, ok := e.StatusSlots[v.Status]; !ok {
+			e.StatusSlots[v.Status] = headSlot
+		}
+		prevOrder, prevOk := exitStatusOrder[e.Status]
+		nextOrder, nextOk := exitStatusOrder[v.Status]
+		if !nextOk {
+			e.deviation(
+				"slot %d: unexpected status %s",
+				headSlot,
+				v.Status,
+			)
+		} else if prevOk && nextOrder < prevOrder {
+			e.deviation(
+				"slot %d: status regressed from %s to %s",
+				headSlot,
+				e.Status,
+				v.Status,
+			)
+		}
+		e.Status = v.Status
+	}
+	if v.Validator.ExitEpoch != common.FAR_FUTURE_EPOCH &&
+		e.ExitEpoch == nil {
+		exitEpoch := v.Validator.ExitEpoch
+		withdrawableEpoch := v.Validator.WithdrawableEpoch
+		e.ExitEpoch = &exitEpoch
+		e.WithdrawableEpoch = &withdrawableEpoch
+		if exitEpoch != e.ExpectedExitEpoch {
+			e.deviation(
+				"unexpected exit epoch: want %d, got %d",
+				e.ExpectedExitEpoch,
+				exitEpoch,
+			)
+		}
+		if withdrawableEpoch != e.ExpectedWithdrawableEpoch {
+			e.deviation(
+				"unexpected withdrawable epoch: want %d, got %d",
+				e.ExpectedWithdrawableEpoch,
+				withdrawableEpoch,
+			)
+		}
+	}
+	if v.Status != beacon.ValidatorStatusWithdrawalDone {
+		e.LastBalance = v.Balance
+	}
+	if e.WithdrawableEpoch == nil || e.WithdrawalSlot != nil {
+		return nil
+	}
+	startSlot := spec.SLOTS_PER_EPOCH * common.Slot(*e.WithdrawableEpoch)
+	return &startSlot
+}
+
+// Tracks the exit lifecycle of a set of validators after their voluntary
+// exits were submitted.
+type ExitTracker struct {
+	Nodes Nodes
+	Exits map[common.ValidatorIndex]*ValidatorExit
+
+	// Next slot to be scanned for full withdrawals
+	nextWithdrawalSlot *common.Slot
+	mu                 sync.Mutex
+}
+
+// Creates a tracker for the given validators, computing the expected exit
+// epochs from the churn limit of the current head state.
+// The expected epochs assume the exits are included in the current epoch in
+// the given order.
+func (all Nodes) NewExitTracker(
+	ctx context.Context,
+	validatorIndexes []common.ValidatorIndex,
+) (*ExitTracker, error) {
+	n, err := all.verificationNode()
+	if err != nil {
+		return nil, err
+	}
+	bn := n.BeaconClient
+	state, err := bn.BeaconStateV2(ctx, eth2api.StateHead)
+	if err != nil {
+		return nil, err
+	}
+	exitEpochs, err := state.ExpectedExitEpochs(validatorIndexes)
+	if err != nil {
+		return nil, err
+	}
+	t := &ExitTracker{
+		Nodes: all,
+		Exits: make(map[common.ValidatorIndex]*ValidatorExit),
+	}
+	for validatorIndex, exitEpoch := range exitEpochs {
+		t.Exits[validatorIndex] = &ValidatorExit{
+			ValidatorIndex:            validatorIndex,
+			ExpectedExitEpoch:         exitEpoch,
+			ExpectedWithdrawableEpoch: exitEpoch + bn.Config.Spec.MIN_VALIDATOR_WITHDRAWABILITY_DELAY,
+			StatusSlots:               make(map[eth2api.ValidatorStatus]common.Slot),
+		}
+	}
+	return t, nil
+}
+
+// Signs and submits the voluntary exits of the given validators, and returns
+// a tracker for their lifecycle
+func (all Nodes) SignSubmitTrackVoluntaryExits(
+	ctx context.Context,
+	epoch common.Epoch,
+	validatorIndexes []common.ValidatorIndex,
+) (*ExitTracker, error) {
+	t, err := all.NewExitTracker(ctx, validatorIndexes)
+	if err != nil {
+		return nil, err
+	}
+	for _, validatorIndex := range validatorIndexes {
+		n := all.ByValidatorIndex(validatorIndex)
+		if n == nil {
+			return nil, fmt.Errorf(
+				"validator index %d not found",
+				validatorIndex,
+			)
+		}
+		if err := n.SignSubmitVoluntaryExit(
+			ctx,
+			epoch,
+			validatorIndex,
+		); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// Polls the head state once and updates the lifecycle of all tracked
+// validators
+func (t *ExitTracker) Update(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n, err := t.Nodes.verificationNode()
+	if err != nil {
+		return err
+	}
+	bn := n.BeaconClient
+	headInfo, err := bn.BlockHeader(ctx, eth2api.BlockHead)
+	if err != nil {
+		return err
+	}
+	headSlot := headInfo.Header.Message.Slot
+
+	ids := make([]eth2api.ValidatorId, 0, len(t.Exits))
+	for validatorIndex := range t.Exits {
+		ids = append(ids, eth2api.ValidatorIdIndex(validatorIndex))
+	}
+	resp, err := bn.StateValidators(
+		ctx,
+		eth2api.StateIdRoot(headInfo.Header.Message.StateRoot),
+		ids,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	var withdrawalScanFrom *common.Slot
+	for i := range resp {
+		e, ok := t.Exits[resp[i].Index]
+		if !ok {
+			continue
+		}
+		startSlot := e.update(bn.Config.Spec, headSlot, &resp[i])
+		if startSlot != nil &&
+			(withdrawalScanFrom == nil || *startSlot < *withdrawalScanFrom) {
+			withdrawalScanFrom = startSlot
+		}
+	}
+
+	if withdrawalScanFrom == nil {
+		return nil
+	}
+	if t.nextWithdrawalSlot != nil &&
+		*t.nextWithdrawalSlot > *withdrawalScanFrom {
+		withdrawalScanFrom = t.nextWithdrawalSlot
+	}
+	for slot := *withdrawalScanFrom; slot <= headSlot; slot++ {
+		versionedBlock, err := bn.BlockV2(ctx, eth2api.BlockIdSlot(slot))
+		if errors.Is(err, beacon.NotFound) {
+			// Missed slot
+			continue
+		} else if err != nil {
+			return err
+		}
+		withdrawals, err := versionedBlock.Withdrawals()
+		if err != nil {
+			return err
+		}
+		t.processWithdrawals(bn.Config.Spec, slot, withdrawals)
+	}
+	next := headSlot + 1
+	t.nextWithdrawalSlot = &next
+
+	for _, e := range t.Exits {
+		if e.Status == beacon.ValidatorStatusWithdrawalDone &&
+			e.WithdrawalSlot == nil && !e.missingWithdrawalReported {
+			e.missingWithdrawalReported = true
+			e.deviation(
+				"slot %d: status is %s but no full withdrawal was found",
+				headSlot,
+				e.Status,
+			)
+		}
+	}
+	return nil
+}
+
+// Records the full withdrawals of the tracked validators included in the
+// block of the given slot
+func (t *ExitTracker) processWithdrawals(
+	spec *common.Spec,
+	slot common.Slot,
+	withdrawals common.Withdrawals,
+) {
+	for _, w := range withdrawals {
+		e, ok := t.Exits[w.ValidatorIndex]
+		if !ok || e.WithdrawalSlot != nil || e.WithdrawableEpoch == nil {
+			continue
+		}
+		if spec.SlotToEpoch(slot) < *e.WithdrawableEpoch {
+			// Partial withdrawal before the validator is withdrawable
+			continue
+		}
+		withdrawalSlot := slot
+		e.WithdrawalSlot = &withdrawalSlot
+		e.WithdrawalAmount = w.Amount
+		if w.Amount != e.LastBalance {
+			e.deviation(
+				"slot %d: full withdrawal amount mismatch: want %d, got %d",
+				slot,
+				e.LastBalance,
+				w.Amount,
+			)
+		}
+	}
+}
+
+// Returns true when all tracked validators have been fully withdrawn
+func (t *ExitTracker) Done() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, e := range t.Exits {
+		if !e.Done() {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns all deviations from the expected lifecycle, keyed by validator
+// index
+func (t *ExitTracker) Deviations() map[common.ValidatorIndex][]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := make(map[common.ValidatorIndex][]string)
+	for validatorIndex, e := range t.Exits {
+		if len(e.Deviations) > 0 {
+			res[validatorIndex] = e.Deviations
+		}
+	}
+	return res
+}
+
+// Updates the tracker every slot until all validators are fully withdrawn
+// or the context is done. Returns an error if any deviation was found.
+func (t *ExitTracker) WaitForWithdrawalDone(ctx context.Context) error {
+	n, err := t.Nodes.verificationNode()
+	if err != nil {
+		return err
+	}
+	timer := n.BeaconClient.NewSlotTicker()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C():
+			if err := t.Update(ctx); err != nil {
+				n.Logf("ExitTracker: update failed: %v", err)
+				continue
+			}
+			if t.Done() {
+				if deviations := t.Deviations(); len(deviations) > 0 {
+					return fmt.Errorf(
+						"exit lifecycle deviations found: %v",
+						deviations,
+					)
+				}
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Tests for the voluntary exit lifecycle tracker
+*/
+package node
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/marioevz/eth-clients/clients/beacon"
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func testValidatorResponse(
+	index common.ValidatorIndex,
+	status eth2api.ValidatorStatus,
+	exitEpoch common.Epoch,
+	withdrawableEpoch common.Epoch,
+	balance common.Gwei,
+) *eth2api.ValidatorResponse {
+	return &eth2api.ValidatorResponse{
+		Index:   index,
+		Balance: balance,
+		Status:  status,
+		Validator: phase0.Validator{
+			ExitEpoch:         exitEpoch,
+			WithdrawableEpoch: withdrawableEpoch,
+		},
+	}
+}
+
+func TestExitTrackerExitEpochs(t *testing.T) {
+	var (
+		spec        = configs.Minimal
+		delay       = spec.MIN_VALIDATOR_WITHDRAWABILITY_DELAY
+		exitEpoch   = common.Epoch(5)
+		withdrawal  = exitEpoch + delay
+		balance     = common.Gwei(32_000_000_000)
+		tracker     = &ExitTracker{Exits: make(map[common.ValidatorIndex]*ValidatorExit)}
+		far         = common.FAR_FUTURE_EPOCH
+		withdrawals = func(slot common.Slot, amount common.Gwei) {
+			tracker.processWithdrawals(spec, slot, common.Withdrawals{
+				{ValidatorIndex: 0, Amount: amount},
+				{ValidatorIndex: 1, Amount: amount},
+			})
+		}
+	)
+	for i := common.ValidatorIndex(0); i < 2; i++ {
+		tracker.Exits[i] = &ValidatorExit{
+			ValidatorIndex:            i,
+			ExpectedExitEpoch:         exitEpoch,
+			ExpectedWithdrawableEpoch: withdrawal,
+			StatusSlots:               make(map[eth2api.ValidatorStatus]common.Slot),
+		}
+	}
+	var (
+		expected = tracker.Exits[0]
+		late     = tracker.Exits[1]
+	)
+
+	// Exit not processed yet
+	if start := expected.update(spec, 1, testValidatorResponse(
+		0, beacon.ValidatorStatusActiveOngoing, far, far, balance,
+	)); start != nil || expected.ExitEpoch != nil {
+		t.Fatalf("withdrawal expected before the exit is processed")
+	}
+
+	// Exit processed at the expected epoch
+	start := expected.update(spec, 2, testValidatorResponse(
+		0, beacon.ValidatorStatusActiveExiting, exitEpoch, withdrawal, balance,
+	))
+	if start == nil || *start != spec.SLOTS_PER_EPOCH*common.Slot(withdrawal) {
+		t.Fatalf("incorrect withdrawal scan start: %v", start)
+	}
+	if expected.ExitEpoch == nil || *expected.ExitEpoch != exitEpoch ||
+		expected.StatusSlots[beacon.ValidatorStatusActiveExiting] != 2 {
+		t.Fatalf("exit not recorded: %+v", expected)
+	}
+	if len(expected.Deviations) != 0 {
+		t.Fatalf("unexpected deviations: %v", expected.Deviations)
+	}
+
+	// Exit processed an epoch later than expected, which is only reported
+	// once
+	for slot := common.Slot(2); slot < 4; slot++ {
+		late.update(spec, slot, testValidatorResponse(
+			1, beacon.ValidatorStatusActiveExiting, exitEpoch+1, withdrawal+1, balance,
+		))
+	}
+	if len(late.Deviations) != 2 ||
+		late.Deviations[0] != "unexpected exit epoch: want 5, got 6" ||
+		!strings.HasPrefix(late.Deviations[1], "unexpected withdrawable epoch") {
+		t.Fatalf("incorrect deviations: %v", late.Deviations)
+	}
+
+	// Status regression
+	expected.update(spec, 3, testValidatorResponse(
+		0, beacon.ValidatorStatusExitedUnslashed, exitEpoch, withdrawal, balance,
+	))
+	expected.update(spec, 4, testValidatorResponse(
+		0, beacon.ValidatorStatusActiveExiting, exitEpoch, withdrawal, balance,
+	))
+	if len(expected.Deviations) != 1 ||
+		!strings.Contains(expected.Deviations[0], "status regressed") {
+		t.Fatalf("incorrect deviations: %v", expected.Deviations)
+	}
+	expected.Deviations = nil
+
+	// Withdrawals before the withdrawable epoch are partial withdrawals
+	withdrawals(spec.SLOTS_PER_EPOCH*common.Slot(withdrawal)-1, 1)
+	if expected.WithdrawalSlot != nil || late.WithdrawalSlot != nil {
+		t.Fatalf("partial withdrawal recorded as full withdrawal")
+	}
+
+	// Full withdrawal of the whole balance, which is only reached by the
+	// validator that exited at the expected epoch
+	fullSlot := spec.SLOTS_PER_EPOCH * common.Slot(withdrawal)
+	withdrawals(fullSlot, balance)
+	if expected.WithdrawalSlot == nil || *expected.WithdrawalSlot != fullSlot ||
+		expected.WithdrawalAmount != balance {
+		t.Fatalf("full withdrawal not recorded: %+v", expected)
+	}
+	if len(expected.Deviations) != 0 {
+		t.Fatalf("unexpected deviations: %v", expected.Deviations)
+	}
+	if late.WithdrawalSlot != nil {
+		t.Fatalf("full withdrawal recorded before the withdrawable epoch")
+	}
+	if start := expected.update(spec, fullSlot, testValidatorResponse(
+		0, beacon.ValidatorStatusWithdrawalDone, exitEpoch, withdrawal, 0,
+	)); start != nil || !expected.Done() {
+		t.Fatalf("withdrawal not done: %+v", expected)
+	}
+
+	// Full withdrawal that does not match the last balance
+	withdrawals(fullSlot+spec.SLOTS_PER_EPOCH, balance-1)
+	if len(late.Deviations) != 3 ||
+		!strings.Contains(late.Deviations[2], "full withdrawal amount mismatch") {
+		t.Fatalf("incorrect deviations: %v", late.Deviations)
+	}
+}
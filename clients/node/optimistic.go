@@ -0,0 +1,434 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	api "github.com/ethereum/go-ethereum/beacon/engine"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/marioevz/eth-clients/clients/execution"
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/tree"
+	"github.com/rauljordan/engine-proxy/proxy"
+)
+
+// Blocks involved in an optimistic sync scenario
+type OptimisticSyncResult struct {
+	// Last block imported before the execution client started syncing
+	LatestValidRoot tree.Root
+	LatestValidHash ethcommon.Hash
+	LatestValidSlot common.Slot
+	// Blocks imported while the execution client was syncing, in ascending
+	// slot order
+	OptimisticRoots  []tree.Root
+	OptimisticHashes []ethcommon.Hash
+}
+
+func (r *OptimisticSyncResult) isOptimisticRoot(root tree.Root) bool {
+	for _, r := range r.OptimisticRoots {
+		if r == root {
+			return true
+		}
+	}
+	return false
+}
+
+// Response fields of a payload status, as expected by the proxy
+func payloadStatusFields(status *api.PayloadStatusV1) map[string]interface{} {
+	return map[string]interface{}{
+		"status":          status.Status,
+		"latestValidHash": status.LatestValidHash,
+		"validationError": status.ValidationError,
+	}
+}
+
+// Pushes a response callback on every newPayload and forkchoiceUpdated
+// method of the proxy of the node, on top of any previously installed
+// callback.
+// Returns the function that removes only this callback.
+func (n *Node) spoofEngineResponses(
+	callback func(method string, res []byte, req []byte) *proxy.Spoof,
+) (func(), error) {
+	if n.ExecutionClient == nil || n.ExecutionClient.Proxy() == nil {
+		return nil, fmt.Errorf("node %d has no execution client proxy", n.Index)
+	}
+	methods := make([]string, 0)
+	methods = append(methods, execution.AllNewPayloadCalls...)
+	methods = append(methods, execution.AllForkchoiceUpdatedCalls...)
+	return n.ExecutionClient.Proxy().PushResponseCallback(callback, methods...), nil
+}
+
+// Makes the proxy of the node respond SYNCING to every newPayload and
+// forkchoiceUpdated call of the beacon client, regardless of the response
+// of the execution client.
+// Returns the function that releases the execution client.
+func (n *Node) SpoofExecutionSyncing() (func(), error) {
+	syncing := &api.PayloadStatusV1{Status: api.SYNCING}
+	return n.spoofEngineResponses(
+		func(method string, res []byte, req []byte) *proxy.Spoof {
+			fields := payloadStatusFields(syncing)
+			if strings.HasPrefix(method, "engine_forkchoiceUpdated") {
+				fields = map[string]interface{}{
+					"payloadStatus": fields,
+					"payloadId":     nil,
+				}
+			}
+			return &proxy.Spoof{
+				Method: method,
+				Fields: fields,
+			}
+		},
+	)
+}
+
+// Makes the proxy of the node respond INVALID, with the given latest valid
+// hash, to every newPayload and forkchoiceUpdated call that involves one of
+// the invalid payloads or any of their descendants
+func (n *Node) SpoofInvalidPayloads(
+	latestValidHash ethcommon.Hash,
+	invalidHashes ...ethcommon.Hash,
+) (func(), error) {
+	var (
+		invalid = make(map[ethcommon.Hash]bool)
+		mu      sync.Mutex
+	)
+	for _, h := range invalidHashes {
+		invalid[h] = true
+	}
+	validationError := "payload spoofed as invalid"
+	status := &api.PayloadStatusV1{
+		Status:          api.INVALID,
+		LatestValidHash: &latestValidHash,
+		ValidationError: &validationError,
+	}
+	return n.spoofEngineResponses(
+		func(method string, res []byte, req []byte) *proxy.Spoof {
+			mu.Lock()
+			defer mu.Unlock()
+			if strings.HasPrefix(method, "engine_forkchoiceUpdated") {
+				var fcState api.ForkchoiceStateV1
+				if err := execution.UnmarshalFromJsonRPCRequest(req, &fcState); err != nil {
+					return nil
+				}
+				if !invalid[fcState.HeadBlockHash] {
+					return nil
+				}
+				return &proxy.Spoof{
+					Method: method,
+					Fields: map[string]interface{}{
+						"payloadStatus": payloadStatusFields(status),
+						"payloadId":     nil,
+					},
+				}
+			}
+			var payload api.ExecutableData
+			if err := execution.UnmarshalFromJsonRPCRequest(req, &payload); err != nil {
+				return nil
+			}
+			if !invalid[payload.BlockHash] && !invalid[payload.ParentHash] {
+				return nil
+			}
+			// Descendants of invalid payloads are invalid too
+			invalid[payload.BlockHash] = true
+			return &proxy.Spoof{
+				Method: method,
+				Fields: payloadStatusFields(status),
+			}
+		},
+	)
+}
+
+// Polls the head of the beacon client once per slot until it reaches the
+// given slot
+func (n *Node) waitForHeadSlot(
+	ctx context.Context,
+	slot common.Slot,
+) (*eth2api.BeaconBlockHeaderAndInfo, error) {
+	bn := n.BeaconClient
+	ticker := bn.NewSlotTicker()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C():
+			head, err := bn.BlockHeader(ctx, eth2api.BlockHead)
+			if err != nil {
+				continue
+			}
+			if head.Header.Message.Slot >= slot {
+				return head, nil
+			}
+		}
+	}
+}
+
+// Returns the roots of the chain of blocks from the given block down to,
+// and excluding, the given ancestor slot, in ascending slot order
+func (n *Node) chainSince(
+	ctx context.Context,
+	root tree.Root,
+	ancestorSlot common.Slot,
+) ([]tree.Root, error) {
+	roots := make([]tree.Root, 0)
+	for {
+		header, err := n.BeaconClient.BlockHeader(ctx, eth2api.BlockIdRoot(root))
+		if err != nil {
+			return nil, err
+		}
+		if header.Header.Message.Slot <= ancestorSlot {
+			break
+		}
+		roots = append([]tree.Root{root}, roots...)
+		root = header.Header.Message.ParentRoot
+	}
+	return roots, nil
+}
+
+// Makes the execution client of the node appear to be syncing for the given
+// number of slots and verifies that the beacon client imports the blocks of
+// that period optimistically
+func (n *Node) startOptimisticSync(
+	ctx context.Context,
+	slots common.Slot,
+) (result *OptimisticSyncResult, release func(), err error) {
+	bn := n.BeaconClient
+	head, err := bn.BlockHeader(ctx, eth2api.BlockHead)
+	if err != nil {
+		return nil, nil, err
+	}
+	if optimistic, err := bn.BlockIsOptimistic(
+		ctx,
+		eth2api.BlockIdRoot(head.Root),
+	); err != nil {
+		return nil, nil, err
+	} else if optimistic {
+		return nil, nil, fmt.Errorf(
+			"node %d: head %s is already optimistic",
+			n.Index,
+			head.Root,
+		)
+	}
+	result = &OptimisticSyncResult{
+		LatestValidRoot: head.Root,
+		LatestValidSlot: head.Header.Message.Slot,
+	}
+	latestValid, err := bn.BlockV2(ctx, eth2api.BlockIdRoot(head.Root))
+	if err != nil {
+		return nil, nil, err
+	}
+	if h := latestValid.ExecutionPayloadBlockHash(); h != nil {
+		result.LatestValidHash = ethcommon.Hash(*h)
+	}
+
+	n.Logf(
+		"Node %d: execution client syncing from slot %d for %d slots",
+		n.Index,
+		result.LatestValidSlot,
+		slots,
+	)
+	releaseSyncing, err := n.SpoofExecutionSyncing()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		// Release the execution client if the scenario could not start
+		if err != nil {
+			releaseSyncing()
+		}
+	}()
+	head, err = n.waitForHeadSlot(ctx, result.LatestValidSlot+slots)
+	if err != nil {
+		return nil, nil, err
+	}
+	if result.OptimisticRoots, err = n.chainSince(
+		ctx,
+		head.Root,
+		result.LatestValidSlot,
+	); err != nil {
+		return nil, nil, err
+	}
+	for _, root := range result.OptimisticRoots {
+		optimistic, err := bn.BlockIsOptimistic(ctx, eth2api.BlockIdRoot(root))
+		if err != nil {
+			return nil, nil, err
+		}
+		if !optimistic {
+			return nil, nil, fmt.Errorf(
+				"node %d: block %s imported while syncing is not optimistic",
+				n.Index,
+				root,
+			)
+		}
+		block, err := bn.BlockV2(ctx, eth2api.BlockIdRoot(root))
+		if err != nil {
+			return nil, nil, err
+		}
+		var h ethcommon.Hash
+		if blockHash := block.ExecutionPayloadBlockHash(); blockHash != nil {
+			h = ethcommon.Hash(*blockHash)
+		}
+		result.OptimisticHashes = append(result.OptimisticHashes, h)
+	}
+	if len(result.OptimisticRoots) == 0 {
+		return nil, nil, fmt.Errorf(
+			"node %d: no blocks imported while syncing",
+			n.Index,
+		)
+	}
+	return result, releaseSyncing, nil
+}
+
+// Puts the execution client of the node into SYNCING, through its proxy, for
+// the given number of slots, and verifies that the beacon client marks the
+// blocks of that period as optimistic.
+// The execution client is then released, and the blocks are verified to
+// transition back to non-optimistic.
+func (n *Node) OptimisticSyncScenario(
+	ctx context.Context,
+	slots common.Slot,
+) (*OptimisticSyncResult, error) {
+	result, release, err := n.startOptimisticSync(ctx, slots)
+	if err != nil {
+		return nil, err
+	}
+	release()
+	n.Logf("Node %d: execution client released", n.Index)
+
+	if _, err := n.BeaconClient.WaitForOptimisticState(
+		ctx,
+		eth2api.BlockHead,
+		false,
+	); err != nil {
+		return result, err
+	}
+	for _, root := range result.OptimisticRoots {
+		optimistic, err := n.BeaconClient.BlockIsOptimistic(
+			ctx,
+			eth2api.BlockIdRoot(root),
+		)
+		if err != nil {
+			return result, err
+		}
+		if optimistic {
+			return result, fmt.Errorf(
+				"node %d: block %s still optimistic after sync",
+				n.Index,
+				root,
+			)
+		}
+	}
+	return result, nil
+}
+
+// Returns the roots that the beacon client still serves as part of its
+// canonical chain. Blocks that are not found are considered removed.
+func (n *Node) canonicalRoots(
+	ctx context.Context,
+	roots []tree.Root,
+) []tree.Root {
+	canonical := make([]tree.Root, 0)
+	for _, root := range roots {
+		header, err := n.BeaconClient.BlockHeader(ctx, eth2api.BlockIdRoot(root))
+		if err != nil {
+			continue
+		}
+		if header.Canonical {
+			canonical = append(canonical, root)
+		}
+	}
+	return canonical
+}
+
+// Puts the execution client of the node into SYNCING, through its proxy, for
+// the given number of slots, and then responds INVALID to the payloads
+// imported optimistically, with the latest valid hash pointing to the last
+// block imported before syncing.
+// Verifies that the beacon client invalidates every descendant of the latest
+// valid hash, which must be no longer served as canonical, and moves its head
+// to a chain that does not include them.
+func (n *Node) OptimisticInvalidChainScenario(
+	ctx context.Context,
+	slots common.Slot,
+) (*OptimisticSyncResult, error) {
+	result, release, err := n.startOptimisticSync(ctx, slots)
+	if err != nil {
+		return nil, err
+	}
+	// Respond INVALID before releasing the execution client, otherwise its
+	// VALID responses could reach the beacon client in between
+	restore, err := n.SpoofInvalidPayloads(
+		result.LatestValidHash,
+		result.OptimisticHashes...,
+	)
+	if err != nil {
+		release()
+		return result, err
+	}
+	defer restore()
+	release()
+	n.Logf(
+		"Node %d: %d optimistic payloads invalidated, latest valid hash %s",
+		n.Index,
+		len(result.OptimisticHashes),
+		result.LatestValidHash,
+	)
+
+	var (
+		bn     = n.BeaconClient
+		ticker = bn.NewSlotTicker()
+		// Last condition not met, reported on timeout
+		pending = fmt.Errorf("head not checked")
+	)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return result, fmt.Errorf(
+				"node %d: invalid chain not removed: %v",
+				n.Index,
+				pending,
+			)
+		case <-ticker.C():
+			head, err := bn.BlockHeader(ctx, eth2api.BlockHead)
+			if err != nil {
+				pending = err
+				continue
+			}
+			chain, err := n.chainSince(ctx, head.Root, result.LatestValidSlot)
+			if err != nil {
+				pending = err
+				continue
+			}
+			invalidAncestor := false
+			for _, root := range chain {
+				if result.isOptimisticRoot(root) {
+					invalidAncestor = true
+					break
+				}
+			}
+			if invalidAncestor {
+				pending = fmt.Errorf("head %s descends from an invalid block", head.Root)
+				continue
+			}
+			if optimistic, err := bn.BlockIsOptimistic(
+				ctx,
+				eth2api.BlockIdRoot(head.Root),
+			); err != nil || optimistic {
+				pending = fmt.Errorf("head %s is optimistic", head.Root)
+				continue
+			}
+			if canonical := n.canonicalRoots(
+				ctx,
+				result.OptimisticRoots,
+			); len(canonical) > 0 {
+				pending = fmt.Errorf("invalid blocks still canonical: %v", canonical)
+				continue
+			}
+			return result, nil
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/marioevz/eth-clients/clients"
+	"github.com/marioevz/eth-clients/clients/execution"
+	"github.com/marioevz/eth-clients/clients/utils"
+	"github.com/protolambda/eth2api"
+)
+
+// Mechanism used to control the connectivity between the clients of two
+// nodes
+type PartitionBackend interface {
+	// Disconnects the clients of both nodes from each other
+	Disconnect(ctx context.Context, a, b *Node) error
+	// Reconnects the clients of both nodes to each other
+	Connect(ctx context.Context, a, b *Node) error
+	// Layers of the nodes whose connectivity is controlled by the backend
+	Layers() PartitionLayers
+}
+
+// Layers of the nodes whose connectivity is controlled by a partition
+// backend
+type PartitionLayers struct {
+	Execution bool
+	Beacon    bool
+}
+
+// Returns the layers controlled by either set of layers
+func (l PartitionLayers) Union(other PartitionLayers) PartitionLayers {
+	return PartitionLayers{
+		Execution: l.Execution || other.Execution,
+		Beacon:    l.Beacon || other.Beacon,
+	}
+}
+
+// Partition backend that uses the admin_removePeer and admin_addPeer
+// endpoints of the execution clients.
+// Discovery must be disabled in the execution clients, otherwise they can
+// find each other again during the partition.
+// Only the execution clients are disconnected: the beacon API provides no
+// endpoint to remove peers, so the beacon clients stay connected and the
+// consensus layer is not partitioned. Combine it with a backend that
+// controls the beacon clients, such as ClientControlPartitionBackend, to
+// partition both layers.
+type ExecutionAdminPartitionBackend struct{}
+
+var _ PartitionBackend = ExecutionAdminPartitionBackend{}
+
+func executionEnode(ec *execution.ExecutionClient) (string, error) {
+	enodeClient, ok := ec.Client.(execution.EnodeClient)
+	if !ok {
+		return "", fmt.Errorf(
+			"execution client %d does not provide an enode",
+			ec.Config.ClientIndex,
+		)
+	}
+	return enodeClient.GetEnodeURL()
+}
+
+func (ExecutionAdminPartitionBackend) update(
+	ctx context.Context,
+	a, b *Node,
+	call func(*execution.ExecutionClient, context.Context, string) (bool, error),
+) error {
+	if a.ExecutionClient == nil || b.ExecutionClient == nil {
+		return nil
+	}
+	for _, pair := range [][2]*execution.ExecutionClient{
+		{a.ExecutionClient, b.ExecutionClient},
+		{b.ExecutionClient, a.ExecutionClient},
+	} {
+		enode, err := executionEnode(pair[1])
+		if err != nil {
+			return err
+		}
+		if ok, err := call(pair[0], ctx, enode); err != nil {
+			return fmt.Errorf(
+				"execution client %d: %v",
+				pair[0].Config.ClientIndex,
+				err,
+			)
+		} else if !ok {
+			return fmt.Errorf(
+				"execution client %d: peer request rejected for %s",
+				pair[0].Config.ClientIndex,
+				enode,
+			)
+		}
+	}
+	return nil
+}
+
+func (b ExecutionAdminPartitionBackend) Disconnect(
+	ctx context.Context,
+	x, y *Node,
+) error {
+	return b.update(ctx, x, y, (*execution.ExecutionClient).RemovePeer)
+}
+
+func (b ExecutionAdminPartitionBackend) Connect(
+	ctx context.Context,
+	x, y *Node,
+) error {
+	return b.update(ctx, x, y, (*execution.ExecutionClient).AddPeer)
+}
+
+func (ExecutionAdminPartitionBackend) Layers() PartitionLayers {
+	return PartitionLayers{Execution: true}
+}
+
+// Partition backend that delegates to the clients that implement
+// clients.NetworkControlledClient, usually provided by the orchestrator
+// that runs them
+type ClientControlPartitionBackend struct{}
+
+var _ PartitionBackend = ClientControlPartitionBackend{}
+
+// Returns the pairs of clients of both nodes that are of the same layer
+func clientPairs(a, b *Node) [][2]clients.Client {
+	pairs := make([][2]clients.Client, 0)
+	if a.ExecutionClient != nil && b.ExecutionClient != nil {
+		pairs = append(pairs, [2]clients.Client{
+			a.ExecutionClient.Client,
+			b.ExecutionClient.Client,
+		})
+	}
+	if a.BeaconClient != nil && b.BeaconClient != nil {
+		pairs = append(pairs, [2]clients.Client{
+			a.BeaconClient.Client,
+			b.BeaconClient.Client,
+		})
+	}
+	return pairs
+}
+
+func (ClientControlPartitionBackend) update(
+	a, b *Node,
+	call func(clients.NetworkControlledClient, clients.Client) error,
+) error {
+	for _, pair := range clientPairs(a, b) {
+		for _, c := range [][2]clients.Client{pair, {pair[1], pair[0]}} {
+			controlled, ok := c[0].(clients.NetworkControlledClient)
+			if !ok {
+				return fmt.Errorf(
+					"client %s does not support network control",
+					c[0].ClientType(),
+				)
+			}
+			if err := call(controlled, c[1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b ClientControlPartitionBackend) Disconnect(
+	_ context.Context,
+	x, y *Node,
+) error {
+	return b.update(x, y, clients.NetworkControlledClient.DisconnectFrom)
+}
+
+func (b ClientControlPartitionBackend) Connect(
+	_ context.Context,
+	x, y *Node,
+) error {
+	return b.update(x, y, clients.NetworkControlledClient.ConnectTo)
+}
+
+func (ClientControlPartitionBackend) Layers() PartitionLayers {
+	return PartitionLayers{Execution: true, Beacon: true}
+}
+
+// Partition backend that applies every backend in order
+type PartitionBackends []PartitionBackend
+
+var _ PartitionBackend = PartitionBackends{}
+
+func (all PartitionBackends) Disconnect(ctx context.Context, a, b *Node) error {
+	for _, backend := range all {
+		if err := backend.Disconnect(ctx, a, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (all PartitionBackends) Connect(ctx context.Context, a, b *Node) error {
+	for _, backend := range all {
+		if err := backend.Connect(ctx, a, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (all PartitionBackends) Layers() PartitionLayers {
+	var layers PartitionLayers
+	for _, backend := range all {
+		layers = layers.Union(backend.Layers())
+	}
+	return layers
+}
+
+// Network split into groups of nodes that cannot reach each other
+type Partition struct {
+	Groups  []Nodes
+	backend PartitionBackend
+	healed  bool
+}
+
+// Returns every pair of nodes that belong to different groups
+func (p *Partition) crossGroupPairs() [][2]*Node {
+	pairs := make([][2]*Node, 0)
+	for i, group := range p.Groups {
+		for _, other := range p.Groups[i+1:] {
+			for _, a := range group {
+				for _, b := range other {
+					pairs = append(pairs, [2]*Node{a, b})
+				}
+			}
+		}
+	}
+	return pairs
+}
+
+// Splits the nodes into the given groups, disconnecting every node from the
+// nodes of the other groups.
+// Nodes not included in any group are left untouched.
+func (all Nodes) Partition(
+	ctx context.Context,
+	backend PartitionBackend,
+	groups ...Nodes,
+) (*Partition, error) {
+	if len(groups) < 2 {
+		return nil, fmt.Errorf("a partition requires at least two groups")
+	}
+	seen := make(map[*Node]bool)
+	for _, group := range groups {
+		for _, n := range group {
+			if seen[n] {
+				return nil, fmt.Errorf("node %d is in more than one group", n.Index)
+			}
+			seen[n] = true
+		}
+	}
+	p := &Partition{
+		Groups:  groups,
+		backend: backend,
+	}
+	for _, pair := range p.crossGroupPairs() {
+		if err := backend.Disconnect(ctx, pair[0], pair[1]); err != nil {
+			return p, fmt.Errorf(
+				"unable to disconnect node %d from node %d: %v",
+				pair[0].Index,
+				pair[1].Index,
+				err,
+			)
+		}
+	}
+	return p, nil
+}
+
+// Splits the nodes into groups according to the subnet of their beacon
+// clients, or the subnet of their execution clients if they have no beacon
+// client
+func (all Nodes) PartitionBySubnet(
+	ctx context.Context,
+	backend PartitionBackend,
+) (*Partition, error) {
+	var (
+		subnets = make([]string, 0)
+		groups  = make(map[string]Nodes)
+	)
+	for _, n := range all {
+		var subnet string
+		if n.BeaconClient != nil {
+			subnet = n.BeaconClient.Config.Subnet
+		} else if n.ExecutionClient != nil {
+			subnet = n.ExecutionClient.Config.Subnet
+		}
+		if _, ok := groups[subnet]; !ok {
+			subnets = append(subnets, subnet)
+		}
+		groups[subnet] = append(groups[subnet], n)
+	}
+	partition := make([]Nodes, len(subnets))
+	for i, subnet := range subnets {
+		partition[i] = groups[subnet]
+	}
+	return all.Partition(ctx, backend, partition...)
+}
+
+// Reconnects every pair of nodes that was disconnected by the partition
+func (p *Partition) Heal(ctx context.Context) error {
+	errs := make([]error, 0)
+	for _, pair := range p.crossGroupPairs() {
+		if err := p.backend.Connect(ctx, pair[0], pair[1]); err != nil {
+			errs = append(errs, fmt.Errorf(
+				"unable to connect node %d to node %d: %v",
+				pair[0].Index,
+				pair[1].Index,
+				err,
+			))
+		}
+	}
+	if len(errs) == 0 {
+		p.healed = true
+	}
+	return errors.Join(errs...)
+}
+
+// Returns the nodes of every group of the partition
+func (p *Partition) nodes() Nodes {
+	all := make(Nodes, 0)
+	for _, group := range p.Groups {
+		all = append(all, group...)
+	}
+	return all
+}
+
+// Returns the connections of the snapshot between nodes of different groups
+// in the given layers, and the errors found while taking the snapshot.
+// Connections to nodes that are not part of any group are ignored.
+func (t Topology) crossGroupConnections(
+	groups []Nodes,
+	layers PartitionLayers,
+) []error {
+	var (
+		groupOf = make(map[int]int)
+		errs    = make([]error, 0)
+	)
+	for i, group := range groups {
+		for _, n := range group {
+			groupOf[n.Index] = i
+		}
+	}
+	for _, n := range t {
+		for _, err := range n.Errors {
+			errs = append(errs, fmt.Errorf("node %d: %v", n.Index, err))
+		}
+		own, ok := groupOf[n.Index]
+		if !ok {
+			continue
+		}
+		for _, layer := range []struct {
+			name      string
+			enabled   bool
+			connected []int
+		}{
+			{"execution", layers.Execution, n.ExecutionConnected},
+			{"beacon", layers.Beacon, n.BeaconConnected},
+		} {
+			if !layer.enabled {
+				continue
+			}
+			for _, peer := range layer.connected {
+				if group, ok := groupOf[peer]; ok && group != own {
+					errs = append(errs, fmt.Errorf(
+						"node %d: %s client is connected to node %d of another group",
+						n.Index,
+						layer.name,
+						peer,
+					))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// Verifies that no client is connected to a node of another group, in the
+// layers controlled by the backend of the partition
+func (p *Partition) VerifyIsolation(ctx context.Context) error {
+	topology := p.nodes().Topology(ctx)
+	return errors.Join(
+		topology.crossGroupConnections(p.Groups, p.backend.Layers())...,
+	)
+}
+
+// Returns true if the beacon and execution heads of all nodes match
+func (all Nodes) headsConverged(ctx context.Context) (bool, error) {
+	var (
+		beacons    = all.BeaconClients().Running()
+		executions = all.ExecutionClients().Running()
+	)
+	if len(beacons) > 1 {
+		base, err := beacons[0].BlockHeader(ctx, eth2api.BlockHead)
+		if err != nil {
+			return false, err
+		}
+		for _, bn := range beacons[1:] {
+			head, err := bn.BlockHeader(ctx, eth2api.BlockHead)
+			if err != nil {
+				return false, err
+			}
+			if head.Root != base.Root {
+				return false, nil
+			}
+		}
+	}
+	if len(executions) > 1 {
+		return executions.CheckHeads(nil, ctx)
+	}
+	return true, nil
+}
+
+// Waits until the beacon and execution heads of every node of the healed
+// partition converge
+func (p *Partition) WaitForConvergence(ctx context.Context) error {
+	if !p.healed {
+		return fmt.Errorf("partition has not been healed")
+	}
+	all := p.nodes().Running()
+	if len(all) == 0 {
+		return fmt.Errorf("no running nodes")
+	}
+	var ticker utils.Ticker
+	if bn := all[0].BeaconClient; bn != nil && bn.Config.Spec != nil {
+		ticker = bn.NewSlotTicker()
+	} else {
+		ticker = utils.SystemClock{}.NewTicker(time.Second)
+	}
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C():
+			if converged, err := all.headsConverged(ctx); err == nil && converged {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Tests for the network partition helpers
+*/
+package node
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/marioevz/eth-clients/clients/beacon"
+)
+
+// Records the links between nodes instead of changing the connectivity
+type testPartitionBackend struct {
+	disconnected map[[2]int]bool
+}
+
+func (b *testPartitionBackend) link(x, y *Node) [2]int {
+	if x.Index > y.Index {
+		x, y = y, x
+	}
+	return [2]int{x.Index, y.Index}
+}
+
+func (b *testPartitionBackend) Disconnect(_ context.Context, x, y *Node) error {
+	b.disconnected[b.link(x, y)] = true
+	return nil
+}
+
+func (b *testPartitionBackend) Connect(_ context.Context, x, y *Node) error {
+	if !b.disconnected[b.link(x, y)] {
+		return fmt.Errorf("nodes %d and %d are not disconnected", x.Index, y.Index)
+	}
+	delete(b.disconnected, b.link(x, y))
+	return nil
+}
+
+func (b *testPartitionBackend) Layers() PartitionLayers {
+	return PartitionLayers{Beacon: true}
+}
+
+func testPartitionNodes(subnets ...string) Nodes {
+	nodes := make(Nodes, len(subnets))
+	for i, subnet := range subnets {
+		nodes[i] = &Node{
+			Index: i,
+			BeaconClient: &beacon.BeaconClient{
+				Config: beacon.BeaconClientConfig{Subnet: subnet},
+			},
+		}
+	}
+	return nodes
+}
+
+func TestPartition(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		nodes   = testPartitionNodes("a", "b", "a", "c", "b")
+		backend = &testPartitionBackend{disconnected: make(map[[2]int]bool)}
+	)
+	p, err := nodes.PartitionBySubnet(ctx, backend)
+	if err != nil {
+		t.Fatalf("unable to partition: %v", err)
+	}
+	if len(p.Groups) != 3 {
+		t.Fatalf("incorrect number of groups: want 3, got %d", len(p.Groups))
+	}
+	for _, link := range [][2]int{{0, 1}, {0, 3}, {0, 4}, {1, 2}, {1, 3}, {2, 3}, {2, 4}, {3, 4}} {
+		if !backend.disconnected[link] {
+			t.Fatalf("nodes %d and %d not disconnected", link[0], link[1])
+		}
+	}
+	for _, link := range [][2]int{{0, 2}, {1, 4}} {
+		if backend.disconnected[link] {
+			t.Fatalf("nodes %d and %d of the same group disconnected", link[0], link[1])
+		}
+	}
+	if err := p.WaitForConvergence(ctx); err == nil {
+		t.Fatalf("convergence awaited before healing")
+	}
+	if err := p.Heal(ctx); err != nil {
+		t.Fatalf("unable to heal: %v", err)
+	}
+	if len(backend.disconnected) != 0 {
+		t.Fatalf("links not healed: %v", backend.disconnected)
+	}
+
+	if _, err := nodes.Partition(ctx, backend, nodes[:2], nodes[1:]); err == nil {
+		t.Fatalf("overlapping groups accepted")
+	}
+	if _, err := nodes.Partition(ctx, backend, nodes); err == nil {
+		t.Fatalf("single group accepted")
+	}
+}
+
+func TestPartitionCrossGroupConnections(t *testing.T) {
+	var (
+		nodes  = testPartitionNodes("a", "a", "b", "b", "c")
+		groups = []Nodes{nodes[:2], nodes[2:4]}
+	)
+	topology := Topology{
+		{Index: 0, ExecutionConnected: []int{1, 2}, BeaconConnected: []int{1}},
+		{Index: 1, BeaconConnected: []int{0, 3, 4}},
+		{Index: 2, ExecutionConnected: []int{0}, BeaconConnected: []int{3}},
+		{Index: 3, BeaconConnected: []int{1, 2}},
+		{Index: 4, BeaconConnected: []int{1, 2}},
+	}
+	for _, test := range []struct {
+		name   string
+		layers PartitionLayers
+		want   int
+	}{
+		{
+			name:   "no layers",
+			layers: PartitionLayers{},
+			want:   0,
+		},
+		{
+			name:   "execution",
+			layers: PartitionLayers{Execution: true},
+			want:   2,
+		},
+		{
+			name:   "beacon",
+			layers: PartitionLayers{Beacon: true},
+			want:   2,
+		},
+		{
+			name:   "both layers",
+			layers: ExecutionAdminPartitionBackend{}.Layers().Union(PartitionLayers{Beacon: true}),
+			want:   4,
+		},
+	} {
+		if errs := topology.crossGroupConnections(groups, test.layers); len(errs) != test.want {
+			t.Fatalf(
+				"%s: incorrect number of cross-group connections: want %d, got %d: %v",
+				test.name,
+				test.want,
+				len(errs),
+				errs,
+			)
+		}
+	}
+
+	topology[0].Errors = []error{fmt.Errorf("unreachable")}
+	if errs := topology.crossGroupConnections(groups, PartitionLayers{}); len(errs) != 1 {
+		t.Fatalf("snapshot errors not reported: %v", errs)
+	}
+}
@@ -0,0 +1,243 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	api "github.com/ethereum/go-ethereum/beacon/engine"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/marioevz/eth-clients/clients/beacon"
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// Execution payload consistency result of a single slot
+type SlotPayloadReport struct {
+	Slot        common.Slot
+	BlockHash   ethcommon.Hash
+	BlockNumber uint64
+	// Block reconstructed from the payload, nil if the reconstruction failed
+	Reconstructed *types.Block
+	// Block stored by the execution client, nil if not found
+	Stored        *types.Block
+	Discrepancies []string
+}
+
+func (r *SlotPayloadReport) discrepancy(
+	format string,
+	values ...interface{},
+) {
+	r.Discrepancies = append(r.Discrepancies, fmt.Sprintf(format, values...))
+}
+
+type PayloadReport []*SlotPayloadReport
+
+// Returns the subset of slot reports that contain discrepancies
+func (report PayloadReport) Discrepancies() PayloadReport {
+	res := make(PayloadReport, 0)
+	for _, r := range report {
+		if len(r.Discrepancies) > 0 {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// Logs the discrepancies found in the report
+func (report PayloadReport) Print(n *Node) {
+	for _, r := range report.Discrepancies() {
+		for _, d := range r.Discrepancies {
+			n.Logf(
+				"slot %d, block %d (%s): payload discrepancy: %s",
+				r.Slot,
+				r.BlockNumber,
+				r.BlockHash,
+				d,
+			)
+		}
+	}
+}
+
+// Compares the payload against the header stored by the execution client
+func (r *SlotPayloadReport) compareHeader(
+	payload *api.ExecutableData,
+	beaconRoot *ethcommon.Hash,
+	header *types.Header,
+) {
+	mismatch := func(field string, want, got interface{}) {
+		r.discrepancy("%s mismatch: want %v, got %v", field, want, got)
+	}
+	if header.ParentHash != payload.ParentHash {
+		mismatch("parent hash", payload.ParentHash, header.ParentHash)
+	}
+	if header.Coinbase != payload.FeeRecipient {
+		mismatch("fee recipient", payload.FeeRecipient, header.Coinbase)
+	}
+	if header.Root != payload.StateRoot {
+		mismatch("state root", payload.StateRoot, header.Root)
+	}
+	if header.ReceiptHash != payload.ReceiptsRoot {
+		mismatch("receipts root", payload.ReceiptsRoot, header.ReceiptHash)
+	}
+	if !bytes.Equal(header.Bloom[:], payload.LogsBloom) {
+		r.discrepancy("logs bloom mismatch")
+	}
+	if header.MixDigest != payload.Random {
+		mismatch("prev randao", payload.Random, header.MixDigest)
+	}
+	if header.Number.Uint64() != payload.Number {
+		mismatch("number", payload.Number, header.Number)
+	}
+	if header.GasLimit != payload.GasLimit {
+		mismatch("gas limit", payload.GasLimit, header.GasLimit)
+	}
+	if header.GasUsed != payload.GasUsed {
+		mismatch("gas used", payload.GasUsed, header.GasUsed)
+	}
+	if header.Time != payload.Timestamp {
+		mismatch("timestamp", payload.Timestamp, header.Time)
+	}
+	if !bytes.Equal(header.Extra, payload.ExtraData) {
+		mismatch("extra data", payload.ExtraData, header.Extra)
+	}
+	if header.BaseFee == nil || payload.BaseFeePerGas == nil ||
+		header.BaseFee.Cmp(payload.BaseFeePerGas) != 0 {
+		mismatch("base fee", payload.BaseFeePerGas, header.BaseFee)
+	}
+	if !equalUint64Ptr(header.BlobGasUsed, payload.BlobGasUsed) {
+		mismatch("blob gas used", payload.BlobGasUsed, header.BlobGasUsed)
+	}
+	if !equalUint64Ptr(header.ExcessBlobGas, payload.ExcessBlobGas) {
+		mismatch("excess blob gas", payload.ExcessBlobGas, header.ExcessBlobGas)
+	}
+	if (header.ParentBeaconRoot == nil) != (beaconRoot == nil) ||
+		(beaconRoot != nil && *header.ParentBeaconRoot != *beaconRoot) {
+		mismatch("parent beacon root", beaconRoot, header.ParentBeaconRoot)
+	}
+}
+
+func equalUint64Ptr(a, b *uint64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// Compares the transactions and withdrawals of the payload against the
+// block stored by the execution client
+func (r *SlotPayloadReport) compareBody(
+	payload *api.ExecutableData,
+	block *types.Block,
+) {
+	txs := block.Transactions()
+	if len(txs) != len(payload.Transactions) {
+		r.discrepancy(
+			"transactions count mismatch: want %d, got %d",
+			len(payload.Transactions),
+			len(txs),
+		)
+	}
+	for i := 0; i < len(txs) && i < len(payload.Transactions); i++ {
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(payload.Transactions[i]); err != nil {
+			r.discrepancy("unable to decode transaction %d: %v", i, err)
+			continue
+		}
+		if tx.Hash() != txs[i].Hash() {
+			r.discrepancy(
+				"transaction %d mismatch: want %s, got %s",
+				i,
+				tx.Hash(),
+				txs[i].Hash(),
+			)
+		}
+	}
+
+	withdrawals := block.Withdrawals()
+	if (withdrawals == nil) != (payload.Withdrawals == nil) ||
+		len(withdrawals) != len(payload.Withdrawals) {
+		r.discrepancy(
+			"withdrawals count mismatch: want %d, got %d",
+			len(payload.Withdrawals),
+			len(withdrawals),
+		)
+	}
+	for i := 0; i < len(withdrawals) && i < len(payload.Withdrawals); i++ {
+		if exp, act := payload.Withdrawals[i], withdrawals[i]; *exp != *act {
+			r.discrepancy(
+				"withdrawal %d mismatch: want %+v, got %+v",
+				i,
+				*exp,
+				*act,
+			)
+		}
+	}
+}
+
+// Verifies that the execution payload of every beacon block within the given
+// slot range matches the block stored by the execution client.
+// The block is reconstructed from the payload, including the versioned hashes
+// and parent beacon block root, to verify its hash, and then compared field
+// by field against the block returned by the execution client.
+func (n *Node) VerifyExecutionPayloads(
+	ctx context.Context,
+	fromSlot common.Slot,
+	toSlot common.Slot,
+) (PayloadReport, error) {
+	var (
+		bn     = n.BeaconClient
+		ec     = n.ExecutionClient
+		report = make(PayloadReport, 0)
+	)
+	if ec == nil {
+		return nil, fmt.Errorf("node %d has no execution client", n.Index)
+	}
+	for slot := fromSlot; slot <= toSlot; slot++ {
+		versionedBlock, err := bn.BlockV2(ctx, eth2api.BlockIdSlot(slot))
+		if errors.Is(err, beacon.NotFound) {
+			// Missed slot
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !versionedBlock.ContainsExecutionPayload() {
+			continue
+		}
+		payload, versionedHashes, beaconRoot, err := versionedBlock.ExecutionPayload()
+		if err != nil {
+			return nil, err
+		}
+		if payload.BlockHash == (ethcommon.Hash{}) {
+			// Pre-merge empty payload
+			continue
+		}
+
+		r := &SlotPayloadReport{
+			Slot:        slot,
+			BlockHash:   payload.BlockHash,
+			BlockNumber: payload.Number,
+		}
+		report = append(report, r)
+
+		if r.Reconstructed, err = api.ExecutableDataToBlock(
+			payload,
+			versionedHashes,
+			beaconRoot,
+		); err != nil {
+			r.discrepancy("unable to reconstruct block from payload: %v", err)
+		}
+		if r.Stored, err = ec.BlockByHash(ctx, payload.BlockHash); err != nil {
+			r.discrepancy("unable to fetch block from execution client: %v", err)
+			continue
+		}
+		if h := r.Stored.Hash(); h != payload.BlockHash {
+			r.discrepancy("block hash mismatch: want %s, got %s", payload.BlockHash, h)
+		}
+		r.compareHeader(&payload, beaconRoot, r.Stored.Header())
+		r.compareBody(&payload, r.Stored)
+	}
+	return report, nil
+}
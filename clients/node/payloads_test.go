@@ -0,0 +1,100 @@
+/*
+Tests for the execution payload consistency checks
+*/
+package node
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	api "github.com/ethereum/go-ethereum/beacon/engine"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func TestComparePayload(t *testing.T) {
+	var (
+		blobGasUsed   = uint64(0)
+		excessBlobGas = uint64(0)
+		beaconRoot    = ethcommon.Hash{1}
+	)
+	withdrawals := types.Withdrawals{
+		{Index: 1, Validator: 2, Address: ethcommon.Address{3}, Amount: 4},
+	}
+	withdrawalsHash := types.DeriveSha(withdrawals, trie.NewStackTrie(nil))
+	header := &types.Header{
+		ParentHash:       ethcommon.Hash{2},
+		UncleHash:        types.EmptyUncleHash,
+		TxHash:           types.EmptyTxsHash,
+		WithdrawalsHash:  &withdrawalsHash,
+		Difficulty:       new(big.Int),
+		Number:           big.NewInt(10),
+		GasLimit:         30_000_000,
+		Time:             12,
+		BaseFee:          big.NewInt(7),
+		BlobGasUsed:      &blobGasUsed,
+		ExcessBlobGas:    &excessBlobGas,
+		ParentBeaconRoot: &beaconRoot,
+	}
+	block := types.NewBlockWithHeader(header).WithWithdrawals(withdrawals)
+	payload := api.BlockToExecutableData(block, nil, nil).ExecutionPayload
+
+	if _, err := api.ExecutableDataToBlock(*payload, nil, &beaconRoot); err != nil {
+		t.Fatalf("%v", err)
+	}
+	r := new(SlotPayloadReport)
+	r.compareHeader(payload, &beaconRoot, block.Header())
+	r.compareBody(payload, block)
+	if len(r.Discrepancies) > 0 {
+		t.Fatalf("unexpected discrepancies: %v", r.Discrepancies)
+	}
+
+	// Stored block with a different timestamp, no beacon root and an extra
+	// withdrawal
+	modified := types.CopyHeader(header)
+	modified.Time++
+	modified.ParentBeaconRoot = nil
+	stored := types.NewBlockWithHeader(modified).WithWithdrawals(
+		append(withdrawals, &types.Withdrawal{Index: 2}),
+	)
+	r = new(SlotPayloadReport)
+	r.compareHeader(payload, &beaconRoot, stored.Header())
+	r.compareBody(payload, stored)
+	if len(r.Discrepancies) != 3 {
+		t.Fatalf("incorrect discrepancies: %v", r.Discrepancies)
+	}
+}
+
+func TestVerifyExecutionPayloadsMissedSlots(t *testing.T) {
+	ctx := context.Background()
+	var status atomic.Int32
+	status.Store(http.StatusNotFound)
+	n := testBatchNode(t, 0, configs.Minimal, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/eth/v2/beacon/blocks/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(int(status.Load()))
+	})
+
+	// Blocks not found are missed slots
+	report, err := n.VerifyExecutionPayloads(ctx, 1, 3)
+	if err != nil {
+		t.Fatalf("unable to verify payloads: %v", err)
+	}
+	if len(report) != 0 {
+		t.Fatalf("unexpected report: %v", report)
+	}
+
+	// Any other error is returned
+	status.Store(http.StatusInternalServerError)
+	if _, err := n.VerifyExecutionPayloads(ctx, 1, 3); err == nil {
+		t.Fatalf("expected block request error")
+	}
+}
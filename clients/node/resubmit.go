@@ -0,0 +1,260 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	api "github.com/ethereum/go-ethereum/beacon/engine"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/marioevz/eth-clients/clients/beacon"
+	"github.com/marioevz/eth-clients/clients/execution"
+	"github.com/marioevz/eth-clients/clients/utils"
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// Result of submitting the payload of a single beacon block
+type PayloadSubmission struct {
+	Slot        common.Slot
+	BlockHash   ethcommon.Hash
+	BlockNumber uint64
+	// Engine API version used for both calls
+	Version int
+
+	NewPayloadStatus *api.PayloadStatusV1
+	NewPayloadErr    error
+
+	ForkchoiceState  *api.ForkchoiceStateV1
+	ForkchoiceStatus *api.PayloadStatusV1
+	ForkchoiceErr    error
+}
+
+func payloadStatusString(status *api.PayloadStatusV1, err error) string {
+	if err != nil {
+		return fmt.Sprintf("error(%v)", err)
+	}
+	if status == nil {
+		return "none"
+	}
+	latestValidHash := "nil"
+	if status.LatestValidHash != nil {
+		latestValidHash = status.LatestValidHash.String()
+	}
+	return fmt.Sprintf("%s(latestValidHash=%s)", status.Status, latestValidHash)
+}
+
+func (s *PayloadSubmission) NewPayloadVerdict() string {
+	return payloadStatusString(s.NewPayloadStatus, s.NewPayloadErr)
+}
+
+func (s *PayloadSubmission) ForkchoiceVerdict() string {
+	return payloadStatusString(s.ForkchoiceStatus, s.ForkchoiceErr)
+}
+
+type PayloadSubmissionReport []*PayloadSubmission
+
+// Logs the verdicts of every submission
+func (report PayloadSubmissionReport) Print(l utils.Logging) {
+	for _, s := range report {
+		l.Logf(
+			"slot %d, block %d (%s): newPayloadV%d: %s, forkchoiceUpdatedV%d: %s",
+			s.Slot,
+			s.BlockNumber,
+			s.BlockHash,
+			s.Version,
+			s.NewPayloadVerdict(),
+			s.Version,
+			s.ForkchoiceVerdict(),
+		)
+	}
+}
+
+// Returns the differences in the verdicts of the blocks submitted in both
+// reports, and the blocks submitted in only one of them
+func (report PayloadSubmissionReport) Diff(
+	reference PayloadSubmissionReport,
+) []string {
+	diffs := make([]string, 0)
+	byHash := make(map[ethcommon.Hash]*PayloadSubmission)
+	for _, s := range reference {
+		byHash[s.BlockHash] = s
+	}
+	submitted := make(map[ethcommon.Hash]bool)
+	for _, s := range report {
+		submitted[s.BlockHash] = true
+		ref, ok := byHash[s.BlockHash]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf(
+				"slot %d, block %d (%s): not submitted in the reference",
+				s.Slot,
+				s.BlockNumber,
+				s.BlockHash,
+			))
+			continue
+		}
+		if got, want := s.NewPayloadVerdict(), ref.NewPayloadVerdict(); got != want {
+			diffs = append(diffs, fmt.Sprintf(
+				"slot %d, block %d (%s): newPayload verdict mismatch: want %s, got %s",
+				s.Slot,
+				s.BlockNumber,
+				s.BlockHash,
+				want,
+				got,
+			))
+		}
+		if got, want := s.ForkchoiceVerdict(), ref.ForkchoiceVerdict(); got != want {
+			diffs = append(diffs, fmt.Sprintf(
+				"slot %d, block %d (%s): forkchoiceUpdated verdict mismatch: want %s, got %s",
+				s.Slot,
+				s.BlockNumber,
+				s.BlockHash,
+				want,
+				got,
+			))
+		}
+	}
+	for _, ref := range reference {
+		if !submitted[ref.BlockHash] {
+			diffs = append(diffs, fmt.Sprintf(
+				"slot %d, block %d (%s): missing, submitted in the reference",
+				ref.Slot,
+				ref.BlockNumber,
+				ref.BlockHash,
+			))
+		}
+	}
+	return diffs
+}
+
+// Returns the execution block hash of the beacon block with the given root,
+// zero if the root is zero or the block predates the merge
+func executionBlockHashByRoot(
+	ctx context.Context,
+	bn *beacon.BeaconClient,
+	root tree.Root,
+	cache map[tree.Root]ethcommon.Hash,
+) (ethcommon.Hash, error) {
+	if root == (tree.Root{}) {
+		return ethcommon.Hash{}, nil
+	}
+	if h, ok := cache[root]; ok {
+		return h, nil
+	}
+	block, err := bn.BlockV2(ctx, eth2api.BlockIdRoot(root))
+	if err != nil {
+		return ethcommon.Hash{}, err
+	}
+	var h ethcommon.Hash
+	if blockHash := block.ExecutionPayloadBlockHash(); blockHash != nil {
+		h = ethcommon.Hash(*blockHash)
+	}
+	cache[root] = h
+	return h, nil
+}
+
+// Feeds the execution payloads of the canonical beacon blocks within the slot
+// range into the execution client, each followed by a forkchoice update that
+// points the head to the payload, and the safe and finalized blocks to the
+// justified and finalized checkpoints of the beacon block.
+// The execution client is expected to be synced up to the parent of the
+// first payload, e.g. a fresh client when starting from the first slot after
+// genesis.
+func ResubmitPayloads(
+	ctx context.Context,
+	bn *beacon.BeaconClient,
+	ec *execution.ExecutionClient,
+	fromSlot common.Slot,
+	toSlot common.Slot,
+) (PayloadSubmissionReport, error) {
+	var (
+		report = make(PayloadSubmissionReport, 0)
+		cache  = make(map[tree.Root]ethcommon.Hash)
+	)
+	for slot := fromSlot; slot <= toSlot; slot++ {
+		versionedBlock, err := bn.BlockV2(ctx, eth2api.BlockIdSlot(slot))
+		if errors.Is(err, beacon.NotFound) {
+			// Missed slot
+			continue
+		} else if err != nil {
+			return report, err
+		}
+		if !versionedBlock.ContainsExecutionPayload() {
+			continue
+		}
+		payload, versionedHashes, beaconRoot, err := versionedBlock.ExecutionPayload()
+		if err != nil {
+			return report, err
+		}
+		if payload.BlockHash == (ethcommon.Hash{}) {
+			// Pre-merge empty payload
+			continue
+		}
+		checkpoints, err := bn.BlockFinalityCheckpoints(
+			ctx,
+			eth2api.BlockIdRoot(versionedBlock.Root()),
+		)
+		if err != nil {
+			return report, err
+		}
+		safe, err := executionBlockHashByRoot(
+			ctx,
+			bn,
+			checkpoints.CurrentJustified.Root,
+			cache,
+		)
+		if err != nil {
+			return report, err
+		}
+		finalized, err := executionBlockHashByRoot(
+			ctx,
+			bn,
+			checkpoints.Finalized.Root,
+			cache,
+		)
+		if err != nil {
+			return report, err
+		}
+
+		s := &PayloadSubmission{
+			Slot:        slot,
+			BlockHash:   payload.BlockHash,
+			BlockNumber: payload.Number,
+			Version:     execution.EngineAPIVersion(&payload, beaconRoot),
+			ForkchoiceState: &api.ForkchoiceStateV1{
+				HeadBlockHash:      payload.BlockHash,
+				SafeBlockHash:      safe,
+				FinalizedBlockHash: finalized,
+			},
+		}
+		report = append(report, s)
+
+		if s.Version >= 3 {
+			s.NewPayloadStatus, s.NewPayloadErr = ec.EngineNewPayloadV3(
+				ctx,
+				&payload,
+				versionedHashes,
+				beaconRoot,
+			)
+		} else {
+			s.NewPayloadStatus, s.NewPayloadErr = ec.EngineNewPayload(
+				ctx,
+				&payload,
+				s.Version,
+			)
+		}
+		fcuResponse, err := ec.EngineForkchoiceUpdated(
+			ctx,
+			s.ForkchoiceState,
+			nil,
+			s.Version,
+		)
+		if err != nil {
+			s.ForkchoiceErr = err
+		} else {
+			s.ForkchoiceStatus = &fcuResponse.PayloadStatus
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,161 @@
+/*
+Tests for the payload resubmission helpers
+*/
+package node
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	api "github.com/ethereum/go-ethereum/beacon/engine"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func TestResubmitPayloadsMissedSlots(t *testing.T) {
+	ctx := context.Background()
+	var status atomic.Int32
+	status.Store(http.StatusNotFound)
+	n := testBatchNode(t, 0, configs.Minimal, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/eth/v2/beacon/blocks/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(int(status.Load()))
+	})
+
+	// Blocks not found are missed slots
+	report, err := ResubmitPayloads(ctx, n.BeaconClient, n.ExecutionClient, 1, 3)
+	if err != nil {
+		t.Fatalf("unable to resubmit payloads: %v", err)
+	}
+	if len(report) != 0 {
+		t.Fatalf("unexpected report: %v", report)
+	}
+
+	// Any other error is returned
+	status.Store(http.StatusInternalServerError)
+	if _, err := ResubmitPayloads(ctx, n.BeaconClient, n.ExecutionClient, 1, 3); err == nil {
+		t.Fatalf("expected block request error")
+	}
+}
+
+func TestPayloadStatusString(t *testing.T) {
+	hash := ethcommon.Hash{0x01}
+	for _, test := range []struct {
+		name     string
+		status   *api.PayloadStatusV1
+		err      error
+		expected string
+	}{
+		{
+			name:     "error",
+			status:   &api.PayloadStatusV1{Status: api.VALID},
+			err:      fmt.Errorf("timeout"),
+			expected: "error(timeout)",
+		},
+		{
+			name:     "no status",
+			expected: "none",
+		},
+		{
+			name:     "no latest valid hash",
+			status:   &api.PayloadStatusV1{Status: api.SYNCING},
+			expected: "SYNCING(latestValidHash=nil)",
+		},
+		{
+			name:     "latest valid hash",
+			status:   &api.PayloadStatusV1{Status: api.VALID, LatestValidHash: &hash},
+			expected: fmt.Sprintf("VALID(latestValidHash=%s)", hash),
+		},
+	} {
+		if got := payloadStatusString(test.status, test.err); got != test.expected {
+			t.Fatalf("%s: incorrect string: want %s, got %s", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestPayloadSubmissionReportDiff(t *testing.T) {
+	submission := func(
+		n byte,
+		newPayloadStatus string,
+		forkchoiceStatus string,
+	) *PayloadSubmission {
+		return &PayloadSubmission{
+			Slot:             1,
+			BlockHash:        ethcommon.Hash{n},
+			BlockNumber:      uint64(n),
+			NewPayloadStatus: &api.PayloadStatusV1{Status: newPayloadStatus},
+			ForkchoiceStatus: &api.PayloadStatusV1{Status: forkchoiceStatus},
+		}
+	}
+	for _, test := range []struct {
+		name      string
+		report    PayloadSubmissionReport
+		reference PayloadSubmissionReport
+		expected  []string
+	}{
+		{
+			name:      "same verdicts",
+			report:    PayloadSubmissionReport{submission(1, api.VALID, api.VALID)},
+			reference: PayloadSubmissionReport{submission(1, api.VALID, api.VALID)},
+			expected:  []string{},
+		},
+		{
+			name:      "new payload mismatch",
+			report:    PayloadSubmissionReport{submission(1, api.INVALID, api.VALID)},
+			reference: PayloadSubmissionReport{submission(1, api.VALID, api.VALID)},
+			expected: []string{
+				"newPayload verdict mismatch: want VALID(latestValidHash=nil), got INVALID(latestValidHash=nil)",
+			},
+		},
+		{
+			name:      "forkchoice mismatch",
+			report:    PayloadSubmissionReport{submission(1, api.VALID, api.SYNCING)},
+			reference: PayloadSubmissionReport{submission(1, api.VALID, api.VALID)},
+			expected: []string{
+				"forkchoiceUpdated verdict mismatch: want VALID(latestValidHash=nil), got SYNCING(latestValidHash=nil)",
+			},
+		},
+		{
+			name: "missing block",
+			report: PayloadSubmissionReport{
+				submission(1, api.VALID, api.VALID),
+			},
+			reference: PayloadSubmissionReport{
+				submission(1, api.VALID, api.VALID),
+				submission(2, api.VALID, api.VALID),
+			},
+			expected: []string{
+				fmt.Sprintf("block 2 (%s): missing, submitted in the reference", ethcommon.Hash{2}),
+			},
+		},
+		{
+			name: "extra block",
+			report: PayloadSubmissionReport{
+				submission(1, api.VALID, api.VALID),
+				submission(3, api.VALID, api.VALID),
+			},
+			reference: PayloadSubmissionReport{
+				submission(1, api.VALID, api.VALID),
+			},
+			expected: []string{
+				fmt.Sprintf("block 3 (%s): not submitted in the reference", ethcommon.Hash{3}),
+			},
+		},
+	} {
+		diffs := test.report.Diff(test.reference)
+		if len(diffs) != len(test.expected) {
+			t.Fatalf("%s: incorrect diffs: %v", test.name, diffs)
+		}
+		for i, expected := range test.expected {
+			if !strings.HasSuffix(diffs[i], expected) {
+				t.Fatalf("%s: incorrect diff %d: want %q, got %q", test.name, i, expected, diffs[i])
+			}
+		}
+	}
+}
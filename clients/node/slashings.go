@@ -0,0 +1,341 @@
+package node
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+)
+
+type AttesterSlashingType int
+
+const (
+	// Two attestations with the same target epoch but different data
+	AttesterSlashingDoubleVote AttesterSlashingType = iota
+	// One attestation whose source and target surround the other's
+	AttesterSlashingSurroundVote
+)
+
+func (t AttesterSlashingType) String() string {
+	switch t {
+	case AttesterSlashingDoubleVote:
+		return "double-vote"
+	case AttesterSlashingSurroundVote:
+		return "surround-vote"
+	}
+	return fmt.Sprintf("unknown(%d)", int(t))
+}
+
+// Returns the first verification node that contains a beacon client
+func (all Nodes) verificationNode() (*Node, error) {
+	for _, n := range all.VerificationNodes() {
+		if n.BeaconClient != nil {
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("no verification beacon client available")
+}
+
+// Creates a proposer slashing for the given validator by signing two
+// conflicting block headers for the same slot.
+func (all Nodes) SignProposerSlashing(
+	ctx context.Context,
+	slot common.Slot,
+	validatorIndex common.ValidatorIndex,
+) (*phase0.ProposerSlashing, error) {
+	n := all.ByValidatorIndex(validatorIndex)
+	if n == nil {
+		return nil, fmt.Errorf(
+			"validator index %d not found",
+			validatorIndex,
+		)
+	}
+	vc, bn := n.ValidatorClient, n.BeaconClient
+	// Sign with the fork version of the slot of the headers, which is the
+	// one used to verify the slashing
+	version := bn.ForkSchedule().ForkVersion(bn.Config.Spec.SlotToEpoch(slot))
+	domain, err := bn.ComputeDomain(
+		ctx,
+		common.DOMAIN_BEACON_PROPOSER,
+		&version,
+	)
+	if err != nil {
+		return nil, err
+	}
+	header1 := common.BeaconBlockHeader{
+		Slot:          slot,
+		ProposerIndex: validatorIndex,
+		BodyRoot:      common.Root{0x01},
+	}
+	header2 := header1
+	header2.BodyRoot = common.Root{0x02}
+
+	signedHeader1, err := vc.SignBeaconBlockHeader(domain, &header1)
+	if err != nil {
+		return nil, err
+	}
+	signedHeader2, err := vc.SignBeaconBlockHeader(domain, &header2)
+	if err != nil {
+		return nil, err
+	}
+	return &phase0.ProposerSlashing{
+		SignedHeader1: *signedHeader1,
+		SignedHeader2: *signedHeader2,
+	}, nil
+}
+
+// Returns two conflicting attestation data objects for the given target epoch
+func SlashableAttestationData(
+	typ AttesterSlashingType,
+	targetEpoch common.Epoch,
+) (*phase0.AttestationData, *phase0.AttestationData, error) {
+	switch typ {
+	case AttesterSlashingDoubleVote:
+		data1 := phase0.AttestationData{
+			Slot:            common.Slot(0),
+			BeaconBlockRoot: common.Root{0x01},
+			Target: common.Checkpoint{
+				Epoch: targetEpoch,
+				Root:  common.Root{0x01},
+			},
+		}
+		if targetEpoch > 0 {
+			data1.Source.Epoch = targetEpoch - 1
+		}
+		data2 := data1
+		data2.BeaconBlockRoot = common.Root{0x02}
+		data2.Target.Root = common.Root{0x02}
+		return &data1, &data2, nil
+	case AttesterSlashingSurroundVote:
+		if targetEpoch < 2 {
+			return nil, nil, fmt.Errorf(
+				"target epoch must be at least 2 for a surround vote: %d",
+				targetEpoch,
+			)
+		}
+		data1 := phase0.AttestationData{
+			BeaconBlockRoot: common.Root{0x01},
+			Source: common.Checkpoint{
+				Epoch: targetEpoch - 2,
+			},
+			Target: common.Checkpoint{
+				Epoch: targetEpoch,
+				Root:  common.Root{0x01},
+			},
+		}
+		data2 := phase0.AttestationData{
+			BeaconBlockRoot: common.Root{0x02},
+			Source: common.Checkpoint{
+				Epoch: targetEpoch - 1,
+			},
+			Target: common.Checkpoint{
+				Epoch: targetEpoch - 1,
+				Root:  common.Root{0x02},
+			},
+		}
+		return &data1, &data2, nil
+	}
+	return nil, nil, fmt.Errorf("unknown attester slashing type: %s", typ)
+}
+
+// Creates an attester slashing of the given type that includes all of the
+// specified validators, which can be spread across different nodes.
+func (all Nodes) SignAttesterSlashing(
+	ctx context.Context,
+	typ AttesterSlashingType,
+	targetEpoch common.Epoch,
+	validatorIndexes []common.ValidatorIndex,
+) (*phase0.AttesterSlashing, error) {
+	n, err := all.verificationNode()
+	if err != nil {
+		return nil, err
+	}
+	data1, data2, err := SlashableAttestationData(typ, targetEpoch)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		bn       = n.BeaconClient
+		schedule = bn.ForkSchedule()
+		vcs      = all.ValidatorClients()
+	)
+	// Each attestation is signed with the fork version of its own target
+	// epoch, which is the one used to verify the slashing
+	sign := func(data *phase0.AttestationData) (*phase0.IndexedAttestation, error) {
+		version := schedule.ForkVersion(data.Target.Epoch)
+		domain, err := bn.ComputeDomain(
+			ctx,
+			common.DOMAIN_BEACON_ATTESTER,
+			&version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		return vcs.SignIndexedAttestation(domain, data, validatorIndexes)
+	}
+	attestation1, err := sign(data1)
+	if err != nil {
+		return nil, err
+	}
+	attestation2, err := sign(data2)
+	if err != nil {
+		return nil, err
+	}
+	return &phase0.AttesterSlashing{
+		Attestation1: *attestation1,
+		Attestation2: *attestation2,
+	}, nil
+}
+
+func (all Nodes) SignSubmitProposerSlashing(
+	ctx context.Context,
+	slot common.Slot,
+	validatorIndex common.ValidatorIndex,
+) error {
+	slashing, err := all.SignProposerSlashing(ctx, slot, validatorIndex)
+	if err != nil {
+		return err
+	}
+	return all.BeaconClients().Running().SubmitPoolProposerSlashing(
+		ctx,
+		slashing,
+	)
+}
+
+func (all Nodes) SignSubmitAttesterSlashing(
+	ctx context.Context,
+	typ AttesterSlashingType,
+	targetEpoch common.Epoch,
+	validatorIndexes []common.ValidatorIndex,
+) error {
+	slashing, err := all.SignAttesterSlashing(
+		ctx,
+		typ,
+		targetEpoch,
+		validatorIndexes,
+	)
+	if err != nil {
+		return err
+	}
+	return all.BeaconClients().Running().SubmitPoolAttesterSlashing(
+		ctx,
+		slashing,
+	)
+}
+
+// Returns the balances of the given validators at the given state, to be
+// later used to verify the slashing penalties
+func (all Nodes) ValidatorBalances(
+	ctx context.Context,
+	stateId eth2api.StateId,
+	validatorIndexes []common.ValidatorIndex,
+) (map[common.ValidatorIndex]common.Gwei, error) {
+	n, err := all.verificationNode()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]eth2api.ValidatorId, len(validatorIndexes))
+	for i, validatorIndex := range validatorIndexes {
+		ids[i] = eth2api.ValidatorIdIndex(validatorIndex)
+	}
+	resp, err := n.BeaconClient.StateValidatorBalances(ctx, stateId, ids)
+	if err != nil {
+		return nil, err
+	}
+	balances := make(map[common.ValidatorIndex]common.Gwei)
+	for _, b := range resp {
+		balances[b.Index] = b.Balance
+	}
+	for _, validatorIndex := range validatorIndexes {
+		if _, ok := balances[validatorIndex]; !ok {
+			return nil, fmt.Errorf(
+				"balance for validator %d not found",
+				validatorIndex,
+			)
+		}
+	}
+	return balances, nil
+}
+
+// Verifies that all validators in the map are marked as slashed at the given
+// state, and that their balances are below the balances they had before the
+// slashing.
+func (all Nodes) VerifySlashedValidators(
+	ctx context.Context,
+	stateId eth2api.StateId,
+	preBalances map[common.ValidatorIndex]common.Gwei,
+) error {
+	n, err := all.verificationNode()
+	if err != nil {
+		return err
+	}
+	ids := make([]eth2api.ValidatorId, 0, len(preBalances))
+	for validatorIndex := range preBalances {
+		ids = append(ids, eth2api.ValidatorIdIndex(validatorIndex))
+	}
+	resp, err := n.BeaconClient.StateValidators(ctx, stateId, ids, nil)
+	if err != nil {
+		return err
+	}
+	if len(resp) != len(preBalances) {
+		return fmt.Errorf(
+			"incorrect number of validators returned: want %d, got %d",
+			len(preBalances),
+			len(resp),
+		)
+	}
+	for _, v := range resp {
+		preBalance, ok := preBalances[v.Index]
+		if !ok {
+			return fmt.Errorf("unexpected validator %d returned", v.Index)
+		}
+		if !v.Validator.Slashed {
+			return fmt.Errorf("validator %d is not slashed", v.Index)
+		}
+		if v.Balance >= preBalance {
+			return fmt.Errorf(
+				"validator %d balance was not reduced: pre=%d, post=%d",
+				v.Index,
+				preBalance,
+				v.Balance,
+			)
+		}
+	}
+	return nil
+}
+
+// Waits until all validators in the map are slashed and penalized at the
+// head state, or the context is done
+func (all Nodes) WaitForSlashedValidators(
+	ctx context.Context,
+	preBalances map[common.ValidatorIndex]common.Gwei,
+) error {
+	n, err := all.verificationNode()
+	if err != nil {
+		return err
+	}
+	timer := n.BeaconClient.NewSlotTicker()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf(
+				"validators not slashed: %v (%v)",
+				err,
+				ctx.Err(),
+			)
+		case <-timer.C():
+			if err = all.VerifySlashedValidators(
+				ctx,
+				eth2api.StateHead,
+				preBalances,
+			); err == nil {
+				return nil
+			}
+			n.Logf("WaitForSlashedValidators: %v", err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Tests for the slashing helpers
+*/
+package node
+
+import (
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+)
+
+func TestSlashableAttestationData(t *testing.T) {
+	for _, test := range []struct {
+		typ         AttesterSlashingType
+		targetEpoch common.Epoch
+		expectErr   bool
+	}{
+		{
+			typ:         AttesterSlashingDoubleVote,
+			targetEpoch: 0,
+		},
+		{
+			typ:         AttesterSlashingDoubleVote,
+			targetEpoch: 10,
+		},
+		{
+			typ:         AttesterSlashingSurroundVote,
+			targetEpoch: 2,
+		},
+		{
+			typ:         AttesterSlashingSurroundVote,
+			targetEpoch: 10,
+		},
+		{
+			typ:         AttesterSlashingSurroundVote,
+			targetEpoch: 1,
+			expectErr:   true,
+		},
+	} {
+		data1, data2, err := SlashableAttestationData(
+			test.typ,
+			test.targetEpoch,
+		)
+		if test.expectErr {
+			if err == nil {
+				t.Fatalf("%s: expected error, got nil", test.typ)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !phase0.IsSlashableAttestationData(data1, data2) {
+			t.Fatalf(
+				"%s: attestation data is not slashable: %v, %v",
+				test.typ,
+				data1,
+				data2,
+			)
+		}
+		switch test.typ {
+		case AttesterSlashingDoubleVote:
+			if !phase0.IsDoubleVote(data1, data2) {
+				t.Fatalf("%s: attestation data is not a double vote", test.typ)
+			}
+		case AttesterSlashingSurroundVote:
+			if !phase0.IsSurroundVote(data1, data2) {
+				t.Fatalf("%s: attestation data is not a surround vote", test.typ)
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/marioevz/eth-clients/clients/utils"
+)
+
+// Peers of a single node at the time of the snapshot
+type NodeTopology struct {
+	Index int
+	// Identifiers of the node in each network
+	ExecutionID  string
+	BeaconPeerID string
+	// Identifiers of the connected peers as reported by the clients
+	ExecutionPeers []string
+	BeaconPeers    []string
+	// Indexes of the nodes of the snapshot that the clients are connected to
+	ExecutionConnected []int
+	BeaconConnected    []int
+	// Number of connected peers that are not part of the snapshot
+	ExecutionUnknownPeers int
+	BeaconUnknownPeers    int
+	// Errors found while querying the clients of the node
+	Errors []error
+}
+
+// Snapshot of the peer connections between a set of nodes
+type Topology []*NodeTopology
+
+// Fetches the identity and peers of every running node and resolves the
+// peers to the nodes of the set
+func (all Nodes) Topology(ctx context.Context) Topology {
+	var (
+		topology      = make(Topology, 0)
+		executionByID = make(map[string]int)
+		beaconByID    = make(map[string]int)
+	)
+	for _, n := range all.Running() {
+		t := &NodeTopology{Index: n.Index}
+		topology = append(topology, t)
+		if ec := n.ExecutionClient; ec != nil {
+			if info, err := ec.AdminNodeInfo(ctx); err != nil {
+				t.Errors = append(t.Errors, fmt.Errorf("admin_nodeInfo: %v", err))
+			} else {
+				t.ExecutionID = info.ID
+				executionByID[info.ID] = n.Index
+			}
+			if peers, err := ec.AdminPeers(ctx); err != nil {
+				t.Errors = append(t.Errors, fmt.Errorf("admin_peers: %v", err))
+			} else {
+				for _, p := range peers {
+					t.ExecutionPeers = append(t.ExecutionPeers, p.ID)
+				}
+			}
+		}
+		if bn := n.BeaconClient; bn != nil {
+			if identity, err := bn.Identity(ctx); err != nil {
+				t.Errors = append(t.Errors, fmt.Errorf("node identity: %v", err))
+			} else {
+				t.BeaconPeerID = string(identity.PeerID)
+				beaconByID[t.BeaconPeerID] = n.Index
+			}
+			if peers, err := bn.ConnectedPeers(ctx); err != nil {
+				t.Errors = append(t.Errors, fmt.Errorf("node peers: %v", err))
+			} else {
+				for _, p := range peers {
+					t.BeaconPeers = append(t.BeaconPeers, string(p.PeerID))
+				}
+			}
+		}
+	}
+	for _, t := range topology {
+		for _, id := range t.ExecutionPeers {
+			if index, ok := executionByID[id]; ok {
+				t.ExecutionConnected = append(t.ExecutionConnected, index)
+			} else {
+				t.ExecutionUnknownPeers++
+			}
+		}
+		for _, id := range t.BeaconPeers {
+			if index, ok := beaconByID[id]; ok {
+				t.BeaconConnected = append(t.BeaconConnected, index)
+			} else {
+				t.BeaconUnknownPeers++
+			}
+		}
+		sort.Ints(t.ExecutionConnected)
+		sort.Ints(t.BeaconConnected)
+	}
+	return topology
+}
+
+func (t Topology) byIndex() map[int]*NodeTopology {
+	res := make(map[int]*NodeTopology)
+	for _, n := range t {
+		res[n.Index] = n
+	}
+	return res
+}
+
+func containsIndex(indexes []int, index int) bool {
+	for _, i := range indexes {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the connections reported by only one of the two nodes involved
+func (t Topology) Asymmetries() []string {
+	var (
+		byIndex     = t.byIndex()
+		asymmetries = make([]string, 0)
+	)
+	for _, n := range t {
+		for _, layer := range []struct {
+			name      string
+			connected func(*NodeTopology) []int
+		}{
+			{"execution", func(t *NodeTopology) []int { return t.ExecutionConnected }},
+			{"beacon", func(t *NodeTopology) []int { return t.BeaconConnected }},
+		} {
+			for _, peer := range layer.connected(n) {
+				other, ok := byIndex[peer]
+				if !ok || containsIndex(layer.connected(other), n.Index) {
+					continue
+				}
+				asymmetries = append(asymmetries, fmt.Sprintf(
+					"%s client of node %d is connected to node %d, but not the other way around",
+					layer.name,
+					n.Index,
+					peer,
+				))
+			}
+		}
+	}
+	return asymmetries
+}
+
+// Returns the indexes of the nodes with no connection to any other node of
+// the snapshot in either layer
+func (t Topology) Isolated() []int {
+	isolated := make([]int, 0)
+	for _, n := range t {
+		if len(n.ExecutionConnected) == 0 && len(n.BeaconConnected) == 0 {
+			isolated = append(isolated, n.Index)
+		}
+	}
+	return isolated
+}
+
+// Logs the connections of every node
+func (t Topology) Print(l utils.Logging) {
+	for _, n := range t {
+		l.Logf(
+			"node %d: execution peers %v (+%d unknown), beacon peers %v (+%d unknown)",
+			n.Index,
+			n.ExecutionConnected,
+			n.ExecutionUnknownPeers,
+			n.BeaconConnected,
+			n.BeaconUnknownPeers,
+		)
+		for _, err := range n.Errors {
+			l.Logf("node %d: error: %v", n.Index, err)
+		}
+	}
+	for _, a := range t.Asymmetries() {
+		l.Logf("%s", a)
+	}
+}
@@ -0,0 +1,25 @@
+/*
+Tests for the topology snapshot helpers
+*/
+package node
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTopologyAsymmetries(t *testing.T) {
+	topology := Topology{
+		{Index: 0, ExecutionConnected: []int{1}, BeaconConnected: []int{1, 2}},
+		{Index: 1, ExecutionConnected: []int{0}, BeaconConnected: []int{0}},
+		{Index: 2},
+		{Index: 3, ExecutionUnknownPeers: 2},
+	}
+	asymmetries := topology.Asymmetries()
+	if len(asymmetries) != 1 {
+		t.Fatalf("incorrect asymmetries: %v", asymmetries)
+	}
+	if isolated := topology.Isolated(); !reflect.DeepEqual(isolated, []int{2, 3}) {
+		t.Fatalf("incorrect isolated nodes: want [2 3], got %v", isolated)
+	}
+}
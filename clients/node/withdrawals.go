@@ -0,0 +1,255 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	api "github.com/ethereum/go-ethereum/beacon/engine"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/marioevz/eth-clients/clients/beacon"
+	"github.com/protolambda/eth2api"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// Withdrawal verification result of a single slot
+type SlotWithdrawalsReport struct {
+	Slot common.Slot
+	// Number of the execution block included in the beacon block
+	ExecutionBlockNumber uint64
+	// Whether the pre-state belongs to a previous epoch, in which case the
+	// epoch processing is applied before computing the expected withdrawals
+	EpochTransition bool
+	Expected        common.Withdrawals
+	Actual          common.Withdrawals
+	Discrepancies   []string
+}
+
+func (r *SlotWithdrawalsReport) discrepancy(
+	format string,
+	values ...interface{},
+) {
+	r.Discrepancies = append(r.Discrepancies, fmt.Sprintf(format, values...))
+}
+
+type WithdrawalsReport []*SlotWithdrawalsReport
+
+// Returns the subset of slot reports that contain discrepancies
+func (report WithdrawalsReport) Discrepancies() WithdrawalsReport {
+	res := make(WithdrawalsReport, 0)
+	for _, r := range report {
+		if len(r.Discrepancies) > 0 {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// Logs the discrepancies found in the report
+func (report WithdrawalsReport) Print(n *Node) {
+	for _, r := range report.Discrepancies() {
+		for _, d := range r.Discrepancies {
+			n.Logf("slot %d: withdrawals discrepancy: %s", r.Slot, d)
+		}
+	}
+}
+
+// Returns the addresses that sent or received a transaction in the list
+func transactionAddresses(
+	txs [][]byte,
+) (map[ethcommon.Address]bool, error) {
+	addresses := make(map[ethcommon.Address]bool)
+	for _, txBytes := range txs {
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(txBytes); err != nil {
+			return nil, err
+		}
+		if to := tx.To(); to != nil {
+			addresses[*to] = true
+		}
+		sender, err := types.Sender(
+			types.LatestSignerForChainID(tx.ChainId()),
+			tx,
+		)
+		if err != nil {
+			return nil, err
+		}
+		addresses[sender] = true
+	}
+	return addresses, nil
+}
+
+// Verifies the withdrawals of every block within the given slot range by
+// computing the expected withdrawals from the block's pre-state advanced to
+// the slot of the block, comparing
+// them against the withdrawals included in the execution payload, and
+// comparing the latter against the balance changes in the execution client.
+func (n *Node) VerifyWithdrawals(
+	ctx context.Context,
+	fromSlot common.Slot,
+	toSlot common.Slot,
+) (WithdrawalsReport, error) {
+	var (
+		bn     = n.BeaconClient
+		ec     = n.ExecutionClient
+		spec   = bn.Config.Spec
+		report = make(WithdrawalsReport, 0)
+	)
+	for slot := fromSlot; slot <= toSlot; slot++ {
+		versionedBlock, err := bn.BlockV2(ctx, eth2api.BlockIdSlot(slot))
+		if errors.Is(err, beacon.NotFound) {
+			// Missed slot
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !versionedBlock.ContainsWithdrawals() {
+			// Withdrawals not enabled
+			continue
+		}
+		actual, err := versionedBlock.Withdrawals()
+		if err != nil {
+			return nil, err
+		}
+		executionPayload, _, _, err := versionedBlock.ExecutionPayload()
+		if err != nil {
+			return nil, err
+		}
+
+		r := &SlotWithdrawalsReport{
+			Slot:                 slot,
+			ExecutionBlockNumber: executionPayload.Number,
+			Actual:               actual,
+		}
+		report = append(report, r)
+
+		preState, err := bn.BeaconStateV2ByBlock(
+			ctx,
+			eth2api.BlockIdRoot(versionedBlock.ParentRoot()),
+		)
+		if err != nil {
+			r.discrepancy("unable to fetch pre-state: %v", err)
+			continue
+		}
+		r.EpochTransition = spec.SlotToEpoch(preState.StateSlot()) !=
+			spec.SlotToEpoch(slot)
+		// Advance the pre-state through the skipped slots and epoch
+		// transitions, which can modify the balances and the validators
+		if preState, err = preState.ProcessSlots(ctx, slot); err != nil {
+			r.discrepancy("unable to process slots of the pre-state: %v", err)
+			continue
+		}
+		if r.Expected, err = preState.NextWithdrawals(slot); err != nil {
+			r.discrepancy("unable to compute expected withdrawals: %v", err)
+			continue
+		}
+
+		// Compare expected against the payload withdrawals
+		if len(r.Expected) != len(r.Actual) {
+			r.discrepancy(
+				"withdrawals count mismatch: want %d, got %d",
+				len(r.Expected),
+				len(r.Actual),
+			)
+		}
+		for i := 0; i < len(r.Expected) && i < len(r.Actual); i++ {
+			exp, act := r.Expected[i], r.Actual[i]
+			if exp.Index != act.Index ||
+				exp.ValidatorIndex != act.ValidatorIndex ||
+				exp.Address != act.Address {
+				r.discrepancy(
+					"withdrawal %d mismatch: want (index=%d, validator=%d, address=%s), got (index=%d, validator=%d, address=%s)",
+					i,
+					exp.Index,
+					exp.ValidatorIndex,
+					exp.Address,
+					act.Index,
+					act.ValidatorIndex,
+					act.Address,
+				)
+			} else if exp.Amount != act.Amount {
+				r.discrepancy(
+					"withdrawal %d amount mismatch: want %d, got %d",
+					i,
+					exp.Amount,
+					act.Amount,
+				)
+			}
+		}
+
+		// Compare the payload withdrawals against the execution balances
+		if ec == nil || len(r.Actual) == 0 || executionPayload.Number == 0 {
+			continue
+		}
+		r.verifyBalances(ctx, ec.BalanceAt, &executionPayload)
+	}
+	return report, nil
+}
+
+// Compares the withdrawals of the report against the balance changes caused
+// by the execution block of the payload, skipping the addresses whose balance
+// is also affected by transactions or fees
+func (r *SlotWithdrawalsReport) verifyBalances(
+	ctx context.Context,
+	balanceAt func(context.Context, ethcommon.Address, *big.Int) (*big.Int, error),
+	executionPayload *api.ExecutableData,
+) {
+	excluded, err := transactionAddresses(executionPayload.Transactions)
+	if err != nil {
+		r.discrepancy("unable to decode transactions: %v", err)
+		return
+	}
+	excluded[executionPayload.FeeRecipient] = true
+	withdrawn := make(map[ethcommon.Address]*big.Int)
+	for _, w := range r.Actual {
+		address := ethcommon.Address(w.Address)
+		if _, ok := withdrawn[address]; !ok {
+			withdrawn[address] = new(big.Int)
+		}
+		withdrawn[address].Add(
+			withdrawn[address],
+			new(big.Int).Mul(
+				new(big.Int).SetUint64(uint64(w.Amount)),
+				big.NewInt(1e9),
+			),
+		)
+	}
+	number := new(big.Int).SetUint64(executionPayload.Number)
+	parentNumber := new(big.Int).Sub(number, big.NewInt(1))
+	for address, amount := range withdrawn {
+		if excluded[address] {
+			// Balance also affected by transactions or fees
+			continue
+		}
+		pre, err := balanceAt(ctx, address, parentNumber)
+		if err != nil {
+			r.discrepancy(
+				"unable to fetch balance of %s at %d: %v",
+				address,
+				parentNumber,
+				err,
+			)
+			continue
+		}
+		post, err := balanceAt(ctx, address, number)
+		if err != nil {
+			r.discrepancy(
+				"unable to fetch balance of %s at %d: %v",
+				address,
+				number,
+				err,
+			)
+			continue
+		}
+		if delta := new(big.Int).Sub(post, pre); delta.Cmp(amount) != 0 {
+			r.discrepancy(
+				"execution balance change mismatch for %s: want %d, got %d",
+				address,
+				amount,
+				delta,
+			)
+		}
+	}
+}
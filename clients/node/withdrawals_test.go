@@ -0,0 +1,144 @@
+/*
+Tests for the withdrawals verification
+*/
+package node
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	api "github.com/ethereum/go-ethereum/beacon/engine"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func TestVerifyBalances(t *testing.T) {
+	key, err := crypto.ToECDSA(ethcommon.LeftPadBytes([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var (
+		withdrawn    = ethcommon.Address{0x01}
+		mismatch     = ethcommon.Address{0x02}
+		feeRecipient = ethcommon.Address{0x03}
+		recipient    = ethcommon.Address{0x04}
+		unreachable  = ethcommon.Address{0x05}
+		sender       = crypto.PubkeyToAddress(key.PublicKey)
+	)
+	tx, err := types.SignNewTx(
+		key,
+		types.LatestSignerForChainID(big.NewInt(1)),
+		&types.DynamicFeeTx{
+			ChainID:   big.NewInt(1),
+			To:        &recipient,
+			Gas:       21000,
+			GasFeeCap: big.NewInt(1),
+		},
+	)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	txBytes, err := tx.MarshalBinary()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	payload := &api.ExecutableData{
+		Number:       10,
+		FeeRecipient: feeRecipient,
+		Transactions: [][]byte{txBytes},
+	}
+
+	// Balance changes of the execution block, in wei
+	deltas := map[ethcommon.Address]int64{
+		withdrawn: 3e9,
+		mismatch:  1e9,
+	}
+	balanceAt := func(
+		ctx context.Context,
+		address ethcommon.Address,
+		number *big.Int,
+	) (*big.Int, error) {
+		if address == unreachable {
+			return nil, fmt.Errorf("connection refused")
+		}
+		balance := big.NewInt(100e9)
+		if number.Uint64() == payload.Number {
+			balance.Add(balance, big.NewInt(deltas[address]))
+		} else if number.Uint64() != payload.Number-1 {
+			t.Fatalf("balance requested at unexpected block %d", number)
+		}
+		return balance, nil
+	}
+
+	r := &SlotWithdrawalsReport{
+		Actual: common.Withdrawals{
+			// Withdrawals to the same address are added up
+			{Index: 0, ValidatorIndex: 0, Address: common.Eth1Address(withdrawn), Amount: 1},
+			{Index: 1, ValidatorIndex: 1, Address: common.Eth1Address(withdrawn), Amount: 2},
+			{Index: 2, ValidatorIndex: 2, Address: common.Eth1Address(mismatch), Amount: 2},
+			// Balances also modified by the block are not compared
+			{Index: 3, ValidatorIndex: 3, Address: common.Eth1Address(feeRecipient), Amount: 4},
+			{Index: 4, ValidatorIndex: 4, Address: common.Eth1Address(recipient), Amount: 5},
+			{Index: 5, ValidatorIndex: 5, Address: common.Eth1Address(sender), Amount: 6},
+			{Index: 6, ValidatorIndex: 6, Address: common.Eth1Address(unreachable), Amount: 7},
+		},
+	}
+	r.verifyBalances(context.Background(), balanceAt, payload)
+	if len(r.Discrepancies) != 2 {
+		t.Fatalf("incorrect discrepancies: %v", r.Discrepancies)
+	}
+	for _, expected := range []string{
+		fmt.Sprintf("execution balance change mismatch for %s: want 2000000000, got 1000000000", mismatch),
+		fmt.Sprintf("unable to fetch balance of %s", unreachable),
+	} {
+		found := false
+		for _, d := range r.Discrepancies {
+			found = found || strings.HasPrefix(d, expected)
+		}
+		if !found {
+			t.Fatalf("missing discrepancy %q: %v", expected, r.Discrepancies)
+		}
+	}
+
+	r = &SlotWithdrawalsReport{Actual: r.Actual[:2]}
+	r.verifyBalances(context.Background(), balanceAt, payload)
+	if len(r.Discrepancies) != 0 {
+		t.Fatalf("unexpected discrepancies: %v", r.Discrepancies)
+	}
+}
+
+func TestVerifyWithdrawalsMissedSlots(t *testing.T) {
+	ctx := context.Background()
+	var status atomic.Int32
+	status.Store(http.StatusNotFound)
+	n := testBatchNode(t, 0, configs.Minimal, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/eth/v2/beacon/blocks/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(int(status.Load()))
+	})
+
+	// Blocks not found are missed slots
+	report, err := n.VerifyWithdrawals(ctx, 1, 3)
+	if err != nil {
+		t.Fatalf("unable to verify withdrawals: %v", err)
+	}
+	if len(report) != 0 {
+		t.Fatalf("unexpected report: %v", report)
+	}
+
+	// Any other error is returned
+	status.Store(http.StatusInternalServerError)
+	if _, err := n.VerifyWithdrawals(ctx, 1, 3); err == nil {
+		t.Fatalf("expected block request error")
+	}
+}
@@ -2,6 +2,7 @@ package validator
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/marioevz/eth-clients/clients"
 	"github.com/marioevz/eth-clients/clients/beacon"
@@ -138,6 +139,53 @@ func (v *ValidatorClient) SignVoluntaryExit(
 	}, nil
 }
 
+func (v *ValidatorClient) SignBeaconBlockHeader(
+	domain common.BLSDomain,
+	header *common.BeaconBlockHeader,
+) (*common.SignedBeaconBlockHeader, error) {
+	kd, ok := v.Keys[header.ProposerIndex]
+	if !ok {
+		return nil, fmt.Errorf(
+			"validator client does not contain validator index %d",
+			header.ProposerIndex,
+		)
+	}
+	sigRoot := common.ComputeSigningRoot(
+		header.HashTreeRoot(tree.GetHashFn()),
+		domain,
+	)
+
+	sk := new(blsu.SecretKey)
+	sk.Deserialize(&kd.ValidatorSecretKey)
+	signature := blsu.Sign(sk, sigRoot[:]).Serialize()
+	return &common.SignedBeaconBlockHeader{
+		Message:   *header,
+		Signature: common.BLSSignature(signature),
+	}, nil
+}
+
+func (v *ValidatorClient) SignAttestationData(
+	domain common.BLSDomain,
+	data *phase0.AttestationData,
+	validatorIndex common.ValidatorIndex,
+) (*blsu.Signature, error) {
+	kd, ok := v.Keys[validatorIndex]
+	if !ok {
+		return nil, fmt.Errorf(
+			"validator client does not contain validator index %d",
+			validatorIndex,
+		)
+	}
+	sigRoot := common.ComputeSigningRoot(
+		data.HashTreeRoot(tree.GetHashFn()),
+		domain,
+	)
+
+	sk := new(blsu.SecretKey)
+	sk.Deserialize(&kd.ValidatorSecretKey)
+	return blsu.Sign(sk, sigRoot[:]), nil
+}
+
 type ValidatorClients []*ValidatorClient
 
 // Return subset of clients that are currently running
@@ -179,3 +227,51 @@ func (all ValidatorClients) SignBLSToExecutionChange(
 		)
 	}
 }
+
+// Signs the attestation data using the keys of all the specified validators,
+// which can be spread across different validator clients, and returns the
+// indexed attestation containing the aggregated signature
+func (all ValidatorClients) SignIndexedAttestation(
+	domain common.BLSDomain,
+	data *phase0.AttestationData,
+	validatorIndexes []common.ValidatorIndex,
+) (*phase0.IndexedAttestation, error) {
+	if len(validatorIndexes) == 0 {
+		return nil, fmt.Errorf("no validator indexes specified")
+	}
+	indices := make(common.CommitteeIndices, len(validatorIndexes))
+	copy(indices, validatorIndexes)
+	sort.Slice(indices, func(i, j int) bool {
+		return indices[i] < indices[j]
+	})
+	signatures := make([]*blsu.Signature, 0, len(indices))
+	for i, validatorIndex := range indices {
+		if i > 0 && indices[i-1] == validatorIndex {
+			return nil, fmt.Errorf(
+				"duplicate validator index %d",
+				validatorIndex,
+			)
+		}
+		v := all.ByValidatorIndex(validatorIndex)
+		if v == nil {
+			return nil, fmt.Errorf(
+				"validator index %d not found",
+				validatorIndex,
+			)
+		}
+		sig, err := v.SignAttestationData(domain, data, validatorIndex)
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, sig)
+	}
+	aggregate, err := blsu.Aggregate(signatures)
+	if err != nil {
+		return nil, err
+	}
+	return &phase0.IndexedAttestation{
+		AttestingIndices: indices,
+		Data:             *data,
+		Signature:        common.BLSSignature(aggregate.Serialize()),
+	}, nil
+}